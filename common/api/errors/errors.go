@@ -47,6 +47,7 @@ const (
 
 	WaitForCooldownTime      = "wait_for_cooldown_time_%s"
 	BatchInternalTransferErr = "batch_internal_transfer_err_%s"
+	InvalidQueryParameterAt  = "invalid_query_parameter_%s"
 
 	InvalidCurrency          = "invalid_currency"
 	InvalidBlockchain        = "invalid_blockchain"
@@ -187,6 +188,18 @@ const (
 	PriceAlertStatusError = "price_alert_status_error"
 	PriceAlertTooMany     = "price_alert_too_many"
 
+	WebhookNotFound   = "webhook_not_found"
+	WebhookTooMany    = "webhook_too_many"
+	InvalidWebhookURL = "invalid_webhook_url"
+
+	PushCampaignNotFound      = "push_campaign_not_found"
+	PushCampaignNotCancelable = "push_campaign_not_cancelable"
+
+	ExportJobNotFound  = "export_job_not_found"
+	ExportFileNotFound = "export_file_not_found"
+	ExportTooLarge     = "export_too_large"
+	QueryTooExpensive  = "query_too_expensive"
+
 	PromoCodeUsed         = "promo_code_used"
 	PromoCodeExpired      = "promo_code_expired"
 	PromoCodeUserRedeemed = "promo_code_user_redeemed"
@@ -325,6 +338,7 @@ var errorCodeMap = map[string]int{
 
 	WaitForCooldownTime:      http.StatusTooManyRequests,
 	BatchInternalTransferErr: http.StatusBadRequest,
+	InvalidQueryParameterAt:  http.StatusBadRequest,
 
 	SlotMachineTokenNotFound:  http.StatusNotFound,
 	SlotMachineRewardNotFound: http.StatusNotFound,
@@ -422,6 +436,18 @@ var errorCodeMap = map[string]int{
 	PriceAlertStatusError: http.StatusBadRequest,
 	PriceAlertTooMany:     http.StatusBadRequest,
 
+	WebhookNotFound:   http.StatusNotFound,
+	WebhookTooMany:    http.StatusBadRequest,
+	InvalidWebhookURL: http.StatusBadRequest,
+
+	PushCampaignNotFound:      http.StatusNotFound,
+	PushCampaignNotCancelable: http.StatusBadRequest,
+
+	ExportJobNotFound:  http.StatusNotFound,
+	ExportFileNotFound: http.StatusNotFound,
+	ExportTooLarge:     http.StatusBadRequest,
+	QueryTooExpensive:  http.StatusBadRequest,
+
 	PromoCodeUsed:         http.StatusBadRequest,
 	PromoCodeExpired:      http.StatusBadRequest,
 	PromoCodeUserRedeemed: http.StatusBadRequest,
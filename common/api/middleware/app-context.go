@@ -5,18 +5,19 @@ import (
 	"net/http"
 	"reflect"
 	"runtime"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 
 	cobxtypes "github.com/jiarung/mochi/apps/exchange/cobx-types"
 	"github.com/jiarung/mochi/cache"
-	"github.com/jiarung/mochi/common"
 	apicontext "github.com/jiarung/mochi/common/api/context"
 	apiutils "github.com/jiarung/mochi/common/api/utils"
 	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/telemetry"
 	"github.com/jiarung/mochi/database"
 	"github.com/jiarung/mochi/infra/api/middleware/logger"
 )
@@ -37,31 +38,43 @@ func RequireAppContext(
 		var appCtx *apicontext.AppContext
 		var err error
 
-		// Add ddtracer span.
-		if common.TracerEnabled() {
+		// Add telemetry span.
+		if telemetry.Enabled() {
 			// Create our span and patch it to the context for downstream.
-			span := tracer.StartSpan("gin.request",
-				tracer.ServiceName(string(serviceName)),
-				tracer.ResourceName(fnName),
+			spanCtx, span := telemetry.Tracer().Start(
+				ctx.Request.Context(), "gin.request",
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.ServiceName(string(serviceName)),
+					attribute.String("resource.name", fnName),
+				),
 			)
+			ctx.Request = ctx.Request.WithContext(spanCtx)
 			defer func() {
 				var spanErr error
 				var code int
 				var failure *apiutils.FailureObj
 				defer func() {
 					// Setup metadata.
-					span.SetTag(ext.HTTPMethod, ctx.Request.Method)
-					span.SetTag(ext.HTTPURL, ctx.Request.URL.Path)
+					span.SetAttributes(
+						semconv.HTTPRequestMethodKey.String(ctx.Request.Method),
+						semconv.URLPath(ctx.Request.URL.Path),
+					)
 
 					if code != 0 {
-						span.SetTag(ext.HTTPCode, strconv.Itoa(code))
+						span.SetAttributes(semconv.HTTPResponseStatusCode(code))
 					}
 					// Set any error information.
 					if failure != nil {
-						span.SetTag("appctx.errors", failure.String()) // set all errors
+						span.SetAttributes(attribute.String(
+							"appctx.errors", failure.String())) // set all errors
+					}
+					if spanErr != nil {
+						span.RecordError(spanErr)
+						span.SetStatus(codes.Error, spanErr.Error())
 					}
 
-					span.Finish(tracer.WithError(spanErr))
+					span.End()
 				}()
 
 				if err != nil {
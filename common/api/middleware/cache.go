@@ -91,6 +91,7 @@ func CacheMiddlewareFunc(seconds int, method CacheMethod) gin.HandlerFunc {
 			return
 		}
 		logger := appCtx.Logger()
+		service := string(appCtx.ServiceName)
 
 		cacheSec := seconds + 20
 		var key *cacheKey
@@ -136,12 +137,14 @@ func CacheMiddlewareFunc(seconds int, method CacheMethod) gin.HandlerFunc {
 			}
 
 			// Always respond the cached value whether it is expired or not.
+			cacheRequestsTotal.WithLabelValues(service, cacheResultHit).Inc()
 			appCtx.SetResp(gin.MIMEJSON, []byte(value.(string)))
 			appCtx.SetIgnoreAndAbort()
 			return
 		}
 
 		// Forward to handler.
+		cacheRequestsTotal.WithLabelValues(service, cacheResultMiss).Inc()
 		ctx.Next()
 
 		// return for error or no key.
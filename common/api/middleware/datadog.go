@@ -2,17 +2,12 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
-	ginTracer "gopkg.in/DataDog/dd-trace-go.v1/contrib/gin-gonic/gin"
-
-	"github.com/jiarung/mochi/common"
 )
 
 // DatadogMiddleware provide datadog logging.
+//
+// Deprecated: Datadog is one of the telemetry exporters now, use
+// TelemetryMiddleware instead.
 func DatadogMiddleware(serviceName string) gin.HandlerFunc {
-	if !common.TracerEnabled() {
-		return func(c *gin.Context) {
-		}
-	}
-
-	return ginTracer.Middleware(serviceName)
+	return TelemetryMiddleware(serviceName)
 }
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	cobxtypes "github.com/jiarung/mochi/apps/exchange/cobx-types"
+	apiutils "github.com/jiarung/mochi/common/api/utils"
+	"github.com/jiarung/mochi/common/metric"
+)
+
+const (
+	metricSubsystemHTTP = "http"
+	metricUnmatchedPath = "unmatched"
+)
+
+// Cache results recorded by cacheRequestsTotal.
+const (
+	cacheResultHit  = "hit"
+	cacheResultMiss = "miss"
+)
+
+var (
+	httpRequestsTotal = metric.NewCounter(metricSubsystemHTTP,
+		"requests_total", "Total number of HTTP requests.",
+		"service", "route", "method", "status")
+	httpRequestErrorsTotal = metric.NewCounter(metricSubsystemHTTP,
+		"request_errors_total", "Total number of HTTP requests by error code.",
+		"service", "route", "error_code")
+	httpRequestDuration = metric.NewHistogram(metricSubsystemHTTP,
+		"request_duration_seconds", "HTTP request latencies in seconds.",
+		nil, "service", "route", "method")
+
+	rateLimitRejectedTotal = metric.NewCounter(metricSubsystemHTTP,
+		"rate_limit_rejected_total", "Total number of rate limited requests.",
+		"service", "limiter")
+	cacheRequestsTotal = metric.NewCounter(metricSubsystemHTTP,
+		"cache_requests_total", "Total number of response cache lookups.",
+		"service", "result")
+	nonceFailuresTotal = metric.NewCounter(metricSubsystemHTTP,
+		"nonce_failures_total", "Total number of rejected nonces.",
+		"service", "reason")
+)
+
+// MetricsMiddleware records request rate, errors and latencies per route
+// template. It should be placed before ResponseHandler so the status code is
+// written when it's recorded.
+func MetricsMiddleware(serviceName cobxtypes.ServiceName) gin.HandlerFunc {
+	service := string(serviceName)
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if len(route) == 0 {
+			route = metricUnmatchedPath
+		}
+		method := ctx.Request.Method
+
+		httpRequestDuration.WithLabelValues(service, route, method).Observe(
+			time.Since(start).Seconds())
+		httpRequestsTotal.WithLabelValues(service, route, method,
+			strconv.Itoa(ctx.Writer.Status())).Inc()
+		if code := ctx.GetString(apiutils.ErrorKey); len(code) > 0 {
+			httpRequestErrorsTotal.WithLabelValues(service, route, code).Inc()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+
+	apierrors "github.com/jiarung/mochi/common/api/errors"
+	apiutils "github.com/jiarung/mochi/common/api/utils"
+)
+
+type MetricsMiddlewareTestSuite struct {
+	suite.Suite
+}
+
+func (s *MetricsMiddlewareTestSuite) TestRecord() {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(MetricsMiddleware("metrics-test"))
+	r.GET("/v1/orders/:id", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	r.GET("/v1/fail", func(ctx *gin.Context) {
+		apiutils.SetError(ctx, apierrors.TryAgainLater)
+		ctx.Status(http.StatusTooManyRequests)
+	})
+
+	for _, path := range []string{"/v1/orders/1", "/v1/orders/2", "/v1/fail"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	s.Require().Equal(2.0, testutil.ToFloat64(httpRequestsTotal.WithLabelValues(
+		"metrics-test", "/v1/orders/:id", "GET", "200")))
+	s.Require().Equal(1.0, testutil.ToFloat64(httpRequestsTotal.WithLabelValues(
+		"metrics-test", "/v1/fail", "GET", "429")))
+	s.Require().Equal(1.0, testutil.ToFloat64(
+		httpRequestErrorsTotal.WithLabelValues(
+			"metrics-test", "/v1/fail", apierrors.TryAgainLater)))
+	s.Require().Equal(0.0, testutil.ToFloat64(
+		httpRequestErrorsTotal.WithLabelValues(
+			"metrics-test", "/v1/orders/:id", apierrors.TryAgainLater)))
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	suite.Run(t, new(MetricsMiddlewareTestSuite))
+}
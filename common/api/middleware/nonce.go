@@ -34,6 +34,13 @@ func prepareNonceCacheKey(pk string) string {
 	return fmt.Sprintf("api:middleware:nonce:%v", pk)
 }
 
+// rejectNonce records the failure and sets the invalid nonce error.
+func rejectNonce(appCtx *apicontext.AppContext, reason string) {
+	nonceFailuresTotal.WithLabelValues(
+		string(appCtx.ServiceName), reason).Inc()
+	appCtx.SetError(apierrors.InvalidNonce)
+}
+
 // NonceMiddleware is a handler function that limits request sequence
 // to prevent duplicated request.
 func NonceMiddleware(ctx *gin.Context) {
@@ -75,7 +82,7 @@ func NonceMiddleware(ctx *gin.Context) {
 	userNonce, err := strconv.ParseInt(ctx.Request.Header.Get(nonceHeader), 10, 64)
 	if err != nil {
 		appCtx.Logger().Warn("Not numeric nonce. Error: %v\n", err)
-		appCtx.SetError(apierrors.InvalidNonce)
+		rejectNonce(appCtx, "malformed")
 		return
 	}
 
@@ -98,7 +105,7 @@ func NonceMiddleware(ctx *gin.Context) {
 		"SET", nonceLock, appCtx.RequestTag(),
 		"EX", nonceTimeout, "NX"); v != "OK" || err != nil {
 		appCtx.Logger().Error("Duplicated Nonce: %v. Error: %v\n", userNonce, err)
-		appCtx.SetError(apierrors.InvalidNonce)
+		rejectNonce(appCtx, "duplicated")
 		return
 	}
 	defer appCtx.Cache.Delete(nonceLock)
@@ -110,7 +117,7 @@ func NonceMiddleware(ctx *gin.Context) {
 				"key: <%v>: Stored nonce (%v) >= userNonce (%v). Error: %v\n",
 				key, storedNonce, userNonce, err,
 			)
-			appCtx.SetError(apierrors.InvalidNonce)
+			rejectNonce(appCtx, "stale")
 			return
 		}
 	}
@@ -15,6 +15,13 @@ import (
 	apiutils "github.com/jiarung/mochi/infra/api/utils"
 )
 
+// rejectRateLimited records the rejection and sets the rate limit error.
+func rejectRateLimited(ctx *apicontext.AppContext, limiter string) {
+	rateLimitRejectedTotal.WithLabelValues(
+		string(ctx.ServiceName), limiter).Inc()
+	ctx.SetError(apierrors.TryAgainLater)
+}
+
 func isLimitReachedAndSetHeader(ctx *apicontext.AppContext,
 	cLimiter limiters.Limiter, key string) bool {
 	ret := limiters.ReachLimitation(cLimiter, key)
@@ -45,9 +52,9 @@ func AuthRateLimitFunc(limit int64, seconds int) gin.HandlerFunc {
 			return
 		}
 
-		key := "waf-auth-limiter:" + appCtx.UserID.String()
+		key := limiters.AuthLimiterKey(appCtx.UserID.String())
 		if isLimitReachedAndSetHeader(appCtx, cLimiter, key) {
-			appCtx.SetError(apierrors.TryAgainLater)
+			rejectRateLimited(appCtx, "auth")
 			return
 		}
 	}
@@ -89,7 +96,7 @@ func urlAuthAPIRateLimitFunc(limiterSelector LimiterSelector) gin.HandlerFunc {
 		l := limiterSelector.SelectLimiter(appCtx.DB, appCtx.UserID)
 
 		if isLimitReachedAndSetHeader(appCtx, l, key) {
-			appCtx.SetError(apierrors.TryAgainLater)
+			rejectRateLimited(appCtx, "url_auth")
 			return
 		}
 	}
@@ -119,7 +126,7 @@ func URLIPRateLimitFunc(limit int64, seconds int) gin.HandlerFunc {
 			ctx.Request.URL.String() +
 			apiutils.GetIPKey(ctx.Request)
 		if isLimitReachedAndSetHeader(appCtx, cLimiter, key) {
-			appCtx.SetError(apierrors.TryAgainLater)
+			rejectRateLimited(appCtx, "url_ip")
 			return
 		}
 	}
@@ -144,7 +151,7 @@ func WebsocketIPAPIRateLimiter(ctx *gin.Context) {
 	}
 
 	if limiters.ReachWebsocketAPIIP10RPS(apiutils.GetIPKey(ctx.Request)) {
-		appCtx.SetError(apierrors.TryAgainLater)
+		rejectRateLimited(appCtx, "websocket_ip")
 		return
 	}
 }
@@ -179,7 +186,7 @@ func WebsocketConnectionAPIRateLimitFunc(maxConnections int) gin.HandlerFunc {
 
 		if limiters.ReachWebsocketConnectionlimit(
 			key, appCtx.RequestTag(), maxConnections) {
-			appCtx.SetError(apierrors.TryAgainLater)
+			rejectRateLimited(appCtx, "websocket_connection")
 			return
 		}
 
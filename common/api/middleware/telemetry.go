@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+
+	"github.com/jiarung/mochi/common/telemetry"
+)
+
+// TelemetryMiddleware traces requests with the providers set up by
+// telemetry.Init. It's a no-op if telemetry isn't enabled.
+func TelemetryMiddleware(serviceName string) gin.HandlerFunc {
+	if !telemetry.Enabled() {
+		return func(c *gin.Context) {
+		}
+	}
+
+	return otelgin.Middleware(serviceName)
+}
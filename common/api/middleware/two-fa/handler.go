@@ -1,6 +1,7 @@
 package twofactor
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -15,6 +16,8 @@ import (
 	jwtFactory "github.com/jiarung/mochi/common/jwt"
 	"github.com/jiarung/mochi/common/logging"
 	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/common/notification/dispatcher"
+	"github.com/jiarung/mochi/common/notification/preference"
 	"github.com/jiarung/mochi/database"
 	models "github.com/jiarung/mochi/models/exchange"
 	"github.com/jiarung/mochi/types"
@@ -227,15 +230,52 @@ func TwoFAHandler(
 					return
 				}
 
-				go func() {
-					if err := smsSender.SendTo(
+				if center := preference.Default(); center != nil {
+					// The code is read at delivery instead of being queued.
+					_, err := center.Notify(context.Background(), userID,
+						preference.CategoryTwoFA, &preference.Payload{
+							ID: fmt.Sprintf("sms-auth:%v", authID),
+							SMS: &preference.SMSMessage{
+								Country:  toCountryCode,
+								Phone:    toPhoneNum,
+								BodyKind: smsAuthBodyKind,
+								BodyRef:  authID.String(),
+							},
+						})
+					if err != nil {
+						logger.Error("Error while notifying SMS Auth. Error: %v", err)
+						appCtx.Abort()
+						return
+					}
+				} else if d := dispatcher.Default(); d != nil {
+					// The code is read at delivery instead of being queued.
+					if err := d.EnqueueSMSRef(
+						fmt.Sprintf("sms-auth:%v", authID),
 						toCountryCode,
 						toPhoneNum,
-						code+" is your COBINHOOD verification code."); err != nil {
-						logger.Error("Error while sending SMS Auth. Error: %v", err)
+						smsAuthBodyKind,
+						authID.String()); err != nil {
+						logger.Error("Error while enqueuing SMS Auth. Error: %v", err)
+						appCtx.Abort()
 						return
 					}
-				}()
+				} else {
+					go func() {
+						record, err := smsSender.Send(
+							context.Background(),
+							toCountryCode,
+							toPhoneNum,
+							smsAuthMessage(code))
+						if err != nil {
+							logger.Error("Error while sending SMS Auth. Error: %v", err)
+							return
+						}
+						if record != nil {
+							logger.Info("SMS Auth<%v> sent as sms<%v> by %v",
+								authID, record.ID, record.Provider())
+						}
+					}()
+				}
 				token, err := jwtFactory.Build(jwtFactory.TwoFARequiredObj{
 					API:       string(handler.TwoFARequiredAPI()),
 					TwoFAType: string(types.TwoFactorAuthSMS),
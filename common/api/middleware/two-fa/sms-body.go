@@ -0,0 +1,47 @@
+package twofactor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/notification/dispatcher"
+	"github.com/jiarung/mochi/database"
+	models "github.com/jiarung/mochi/models/exchange"
+)
+
+// smsAuthBodyKind is the kind of SMS bodies of sms auths, which are rendered
+// from codes of sms auths at delivery, so codes aren't persisted in queues
+// and dead letters of the dispatcher.
+const smsAuthBodyKind = "sms-auth"
+
+func init() {
+	dispatcher.RegisterSMSBody(smsAuthBodyKind, smsAuthBody)
+}
+
+func smsAuthMessage(code string) string {
+	return code + " is your COBINHOOD verification code."
+}
+
+// smsAuthBody renders the SMS body of the sms auth of ID ref. Expired sms
+// auths aren't sent.
+func smsAuthBody(ctx context.Context, ref string) (string, error) {
+	authID, err := uuid.FromString(ref)
+	if err != nil {
+		return "", dispatcher.Permanent(err)
+	}
+	smsAuth := models.SMSAuth{}
+	result := database.GetDB(database.Default).Where("id = ?", authID).First(&smsAuth)
+	if result.RecordNotFound() {
+		return "", dispatcher.Permanent(fmt.Errorf("sms_auth<%v> not found", authID))
+	}
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if time.Now().After(smsAuth.ExpireAt) {
+		return "", dispatcher.Permanent(fmt.Errorf("sms_auth<%v> expired", authID))
+	}
+	return smsAuthMessage(smsAuth.SMSCode), nil
+}
@@ -0,0 +1,63 @@
+//go:build go1.18
+
+package collections
+
+import (
+	"sync/atomic"
+)
+
+// Array provides thread-safe array collection with fixed length.
+type Array[T any] struct {
+	arr []atomic.Value
+}
+
+// arrayValue boxes values, so atomic.Value always stores the same type.
+type arrayValue[T any] struct {
+	v T
+}
+
+// NewArray returns new Array.
+func NewArray[T any](length int) *Array[T] {
+	return &Array[T]{arr: make([]atomic.Value, length)}
+}
+
+// Store saves value. user should check k is not out of range.
+func (s *Array[T]) Store(k int, v T) {
+	s.arr[k].Store(arrayValue[T]{v})
+}
+
+// Load returns value. zero value if not store yet.
+// user should check k is not out of range.
+func (s *Array[T]) Load(k int) T {
+	v, _ := s.LoadOK(k)
+	return v
+}
+
+// LoadOK returns value and whether it's stored.
+// user should check k is not out of range.
+func (s *Array[T]) LoadOK(k int) (T, bool) {
+	v, ok := s.arr[k].Load().(arrayValue[T])
+	return v.v, ok
+}
+
+// Len returns the length.
+func (s *Array[T]) Len() int {
+	return len(s.arr)
+}
+
+// StoreAll saves values from index 0. user should check vs is not longer
+// than the array.
+func (s *Array[T]) StoreAll(vs ...T) {
+	for i, v := range vs {
+		s.Store(i, v)
+	}
+}
+
+// Slice returns a snapshot of all values, which are loaded one by one.
+func (s *Array[T]) Slice() []T {
+	vs := make([]T, len(s.arr))
+	for i := range s.arr {
+		vs[i] = s.Load(i)
+	}
+	return vs
+}
@@ -0,0 +1,59 @@
+//go:build go1.18
+
+package collections
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ArrayTestSuite struct {
+	suite.Suite
+}
+
+func (s *ArrayTestSuite) TestStoreLoad() {
+	a := NewArray[*int](2)
+	s.Require().Equal(2, a.Len())
+	s.Require().Nil(a.Load(0))
+	_, ok := a.LoadOK(0)
+	s.Require().False(ok)
+
+	one := 1
+	a.Store(0, &one)
+	s.Require().Equal(&one, a.Load(0))
+	// nil is stored as well.
+	a.Store(0, nil)
+	v, ok := a.LoadOK(0)
+	s.Require().True(ok)
+	s.Require().Nil(v)
+}
+
+func (s *ArrayTestSuite) TestAny() {
+	// Values of different types are stored in arrays of interface{}.
+	a := NewArray[interface{}](2)
+	a.StoreAll(1, "b")
+	a.Store(0, "a")
+	s.Require().Equal([]interface{}{"a", "b"}, a.Slice())
+}
+
+func (s *ArrayTestSuite) TestConcurrency() {
+	a := NewArray[int](8)
+	var wg sync.WaitGroup
+	for i := 0; i < a.Len(); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a.Store(i, a.Load(i)+1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	s.Require().Equal([]int{100, 100, 100, 100, 100, 100, 100, 100}, a.Slice())
+}
+
+func TestArray(t *testing.T) {
+	suite.Run(t, new(ArrayTestSuite))
+}
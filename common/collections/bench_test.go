@@ -0,0 +1,103 @@
+//go:build go1.18
+
+package collections
+
+import (
+	"testing"
+)
+
+// Benchmarks compare containers of concrete types with containers of
+// interface{}, which is what the previous APIs like utils.Deque store.
+
+type benchMessage struct {
+	channel string
+	seq     int64
+}
+
+func BenchmarkDeque(b *testing.B) {
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		d := NewDeque[benchMessage]()
+		for i := 0; i < b.N; i++ {
+			d.PushBack(benchMessage{seq: int64(i)})
+			if d.Len() > 64 {
+				m, _ := d.PopFront()
+				_ = m.seq
+			}
+		}
+	})
+	b.Run("interface", func(b *testing.B) {
+		b.ReportAllocs()
+		d := NewDeque[interface{}]()
+		for i := 0; i < b.N; i++ {
+			d.PushBack(benchMessage{seq: int64(i)})
+			if d.Len() > 64 {
+				m, _ := d.PopFront()
+				_ = m.(benchMessage).seq
+			}
+		}
+	})
+}
+
+func BenchmarkRingBuffer(b *testing.B) {
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		r := NewRingBuffer[benchMessage](64)
+		for i := 0; i < b.N; i++ {
+			r.PushBack(benchMessage{seq: int64(i)})
+		}
+		var sum int64
+		r.Each(func(m benchMessage) { sum += m.seq })
+	})
+	b.Run("interface", func(b *testing.B) {
+		b.ReportAllocs()
+		r := NewRingBuffer[interface{}](64)
+		for i := 0; i < b.N; i++ {
+			r.PushBack(benchMessage{seq: int64(i)})
+		}
+		var sum int64
+		r.Each(func(m interface{}) { sum += m.(benchMessage).seq })
+	})
+}
+
+func BenchmarkUnboundedChan(b *testing.B) {
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		c := NewUnboundedChan[benchMessage]()
+		defer c.Close()
+		for i := 0; i < b.N; i++ {
+			c.In() <- benchMessage{seq: int64(i)}
+			m := <-c.Out()
+			_ = m.seq
+		}
+	})
+	b.Run("interface", func(b *testing.B) {
+		b.ReportAllocs()
+		c := NewUnboundedChan[interface{}]()
+		defer c.Close()
+		for i := 0; i < b.N; i++ {
+			c.In() <- benchMessage{seq: int64(i)}
+			m := <-c.Out()
+			_ = m.(benchMessage).seq
+		}
+	})
+}
+
+func BenchmarkArray(b *testing.B) {
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		a := NewArray[int64](16)
+		for i := 0; i < b.N; i++ {
+			a.Store(i%16, int64(i))
+			_ = a.Load(i % 16)
+		}
+	})
+	b.Run("interface", func(b *testing.B) {
+		b.ReportAllocs()
+		a := NewArray[interface{}](16)
+		for i := 0; i < b.N; i++ {
+			a.Store(i%16, int64(i))
+			_ = a.Load(i % 16).(int64)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+//go:build go1.18
+
+package collections
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrChannelFull is sent to Err of BufferedChan if objects are dropped
+// since the channel is full.
+var ErrChannelFull = errors.New("channel full")
+
+// UnboundedChan is a channel whose sends never block, buffering objects
+// in a deque until they're received.
+type UnboundedChan[T any] struct {
+	in     chan T
+	out    chan T
+	done   chan struct{}
+	deque  *Deque[T]
+	ctx    context.Context
+	cancel func()
+	// bounded limits buffered objects to cap, and err reports dropped
+	// objects.
+	bounded bool
+	cap     uint64
+	err     chan error
+}
+
+// NewUnboundedChan returns an unbounded channel.
+func NewUnboundedChan[T any]() *UnboundedChan[T] {
+	c := newChan[T]()
+	go c.run()
+	return c
+}
+
+func newChan[T any]() *UnboundedChan[T] {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &UnboundedChan[T]{
+		in:     make(chan T),
+		out:    make(chan T),
+		done:   make(chan struct{}),
+		deque:  NewDeque[T](),
+		ctx:    ctx,
+		cancel: cancel,
+		err:    make(chan error, 1),
+	}
+}
+
+// In returns input channel, which is nil and blocks once it's closed.
+func (c *UnboundedChan[T]) In() chan<- T {
+	if c.ctx.Err() != nil {
+		return nil
+	}
+	return c.in
+}
+
+// Out returns output channel, which is nil and blocks once it's closed.
+func (c *UnboundedChan[T]) Out() <-chan T {
+	if c.ctx.Err() != nil {
+		return nil
+	}
+	return c.out
+}
+
+// Close close the channel.
+func (c *UnboundedChan[T]) Close() {
+	c.cancel()
+}
+
+// Done returns done channel.
+func (c *UnboundedChan[T]) Done() <-chan struct{} {
+	return c.done
+}
+
+// Len returns lenght of channel.
+func (c *UnboundedChan[T]) Len() uint64 {
+	return c.deque.Len()
+}
+
+// Dump returns data stuck in channel.
+func (c *UnboundedChan[T]) Dump() []T {
+	return c.deque.Slice()
+}
+
+// SendAll sends objs in order, returning false if the channel is closed
+// before all objects are sent.
+func (c *UnboundedChan[T]) SendAll(objs ...T) bool {
+	for _, obj := range objs {
+		select {
+		case c.in <- obj:
+		case <-c.ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (c *UnboundedChan[T]) sendErr(err error) {
+	select {
+	case c.err <- err:
+	default:
+	}
+}
+
+func (c *UnboundedChan[T]) push(obj T) {
+	if c.bounded && c.deque.Len() >= c.cap {
+		c.sendErr(ErrChannelFull)
+		return
+	}
+	c.deque.PushBack(obj)
+}
+
+func (c *UnboundedChan[T]) run() {
+	defer close(c.done)
+	for {
+		// Priority Done().
+		select {
+		case <-c.ctx.Done():
+			return
+		default:
+		}
+
+		out, ok := c.deque.Head()
+		if !ok {
+			select {
+			case <-c.ctx.Done():
+				return
+			case in := <-c.in:
+				c.push(in)
+			}
+			continue
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case in := <-c.in:
+			c.push(in)
+		case c.out <- out:
+			c.deque.PopFront()
+		}
+	}
+}
+
+// BufferedChan is a channel buffering at most Cap objects, whose sends
+// never block. Objects sent to a full channel are dropped with
+// ErrChannelFull sent to Err, so every object sent to a channel of Cap 0 is
+// dropped.
+type BufferedChan[T any] struct {
+	*UnboundedChan[T]
+}
+
+// NewBufferedChan returns a buffered channel of cap. Use NewUnboundedChan
+// for unbounded buffers.
+func NewBufferedChan[T any](cap uint64) *BufferedChan[T] {
+	c := &BufferedChan[T]{UnboundedChan: newChan[T]()}
+	c.bounded = true
+	c.cap = cap
+	go c.run()
+	return c
+}
+
+// Err returns error channel.
+func (c *BufferedChan[T]) Err() <-chan error {
+	return c.err
+}
+
+// Cap returns capacity of buffered channel.
+func (c *BufferedChan[T]) Cap() uint64 {
+	return c.cap
+}
@@ -0,0 +1,69 @@
+//go:build go1.18
+
+package collections
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ChanTestSuite struct {
+	suite.Suite
+}
+
+func (s *ChanTestSuite) TestUnbounded() {
+	c := NewUnboundedChan[[]byte]()
+	defer c.Close()
+
+	s.Require().True(c.SendAll([]byte("a"), []byte("b")))
+	c.In() <- []byte("c")
+	s.Require().Equal([]byte("a"), <-c.Out())
+	s.Require().Equal([]byte("b"), <-c.Out())
+	s.Require().Equal([]byte("c"), <-c.Out())
+}
+
+func (s *ChanTestSuite) TestClose() {
+	c := NewUnboundedChan[int]()
+	s.Require().True(c.SendAll(1, 2))
+	c.Close()
+	<-c.Done()
+
+	s.Require().False(c.SendAll(3))
+	s.Require().Equal([]int{1, 2}, c.Dump())
+}
+
+func (s *ChanTestSuite) TestBuffered() {
+	c := NewBufferedChan[int](1)
+	defer c.Close()
+	s.Require().Equal(uint64(1), c.Cap())
+
+	c.In() <- 1
+	c.In() <- 2
+	s.Require().Equal(ErrChannelFull, <-c.Err())
+	s.Require().Equal(1, <-c.Out())
+
+	select {
+	case <-c.Out():
+		s.Require().True(false)
+	default:
+	}
+}
+
+func (s *ChanTestSuite) TestZeroCap() {
+	c := NewBufferedChan[int](0)
+	defer c.Close()
+	s.Require().Equal(uint64(0), c.Cap())
+
+	c.In() <- 1
+	s.Require().Equal(ErrChannelFull, <-c.Err())
+	select {
+	case <-c.Out():
+		s.Require().True(false)
+	default:
+	}
+}
+
+func TestChan(t *testing.T) {
+	suite.Run(t, new(ChanTestSuite))
+}
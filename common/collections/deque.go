@@ -0,0 +1,216 @@
+//go:build go1.18
+
+// Package collections provides typed containers, like deques, ring buffers
+// and unbounded channels. It requires Go 1.18 for generics.
+package collections
+
+// Deque is a double-ended queue backed by a ring of power-of-two capacity,
+// which grows and shrinks with its length.
+type Deque[T any] struct {
+	start uint64
+	end   uint64
+	cap   uint64
+	len   uint64
+	data  []T
+}
+
+// NewDeque returns an empty deque.
+func NewDeque[T any]() *Deque[T] {
+	return &Deque[T]{
+		cap:  1,
+		data: make([]T, 1),
+	}
+}
+
+func (d *Deque[T]) copyTo(data []T) {
+	if d.len == 0 {
+		return
+	}
+	if d.start < d.end {
+		copy(data, d.data[d.start:d.end])
+	} else {
+		copy(data, d.data[d.start:d.cap])
+		copy(data[d.cap-d.start:], d.data[:d.end])
+	}
+}
+
+func (d *Deque[T]) resize(c uint64) {
+	data := make([]T, c)
+	d.copyTo(data)
+	d.cap = c
+	d.data = data
+	d.start = 0
+	d.end = d.len
+}
+
+func (d *Deque[T]) expand() {
+	d.resize(d.cap << 1)
+}
+
+func (d *Deque[T]) shrink() {
+	d.resize(d.cap >> 1)
+}
+
+func (d *Deque[T]) next(pos uint64) uint64 {
+	return (pos + 1) % d.cap
+}
+
+func (d *Deque[T]) prev(pos uint64) uint64 {
+	// Because pos type is unsigned and cap is pow of 2, loop back works.
+	return (pos - 1) % d.cap
+}
+
+// PushBack pushes an object to back.
+func (d *Deque[T]) PushBack(obj T) {
+	if d.cap == d.len {
+		d.expand()
+	}
+	d.data[d.end] = obj
+	d.end = d.next(d.end)
+	d.len = d.len + 1
+}
+
+// PushFront pushes an object to front.
+func (d *Deque[T]) PushFront(obj T) {
+	if d.cap == d.len {
+		d.expand()
+	}
+	d.start = d.prev(d.start)
+	d.data[d.start] = obj
+	d.len = d.len + 1
+}
+
+// PushBackAll pushes objs to back in order, growing at most once.
+func (d *Deque[T]) PushBackAll(objs ...T) {
+	c := d.cap
+	for c < d.len+uint64(len(objs)) {
+		c <<= 1
+	}
+	if c != d.cap {
+		d.resize(c)
+	}
+	for _, obj := range objs {
+		d.data[d.end] = obj
+		d.end = d.next(d.end)
+	}
+	d.len += uint64(len(objs))
+}
+
+// PopBack pops an object from back.
+func (d *Deque[T]) PopBack() (T, bool) {
+	var zero T
+	if d.len == 0 {
+		return zero, false
+	}
+	d.end = d.prev(d.end)
+	ret := d.data[d.end]
+	d.data[d.end] = zero
+	d.len = d.len - 1
+	if (d.cap >> 2) > d.len {
+		d.shrink()
+	}
+	return ret, true
+}
+
+// PopFront pops an object from front.
+func (d *Deque[T]) PopFront() (T, bool) {
+	var zero T
+	if d.len == 0 {
+		return zero, false
+	}
+	ret := d.data[d.start]
+	d.data[d.start] = zero
+	d.start = d.next(d.start)
+	d.len = d.len - 1
+	if (d.cap >> 2) > d.len {
+		d.shrink()
+	}
+	return ret, true
+}
+
+// PopFrontN pops at most n objects from front, shrinking at most once.
+func (d *Deque[T]) PopFrontN(n uint64) []T {
+	if n > d.len {
+		n = d.len
+	}
+	var zero T
+	objs := make([]T, n)
+	for i := range objs {
+		objs[i] = d.data[d.start]
+		d.data[d.start] = zero
+		d.start = d.next(d.start)
+	}
+	d.len -= n
+	c := d.cap
+	for c > 1 && (c>>2) > d.len {
+		c >>= 1
+	}
+	if c != d.cap {
+		d.resize(c)
+	}
+	return objs
+}
+
+// Head peeks head.
+func (d *Deque[T]) Head() (T, bool) {
+	if d.len == 0 {
+		var zero T
+		return zero, false
+	}
+	return d.data[d.start], true
+}
+
+// Back peeks back.
+func (d *Deque[T]) Back() (T, bool) {
+	if d.len == 0 {
+		var zero T
+		return zero, false
+	}
+	return d.data[d.prev(d.end)], true
+}
+
+// At returns the i-th object from front.
+func (d *Deque[T]) At(i uint64) (T, bool) {
+	if i >= d.len {
+		var zero T
+		return zero, false
+	}
+	return d.data[(d.start+i)%d.cap], true
+}
+
+// Cap returns capacity.
+func (d *Deque[T]) Cap() uint64 {
+	return d.cap
+}
+
+// Len returns length.
+func (d *Deque[T]) Len() uint64 {
+	return d.len
+}
+
+// Clear removes all objects.
+func (d *Deque[T]) Clear() {
+	*d = *NewDeque[T]()
+}
+
+// Slice converts to slice.
+func (d *Deque[T]) Slice() []T {
+	data := make([]T, d.len)
+	d.copyTo(data)
+	return data
+}
+
+// Each traverses the objects from front to back.
+func (d *Deque[T]) Each(f func(T)) {
+	for i := uint64(0); i < d.len; i++ {
+		f(d.data[(d.start+i)%d.cap])
+	}
+}
+
+// Iter returns an iterator of the objects from front to back. The deque
+// shouldn't be modified during iterations.
+func (d *Deque[T]) Iter() *Iterator[T] {
+	return newIterator(d.len, func(i uint64) T {
+		return d.data[(d.start+i)%d.cap]
+	})
+}
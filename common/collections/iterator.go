@@ -0,0 +1,42 @@
+//go:build go1.18
+
+package collections
+
+// Iterator iterates objects of a container.
+//
+//	it := d.Iter()
+//	for it.Next() {
+//		v := it.Value()
+//	}
+type Iterator[T any] struct {
+	len   uint64
+	next  uint64
+	at    func(i uint64) T
+	value T
+}
+
+func newIterator[T any](n uint64, at func(i uint64) T) *Iterator[T] {
+	return &Iterator[T]{len: n, at: at}
+}
+
+// Next advances to the next object, returning false if there're no more.
+func (it *Iterator[T]) Next() bool {
+	if it.next >= it.len {
+		var zero T
+		it.value = zero
+		return false
+	}
+	it.value = it.at(it.next)
+	it.next++
+	return true
+}
+
+// Value returns the current object.
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Index returns the index of the current object.
+func (it *Iterator[T]) Index() uint64 {
+	return it.next - 1
+}
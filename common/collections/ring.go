@@ -0,0 +1,161 @@
+//go:build go1.18
+
+package collections
+
+// RingBuffer provides a deque style, limited, auto rotate buffer.
+type RingBuffer[T any] struct {
+	limit uint64
+	start uint64
+	end   uint64
+	size  uint64
+	data  []T
+}
+
+// NewRingBuffer creates a RingBuffer with given limit size.
+func NewRingBuffer[T any](limit uint64) *RingBuffer[T] {
+	return &RingBuffer[T]{
+		limit: limit,
+		data:  make([]T, limit),
+	}
+}
+
+func (b *RingBuffer[T]) prev(i uint64) uint64 {
+	if i == 0 {
+		return b.limit - 1
+	}
+	return i - 1
+}
+
+func (b *RingBuffer[T]) next(i uint64) uint64 {
+	return (i + 1) % b.limit
+}
+
+// PushFront add one object in the front, the one in back may be rotated out.
+func (b *RingBuffer[T]) PushFront(d T) {
+	if b.size == b.limit {
+		b.PopBack()
+	}
+	b.start = b.prev(b.start)
+	b.data[b.start] = d
+	b.size++
+}
+
+// PushBack add one object in the back, the one in front may be rotated out.
+func (b *RingBuffer[T]) PushBack(d T) {
+	if b.size == b.limit {
+		b.PopFront()
+	}
+	b.data[b.end] = d
+	b.end = b.next(b.end)
+	b.size++
+}
+
+// PushBackAll adds objects in the back in order. Only the last Capacity
+// objects are kept if there're more.
+func (b *RingBuffer[T]) PushBackAll(ds ...T) {
+	if uint64(len(ds)) > b.limit {
+		ds = ds[uint64(len(ds))-b.limit:]
+	}
+	for _, d := range ds {
+		b.PushBack(d)
+	}
+}
+
+// PopFront pops the object in front.
+func (b *RingBuffer[T]) PopFront() (T, bool) {
+	var zero T
+	if b.size == 0 {
+		return zero, false
+	}
+	d := b.data[b.start]
+	b.data[b.start] = zero
+	b.start = b.next(b.start)
+	b.size--
+	return d, true
+}
+
+// PopBack pops the object in back.
+func (b *RingBuffer[T]) PopBack() (T, bool) {
+	var zero T
+	if b.size == 0 {
+		return zero, false
+	}
+	b.end = b.prev(b.end)
+	d := b.data[b.end]
+	b.data[b.end] = zero
+	b.size--
+	return d, true
+}
+
+// Head returns the object in front.
+func (b *RingBuffer[T]) Head() (T, bool) {
+	if b.size == 0 {
+		var zero T
+		return zero, false
+	}
+	return b.data[b.start], true
+}
+
+// Back returns the object in back.
+func (b *RingBuffer[T]) Back() (T, bool) {
+	if b.size == 0 {
+		var zero T
+		return zero, false
+	}
+	return b.data[b.prev(b.end)], true
+}
+
+// Len returns the number of object in buffer.
+func (b *RingBuffer[T]) Len() uint64 {
+	return b.size
+}
+
+// Capacity returns the capacity of buffer.
+func (b *RingBuffer[T]) Capacity() uint64 {
+	return b.limit
+}
+
+// Clear removes all objects.
+func (b *RingBuffer[T]) Clear() {
+	var zero T
+	for i := range b.data {
+		b.data[i] = zero
+	}
+	b.start, b.end, b.size = 0, 0, 0
+}
+
+// Each traverses the objects from front to back.
+func (b *RingBuffer[T]) Each(f func(T)) {
+	if b.size == 0 {
+		return
+	}
+	if b.start < b.end {
+		for _, v := range b.data[b.start:b.end] {
+			f(v)
+		}
+	} else {
+		for _, v := range b.data[b.start:b.limit] {
+			f(v)
+		}
+		for _, v := range b.data[0:b.end] {
+			f(v)
+		}
+	}
+}
+
+// Slice returns the objects from front to back.
+func (b *RingBuffer[T]) Slice() []T {
+	data := make([]T, 0, b.size)
+	b.Each(func(v T) {
+		data = append(data, v)
+	})
+	return data
+}
+
+// Iter returns an iterator of the objects from front to back. The buffer
+// shouldn't be modified during iterations.
+func (b *RingBuffer[T]) Iter() *Iterator[T] {
+	return newIterator(b.size, func(i uint64) T {
+		return b.data[(b.start+i)%b.limit]
+	})
+}
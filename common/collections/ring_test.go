@@ -0,0 +1,247 @@
+//go:build go1.18
+
+package collections
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RingBufferTestSuite struct {
+	suite.Suite
+}
+
+func (s *RingBufferTestSuite) TestPushBackPopFront() {
+	expected := []RingBuffer[interface{}]{
+		{
+			start: 0,
+			end:   1,
+			size:  1,
+			limit: 3,
+			data:  []interface{}{0, nil, nil},
+		},
+		{
+			start: 0,
+			end:   2,
+			size:  2,
+			limit: 3,
+			data:  []interface{}{0, 1, nil},
+		},
+		{
+			start: 0,
+			end:   0,
+			size:  3,
+			limit: 3,
+			data:  []interface{}{0, 1, 2},
+		},
+		{
+			start: 1,
+			end:   1,
+			size:  3,
+			limit: 3,
+			data:  []interface{}{3, 1, 2},
+		},
+		{
+			start: 2,
+			end:   2,
+			size:  3,
+			limit: 3,
+			data:  []interface{}{3, 4, 2},
+		},
+		{
+			start: 0,
+			end:   2,
+			size:  2,
+			limit: 3,
+			data:  []interface{}{3, 4, nil},
+		},
+		{
+			start: 1,
+			end:   2,
+			size:  1,
+			limit: 3,
+			data:  []interface{}{nil, 4, nil},
+		},
+		{
+			start: 2,
+			end:   2,
+			size:  0,
+			limit: 3,
+			data:  []interface{}{nil, nil, nil},
+		},
+	}
+	b := NewRingBuffer[interface{}](3)
+	idx := 0
+	for i := 0; i < 5; i++ {
+		b.PushBack(i)
+		s.Require().Equal(expected[idx], *b)
+		idx++
+	}
+	for i := 0; i < 3; i++ {
+		b.PopFront()
+		s.Require().Equal(expected[idx], *b)
+		idx++
+	}
+}
+
+func (s *RingBufferTestSuite) TestPushFrontPopBack() {
+	expected := []RingBuffer[interface{}]{
+		{
+			start: 2,
+			end:   0,
+			size:  1,
+			limit: 3,
+			data:  []interface{}{nil, nil, 0},
+		},
+		{
+			start: 1,
+			end:   0,
+			size:  2,
+			limit: 3,
+			data:  []interface{}{nil, 1, 0},
+		},
+		{
+			start: 0,
+			end:   0,
+			size:  3,
+			limit: 3,
+			data:  []interface{}{2, 1, 0},
+		},
+		{
+			start: 2,
+			end:   2,
+			size:  3,
+			limit: 3,
+			data:  []interface{}{2, 1, 3},
+		},
+		{
+			start: 1,
+			end:   1,
+			size:  3,
+			limit: 3,
+			data:  []interface{}{2, 4, 3},
+		},
+		{
+			start: 1,
+			end:   0,
+			size:  2,
+			limit: 3,
+			data:  []interface{}{nil, 4, 3},
+		},
+		{
+			start: 1,
+			end:   2,
+			size:  1,
+			limit: 3,
+			data:  []interface{}{nil, 4, nil},
+		},
+		{
+			start: 1,
+			end:   1,
+			size:  0,
+			limit: 3,
+			data:  []interface{}{nil, nil, nil},
+		},
+	}
+	b := NewRingBuffer[interface{}](3)
+	idx := 0
+	for i := 0; i < 5; i++ {
+		b.PushFront(i)
+		s.Require().Equal(expected[idx], *b)
+		idx++
+	}
+	for i := 0; i < 3; i++ {
+		b.PopBack()
+		s.Require().Equal(expected[idx], *b)
+		idx++
+	}
+}
+
+func (s *RingBufferTestSuite) TestHeadBack() {
+	b := NewRingBuffer[interface{}](3)
+
+	data, ok := b.Head()
+	s.Require().Nil(data)
+	s.Require().False(ok)
+
+	data, ok = b.Back()
+	s.Require().Nil(data)
+	s.Require().False(ok)
+
+	b.PushBack(1)
+	b.PushBack(2)
+
+	data, ok = b.Head()
+	s.Require().Equal(1, data)
+	s.Require().True(ok)
+
+	data, ok = b.Back()
+	s.Require().Equal(2, data)
+	s.Require().True(ok)
+
+	b.PushBack(3)
+	b.PushBack(4)
+
+	data, ok = b.Head()
+	s.Require().Equal(2, data)
+	s.Require().True(ok)
+
+	data, ok = b.Back()
+	s.Require().Equal(4, data)
+	s.Require().True(ok)
+
+	b.PushFront(1)
+
+	data, ok = b.Head()
+	s.Require().Equal(1, data)
+	s.Require().True(ok)
+
+	data, ok = b.Back()
+	s.Require().Equal(3, data)
+	s.Require().True(ok)
+}
+
+func (s *RingBufferTestSuite) TestEach() {
+	b := NewRingBuffer[interface{}](5)
+
+	for i := 0; i < 8; {
+		b.PushBack(i)
+		i++
+		b.PushFront(i)
+		i++
+	}
+
+	expected := []interface{}{7, 3, 1, 0, 2}
+	result := []interface{}{}
+
+	b.Each(func(v interface{}) {
+		result = append(result, v)
+	})
+	s.Require().Equal(expected, result)
+}
+
+func (s *RingBufferTestSuite) TestBulk() {
+	b := NewRingBuffer[int](3)
+	b.PushBackAll(1, 2)
+	s.Require().Equal([]int{1, 2}, b.Slice())
+	b.PushBackAll(3, 4)
+	s.Require().Equal([]int{2, 3, 4}, b.Slice())
+	b.PushBackAll(5, 6, 7, 8, 9)
+	s.Require().Equal([]int{7, 8, 9}, b.Slice())
+
+	var values []int
+	it := b.Iter()
+	for it.Next() {
+		values = append(values, it.Value())
+	}
+	s.Require().Equal([]int{7, 8, 9}, values)
+
+	b.Clear()
+	s.Require().Equal(*NewRingBuffer[int](3), *b)
+	s.Require().False(b.Iter().Next())
+}
+
+func TestRingBuffer(t *testing.T) {
+	suite.Run(t, new(RingBufferTestSuite))
+}
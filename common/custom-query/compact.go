@@ -0,0 +1,262 @@
+package customquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apicontext "github.com/jiarung/mochi/common/api/context"
+	apierrors "github.com/jiarung/mochi/common/api/errors"
+)
+
+// ParseError is the error of a compact filter or sort, with the position of
+// the invalid token.
+type ParseError struct {
+	// Param is the query parameter, filter or sort.
+	Param string
+	// Pos is the 1-based byte position of Token in Param.
+	Pos int
+	// Reason is the snake case reason, e.g. unknown_operator.
+	Reason string
+	Token  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid %s at %d: %s %q", e.Param, e.Pos,
+		strings.Replace(e.Reason, "_", " ", -1), e.Token)
+}
+
+// Code returns the argument of apierrors.InvalidQueryParameterAt, like
+// filter_at_12_unknown_operator.
+func (e *ParseError) Code() string {
+	return fmt.Sprintf("%s_at_%d_%s", e.Param, e.Pos, e.Reason)
+}
+
+// setOptionError sets err of parsing or applying options to appCtx, or code
+// if it isn't a ParseError or CostError. ParseErrors only come from compact
+// syntax and the projection params, so requests of JSON options keep their
+// codes.
+func setOptionError(appCtx *apicontext.AppContext, err error, code string) {
+	switch err := err.(type) {
+	case *ParseError:
+		appCtx.SetError(apierrors.InvalidQueryParameterAt, err.Code())
+		return
+	case *CostError:
+		appCtx.SetError(apierrors.QueryTooExpensive)
+		return
+	}
+	appCtx.SetError(code)
+}
+
+// isCompactFilter returns whether query filter is in compact syntax. Values
+// which look like JSON are decoded as JSON as before, so their errors keep
+// their codes.
+func isCompactFilter(filter string) bool {
+	trimmed := strings.TrimSpace(filter)
+	if len(trimmed) == 0 || json.Valid([]byte(trimmed)) {
+		return false
+	}
+	switch trimmed[0] {
+	case '{', '[', '"':
+		return false
+	}
+	return true
+}
+
+// compactOperators maps operators of compact filters to filter operators.
+var compactOperators = map[string]string{
+	"eq":      equalOperatorStr,
+	"ne":      notEqualOperatorStr,
+	"gt":      greaterThanOperatorStr,
+	"gte":     greaterThanOrEqualOperatorStr,
+	"lt":      smallerThanOperatorStr,
+	"lte":     smallerThanOrEqualOperatorStr,
+	"in":      inOperatorStr,
+	"like":    likeOperatorStr,
+	"ilike":   ilikeOperatorStr,
+	"between": betweenOperatorStr,
+}
+
+// compactColumn is a column in a compact filter or sort, kept to report the
+// position of invalid columns when options are applied.
+type compactColumn struct {
+	param  string
+	column string
+	pos    int
+}
+
+// validate returns a ParseError of reason if c isn't in validColumnMap.
+func (c *compactColumn) validate(validColumnMap map[string]struct{},
+	reason string) error {
+	if _, ok := validColumnMap[c.column]; !ok {
+		return &ParseError{
+			Param: c.param, Pos: c.pos, Reason: reason, Token: c.column,
+		}
+	}
+	return nil
+}
+
+// token is a part of compact syntax with its 0-based position.
+type token struct {
+	text string
+	pos  int
+}
+
+// splitEscaped splits s from offset by unescaped sep, at most n parts if n
+// is positive. Escapes are kept, and removed by unescape.
+func splitEscaped(param, s string, offset int, sep byte, n int) (
+	[]token, error) {
+	var tokens []token
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			if i+1 == len(s) {
+				return nil, &ParseError{Param: param, Pos: offset + i + 1,
+					Reason: "unterminated_escape", Token: s[start:]}
+			}
+			i++
+			continue
+		}
+		if s[i] == sep && (n <= 0 || len(tokens) < n-1) {
+			tokens = append(tokens, token{s[start:i], offset + start})
+			start = i + 1
+		}
+	}
+	return append(tokens, token{s[start:], offset + start}), nil
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isColumn returns whether s is a plain column name, so columns can't inject
+// SQL before they're validated against models.
+func isColumn(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCompactFilter parses a compact filter like
+// "status:eq:filled,created_at:gte:2020-01-01" into Filter. Terms are joined
+// by AND, and each term is column:operator:value with operators eq, ne, gt,
+// gte, lt, lte, in, like, ilike and between. Values of in and between are
+// separated by "|", and "\" escapes ",", ":", "|" and "\" in values.
+func ParseCompactFilter(s string) (Filter, error) {
+	f, _, err := parseCompactFilter("filter", s)
+	return f, err
+}
+
+// parseCompactFilter parses a compact filter of param, filter or having.
+func parseCompactFilter(param, s string) (Filter, []compactColumn, error) {
+	terms, err := splitEscaped(param, s, 0, ',', 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var columns []compactColumn
+	filters := make([]interface{}, 0, len(terms))
+	for _, term := range terms {
+		if len(term.text) == 0 {
+			return nil, nil, &ParseError{
+				Param: param, Pos: term.pos + 1, Reason: "empty_term"}
+		}
+		parts, err := splitEscaped(param, term.text, term.pos, ':', 3)
+		if err != nil {
+			return nil, nil, err
+		}
+		column := parts[0]
+		if !isColumnPath(column.text) {
+			return nil, nil, &ParseError{Param: param, Pos: column.pos + 1,
+				Reason: "invalid_column", Token: column.text}
+		}
+		if len(parts) < 2 {
+			return nil, nil, &ParseError{Param: param,
+				Pos: column.pos + len(column.text) + 1, Reason: "missing_operator",
+				Token: term.text}
+		}
+		op, ok := compactOperators[parts[1].text]
+		if !ok {
+			return nil, nil, &ParseError{Param: param, Pos: parts[1].pos + 1,
+				Reason: "unknown_operator", Token: parts[1].text}
+		}
+		if len(parts) < 3 {
+			return nil, nil, &ParseError{Param: param,
+				Pos: parts[1].pos + len(parts[1].text) + 1, Reason: "missing_value",
+				Token: term.text}
+		}
+
+		var value interface{} = unescape(parts[2].text)
+		if op == inOperatorStr || op == betweenOperatorStr {
+			items, err := splitEscaped(param, parts[2].text, parts[2].pos, '|', 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			if op == betweenOperatorStr && len(items) != 2 {
+				return nil, nil, &ParseError{Param: param, Pos: parts[2].pos + 1,
+					Reason: "invalid_between", Token: parts[2].text}
+			}
+			values := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				values = append(values, unescape(item.text))
+			}
+			value = values
+		}
+		columns = append(columns, compactColumn{param, column.text, column.pos + 1})
+		filters = append(filters, map[string]interface{}{
+			op: map[string]interface{}{
+				"column": column.text,
+				"value":  value,
+			},
+		})
+	}
+	if len(filters) == 1 {
+		return Filter(filters[0].(map[string]interface{})), columns, nil
+	}
+	return Filter{andOperatorStr: filters}, columns, nil
+}
+
+// ParseSort parses a sort like "-created_at,id" into Order. Columns are
+// descending if they're prefixed by "-", and ascending without a prefix or
+// with "+".
+func ParseSort(s string) (Order, error) {
+	o, _, err := parseSort(s)
+	return o, err
+}
+
+func parseSort(s string) (Order, []compactColumn, error) {
+	const param = "sort"
+	var o Order
+	var columns []compactColumn
+	pos := 0
+	for _, part := range strings.Split(s, ",") {
+		column, keyword, columnPos := part, "asc", pos
+		if strings.HasPrefix(column, "-") || strings.HasPrefix(column, "+") {
+			if column[0] == '-' {
+				keyword = "desc"
+			}
+			column, columnPos = column[1:], columnPos+1
+		}
+		if !isColumn(column) {
+			return nil, nil, &ParseError{Param: param, Pos: columnPos + 1,
+				Reason: "invalid_column", Token: part}
+		}
+		o = append(o, order{Column: column, Keyword: keyword})
+		columns = append(columns, compactColumn{param, column, columnPos + 1})
+		pos += len(part) + 1
+	}
+	return o, columns, nil
+}
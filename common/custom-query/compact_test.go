@@ -0,0 +1,131 @@
+package customquery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// CompactSuite tests compact filter and sort syntax.
+type CompactSuite struct {
+	suite.Suite
+	validColumnMap map[string]struct{}
+}
+
+func (suite *CompactSuite) SetupSuite() {
+	suite.validColumnMap = map[string]struct{}{
+		"status":     struct{}{},
+		"created_at": struct{}{},
+		"side":       struct{}{},
+		"note":       struct{}{},
+	}
+}
+
+func (suite *CompactSuite) TestParseCompactFilter() {
+	f, err := ParseCompactFilter("status:eq:filled")
+	suite.Require().Nil(err)
+	suite.Require().Equal(Filter{
+		"equal": map[string]interface{}{"column": "status", "value": "filled"},
+	}, f)
+
+	f, err = ParseCompactFilter(
+		`status:eq:filled,created_at:between:2020-01-01T00:00:00Z|2020-02-01,` +
+			`side:in:buy|sell,note:like:a\,b\:c\|d\\%`)
+	suite.Require().Nil(err)
+	format, values, err := evaluateFilterWithValidator(f, suite.validColumnMap)
+	suite.Require().Nil(err)
+	suite.Require().Equal(`("status" = ?) AND ("created_at" BETWEEN ? AND ?)`+
+		` AND ("side" IN (?)) AND ("note" LIKE ?)`, format)
+	suite.Require().Equal([]interface{}{
+		"filled",
+		"2020-01-01T00:00:00Z", "2020-02-01",
+		[]interface{}{"buy", "sell"},
+		`a,b:c|d\%`,
+	}, values)
+
+	// Empty values are allowed.
+	f, err = ParseCompactFilter("note:eq:")
+	suite.Require().Nil(err)
+	suite.Require().Equal("", f["equal"].(map[string]interface{})["value"])
+}
+
+func (suite *CompactSuite) TestParseCompactFilterError() {
+	cases := []struct {
+		filter string
+		pos    int
+		reason string
+	}{
+		{"status:eqq:filled", 8, "unknown_operator"},
+		{"status:eq:filled,,side:eq:buy", 18, "empty_term"},
+		{"status:eq:filled,side", 22, "missing_operator"},
+		{"status:eq:filled,side:in", 25, "missing_value"},
+		{`status";drop:eq:x`, 1, "invalid_column"},
+		{"created_at:between:a|b|c", 20, "invalid_between"},
+		{`note:eq:abc\`, 12, "unterminated_escape"},
+	}
+	for _, c := range cases {
+		_, err := ParseCompactFilter(c.filter)
+		parseErr, ok := err.(*ParseError)
+		suite.Require().True(ok, c.filter)
+		suite.Require().Equal("filter", parseErr.Param, c.filter)
+		suite.Require().Equal(c.pos, parseErr.Pos, c.filter)
+		suite.Require().Equal(c.reason, parseErr.Reason, c.filter)
+	}
+
+	_, err := ParseCompactFilter("status:eqq:filled")
+	suite.Require().Equal(`invalid filter at 8: unknown operator "eqq"`,
+		err.Error())
+	suite.Require().Equal("filter_at_8_unknown_operator",
+		err.(*ParseError).Code())
+}
+
+func (suite *CompactSuite) TestIsCompactFilter() {
+	suite.Require().True(isCompactFilter("status:eq:filled"))
+	suite.Require().True(isCompactFilter(" side:in:buy|sell"))
+	for _, filter := range []string{
+		`{"equal": {"column": "status", "value": "filled"}}`,
+		` {"equal": `, `["status"]`, `"status"`, "null", "1", "",
+	} {
+		suite.Require().False(isCompactFilter(filter), filter)
+	}
+}
+
+func (suite *CompactSuite) TestParseSort() {
+	o, err := ParseSort("-created_at,+side,status")
+	suite.Require().Nil(err)
+	suite.Require().Equal(Order{
+		{Column: "created_at", Keyword: "desc"},
+		{Column: "side", Keyword: "asc"},
+		{Column: "status", Keyword: "asc"},
+	}, o)
+
+	_, err = ParseSort("-created_at,--side")
+	suite.Require().Equal(&ParseError{
+		Param: "sort", Pos: 14, Reason: "invalid_column", Token: "--side",
+	}, err)
+}
+
+func (suite *CompactSuite) TestValidateCompactColumns() {
+	filter, columns, err := parseCompactFilter("filter", "status:eq:a,secret:eq:b")
+	suite.Require().Nil(err)
+	order, sortColumns, err := parseSort("-created_at,password")
+	suite.Require().Nil(err)
+	opt := SQLOptions{
+		Filter:         &filter,
+		Order:          &order,
+		compactColumns: append(columns, sortColumns...),
+	}
+
+	err = opt.validateCompactColumns("filter", suite.validColumnMap)
+	suite.Require().Equal(&ParseError{
+		Param: "filter", Pos: 13, Reason: "unknown_column", Token: "secret",
+	}, err)
+	err = opt.validateCompactColumns("sort", suite.validColumnMap)
+	suite.Require().Equal(&ParseError{
+		Param: "sort", Pos: 13, Reason: "unknown_column", Token: "password",
+	}, err)
+}
+
+func TestCompact(t *testing.T) {
+	suite.Run(t, new(CompactSuite))
+}
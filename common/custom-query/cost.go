@@ -0,0 +1,192 @@
+package customquery
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jiarung/gorm"
+)
+
+// seqScanNode is the node type of sequential scans in plans.
+const seqScanNode = "Seq Scan"
+
+// CostError is returned if the plan of a query is rejected by CostGuard.
+type CostError struct {
+	// Cost is the estimated total cost of the plan.
+	Cost float64
+	// MaxCost is the max cost of CostGuard.
+	MaxCost float64
+	// Table is the protected table scanned sequentially, which is empty if
+	// the plan is rejected by its cost.
+	Table string
+}
+
+func (e *CostError) Error() string {
+	if len(e.Table) > 0 {
+		return fmt.Sprintf("query scans protected table %s sequentially", e.Table)
+	}
+	return fmt.Sprintf("query cost %.2f exceeds %.2f", e.Cost, e.MaxCost)
+}
+
+// CostGuard rejects expensive queries by their plans before they're
+// executed, and cancels queries running too long.
+type CostGuard struct {
+	// MaxCost is the max estimated total cost of plans if it's positive.
+	MaxCost float64
+	// ProtectedTables are tables which can't be scanned sequentially.
+	ProtectedTables []string
+	// StatementTimeout cancels statements running longer if it's positive.
+	StatementTimeout time.Duration
+}
+
+// planNode is a node of plans of EXPLAIN (FORMAT JSON).
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	TotalCost    float64    `json:"Total Cost"`
+	Plans        []planNode `json:"Plans"`
+}
+
+// parsePlan parses the output of EXPLAIN (FORMAT JSON).
+func parsePlan(data []byte) (*planNode, error) {
+	var plans []struct {
+		Plan planNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal plan. err: %v", err)
+	}
+	if len(plans) == 0 {
+		return nil, errors.New("empty plan")
+	}
+	return &plans[0].Plan, nil
+}
+
+// checkPlan returns *CostError if plan exceeds MaxCost or scans protected
+// tables sequentially.
+func (g *CostGuard) checkPlan(plan *planNode) error {
+	if g.MaxCost > 0 && plan.TotalCost > g.MaxCost {
+		return &CostError{Cost: plan.TotalCost, MaxCost: g.MaxCost}
+	}
+	return g.checkScans(plan)
+}
+
+func (g *CostGuard) checkScans(node *planNode) error {
+	if node.NodeType == seqScanNode {
+		for _, table := range g.ProtectedTables {
+			if node.RelationName == table {
+				return &CostError{Cost: node.TotalCost, MaxCost: g.MaxCost,
+					Table: table}
+			}
+		}
+	}
+	for i := range node.Plans {
+		if err := g.checkScans(&node.Plans[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check explains query without executing it, and returns *CostError if
+// its plan is rejected.
+func (g *CostGuard) Check(query *gorm.DB) error {
+	return g.explain(query, "?", query.QueryExpr())
+}
+
+// explain explains sql with values by db, and checks its plan.
+func (g *CostGuard) explain(db *gorm.DB, sql string,
+	values ...interface{}) error {
+	rows, err := db.New().Raw("EXPLAIN (FORMAT JSON) "+sql, values...).Rows()
+	if err != nil {
+		return fmt.Errorf("failed to explain query. err: %v", err)
+	}
+	defer rows.Close()
+
+	var data []byte
+	if rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			return fmt.Errorf("failed to scan plan. err: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to explain query. err: %v", err)
+	}
+	plan, err := parsePlan(data)
+	if err != nil {
+		return err
+	}
+	return g.checkPlan(plan)
+}
+
+// costGuardSavepoint is the savepoint of Run in transactions.
+const costGuardSavepoint = "custom_query_cost_guard"
+
+// Run runs fn with db in a transaction whose statements time out after
+// StatementTimeout. The transaction is read only and always rolled back. If
+// db is a transaction already, fn runs in a savepoint of it instead, which
+// is rolled back to. fn runs with db directly if g is nil.
+func (g *CostGuard) Run(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if g == nil {
+		return fn(db)
+	}
+	tx := db
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		err := db.New().Exec("SAVEPOINT " + costGuardSavepoint).Error
+		if err != nil {
+			return fmt.Errorf("failed to create savepoint. err: %v", err)
+		}
+		defer func() {
+			db.New().Exec("ROLLBACK TO SAVEPOINT " + costGuardSavepoint)
+			db.New().Exec("RELEASE SAVEPOINT " + costGuardSavepoint)
+		}()
+	} else {
+		tx = db.Begin()
+		if tx.Error != nil {
+			return fmt.Errorf("failed to begin transaction. err: %v", tx.Error)
+		}
+		defer tx.Rollback()
+	}
+
+	if err := tx.New().Exec("SET TRANSACTION READ ONLY").Error; err != nil {
+		return fmt.Errorf("failed to set read only. err: %v", err)
+	}
+	if g.StatementTimeout > 0 {
+		// SET doesn't take bind parameters.
+		err := tx.New().Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d",
+			g.StatementTimeout.Nanoseconds()/int64(time.Millisecond))).Error
+		if err != nil {
+			return fmt.Errorf("failed to set statement timeout. err: %v", err)
+		}
+	}
+	return fn(tx)
+}
+
+// checkQuery checks query as it will be executed, e.g. with its page
+// applied. It does nothing if g is nil.
+func (g *CostGuard) checkQuery(query *gorm.DB) error {
+	if g == nil {
+		return nil
+	}
+	return g.Check(query)
+}
+
+// checkCount checks counting rows of the query of opt applied to db. Counts
+// are explained by a subquery, which is planned like counts of Apply. It
+// does nothing if g is nil.
+func (g *CostGuard) checkCount(opt *SQLOptions, db *gorm.DB) error {
+	if g == nil {
+		return nil
+	}
+	query, err := opt.applyFilterIfNeeded(db)
+	if err != nil {
+		return err
+	}
+	if query, err = opt.applyProjectionIfNeeded(query); err != nil {
+		return err
+	}
+	return g.explain(query, "SELECT count(*) FROM (?) AS counted",
+		query.QueryExpr())
+}
@@ -0,0 +1,61 @@
+package customquery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// CostSuite tests plans checked by CostGuard.
+type CostSuite struct {
+	suite.Suite
+}
+
+const testPlan = `[{"Plan": {
+	"Node Type": "Limit", "Total Cost": 120.5,
+	"Plans": [{
+		"Node Type": "Nested Loop", "Total Cost": 120.5,
+		"Plans": [
+			{"Node Type": "Index Scan", "Relation Name": "user", "Total Cost": 8.3},
+			{"Node Type": "Seq Scan", "Relation Name": "kyc", "Total Cost": 110.2}
+		]
+	}]
+}}]`
+
+func (suite *CostSuite) TestParsePlan() {
+	plan, err := parsePlan([]byte(testPlan))
+	suite.Require().Nil(err)
+	suite.Require().Equal("Limit", plan.NodeType)
+	suite.Require().Equal(120.5, plan.TotalCost)
+	suite.Require().Len(plan.Plans, 1)
+	suite.Require().Equal("kyc", plan.Plans[0].Plans[1].RelationName)
+
+	_, err = parsePlan([]byte(`[]`))
+	suite.Require().NotNil(err)
+	_, err = parsePlan(nil)
+	suite.Require().NotNil(err)
+}
+
+func (suite *CostSuite) TestCheckPlan() {
+	plan, err := parsePlan([]byte(testPlan))
+	suite.Require().Nil(err)
+
+	suite.Require().Nil((&CostGuard{}).checkPlan(plan))
+	suite.Require().Nil((&CostGuard{MaxCost: 200}).checkPlan(plan))
+	suite.Require().Nil((&CostGuard{ProtectedTables: []string{"user"}}).
+		checkPlan(plan))
+
+	err = (&CostGuard{MaxCost: 100}).checkPlan(plan)
+	suite.Require().Equal(&CostError{Cost: 120.5, MaxCost: 100}, err)
+	suite.Require().Equal("query cost 120.50 exceeds 100.00", err.Error())
+
+	err = (&CostGuard{ProtectedTables: []string{"user", "kyc"}}).
+		checkPlan(plan)
+	suite.Require().Equal(&CostError{Cost: 110.2, Table: "kyc"}, err)
+	suite.Require().Equal("query scans protected table kyc sequentially",
+		err.Error())
+}
+
+func TestCost(t *testing.T) {
+	suite.Run(t, new(CostSuite))
+}
@@ -0,0 +1,444 @@
+package customquery
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jiarung/gorm"
+
+	"github.com/jiarung/mochi/common/utils"
+)
+
+// ErrInvalidCursor is returned if a cursor is malformed, isn't signed by
+// the cursor key, or doesn't match the filter and order of the query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorKeyEnv is the env of the key signing cursors.
+const cursorKeyEnv = "CUSTOM_QUERY_CURSOR_KEY"
+
+// DefaultPrimaryKey is the primary key column breaking ties of orders in
+// cursor mode.
+const DefaultPrimaryKey = "id"
+
+var (
+	cursorKeyMutex sync.RWMutex
+	cursorKey      []byte
+)
+
+func init() {
+	key := []byte(os.Getenv(cursorKeyEnv))
+	if len(key) == 0 {
+		// Cursors signed by a random key are only valid within the process,
+		// which breaks paging across instances.
+		switch utils.Environment() {
+		case utils.LocalDevelopment, utils.CI:
+		default:
+			panic(fmt.Sprintf("%s is required", cursorKeyEnv))
+		}
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	cursorKey = key
+}
+
+// SetCursorKey sets the key signing cursors, which should be the same across
+// instances serving the same endpoints.
+func SetCursorKey(key []byte) {
+	cursorKeyMutex.Lock()
+	defer cursorKeyMutex.Unlock()
+	cursorKey = append([]byte(nil), key...)
+}
+
+func signCursor(payload []byte) []byte {
+	cursorKeyMutex.RLock()
+	defer cursorKeyMutex.RUnlock()
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// cursor is the position of a row in a query.
+type cursor struct {
+	// Order is the order of the query, including the primary key.
+	Order Order `json:"o"`
+	// Filter is the hash of the filter of the query.
+	Filter string `json:"f,omitempty"`
+	// Values are values of the row of columns in Order.
+	Values []interface{} `json:"v"`
+	// Backward means rows before the row are queried.
+	Backward bool `json:"b,omitempty"`
+}
+
+func (c *cursor) encode() (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signCursor(payload)), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, signCursor(payload)) {
+		return nil, ErrInvalidCursor
+	}
+	// Keep numbers as json.Number, so large integers aren't rounded.
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	c := &cursor{}
+	if err := decoder.Decode(c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if len(c.Values) != len(c.Order) {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// filterHash returns the hash of filter binding cursors to it.
+func filterHash(filter *Filter) string {
+	if filter == nil {
+		return ""
+	}
+	data, _ := json.Marshal(filter)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// ParseOrder parses an ORDER BY clause like "created_at DESC, id ASC".
+// Keywords are required.
+func ParseOrder(s string) (Order, error) {
+	var o Order
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid order(%v)", s)
+		}
+		o = append(o, order{
+			Column:  strings.Trim(fields[0], "\""),
+			Keyword: strings.ToLower(fields[1]),
+		})
+	}
+	return o, nil
+}
+
+func (o *order) desc() bool {
+	return o.Keyword == "desc"
+}
+
+func (o *order) reversed() order {
+	keyword := "desc"
+	if o.desc() {
+		keyword = "asc"
+	}
+	return order{Column: o.Column, Keyword: keyword}
+}
+
+// equal returns whether o and other are the same order.
+func (o Order) equal(other Order) bool {
+	if len(o) != len(other) {
+		return false
+	}
+	for i := range o {
+		if o[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// seek returns the condition of rows after values in order o. Orders of the
+// same direction use a row comparison like `("a", "id") < (?, ?)`, which
+// can be served by a composite index.
+func (o Order) seek(values []interface{}) (string, []interface{}) {
+	uniform := true
+	for _, order := range o {
+		uniform = uniform && order.desc() == o[0].desc()
+	}
+	operator := func(order order) string {
+		if order.desc() {
+			return "<"
+		}
+		return ">"
+	}
+	columns := make([]string, 0, len(o))
+	for _, order := range o {
+		columns = append(columns, fmt.Sprintf("\"%s\"", order.Column))
+	}
+	if uniform {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(o)), ", ")
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "),
+			operator(o[0]), placeholders), values
+	}
+
+	// Expand (a, b) > (x, y) into (a > x) OR (a = x AND b > y) otherwise.
+	var terms []string
+	var args []interface{}
+	for i, order := range o {
+		conds := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			conds = append(conds, columns[j]+" = ?")
+			args = append(args, values[j])
+		}
+		conds = append(conds, fmt.Sprintf("%s %s ?", columns[i], operator(order)))
+		args = append(args, values[i])
+		terms = append(terms, "("+strings.Join(conds, " AND ")+")")
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+// hasColumn returns whether column is a column of the model of db.
+func hasColumn(db *gorm.DB, column string) bool {
+	for _, field := range db.NewScope(db.Value).GetModelStruct().StructFields {
+		if field.DBName == column && !field.IsIgnored {
+			return true
+		}
+	}
+	return false
+}
+
+// CursorPage defines a page queried in cursor mode. It's returned by
+// ApplyCursor, and turned into Result by Result once rows are found.
+type CursorPage struct {
+	Limit int
+	// TotalCount is -1 if it isn't counted.
+	TotalCount int
+
+	order     Order
+	filter    string
+	backward  bool
+	hasCursor bool
+}
+
+// ApplyCursor applies db with query params in cursor mode. Rows are sought
+// from opt.Cursor by opt.Order, or defaultOrder if it's nil, with
+// primaryKey breaking ties. Total count is only queried if opt.Count is
+// true, and NoLimit isn't allowed.
+func (opt *SQLOptions) ApplyCursor(db *gorm.DB, defaultLimit int,
+	defaultOrder Order, primaryKey string) (
+	result *gorm.DB, page *CursorPage, err error) {
+	if defaultLimit < 1 {
+		return nil, nil, errors.New("default limit should be greater than 1")
+	}
+	if len(primaryKey) == 0 {
+		primaryKey = DefaultPrimaryKey
+	}
+	if opt.grouped() || opt.Having != nil {
+		return nil, nil, errors.New(
+			"cursor mode doesn't support group_by and aggregates")
+	}
+
+	result, err = opt.applyFilterIfNeeded(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	validColumnMap, err := opt.validColumnMap(db.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = opt.validateCompactColumns("sort", validColumnMap); err != nil {
+		return nil, nil, err
+	}
+	// The primary key is ordered by even if it isn't a filterable column,
+	// but it must be a column of the model.
+	if !hasColumn(db, primaryKey) {
+		return nil, nil, fmt.Errorf("primary key %s isn't a column of %T",
+			primaryKey, db.Value)
+	}
+	validColumnMap[primaryKey] = struct{}{}
+
+	o := defaultOrder
+	if opt.Order != nil {
+		o = *opt.Order
+	}
+	page = &CursorPage{
+		Limit:      *opt.limit(defaultLimit, false),
+		TotalCount: -1,
+		filter:     filterHash(opt.Filter),
+	}
+	hasPrimaryKey := false
+	for _, order := range o {
+		if err = order.validate(validColumnMap); err != nil {
+			return nil, nil, err
+		}
+		page.order = append(page.order, order)
+		hasPrimaryKey = hasPrimaryKey || order.Column == primaryKey
+	}
+	if !hasPrimaryKey {
+		// Follow the direction of the last order, so row comparison works for
+		// orders of the same direction.
+		keyword := "asc"
+		if len(page.order) > 0 {
+			keyword = page.order[len(page.order)-1].Keyword
+		}
+		page.order = append(page.order, order{
+			Column: primaryKey, Keyword: keyword,
+		})
+	}
+
+	// Columns of cursors are always selected.
+	columns := make([]string, 0, len(page.order))
+	for _, order := range page.order {
+		columns = append(columns, order.Column)
+	}
+	result, err = opt.applyProjectionIfNeeded(result, columns...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opt.Count != nil && *opt.Count {
+		var count int
+		if countErr := result.Count(&count).Error; countErr != nil {
+			return nil, nil, fmt.Errorf("failed to count result. err: %v",
+				countErr)
+		}
+		page.TotalCount = count
+	}
+
+	queryOrder := page.order
+	if opt.Cursor != nil && len(*opt.Cursor) > 0 {
+		c, decodeErr := decodeCursor(*opt.Cursor)
+		if decodeErr != nil {
+			return nil, nil, decodeErr
+		}
+		if !c.Order.equal(page.order) || c.Filter != page.filter {
+			return nil, nil, ErrInvalidCursor
+		}
+		page.hasCursor = true
+		page.backward = c.Backward
+		if c.Backward {
+			queryOrder = make(Order, 0, len(page.order))
+			for _, order := range page.order {
+				queryOrder = append(queryOrder, order.reversed())
+			}
+		}
+		format, values := queryOrder.seek(c.Values)
+		result = result.Where(format, values...)
+	}
+	for _, order := range queryOrder {
+		result = result.Order(fmt.Sprintf("\"%s\" %s", order.Column,
+			strings.ToUpper(order.Keyword)))
+	}
+	// Query one more row to know if there's a next page.
+	return result.Limit(page.Limit + 1), page, nil
+}
+
+// Result trims the extra row of rows, which is a pointer to the slice found
+// by the query of ApplyCursor, and returns the result with cursors of the
+// adjacent pages.
+func (p *CursorPage) Result(rows interface{}) (*Result, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("rows should be a pointer to slice. %T", rows)
+	}
+	v = v.Elem()
+	more := v.Len() > p.Limit
+	if more {
+		v.Set(v.Slice(0, p.Limit))
+	}
+	if p.backward {
+		// Rows are queried in the reversed order.
+		swap := reflect.Swapper(v.Interface())
+		for i, j := 0, v.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	result := &Result{
+		Limit:      p.Limit,
+		TotalCount: p.TotalCount,
+	}
+	if v.Len() == 0 {
+		return result, nil
+	}
+	if (!p.backward && more) || (p.backward && p.hasCursor) {
+		next, err := p.cursor(v.Index(v.Len()-1), false)
+		if err != nil {
+			return nil, err
+		}
+		result.NextCursor = &next
+	}
+	if (p.backward && more) || (!p.backward && p.hasCursor) {
+		prev, err := p.cursor(v.Index(0), true)
+		if err != nil {
+			return nil, err
+		}
+		result.PrevCursor = &prev
+	}
+	return result, nil
+}
+
+func (p *CursorPage) cursor(row reflect.Value, backward bool) (string, error) {
+	c := &cursor{
+		Order:    p.order,
+		Filter:   p.filter,
+		Backward: backward,
+		Values:   make([]interface{}, 0, len(p.order)),
+	}
+	for _, order := range p.order {
+		value, ok := columnValue(row, order.Column)
+		if !ok {
+			return "", fmt.Errorf("column %s not found in %v", order.Column,
+				row.Type())
+		}
+		c.Values = append(c.Values, value)
+	}
+	return c.encode()
+}
+
+// columnValue returns the value of column of row by gorm column tags, like
+// validColumnMap.
+func columnValue(row reflect.Value, column string) (interface{}, bool) {
+	for row.Kind() == reflect.Ptr || row.Kind() == reflect.Interface {
+		if row.IsNil() {
+			return nil, false
+		}
+		row = row.Elem()
+	}
+	if row.Kind() != reflect.Struct {
+		return nil, false
+	}
+	rowType := row.Type()
+	for i := 0; i < rowType.NumField(); i++ {
+		gormTag := rowType.Field(i).Tag.Get("gorm")
+		if len(gormTag) > 0 {
+			for _, str := range strings.Split(gormTag, ";") {
+				if splited := strings.Split(str, ":"); len(splited) == 2 &&
+					splited[0] == "column" && splited[1] == column {
+					if !row.Field(i).CanInterface() {
+						return nil, false
+					}
+					return row.Field(i).Interface(), true
+				}
+			}
+			continue
+		}
+		if row.Field(i).Kind() == reflect.Struct {
+			if value, ok := columnValue(row.Field(i), column); ok {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
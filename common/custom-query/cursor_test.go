@@ -0,0 +1,154 @@
+package customquery
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type cursorRow struct {
+	ID        int64     `gorm:"column:id"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+type cursorModel struct {
+	cursorRow
+	Name string `gorm:"column:name"`
+}
+
+// CursorSuite tests cursor mode.
+type CursorSuite struct {
+	suite.Suite
+	order Order
+	t0    time.Time
+}
+
+func (suite *CursorSuite) SetupTest() {
+	var err error
+	suite.order, err = ParseOrder("created_at DESC, id DESC")
+	suite.Require().Nil(err)
+	suite.t0 = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (suite *CursorSuite) rows(ids ...int64) []cursorModel {
+	rows := make([]cursorModel, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, cursorModel{cursorRow: cursorRow{
+			ID:        id,
+			CreatedAt: suite.t0.Add(time.Duration(id) * time.Second),
+		}})
+	}
+	return rows
+}
+
+func (suite *CursorSuite) TestParseOrder() {
+	suite.Require().Equal(Order{
+		{Column: "created_at", Keyword: "desc"},
+		{Column: "id", Keyword: "desc"},
+	}, suite.order)
+
+	order, err := ParseOrder(`"price" asc`)
+	suite.Require().Nil(err)
+	suite.Require().Equal(Order{{Column: "price", Keyword: "asc"}}, order)
+
+	_, err = ParseOrder("created_at")
+	suite.Require().NotNil(err)
+}
+
+func (suite *CursorSuite) TestSeek() {
+	format, values := suite.order.seek([]interface{}{"t", 3})
+	suite.Require().Equal(`("created_at", "id") < (?, ?)`, format)
+	suite.Require().Equal([]interface{}{"t", 3}, values)
+
+	mixed := Order{
+		{Column: "price", Keyword: "asc"},
+		{Column: "created_at", Keyword: "desc"},
+		{Column: "id", Keyword: "asc"},
+	}
+	format, values = mixed.seek([]interface{}{1, "t", 3})
+	suite.Require().Equal(`("price" > ?) OR ("price" = ? AND "created_at" < ?)`+
+		` OR ("price" = ? AND "created_at" = ? AND "id" > ?)`, format)
+	suite.Require().Equal([]interface{}{1, 1, "t", 1, "t", 3}, values)
+}
+
+func (suite *CursorSuite) TestEncodeDecode() {
+	c := &cursor{
+		Order:  suite.order,
+		Values: []interface{}{suite.t0, int64(9007199254740993)},
+	}
+	s, err := c.encode()
+	suite.Require().Nil(err)
+
+	decoded, err := decodeCursor(s)
+	suite.Require().Nil(err)
+	suite.Require().Equal(suite.order, decoded.Order)
+	suite.Require().Equal(json.Number("9007199254740993"), decoded.Values[1])
+
+	_, err = decodeCursor(s[1:])
+	suite.Require().Equal(ErrInvalidCursor, err)
+	_, err = decodeCursor("abc")
+	suite.Require().Equal(ErrInvalidCursor, err)
+
+	// Cursors signed by other keys are rejected.
+	SetCursorKey([]byte("another key"))
+	_, err = decodeCursor(s)
+	suite.Require().Equal(ErrInvalidCursor, err)
+}
+
+func (suite *CursorSuite) TestResult() {
+	page := &CursorPage{Limit: 2, TotalCount: -1, order: suite.order}
+
+	// The first page has a next page.
+	rows := suite.rows(5, 4, 3)
+	result, err := page.Result(&rows)
+	suite.Require().Nil(err)
+	suite.Require().Len(rows, 2)
+	suite.Require().Nil(result.PrevCursor)
+	suite.Require().NotNil(result.NextCursor)
+	suite.Require().Equal(-1, result.TotalCount)
+	next, err := decodeCursor(*result.NextCursor)
+	suite.Require().Nil(err)
+	suite.Require().False(next.Backward)
+	suite.Require().Equal(json.Number("4"), next.Values[1])
+
+	// The last page sought from a cursor only has a previous page.
+	page.hasCursor = true
+	rows = suite.rows(2, 1)
+	result, err = page.Result(&rows)
+	suite.Require().Nil(err)
+	suite.Require().Nil(result.NextCursor)
+	prev, err := decodeCursor(*result.PrevCursor)
+	suite.Require().Nil(err)
+	suite.Require().True(prev.Backward)
+	suite.Require().Equal(json.Number("2"), prev.Values[1])
+
+	// Backward pages are queried in the reversed order.
+	page.backward = true
+	ptrRows := []*cursorModel{}
+	for _, row := range suite.rows(3, 4, 5) {
+		row := row
+		ptrRows = append(ptrRows, &row)
+	}
+	result, err = page.Result(&ptrRows)
+	suite.Require().Nil(err)
+	suite.Require().Equal(int64(4), ptrRows[0].ID)
+	suite.Require().Equal(int64(3), ptrRows[1].ID)
+	suite.Require().NotNil(result.NextCursor)
+	suite.Require().NotNil(result.PrevCursor)
+
+	// Empty pages have no cursors.
+	rows = nil
+	result, err = page.Result(&rows)
+	suite.Require().Nil(err)
+	suite.Require().Nil(result.NextCursor)
+	suite.Require().Nil(result.PrevCursor)
+
+	_, err = page.Result(rows)
+	suite.Require().NotNil(err)
+}
+
+func TestCursor(t *testing.T) {
+	suite.Run(t, new(CursorSuite))
+}
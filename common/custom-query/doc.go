@@ -87,6 +87,91 @@ ex. 2: "Where ((column_a = 'A') AND (column_b != 'B'))"
 		]
 	}
 
+Compact Syntax
+
+Query parameters also accept a compact filter, which is parsed if "filter"
+doesn't look like JSON, and "sort" instead of "order".
+	?filter=status:eq:filled,created_at:gte:2020-01-01&sort=-created_at,id
+Terms of the filter are joined by AND, and each term is column:operator:value
+with operators eq, ne, gt, gte, lt, lte, in, like, ilike and between. Values
+of in and between are separated by "|", e.g. "side:in:buy|sell", and "\"
+escapes ",", ":", "|" and "\" in values. Sort columns are descending if
+they're prefixed by "-".
+
+Errors of compact syntax, including columns not in the model, are returned as
+*ParseError with the 1-based position, and PageFind responds them with
+apierrors.InvalidQueryParameterAt like
+	invalid_query_parameter_filter_at_12_unknown_operator
+Errors of JSON filters and orders keep their codes.
+
+Projection and Aggregates
+
+"fields" selects only the given columns, and "group_by", "aggregates" and
+"having" aggregate rows in the query instead of in Go.
+	?group_by=currency_id&aggregates=count,sum:total&having=count:gt:10&sort=-sum_total
+Aggregates are function[:column] with functions count, sum, avg, min and max,
+and are selected as function_column, or "count" for count(*). Sums and
+averages are cast to NUMERIC, so they should be scanned into decimal.Decimal.
+"having" is a filter of the same syntax as "filter", whose columns are
+group_by columns and aliases of aggregates, and grouped rows are ordered by
+them too. All columns are validated against the model, and endpoints could
+validate them further by ProjectionValidator.
+    err := opt.ValidateProjection(func(column, function string) error {
+        if column == "secret_column" {
+            return errors.New("attempt to aggregate secret column")
+        }
+        return nil
+    })
+PageFind rejects group_by, aggregates and having unless
+PageFindParams.AllowAggregates is true, and they're not supported in cursor
+mode.
+
+Relations and JSONB
+
+Filters could refer to columns of associations by dotted paths, and to keys
+of JSONB columns by "->" paths, if they're allowed by the endpoint.
+    opt.AllowRelations("user.email", "user.metadata")
+    opt.AllowJSONColumns("payload", "user.metadata")
+    ?filter=user.email:eq:a@b.c,payload->document->type:eq:passport
+Associations are fields with gorm ForeignKey tags, named by their snake case
+field names. Only the allowed columns of associations could be filtered, and
+they're validated against the associated models like columns of the model.
+Conditions on them are joined by
+	EXISTS (SELECT 1 FROM "user" AS "user" WHERE "user"."id" = "kyc"."user_id" AND "user"."email" = ?)
+, so has-many associations don't duplicate rows and columns of the model
+stay unambiguous. JSONB paths are evaluated as text by "#>>", and cast to
+numeric or boolean if they're compared with JSON numbers or booleans, so
+{"greater_than": {"column": "payload->level", "value": 2}} compares levels
+numerically. Values of compact filters are strings, compared as text. Keys
+are plain names like columns. PageFind allows them by PageFindParams.Relations
+and PageFindParams.JSONColumns.
+
+Cost Guard
+
+CostGuard explains queries before they're executed, and rejects plans whose
+total cost exceeds MaxCost or which scan ProtectedTables sequentially with
+CostError. Queries run in read only transactions with StatementTimeout.
+PageFind checks queries as they're executed with their pages, and checks
+counts of pages before rows are counted.
+	params.CostGuard = &customquery.CostGuard{
+		MaxCost:          100000,
+		ProtectedTables:  []string{"trade", "ledger"},
+		StatementTimeout: 5 * time.Second,
+	}
+Rejected queries respond query_too_expensive.
+
+Saved Queries
+
+NewSavedQuery validates options by the model of the endpoint and saves them
+without paging. Filter values could be placeholders like "${user_id}",
+which are replaced by parameters when the query is executed. Queries are
+visible to their users, or all users if they're shared.
+	q, err := customquery.VisibleSavedQuery(store, userID, id)
+	opt, err := q.SQLOptions(map[string]interface{}{"user_id": userID})
+	params.Options = opt
+Options of PageFindParams replace options of the request, while limit, page
+and cursor of the request still apply.
+
 Filter Validator
 
 FilterComparisonValidator is called to validate comparison operation, which
@@ -105,6 +190,28 @@ Order is a struct that will be evaluated to `ORDER BY` arguments in a SQL query.
 	- Column:  is the column to order
 	- Keyword:  is the way rows will be ordered. (`asc`|`desc`)
 
+Cursor
+
+Deep pages of LIMIT/OFFSET queries get slow since skipped rows are still
+scanned. In cursor mode, rows are sought from the row of a cursor instead.
+`ApplyCursor` orders the query by "order" (or the default order) plus the
+primary key, and seeks with
+	WHERE ("created_at", "id") < (?, ?)
+. Cursors are opaque and signed by the key of `SetCursorKey` (or env
+CUSTOM_QUERY_CURSOR_KEY, which is required outside local development and CI),
+and are bound to the filter and order of the query.
+Total rows are only counted if "count" is true.
+    order, err := customquery.ParseOrder("created_at DESC")
+    query, page, err := opt.ApplyCursor(db.Model(&models.Trade{}), 50,
+        order, customquery.DefaultPrimaryKey)
+    var trades []models.Trade
+    query.Find(&trades)
+    result, err := page.Result(&trades)
+`result.NextCursor` and `result.PrevCursor` are passed as query "cursor" to
+get the adjacent pages. PageFind runs in cursor mode only if
+PageFindParams.Cursor is true and query "cursor" is given, which is empty for
+the first page, so pages of other endpoints and requests are unchanged.
+
 Order Validator
 
 OrderValidator is called to validate order operation, which could prevent
@@ -0,0 +1,21 @@
+/*
+Package export exports rows filtered and ordered by customquery.SQLOptions as
+CSV, JSON lines or XLSX. Rows are streamed from the query cursor into the
+writer, so exports don't load all rows into memory.
+
+Endpoint handles export requests. Small exports are streamed in responses,
+and exports with more than SyncLimit rows run as jobs of Runner, whose files
+are saved in chunks and streamed to their users by Runner.DownloadHandler.
+Jobs run in the process which submits them and aren't resumed after restart,
+see Runner.
+
+	endpoint := &export.Endpoint{
+		Name:      "kycs",
+		Query:     func(db *gorm.DB) *gorm.DB { return db.Model(&models.KYC{}) },
+		Columns:   []export.Column{{Name: "id"}, {Name: "email", Mask: export.MaskEmail}},
+		DftOrder:  "created_at DESC",
+		SyncLimit: 1000,
+		Runner:    runner,
+	}
+*/
+package export
@@ -0,0 +1,207 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jiarung/gorm"
+
+	customquery "github.com/jiarung/mochi/common/custom-query"
+	"github.com/jiarung/mochi/common/utils"
+)
+
+// ErrTooManyRows is returned if rows of an export exceed MaxRows.
+var ErrTooManyRows = errors.New("too many rows to export")
+
+// Column is a column of exports.
+type Column struct {
+	// Name is the column of the query.
+	Name string
+	// Header is the header of the column, which is Name if it's empty.
+	Header string
+	// Mask masks values of the column if it's set, like MaskEmail.
+	Mask func(string) string
+}
+
+func (c *Column) header() string {
+	if len(c.Header) > 0 {
+		return c.Header
+	}
+	return c.Name
+}
+
+// MaskEmail masks emails by utils.GetMaskedEmail.
+func MaskEmail(v string) string {
+	if len(v) == 0 {
+		return v
+	}
+	return utils.GetMaskedEmail(v)
+}
+
+// MaskTail returns a mask which keeps only the last n characters, e.g. of
+// phone and ID numbers.
+func MaskTail(n int) func(string) string {
+	return func(v string) string {
+		runes := []rune(v)
+		masked := make([]rune, len(runes))
+		for i := range runes {
+			if i < len(runes)-n {
+				masked[i] = '*'
+			} else {
+				masked[i] = runes[i]
+			}
+		}
+		return string(masked)
+	}
+}
+
+// SelectColumns returns columns of available by names of fields, or all of
+// available if fields is empty, so users can't export undefined columns.
+func SelectColumns(available []Column, fields []string) ([]Column, error) {
+	if len(fields) == 0 {
+		return available, nil
+	}
+	columns := make([]Column, 0, len(fields))
+	for _, field := range fields {
+		found := false
+		for _, c := range available {
+			if c.Name == field {
+				columns = append(columns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid export column. %s", field)
+		}
+	}
+	return columns, nil
+}
+
+// Request is a request of exports.
+type Request struct {
+	// Query is the query of the model, like db.Model(&models.KYC{}).
+	Query *gorm.DB
+	// Options filters and orders rows, whose fields are replaced by names of
+	// Columns.
+	Options *customquery.SQLOptions
+	Columns []Column
+	Format  Format
+	// MaxRows limits rows of the export if it's positive.
+	MaxRows int
+}
+
+// query returns the query of rows, which selects names of columns.
+func (r *Request) query() (*gorm.DB, error) {
+	opt := *r.Options
+	opt.Fields = make([]string, 0, len(r.Columns))
+	for _, c := range r.Columns {
+		opt.Fields = append(opt.Fields, c.Name)
+	}
+	return opt.ApplyUnpaged(r.Query)
+}
+
+// Count counts rows of r.
+func (r *Request) Count() (int, error) {
+	query, err := r.query()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := query.Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count rows. err: %v", err)
+	}
+	return count, nil
+}
+
+// Export streams rows of r into w row by row, returning the number of rows.
+// ErrTooManyRows is returned after MaxRows rows if there're more rows.
+func Export(r *Request, w io.Writer) (int, error) {
+	query, err := r.query()
+	if err != nil {
+		return 0, err
+	}
+	if r.MaxRows > 0 {
+		// Query one more row to know if there're too many rows.
+		query = query.Limit(r.MaxRows + 1)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query rows. err: %v", err)
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	// indexes are indexes of columns in rows.
+	indexes := make([]int, len(r.Columns))
+	headers := make([]string, len(r.Columns))
+	for i := range r.Columns {
+		indexes[i] = -1
+		for j, name := range names {
+			if name == r.Columns[i].Name {
+				indexes[i] = j
+			}
+		}
+		if indexes[i] < 0 {
+			return 0, fmt.Errorf("column not found in rows. %s", r.Columns[i].Name)
+		}
+		headers[i] = r.Columns[i].header()
+	}
+
+	rw, err := newRowWriter(r.Format, w)
+	if err != nil {
+		return 0, err
+	}
+	if err = rw.WriteHeader(headers); err != nil {
+		return 0, err
+	}
+	values := make([]interface{}, len(names))
+	dest := make([]interface{}, len(names))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(r.Columns))
+	count := 0
+	for rows.Next() {
+		if r.MaxRows > 0 && count == r.MaxRows {
+			return count, ErrTooManyRows
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return count, fmt.Errorf("failed to scan row. err: %v", err)
+		}
+		for i, c := range r.Columns {
+			record[i] = formatValue(values[indexes[i]])
+			if c.Mask != nil {
+				record[i] = c.Mask(record[i])
+			}
+		}
+		if err = rw.WriteRow(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to iterate rows. err: %v", err)
+	}
+	return count, rw.Close()
+}
+
+// formatValue formats values scanned from rows.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,236 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/aes"
+)
+
+// ExportSuite tests formats, columns and jobs of exports.
+type ExportSuite struct {
+	suite.Suite
+}
+
+func (suite *ExportSuite) write(f Format, rows ...[]string) []byte {
+	var buf bytes.Buffer
+	w, err := newRowWriter(f, &buf)
+	suite.Require().Nil(err)
+	suite.Require().Nil(w.WriteHeader([]string{"id", "email"}))
+	for _, row := range rows {
+		suite.Require().Nil(w.WriteRow(row))
+	}
+	suite.Require().Nil(w.Close())
+	return buf.Bytes()
+}
+
+func (suite *ExportSuite) TestCSV() {
+	data := suite.write(CSV,
+		[]string{"1", "a,b@c.d"},
+		[]string{"-2.5", "=HYPERLINK(\"x\")"},
+		[]string{"@sum", "+1 234"},
+	)
+	suite.Require().Equal("id,email\n"+
+		"1,\"a,b@c.d\"\n"+
+		"-2.5,\"'=HYPERLINK(\"\"x\"\")\"\n"+
+		"'@sum,'+1 234\n", string(data))
+}
+
+func (suite *ExportSuite) TestJSONL() {
+	data := suite.write(JSONL,
+		[]string{"1", "a@b.c"},
+		[]string{"2", "\"quoted\"\n"},
+	)
+	suite.Require().Equal(`{"id":"1","email":"a@b.c"}`+"\n"+
+		`{"id":"2","email":"\"quoted\"\n"}`+"\n", string(data))
+}
+
+func (suite *ExportSuite) TestXLSX() {
+	data := suite.write(XLSX, []string{"1", "<a&b>\x00"})
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	suite.Require().Nil(err)
+	parts := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		suite.Require().Nil(err)
+		content, err := ioutil.ReadAll(rc)
+		suite.Require().Nil(err)
+		rc.Close()
+		parts[f.Name] = string(content)
+	}
+	suite.Require().Len(parts, 5)
+	suite.Require().Contains(parts, "[Content_Types].xml")
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	suite.Require().True(strings.HasSuffix(sheet,
+		`<row><c t="inlineStr"><is><t xml:space="preserve">1</t></is></c>`+
+			`<c t="inlineStr"><is><t xml:space="preserve">&lt;a&amp;b&gt;`+
+			"�</t></is></c></row></sheetData></worksheet>"), sheet)
+}
+
+func (suite *ExportSuite) TestParseFormat() {
+	f, err := ParseFormat("xlsx")
+	suite.Require().Nil(err)
+	suite.Require().Equal(XLSX, f)
+	_, err = ParseFormat("xls")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ExportSuite) TestColumns() {
+	available := []Column{
+		{Name: "id"},
+		{Name: "email", Header: "Email", Mask: MaskEmail},
+		{Name: "phone", Mask: MaskTail(3)},
+	}
+	columns, err := SelectColumns(available, nil)
+	suite.Require().Nil(err)
+	suite.Require().Len(columns, 3)
+
+	columns, err = SelectColumns(available, []string{"phone", "email"})
+	suite.Require().Nil(err)
+	suite.Require().Equal("phone", columns[0].header())
+	suite.Require().Equal("Email", columns[1].header())
+
+	_, err = SelectColumns(available, []string{"id", "password"})
+	suite.Require().NotNil(err)
+
+	suite.Require().Equal("a*****c@*****.***", MaskEmail("abc@d.com"))
+	suite.Require().Equal("", MaskEmail(""))
+	suite.Require().Equal("*******678", MaskTail(3)("0912345678"))
+	suite.Require().Equal("12", MaskTail(3)("12"))
+}
+
+func (suite *ExportSuite) TestFormatValue() {
+	t := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("", 8*3600))
+	suite.Require().Equal("", formatValue(nil))
+	suite.Require().Equal("1.50", formatValue([]byte("1.50")))
+	suite.Require().Equal("2020-01-01T19:04:05Z", formatValue(t))
+	suite.Require().Equal("42", formatValue(int64(42)))
+	suite.Require().Equal("true", formatValue(true))
+}
+
+// failStore fails to save chunks.
+type failStore struct {
+	JobStore
+}
+
+func (failStore) SaveChunk(string, int, []byte) error {
+	return errors.New("redis down")
+}
+
+// readFile returns the content of file key of userID.
+func (suite *ExportSuite) readFile(r *Runner, userID uuid.UUID, key string) string {
+	f, err := r.File(userID, key)
+	suite.Require().Nil(err)
+	var buf bytes.Buffer
+	suite.Require().Nil(r.WriteFile(f, &buf))
+	return buf.String()
+}
+
+func (suite *ExportSuite) TestRunner() {
+	key, err := aes.GenerateAESKey(aes.AES256KeySize)
+	suite.Require().Nil(err)
+	store := NewMemoryStore()
+	r := NewRunner(store, key, 1)
+	r.export = func(req *Request, w io.Writer) (int, error) {
+		if req.MaxRows > 0 {
+			return 0, ErrTooManyRows
+		}
+		_, err := io.WriteString(w, "id\n1\n")
+		return 1, err
+	}
+
+	userID := uuid.NewV4()
+	job, err := r.Submit(userID, &Request{Format: CSV})
+	suite.Require().Nil(err)
+	suite.Require().Equal(StatusPending, job.Status)
+	r.Wait()
+
+	job, err = r.Job(userID, job.ID)
+	suite.Require().Nil(err)
+	suite.Require().Equal(StatusCompleted, job.Status)
+	suite.Require().Equal(1, job.Rows)
+	suite.Require().NotEmpty(job.FileKey)
+	suite.Require().NotNil(job.FinishedAt)
+	suite.Require().Equal("id\n1\n", suite.readFile(r, userID, job.FileKey))
+	f, err := r.File(userID, job.FileKey)
+	suite.Require().Nil(err)
+	suite.Require().True(f.Encrypted)
+	suite.Require().Equal(CSV.ContentType(), f.ContentType)
+	chunk, err := store.Chunk(job.FileKey, 0)
+	suite.Require().Nil(err)
+	suite.Require().NotContains(string(chunk), "id")
+
+	// Jobs and files are only visible to their users.
+	_, err = r.Job(uuid.NewV4(), job.ID)
+	suite.Require().Equal(ErrJobNotFound, err)
+	_, err = r.Job(userID, uuid.NewV4())
+	suite.Require().Equal(ErrJobNotFound, err)
+	_, err = r.File(uuid.NewV4(), job.FileKey)
+	suite.Require().Equal(ErrFileNotFound, err)
+
+	job, err = r.Submit(userID, &Request{Format: CSV, MaxRows: 1})
+	suite.Require().Nil(err)
+	r.Wait()
+	job, err = r.Job(userID, job.ID)
+	suite.Require().Nil(err)
+	suite.Require().Equal(StatusFailed, job.Status)
+	suite.Require().Equal(ErrTooManyRows.Error(), job.Error)
+	suite.Require().Empty(job.FileKey)
+
+	r.store = failStore{JobStore: store}
+	job, err = r.Submit(userID, &Request{Format: CSV})
+	suite.Require().Nil(err)
+	r.Wait()
+	job, err = r.Job(userID, job.ID)
+	suite.Require().Nil(err)
+	suite.Require().Equal(StatusFailed, job.Status)
+}
+
+func (suite *ExportSuite) TestChunks() {
+	store := NewMemoryStore()
+	r := NewRunner(store, nil, 1)
+	data := strings.Repeat("0123456789", fileChunkSize/4)
+	r.export = func(req *Request, w io.Writer) (int, error) {
+		// Writes across chunk boundaries.
+		for i := 0; i < len(data); i += 7777 {
+			end := i + 7777
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err := io.WriteString(w, data[i:end]); err != nil {
+				return 0, err
+			}
+		}
+		return 1, nil
+	}
+
+	userID := uuid.NewV4()
+	job, err := r.Submit(userID, &Request{Format: JSONL})
+	suite.Require().Nil(err)
+	r.Wait()
+	job, err = r.Job(userID, job.ID)
+	suite.Require().Nil(err)
+	suite.Require().Equal(StatusCompleted, job.Status)
+
+	f, err := r.File(userID, job.FileKey)
+	suite.Require().Nil(err)
+	suite.Require().False(f.Encrypted)
+	suite.Require().Equal(3, f.Chunks)
+	chunk, err := store.Chunk(f.Key, 0)
+	suite.Require().Nil(err)
+	suite.Require().Len(chunk, fileChunkSize)
+	suite.Require().Equal(data, suite.readFile(r, userID, job.FileKey))
+}
+
+func TestExport(t *testing.T) {
+	suite.Run(t, new(ExportSuite))
+}
@@ -0,0 +1,143 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Format is the file format of exports.
+type Format string
+
+// Supported formats.
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+	XLSX  Format = "xlsx"
+)
+
+// ParseFormat parses s into Format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case CSV, JSONL, XLSX:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid export format. %s", s)
+}
+
+// ContentType returns the MIME type of f.
+func (f Format) ContentType() string {
+	switch f {
+	case JSONL:
+		return "application/x-ndjson"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// rowWriter writes rows of string values in a format. Headers are written
+// before any row, and Close flushes the format without closing the
+// underlying writer.
+type rowWriter interface {
+	WriteHeader(headers []string) error
+	WriteRow(values []string) error
+	Close() error
+}
+
+func newRowWriter(f Format, w io.Writer) (rowWriter, error) {
+	switch f {
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case JSONL:
+		return &jsonlWriter{w: w}, nil
+	case XLSX:
+		return newXLSXWriter(w), nil
+	}
+	return nil, fmt.Errorf("invalid export format. %s", f)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvWriter) WriteHeader(headers []string) error {
+	return w.w.Write(headers)
+}
+
+// WriteRow writes values, escaping values which spreadsheets would evaluate
+// as formulas.
+func (w *csvWriter) WriteRow(values []string) error {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = escapeFormula(v)
+	}
+	return w.w.Write(escaped)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// escapeFormula prefixes v by "'" if it starts like a formula but isn't a
+// number.
+func escapeFormula(v string) string {
+	if len(v) == 0 {
+		return v
+	}
+	switch v[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "'" + v
+		}
+	}
+	return v
+}
+
+// jsonlWriter writes a JSON object per line, with keys in the order of
+// headers.
+type jsonlWriter struct {
+	w       io.Writer
+	headers [][]byte
+	buf     bytes.Buffer
+}
+
+func (w *jsonlWriter) WriteHeader(headers []string) error {
+	w.headers = make([][]byte, 0, len(headers))
+	for _, h := range headers {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		w.headers = append(w.headers, data)
+	}
+	return nil
+}
+
+func (w *jsonlWriter) WriteRow(values []string) error {
+	w.buf.Reset()
+	w.buf.WriteByte('{')
+	for i, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		w.buf.Write(w.headers[i])
+		w.buf.WriteByte(':')
+		w.buf.Write(data)
+	}
+	w.buf.WriteString("}\n")
+	_, err := w.w.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,201 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jiarung/gorm"
+	"github.com/satori/go.uuid"
+
+	apicontext "github.com/jiarung/mochi/common/api/context"
+	apierrors "github.com/jiarung/mochi/common/api/errors"
+	customquery "github.com/jiarung/mochi/common/custom-query"
+)
+
+// Endpoint exports rows of a model filtered and ordered by customquery
+// query params, with query "format" and "fields" of Columns.
+type Endpoint struct {
+	// Name is the prefix of file names, like kycs.
+	Name string
+	// Query returns the query of the model, like db.Model(&models.KYC{}).
+	Query   func(db *gorm.DB) *gorm.DB
+	Columns []Column
+	// DftOrder orders rows if order and sort aren't given, like
+	// "created_at DESC".
+	DftOrder string
+	// SyncLimit is the max rows exported in responses. Larger exports run
+	// as jobs of Runner.
+	SyncLimit int
+	// MaxRows is the max rows of exports if it's positive.
+	MaxRows int
+	Runner  *Runner
+	// Relations and JSONColumns are allowed in filters, see
+	// customquery.SQLOptions.AllowRelations and AllowJSONColumns.
+	Relations   []string
+	JSONColumns []string
+}
+
+// setQueryError sets err of query params to appCtx.
+func setQueryError(appCtx *apicontext.AppContext, err error) {
+	if parseErr, ok := err.(*customquery.ParseError); ok {
+		appCtx.SetError(apierrors.InvalidQueryParameterAt, parseErr.Code())
+		return
+	}
+	appCtx.SetError(apierrors.InvalidQueryParameter)
+}
+
+// request returns the export request of query params.
+func (e *Endpoint) request(appCtx *apicontext.AppContext) (*Request, error) {
+	format, err := ParseFormat(appCtx.DefaultQuery("format", string(CSV)))
+	if err != nil {
+		return nil, err
+	}
+	opt, err := customquery.NewSQLOptionsFromAppCtxQuery(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.GroupBy) > 0 || len(opt.Aggregates) > 0 || opt.Having != nil ||
+		opt.Cursor != nil {
+		return nil, errors.New("group_by, aggregates and cursor can't be exported")
+	}
+	columns, err := SelectColumns(e.Columns, opt.Fields)
+	if err != nil {
+		return nil, err
+	}
+	opt.AllowRelations(e.Relations...)
+	opt.AllowJSONColumns(e.JSONColumns...)
+	if opt.Order == nil && len(e.DftOrder) > 0 {
+		order, err := customquery.ParseOrder(e.DftOrder)
+		if err != nil {
+			return nil, err
+		}
+		opt.Order = &order
+	}
+	return &Request{
+		Query:   e.Query(appCtx.DB),
+		Options: opt,
+		Columns: columns,
+		Format:  format,
+		MaxRows: e.MaxRows,
+	}, nil
+}
+
+// Handler exports rows in the response, or submits a job to Runner and
+// responds the job if there're more than SyncLimit rows.
+func (e *Endpoint) Handler(appCtx *apicontext.AppContext) {
+	logger := appCtx.Logger()
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	userID, err := appCtx.GetUserID()
+	if err != nil {
+		logger.Error("failed to get user id. err: %v", err)
+		appCtx.Abort()
+		return
+	}
+
+	req, err := e.request(appCtx)
+	if err != nil {
+		logger.Info("invalid export params. err: %v", err)
+		setQueryError(appCtx, err)
+		return
+	}
+	count, err := req.Count()
+	if err != nil {
+		logger.Error("failed to count export rows. err: %v", err)
+		appCtx.SetError(apierrors.DBError)
+		return
+	}
+	if e.MaxRows > 0 && count > e.MaxRows {
+		logger.Info("too many rows to export. %d", count)
+		appCtx.SetError(apierrors.ExportTooLarge)
+		return
+	}
+
+	if count > e.SyncLimit && e.Runner != nil {
+		job, err := e.Runner.Submit(userID, req)
+		if err != nil {
+			logger.Error("failed to submit export job. err: %v", err)
+			appCtx.Abort()
+			return
+		}
+		appCtx.SetJSON(job)
+		return
+	}
+
+	// Rows are streamed into the response, so errors can't be responded
+	// once rows are written.
+	w := appCtx.Writer()
+	w.Header().Set("Content-Type", req.Format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(
+		"attachment; filename=\"%s-%s.%s\"", e.Name,
+		time.Now().UTC().Format("20060102150405"), req.Format))
+	if _, err := Export(req, w); err != nil {
+		logger.Error("failed to export rows. err: %v", err)
+	}
+}
+
+// JobHandler handles [GET] /v1/crm/exports/:job_id, responding jobs of the
+// user.
+func (r *Runner) JobHandler(appCtx *apicontext.AppContext) {
+	logger := appCtx.Logger()
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	userID, err := appCtx.GetUserID()
+	if err != nil {
+		logger.Error("failed to get user id. err: %v", err)
+		appCtx.Abort()
+		return
+	}
+	id, err := uuid.FromString(appCtx.Param("job_id"))
+	if err != nil {
+		appCtx.SetError(apierrors.ExportJobNotFound)
+		return
+	}
+	job, err := r.Job(userID, id)
+	if err == ErrJobNotFound {
+		appCtx.SetError(apierrors.ExportJobNotFound)
+		return
+	} else if err != nil {
+		logger.Error("failed to get export job. err: %v", err)
+		appCtx.Abort()
+		return
+	}
+	appCtx.SetJSON(job)
+}
+
+// DownloadHandler handles [GET] /v1/crm/exports/files/:file_key, streaming
+// files of completed jobs to their users chunk by chunk.
+func (r *Runner) DownloadHandler(appCtx *apicontext.AppContext) {
+	logger := appCtx.Logger()
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	userID, err := appCtx.GetUserID()
+	if err != nil {
+		logger.Error("failed to get user id. err: %v", err)
+		appCtx.Abort()
+		return
+	}
+	f, err := r.File(userID, appCtx.Param("file_key"))
+	if err == ErrFileNotFound {
+		appCtx.SetError(apierrors.ExportFileNotFound)
+		return
+	} else if err != nil {
+		logger.Error("failed to get export file. err: %v", err)
+		appCtx.Abort()
+		return
+	}
+
+	// Chunks are streamed into the response, so errors can't be responded
+	// once chunks are written.
+	w := appCtx.Writer()
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s\"", f.Name))
+	if err := r.WriteFile(f, w); err != nil {
+		logger.Error("failed to write export file. err: %v", err)
+	}
+}
@@ -0,0 +1,466 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/cache"
+	"github.com/jiarung/mochi/common/aes"
+	"github.com/jiarung/mochi/common/logging"
+)
+
+// Errors of jobs.
+var (
+	// ErrJobNotFound is returned if a job doesn't exist or has expired.
+	ErrJobNotFound = errors.New("export job not found")
+	// ErrFileNotFound is returned if a file or its chunk doesn't exist or
+	// has expired.
+	ErrFileNotFound = errors.New("export file not found")
+)
+
+// fileChunkSize is the max size of file chunks before encryption, so
+// neither exports nor downloads hold more than a chunk of a file in memory.
+const fileChunkSize = 1 << 20
+
+// Status is the status of jobs.
+type Status string
+
+// Job statuses.
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Job is an export running in background. The file of completed jobs is the
+// File of FileKey, which is only downloaded by the user of the job.
+type Job struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	Format     Format     `json:"format"`
+	Status     Status     `json:"status"`
+	Rows       int        `json:"rows"`
+	FileKey    string     `json:"file_key,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// storedJob keeps UserID, which isn't in JSON responses.
+type storedJob struct {
+	*Job
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func marshalJob(j *Job) ([]byte, error) {
+	return json.Marshal(storedJob{Job: j, UserID: j.UserID})
+}
+
+func unmarshalJob(data []byte) (*Job, error) {
+	s := storedJob{Job: &Job{}}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	s.Job.UserID = s.UserID
+	return s.Job, nil
+}
+
+// File is the file of a completed job, which is saved in chunks of at most
+// fileChunkSize bytes before encryption.
+type File struct {
+	Key         string `json:"key"`
+	AccessKey   string `json:"access_key"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Chunks      int    `json:"chunks"`
+	// Encrypted is true if chunks are encrypted by the key of Runner one by
+	// one.
+	Encrypted bool `json:"encrypted"`
+}
+
+// JobStore stores jobs and their files.
+type JobStore interface {
+	// Job returns job id, or ErrJobNotFound.
+	Job(id uuid.UUID) (*Job, error)
+	SaveJob(j *Job) error
+	// File returns file key, or ErrFileNotFound.
+	File(key string) (*File, error)
+	// SaveFile saves f after its chunks are saved.
+	SaveFile(f *File) error
+	// Chunk returns chunk index of file key, or ErrFileNotFound.
+	Chunk(key string, index int) ([]byte, error)
+	SaveChunk(key string, index int, b []byte) error
+}
+
+// memoryStore keeps jobs and files in memory.
+type memoryStore struct {
+	mutex  sync.Mutex
+	jobs   map[uuid.UUID][]byte
+	files  map[string]File
+	chunks map[string][]byte
+}
+
+// NewMemoryStore returns a store which keeps jobs and files in memory
+// without expiration, so it's only for tests and local development.
+func NewMemoryStore() JobStore {
+	return &memoryStore{
+		jobs:   make(map[uuid.UUID][]byte),
+		files:  make(map[string]File),
+		chunks: make(map[string][]byte),
+	}
+}
+
+func chunkKey(key string, index int) string {
+	return fmt.Sprintf("%s:%d", key, index)
+}
+
+func (s *memoryStore) Job(id uuid.UUID) (*Job, error) {
+	s.mutex.Lock()
+	data, ok := s.jobs[id]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return unmarshalJob(data)
+}
+
+func (s *memoryStore) SaveJob(j *Job) error {
+	data, err := marshalJob(j)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs[j.ID] = data
+	return nil
+}
+
+func (s *memoryStore) File(key string) (*File, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, ok := s.files[key]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return &f, nil
+}
+
+func (s *memoryStore) SaveFile(f *File) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files[f.Key] = *f
+	return nil
+}
+
+func (s *memoryStore) Chunk(key string, index int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.chunks[chunkKey(key, index)]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return b, nil
+}
+
+func (s *memoryStore) SaveChunk(key string, index int, b []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.chunks[chunkKey(key, index)] = append([]byte(nil), b...)
+	return nil
+}
+
+// redisStore keeps jobs and files in redis, which expire together.
+type redisStore struct {
+	redis     *cache.Redis
+	expireSec int
+}
+
+// NewRedisStore returns a store backed by redis, whose jobs and files expire
+// after expireSec.
+func NewRedisStore(redis *cache.Redis, expireSec int) JobStore {
+	return &redisStore{redis: redis, expireSec: expireSec}
+}
+
+// Key prefixes of redisStore.
+const (
+	redisJobKeyPrefix   = "customquery:export:job:"
+	redisFileKeyPrefix  = "customquery:export:file:"
+	redisChunkKeyPrefix = "customquery:export:chunk:"
+)
+
+// get returns the value of key, or notFound if it doesn't exist.
+func (s *redisStore) get(key string, notFound error) ([]byte, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	data, err := redis.Bytes(rCli.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, notFound
+	}
+	return data, err
+}
+
+func (s *redisStore) set(key string, data []byte) error {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	_, err := rCli.Do("SET", key, data, "EX", s.expireSec)
+	return err
+}
+
+func (s *redisStore) Job(id uuid.UUID) (*Job, error) {
+	data, err := s.get(redisJobKeyPrefix+id.String(), ErrJobNotFound)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalJob(data)
+}
+
+func (s *redisStore) SaveJob(j *Job) error {
+	data, err := marshalJob(j)
+	if err != nil {
+		return err
+	}
+	return s.set(redisJobKeyPrefix+j.ID.String(), data)
+}
+
+func (s *redisStore) File(key string) (*File, error) {
+	data, err := s.get(redisFileKeyPrefix+key, ErrFileNotFound)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	return f, json.Unmarshal(data, f)
+}
+
+func (s *redisStore) SaveFile(f *File) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.set(redisFileKeyPrefix+f.Key, data)
+}
+
+func (s *redisStore) Chunk(key string, index int) ([]byte, error) {
+	return s.get(redisChunkKeyPrefix+chunkKey(key, index), ErrFileNotFound)
+}
+
+func (s *redisStore) SaveChunk(key string, index int, b []byte) error {
+	return s.set(redisChunkKeyPrefix+chunkKey(key, index), b)
+}
+
+// Runner runs exports in background, and saves their files in chunks
+// encrypted by its AES key.
+//
+// Jobs run in goroutines of the process which submits them, and aren't
+// resumed after restart. Services should call Wait on graceful shutdown so
+// running jobs finish, and jobs interrupted by crashes stay pending or
+// running until they expire from the store, so users should submit them
+// again.
+type Runner struct {
+	store  JobStore
+	key    aes.Key
+	slots  chan struct{}
+	wg     sync.WaitGroup
+	logger logging.Logger
+
+	// export is replaced in tests.
+	export func(r *Request, w io.Writer) (int, error)
+}
+
+// NewRunner creates a runner which runs at most concurrency exports at the
+// same time. Files are encrypted by key if it's not nil.
+func NewRunner(store JobStore, key aes.Key, concurrency int) *Runner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Runner{
+		store:  store,
+		key:    key,
+		slots:  make(chan struct{}, concurrency),
+		logger: logging.NewLoggerTag("customquery:export"),
+		export: Export,
+	}
+}
+
+// Job returns job id of userID, or ErrJobNotFound if it belongs to other
+// users.
+func (r *Runner) Job(userID, id uuid.UUID) (*Job, error) {
+	j, err := r.store.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	if !uuid.Equal(j.UserID, userID) {
+		return nil, ErrJobNotFound
+	}
+	return j, nil
+}
+
+// File returns file key of userID, or ErrFileNotFound if it belongs to other
+// users.
+func (r *Runner) File(userID uuid.UUID, key string) (*File, error) {
+	f, err := r.store.File(key)
+	if err != nil {
+		return nil, err
+	}
+	if f.AccessKey != userID.String() {
+		return nil, ErrFileNotFound
+	}
+	return f, nil
+}
+
+// Submit saves a pending job of req for userID and runs it in background.
+// Query of req shouldn't be bound to the request, like transactions.
+func (r *Runner) Submit(userID uuid.UUID, req *Request) (*Job, error) {
+	j := &Job{
+		ID:        uuid.NewV4(),
+		UserID:    userID,
+		Format:    req.Format,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+	}
+	if err := r.store.SaveJob(j); err != nil {
+		return nil, err
+	}
+	submitted := *j
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.slots <- struct{}{}
+		defer func() { <-r.slots }()
+		r.run(j, req)
+	}()
+	return &submitted, nil
+}
+
+// Wait waits for submitted jobs to finish.
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+func (r *Runner) run(j *Job, req *Request) {
+	j.Status = StatusRunning
+	if err := r.store.SaveJob(j); err != nil {
+		r.logger.Error("failed to save export job %s. err: %v", j.ID, err)
+	}
+
+	fileKey, rows, err := r.exportFile(j, req)
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Rows = rows
+	if err != nil {
+		r.logger.Error("failed to export job %s. err: %v", j.ID, err)
+		j.Status = StatusFailed
+		j.Error = err.Error()
+	} else {
+		j.Status = StatusCompleted
+		j.FileKey = fileKey
+	}
+	if err := r.store.SaveJob(j); err != nil {
+		r.logger.Error("failed to save export job %s. err: %v", j.ID, err)
+	}
+}
+
+// exportFile exports req into a file of the store, whose rows are streamed
+// into chunks, so only a chunk is held in memory.
+func (r *Runner) exportFile(j *Job, req *Request) (string, int, error) {
+	w := &chunkWriter{
+		store: r.store,
+		key:   r.key,
+		file: &File{
+			Key:         uuid.NewV4().String(),
+			AccessKey:   j.UserID.String(),
+			Name:        fmt.Sprintf("%s.%s", j.ID, req.Format),
+			ContentType: req.Format.ContentType(),
+			Encrypted:   r.key != nil,
+		},
+		buf: make([]byte, 0, fileChunkSize),
+	}
+	rows, err := r.export(req, w)
+	if err != nil {
+		return "", rows, err
+	}
+	if err = w.Close(); err != nil {
+		return "", rows, err
+	}
+	return w.file.Key, rows, nil
+}
+
+// chunkWriter saves bytes written to it as chunks of file, each encrypted by
+// key if it's not nil.
+type chunkWriter struct {
+	store JobStore
+	key   aes.Key
+	file  *File
+	buf   []byte
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		m := fileChunkSize - len(w.buf)
+		if m > len(b) {
+			m = len(b)
+		}
+		w.buf = append(w.buf, b[:m]...)
+		b = b[m:]
+		if len(w.buf) == fileChunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush saves buffered bytes as the next chunk.
+func (w *chunkWriter) flush() error {
+	data := w.buf
+	if w.key != nil {
+		var err error
+		if data, err = aes.CBCEncrypt(w.key, w.buf); err != nil {
+			return err
+		}
+	}
+	if err := w.store.SaveChunk(w.file.Key, w.file.Chunks, data); err != nil {
+		return err
+	}
+	w.file.Chunks++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close saves the last chunk and the file. Empty files have an empty chunk.
+func (w *chunkWriter) Close() error {
+	if len(w.buf) > 0 || w.file.Chunks == 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	return w.store.SaveFile(w.file)
+}
+
+// WriteFile writes chunks of file to w, decrypted by the key of r.
+func (r *Runner) WriteFile(f *File, w io.Writer) error {
+	for i := 0; i < f.Chunks; i++ {
+		b, err := r.store.Chunk(f.Key, i)
+		if err != nil {
+			return err
+		}
+		if f.Encrypted {
+			if b, err = aes.CBCDecrypt(r.key, b); err != nil {
+				return err
+			}
+		}
+		if _, err = w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -74,6 +74,19 @@ var operatorMap = map[string]string{
 func evaluateFilterWithValidator(filter map[string]interface{},
 	validColumnMap map[string]struct{}) (
 	format string, values []interface{}, err error) {
+	return evaluateFilter(filter, func(column string, _ interface{}) (
+		string, bool) {
+		_, ok := validColumnMap[column]
+		return fmt.Sprintf("\"%s\" %%s", column), ok
+	})
+}
+
+// evaluateFilter evaluates filter with columnFormat, which returns the
+// format of conditions of a column compared with a value and whether the
+// column is valid. Conditions like "= ?" fill "%s" of the format.
+func evaluateFilter(filter map[string]interface{},
+	columnFormat func(column string, value interface{}) (string, bool)) (
+	format string, values []interface{}, err error) {
 	for _, comparisonOp := range comparisonOperators {
 		payload, ok := filter[comparisonOp]
 		if !ok {
@@ -96,7 +109,8 @@ func evaluateFilterWithValidator(filter map[string]interface{},
 			return
 		}
 
-		if _, isColumnValid := validColumnMap[columnStr]; !isColumnValid {
+		columnFmt, isColumnValid := columnFormat(columnStr, value)
+		if !isColumnValid {
 			format = ""
 			values = nil
 			err = fmt.Errorf("filter comparison with invalid column. %s", columnStr)
@@ -111,7 +125,7 @@ func evaluateFilterWithValidator(filter map[string]interface{},
 				err = fmt.Errorf("invalid value for in. %s", value)
 				return
 			}
-			format = fmt.Sprintf("\"%s\" %s (?)", columnStr, operatorMap[comparisonOp])
+			format = fmt.Sprintf(columnFmt, operatorMap[comparisonOp]+" (?)")
 			values = []interface{}{valueArr}
 			err = nil
 			return
@@ -125,13 +139,13 @@ func evaluateFilterWithValidator(filter map[string]interface{},
 				err = fmt.Errorf("invalid value for between. %s", value)
 				return
 			}
-			format = fmt.Sprintf("\"%s\" %s ? AND ?", columnStr, operatorMap[comparisonOp])
+			format = fmt.Sprintf(columnFmt, operatorMap[comparisonOp]+" ? AND ?")
 			values = valueArr
 			err = nil
 			return
 		}
 
-		format = fmt.Sprintf("\"%s\" %s ?", columnStr, operatorMap[comparisonOp])
+		format = fmt.Sprintf(columnFmt, operatorMap[comparisonOp]+" ?")
 		values = []interface{}{value}
 		err = nil
 		return
@@ -163,7 +177,7 @@ func evaluateFilterWithValidator(filter map[string]interface{},
 			}
 			subFormat,
 				subValues,
-				_err := evaluateFilterWithValidator(subFilter, validColumnMap)
+				_err := evaluateFilter(subFilter, columnFormat)
 			if _err != nil {
 				format = ""
 				values = nil
@@ -1,6 +1,7 @@
 package customquery
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -18,72 +19,147 @@ type PageFindParams struct {
 	DftOrder     string
 	AllowNoLimit bool
 	MaxRecords   int
+	// Cursor allows cursor mode, which is used by requests with query
+	// cursor, empty for the first page. Other requests are paged by page.
+	Cursor bool
+	// PrimaryKey breaks ties of orders in cursor mode, which is
+	// DefaultPrimaryKey if it's empty.
+	PrimaryKey string
+	// AllowAggregates allows group_by, aggregates and having, where Object
+	// should be rows of group_by columns and aliases of aggregates.
+	AllowAggregates bool
+	// ProjectionValidator validates fields, group_by and aggregates if it's
+	// set.
+	ProjectionValidator ProjectionValidator
+	// Relations are columns of associations allowed in filters, like
+	// "user.email", see AllowRelations.
+	Relations []string
+	// JSONColumns are JSONB columns allowed in filters, see
+	// AllowJSONColumns.
+	JSONColumns []string
+	// CostGuard checks queries before they're executed if it's set.
+	CostGuard *CostGuard
+	// Options are used instead of options of the request if they're set,
+	// like options of saved queries, while limit, page and cursor of the
+	// request still apply.
+	Options *SQLOptions
 }
 
-// PageFind makes an easy way for use customquery.
-func PageFind(params *PageFindParams) (*Result, error) {
+// requestOptions returns options of the request, which are Options of
+// params with paging of opt if Options are set.
+func (params *PageFindParams) requestOptions(opt *SQLOptions) *SQLOptions {
+	if params.Options == nil {
+		return opt
+	}
+	result := *params.Options
+	result.Limit = opt.Limit
+	result.Page = opt.Page
+	result.Cursor = opt.Cursor
+	result.Count = opt.Count
+	return &result
+}
+
+// guard runs fn with sql in the transaction of CostGuard of params. fn
+// should check its queries by CostGuard before they're executed, and set
+// its errors to AppCtx.
+func (params *PageFindParams) guard(sql *gorm.DB,
+	fn func(sql *gorm.DB) (*Result, error)) (*Result, error) {
+	var result *Result
+	var fnErr error
+	err := params.CostGuard.Run(sql, func(tx *gorm.DB) error {
+		result, fnErr = fn(tx)
+		return fnErr
+	})
+	if fnErr != nil {
+		return nil, fnErr
+	} else if err != nil {
+		return nil, params.rejected(err)
+	}
+	return result, nil
+}
+
+// rejected sets err of CostGuard to AppCtx, and returns the error of the
+// rejected query.
+func (params *PageFindParams) rejected(err error) error {
+	setOptionError(params.AppCtx, err, apierrors.DBError)
+	return fmt.Errorf("query rejected. err(%s)", err)
+}
+
+// validateProjection validates projection of opt by params, and sets the
+// error to AppCtx if it's invalid.
+func (params *PageFindParams) validateProjection(opt *SQLOptions) error {
+	if !params.AllowAggregates && (opt.grouped() || opt.Having != nil) {
+		params.AppCtx.SetError(apierrors.InvalidQueryParameter)
+		return errors.New("aggregates aren't allowed")
+	}
+	if params.ProjectionValidator == nil {
+		return nil
+	}
+	if err := opt.ValidateProjection(params.ProjectionValidator); err != nil {
+		params.AppCtx.SetError(apierrors.InvalidQueryParameter)
+		return fmt.Errorf("invalid projection. err(%s)", err)
+	}
+	return nil
+}
+
+// cursorFind finds a page in cursor mode by find.
+func cursorFind(params *PageFindParams, opt *SQLOptions, sql *gorm.DB,
+	find func(*gorm.DB) error) (*Result, error) {
 	appCtx := params.AppCtx
-	sql := params.SQL
-	object := params.Object
 	dftOrder := params.DftOrder
-	allowNoLimit := params.AllowNoLimit
-	maxRecords := params.MaxRecords
-
-	opt, err := NewSQLOptionsFromAppCtxQuery(appCtx)
+	if len(dftOrder) == 0 {
+		dftOrder = "created_at DESC"
+	}
+	order, err := ParseOrder(dftOrder)
 	if err != nil {
-		appCtx.SetError(apierrors.InvalidQueryParameter)
-		return nil, fmt.Errorf("invalid params. err(%s)", err)
+		return nil, err
 	}
 
-	if opt.Order == nil {
-		if len(dftOrder) > 0 {
-			if !strings.Contains(strings.ToUpper(dftOrder), "DESC") &&
-				!strings.Contains(strings.ToUpper(dftOrder), "ASC") {
-				return nil, fmt.Errorf("invalid order(%v)", dftOrder)
-			}
-			sql = sql.Order(dftOrder)
-		} else {
-			sql = sql.Order("created_at DESC")
+	if opt.Count != nil && *opt.Count {
+		if err := params.CostGuard.checkCount(opt, sql); err != nil {
+			return nil, params.rejected(err)
 		}
 	}
 
-	query, limit, page, totalPage, totalCount, err := opt.Apply(
-		sql, 50, 1, maxRecords, allowNoLimit)
-	if err != nil {
-		appCtx.SetError(apierrors.DBError)
+	query, page, err := opt.ApplyCursor(sql, 50, order, params.PrimaryKey)
+	if err == ErrInvalidCursor {
+		appCtx.SetError(apierrors.InvalidQueryParameter)
+		return nil, fmt.Errorf("invalid params. err(%s)", err)
+	} else if err != nil {
+		setOptionError(appCtx, err, apierrors.DBError)
 		return nil, fmt.Errorf("failed to apply sql option. err(%s)", err)
 	}
 
-	if err := query.Find(object).Error; err != nil {
+	if err := params.CostGuard.checkQuery(query); err != nil {
+		return nil, params.rejected(err)
+	}
+	if err := find(query); err != nil {
 		appCtx.SetError(apierrors.DBError)
 		return nil, fmt.Errorf("failed to find: %v", err)
 	}
 
-	return &Result{
-		Limit:      limit,
-		Page:       page,
-		TotalPage:  totalPage,
-		TotalCount: totalCount,
-		Data:       nil,
-	}, nil
+	result, err := page.Result(params.Object)
+	if err != nil {
+		appCtx.SetError(apierrors.DBError)
+		return nil, err
+	}
+	return result, nil
 }
 
-// PageScan makes an easy way for use customquery.
-func PageScan(params *PageFindParams) (*Result, error) {
+// pageFind finds a page of sql by find.
+func pageFind(params *PageFindParams, opt *SQLOptions, sql *gorm.DB,
+	find func(*gorm.DB) error) (*Result, error) {
 	appCtx := params.AppCtx
-	sql := params.SQL
-	object := params.Object
 	dftOrder := params.DftOrder
-	allowNoLimit := params.AllowNoLimit
-	maxRecords := params.MaxRecords
 
-	opt, err := NewSQLOptionsFromAppCtxQuery(appCtx)
-	if err != nil {
-		appCtx.SetError(apierrors.InvalidQueryParameter)
-		return nil, fmt.Errorf("invalid params. err(%s)", err)
+	if opt.counted(params.MaxRecords) {
+		if err := params.CostGuard.checkCount(opt, sql); err != nil {
+			return nil, params.rejected(err)
+		}
 	}
 
-	if opt.Order == nil {
+	// Grouped rows can't be ordered by the default order of rows.
+	if opt.Order == nil && !opt.grouped() {
 		if len(dftOrder) > 0 {
 			if !strings.Contains(strings.ToUpper(dftOrder), "DESC") &&
 				!strings.Contains(strings.ToUpper(dftOrder), "ASC") {
@@ -96,15 +172,18 @@ func PageScan(params *PageFindParams) (*Result, error) {
 	}
 
 	query, limit, page, totalPage, totalCount, err := opt.Apply(
-		sql, 50, 1, maxRecords, allowNoLimit)
+		sql, 50, 1, params.MaxRecords, params.AllowNoLimit)
 	if err != nil {
-		appCtx.SetError(apierrors.DBError)
+		setOptionError(appCtx, err, apierrors.DBError)
 		return nil, fmt.Errorf("failed to apply sql option. err(%s)", err)
 	}
 
-	if err := query.Scan(object).Error; err != nil {
+	if err := params.CostGuard.checkQuery(query); err != nil {
+		return nil, params.rejected(err)
+	}
+	if err := find(query); err != nil {
 		appCtx.SetError(apierrors.DBError)
-		return nil, fmt.Errorf("failed to scan: %v", err)
+		return nil, fmt.Errorf("failed to find: %v", err)
 	}
 
 	return &Result{
@@ -116,50 +195,57 @@ func PageScan(params *PageFindParams) (*Result, error) {
 	}, nil
 }
 
-// PageFindFromGinBody makes an easy way for use customquery.
-func PageFindFromGinBody(params *PageFindParams) (*Result, error) {
-	appCtx := params.AppCtx
-	sql := params.SQL
-	object := params.Object
-	dftOrder := params.DftOrder
-	allowNoLimit := params.AllowNoLimit
-	maxRecords := params.MaxRecords
-
-	opt, err := NewSQLOptionsFromAppCtxBody(appCtx)
-	if err != nil {
-		appCtx.SetError(apierrors.InvalidQueryParameter)
-		return nil, fmt.Errorf("invalid params. err(%s)", err)
+// find finds a page of opt by find, in cursor mode if it's allowed and
+// requested.
+func (params *PageFindParams) find(opt *SQLOptions,
+	find func(*gorm.DB) error) (*Result, error) {
+	opt = params.requestOptions(opt)
+	if err := params.validateProjection(opt); err != nil {
+		return nil, err
 	}
+	opt.AllowRelations(params.Relations...)
+	opt.AllowJSONColumns(params.JSONColumns...)
 
-	if opt.Order == nil {
-		if len(dftOrder) > 0 {
-			if !strings.Contains(strings.ToUpper(dftOrder), "DESC") &&
-				!strings.Contains(strings.ToUpper(dftOrder), "ASC") {
-				return nil, fmt.Errorf("invalid order(%v)", dftOrder)
-			}
-			sql = sql.Order(dftOrder)
-		} else {
-			sql = sql.Order("created_at DESC")
+	return params.guard(params.SQL, func(sql *gorm.DB) (*Result, error) {
+		if params.Cursor && opt.Cursor != nil {
+			return cursorFind(params, opt, sql, find)
 		}
-	}
+		return pageFind(params, opt, sql, find)
+	})
+}
 
-	query, limit, page, totalPage, totalCount, err := opt.Apply(
-		sql, 50, 1, maxRecords, allowNoLimit)
+// PageFind makes an easy way for use customquery.
+func PageFind(params *PageFindParams) (*Result, error) {
+	opt, err := NewSQLOptionsFromAppCtxQuery(params.AppCtx)
 	if err != nil {
-		appCtx.SetError(apierrors.DBError)
-		return nil, fmt.Errorf("failed to apply sql option. err(%s)", err)
+		setOptionError(params.AppCtx, err, apierrors.InvalidQueryParameter)
+		return nil, fmt.Errorf("invalid params. err(%s)", err)
 	}
+	return params.find(opt, func(query *gorm.DB) error {
+		return query.Find(params.Object).Error
+	})
+}
 
-	if err := query.Find(object).Error; err != nil {
-		appCtx.SetError(apierrors.DBError)
-		return nil, fmt.Errorf("failed to Find object: %v", err)
+// PageScan makes an easy way for use customquery.
+func PageScan(params *PageFindParams) (*Result, error) {
+	opt, err := NewSQLOptionsFromAppCtxQuery(params.AppCtx)
+	if err != nil {
+		setOptionError(params.AppCtx, err, apierrors.InvalidQueryParameter)
+		return nil, fmt.Errorf("invalid params. err(%s)", err)
 	}
+	return params.find(opt, func(query *gorm.DB) error {
+		return query.Scan(params.Object).Error
+	})
+}
 
-	return &Result{
-		Limit:      limit,
-		Page:       page,
-		TotalPage:  totalPage,
-		TotalCount: totalCount,
-		Data:       nil,
-	}, nil
+// PageFindFromGinBody makes an easy way for use customquery.
+func PageFindFromGinBody(params *PageFindParams) (*Result, error) {
+	opt, err := NewSQLOptionsFromAppCtxBody(params.AppCtx)
+	if err != nil {
+		params.AppCtx.SetError(apierrors.InvalidQueryParameter)
+		return nil, fmt.Errorf("invalid params. err(%s)", err)
+	}
+	return params.find(opt, func(query *gorm.DB) error {
+		return query.Find(params.Object).Error
+	})
 }
@@ -0,0 +1,293 @@
+package customquery
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jiarung/gorm"
+)
+
+// aggregateFormats maps whitelisted aggregate functions to their SQL formats.
+// Sums and averages are cast to NUMERIC, so they're scanned into
+// decimal.Decimal without losing precision even for float columns.
+var aggregateFormats = map[string]string{
+	"count": "COUNT(%s)",
+	"sum":   "CAST(SUM(%s) AS NUMERIC)",
+	"avg":   "CAST(AVG(%s) AS NUMERIC)",
+	"min":   "MIN(%s)",
+	"max":   "MAX(%s)",
+}
+
+// Aggregate is an aggregate function of a column, which is selected as
+// Alias.
+type Aggregate struct {
+	// Function is one of count, sum, avg, min and max.
+	Function string `json:"function" binding:"required"`
+	// Column is the column to aggregate, which is empty for count(*).
+	Column string `json:"column"`
+}
+
+// Alias returns the selected column name of a, like sum_amount, or count for
+// count(*).
+func (a *Aggregate) Alias() string {
+	if len(a.Column) == 0 {
+		return a.Function
+	}
+	return a.Function + "_" + a.Column
+}
+
+func (a *Aggregate) expr() string {
+	column := "*"
+	if len(a.Column) > 0 {
+		column = fmt.Sprintf("\"%s\"", a.Column)
+	}
+	return fmt.Sprintf(aggregateFormats[a.Function], column)
+}
+
+func (a *Aggregate) validate(validColumnMap map[string]struct{}) error {
+	if _, ok := aggregateFormats[a.Function]; !ok {
+		return fmt.Errorf("invalid aggregate function (%s)", a.Function)
+	}
+	if len(a.Column) == 0 {
+		if a.Function != "count" {
+			return fmt.Errorf("missing column of aggregate function (%s)",
+				a.Function)
+		}
+		return nil
+	}
+	if _, ok := validColumnMap[a.Column]; !ok {
+		return fmt.Errorf("invalid column. %s", a.Column)
+	}
+	return nil
+}
+
+// ProjectionValidator validates a column of fields, group_by or aggregates
+// for an endpoint, where function is empty for fields and group_by, so
+// endpoints could hide columns or disallow aggregates.
+type ProjectionValidator func(column, function string) error
+
+// ValidateProjection validates fields, group_by and aggregates of opt by v.
+func (opt *SQLOptions) ValidateProjection(v ProjectionValidator) error {
+	for _, column := range opt.Fields {
+		if err := v(column, ""); err != nil {
+			return err
+		}
+	}
+	for _, column := range opt.GroupBy {
+		if err := v(column, ""); err != nil {
+			return err
+		}
+	}
+	for _, a := range opt.Aggregates {
+		if err := v(a.Column, a.Function); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grouped returns whether rows are grouped by group_by or aggregates.
+func (opt *SQLOptions) grouped() bool {
+	return len(opt.GroupBy) > 0 || len(opt.Aggregates) > 0
+}
+
+// groupedColumnMap returns columns of grouped rows, which are group_by
+// columns and aliases of aggregates, mapped to their SQL expressions.
+func (opt *SQLOptions) groupedColumnMap() map[string]string {
+	m := map[string]string{}
+	for _, column := range opt.GroupBy {
+		m[column] = fmt.Sprintf("\"%s\"", column)
+	}
+	for i := range opt.Aggregates {
+		m[opt.Aggregates[i].Alias()] = opt.Aggregates[i].expr()
+	}
+	return m
+}
+
+// havingColumnFormat returns a function of the format of having conditions
+// of columns of grouped rows.
+func (opt *SQLOptions) havingColumnFormat() func(string, interface{}) (
+	string, bool) {
+	groupedColumnMap := opt.groupedColumnMap()
+	return func(column string, _ interface{}) (string, bool) {
+		expr, ok := groupedColumnMap[column]
+		return expr + " %s", ok
+	}
+}
+
+// orderColumnMap returns valid columns to order by, which are columns of
+// grouped rows if rows are grouped.
+func (opt *SQLOptions) orderColumnMap(model interface{}) (
+	map[string]struct{}, error) {
+	if !opt.grouped() {
+		return opt.validColumnMap(model)
+	}
+	m := map[string]struct{}{}
+	for column := range opt.groupedColumnMap() {
+		m[column] = struct{}{}
+	}
+	return m, nil
+}
+
+// validateProjection validates fields, group_by, aggregates and having
+// against validColumnMap.
+func (opt *SQLOptions) validateProjection(
+	validColumnMap map[string]struct{}) error {
+	for _, param := range []string{"fields", "group_by", "aggregates"} {
+		if err := opt.validateCompactColumns(param, validColumnMap); err != nil {
+			return err
+		}
+	}
+	for _, columns := range [][]string{opt.Fields, opt.GroupBy} {
+		for _, column := range columns {
+			if _, ok := validColumnMap[column]; !ok {
+				return fmt.Errorf("invalid column. %s", column)
+			}
+		}
+	}
+	for i := range opt.Aggregates {
+		if err := opt.Aggregates[i].validate(validColumnMap); err != nil {
+			return err
+		}
+	}
+	if !opt.grouped() {
+		if opt.Having != nil {
+			return errors.New("having without group_by or aggregates")
+		}
+		return nil
+	}
+
+	// Selected fields and having should be columns of grouped rows.
+	groupedColumns := map[string]struct{}{}
+	for column := range opt.groupedColumnMap() {
+		groupedColumns[column] = struct{}{}
+	}
+	err := opt.validateCompactColumnsFor("fields", groupedColumns, "not_grouped")
+	if err != nil {
+		return err
+	}
+	if err = opt.validateCompactColumns("having", groupedColumns); err != nil {
+		return err
+	}
+	for _, column := range opt.Fields {
+		if _, ok := groupedColumns[column]; !ok {
+			return fmt.Errorf("field isn't grouped. %s", column)
+		}
+	}
+	return nil
+}
+
+// applyProjectionIfNeeded selects fields and aggregates, and groups rows
+// by group_by with having. Columns of extraColumns are also selected if
+// fields are projected, e.g. columns of cursors.
+func (opt *SQLOptions) applyProjectionIfNeeded(db *gorm.DB,
+	extraColumns ...string) (*gorm.DB, error) {
+	if len(opt.Fields) == 0 && !opt.grouped() && opt.Having == nil {
+		return db, nil
+	}
+	validColumnMap, err := opt.validColumnMap(db.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err = opt.validateProjection(validColumnMap); err != nil {
+		return nil, err
+	}
+
+	fields := append([]string(nil), opt.Fields...)
+	if len(fields) == 0 {
+		fields = opt.GroupBy
+	} else {
+		selected := map[string]struct{}{}
+		for _, column := range fields {
+			selected[column] = struct{}{}
+		}
+		for _, column := range extraColumns {
+			if _, ok := selected[column]; !ok {
+				fields = append(fields, column)
+				selected[column] = struct{}{}
+			}
+		}
+	}
+	selects := make([]string, 0, len(fields)+len(opt.Aggregates))
+	for _, column := range fields {
+		selects = append(selects, fmt.Sprintf("\"%s\"", column))
+	}
+	for i := range opt.Aggregates {
+		selects = append(selects, fmt.Sprintf("%s AS \"%s\"",
+			opt.Aggregates[i].expr(), opt.Aggregates[i].Alias()))
+	}
+	result := db.Select(strings.Join(selects, ", "))
+
+	if len(opt.GroupBy) > 0 {
+		groups := make([]string, 0, len(opt.GroupBy))
+		for _, column := range opt.GroupBy {
+			groups = append(groups, fmt.Sprintf("\"%s\"", column))
+		}
+		result = result.Group(strings.Join(groups, ", "))
+	}
+
+	if opt.Having != nil {
+		format, values, err := evaluateFilter(*opt.Having,
+			opt.havingColumnFormat())
+		if err != nil {
+			return nil, err
+		}
+		result = result.Having(format, values...)
+	}
+	return result, nil
+}
+
+// parseFields parses comma separated columns like "id,status" of param.
+func parseFields(param, s string) ([]string, []compactColumn, error) {
+	var fields []string
+	var columns []compactColumn
+	pos := 0
+	for _, column := range strings.Split(s, ",") {
+		if !isColumn(column) {
+			return nil, nil, &ParseError{Param: param, Pos: pos + 1,
+				Reason: "invalid_column", Token: column}
+		}
+		fields = append(fields, column)
+		columns = append(columns, compactColumn{param, column, pos + 1})
+		pos += len(column) + 1
+	}
+	return fields, columns, nil
+}
+
+// parseAggregates parses comma separated aggregates like
+// "count,sum:amount", where each aggregate is function[:column].
+func parseAggregates(s string) ([]Aggregate, []compactColumn, error) {
+	const param = "aggregates"
+	var aggregates []Aggregate
+	var columns []compactColumn
+	pos := 0
+	for _, part := range strings.Split(s, ",") {
+		function, column := part, ""
+		if i := strings.IndexByte(part, ':'); i >= 0 {
+			function, column = part[:i], part[i+1:]
+		}
+		// columnPos is the 1-based position of the column.
+		columnPos := pos + len(function) + 2
+		if _, ok := aggregateFormats[function]; !ok {
+			return nil, nil, &ParseError{Param: param, Pos: pos + 1,
+				Reason: "unknown_function", Token: function}
+		}
+		if len(column) == 0 && function != "count" {
+			return nil, nil, &ParseError{Param: param, Pos: columnPos - 1,
+				Reason: "missing_column", Token: part}
+		}
+		if len(column) > 0 {
+			if !isColumn(column) {
+				return nil, nil, &ParseError{Param: param, Pos: columnPos,
+					Reason: "invalid_column", Token: column}
+			}
+			columns = append(columns, compactColumn{param, column, columnPos})
+		}
+		aggregates = append(aggregates, Aggregate{
+			Function: function, Column: column,
+		})
+		pos += len(part) + 1
+	}
+	return aggregates, columns, nil
+}
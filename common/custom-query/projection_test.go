@@ -0,0 +1,172 @@
+package customquery
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// ProjectionSuite tests fields, group_by, aggregates and having.
+type ProjectionSuite struct {
+	suite.Suite
+	validColumnMap map[string]struct{}
+}
+
+func (suite *ProjectionSuite) SetupSuite() {
+	suite.validColumnMap = map[string]struct{}{
+		"id":       struct{}{},
+		"status":   struct{}{},
+		"side":     struct{}{},
+		"amount":   struct{}{},
+		"price":    struct{}{},
+		"password": struct{}{},
+	}
+}
+
+func (suite *ProjectionSuite) TestAggregate() {
+	count := Aggregate{Function: "count"}
+	suite.Require().Equal("count", count.Alias())
+	suite.Require().Equal("COUNT(*)", count.expr())
+	suite.Require().Nil(count.validate(suite.validColumnMap))
+
+	sum := Aggregate{Function: "sum", Column: "amount"}
+	suite.Require().Equal("sum_amount", sum.Alias())
+	suite.Require().Equal(`CAST(SUM("amount") AS NUMERIC)`, sum.expr())
+	suite.Require().Nil(sum.validate(suite.validColumnMap))
+
+	invalids := []Aggregate{
+		{Function: "sum"},
+		{Function: "stddev", Column: "amount"},
+		{Function: "max", Column: "secret"},
+		{Function: "max", Column: `amount") FROM "user" --`},
+	}
+	for _, a := range invalids {
+		suite.Require().NotNil(a.validate(suite.validColumnMap), a)
+	}
+}
+
+func (suite *ProjectionSuite) TestParseAggregates() {
+	aggregates, columns, err := parseAggregates("count,sum:amount,max:price")
+	suite.Require().Nil(err)
+	suite.Require().Equal([]Aggregate{
+		{Function: "count"},
+		{Function: "sum", Column: "amount"},
+		{Function: "max", Column: "price"},
+	}, aggregates)
+	suite.Require().Equal([]compactColumn{
+		{"aggregates", "amount", 11},
+		{"aggregates", "price", 22},
+	}, columns)
+
+	cases := []struct {
+		aggregates string
+		pos        int
+		reason     string
+	}{
+		{"count,median:price", 7, "unknown_function"},
+		{"count,sum", 10, "missing_column"},
+		{"count,sum:", 10, "missing_column"},
+		{`count,sum:"amount"`, 11, "invalid_column"},
+	}
+	for _, c := range cases {
+		_, _, err := parseAggregates(c.aggregates)
+		parseErr, ok := err.(*ParseError)
+		suite.Require().True(ok, c.aggregates)
+		suite.Require().Equal(c.pos, parseErr.Pos, c.aggregates)
+		suite.Require().Equal(c.reason, parseErr.Reason, c.aggregates)
+	}
+}
+
+func (suite *ProjectionSuite) TestParseFields() {
+	fields, columns, err := parseFields("group_by", "status,side")
+	suite.Require().Nil(err)
+	suite.Require().Equal([]string{"status", "side"}, fields)
+	suite.Require().Equal([]compactColumn{
+		{"group_by", "status", 1},
+		{"group_by", "side", 8},
+	}, columns)
+
+	_, _, err = parseFields("fields", "id,,side")
+	suite.Require().Equal(&ParseError{
+		Param: "fields", Pos: 4, Reason: "invalid_column", Token: "",
+	}, err)
+}
+
+func (suite *ProjectionSuite) TestValidateProjection() {
+	fields, fieldColumns, err := parseFields("fields", "status,side")
+	suite.Require().Nil(err)
+	groupBy, groupColumns, err := parseFields("group_by", "status")
+	suite.Require().Nil(err)
+	opt := SQLOptions{
+		Fields:         fields,
+		GroupBy:        groupBy,
+		Aggregates:     []Aggregate{{Function: "count"}},
+		compactColumns: append(fieldColumns, groupColumns...),
+	}
+	suite.Require().Equal(&ParseError{
+		Param: "fields", Pos: 8, Reason: "not_grouped", Token: "side",
+	}, opt.validateProjection(suite.validColumnMap))
+
+	opt.Fields = []string{"status"}
+	opt.compactColumns = groupColumns
+	suite.Require().Nil(opt.validateProjection(suite.validColumnMap))
+
+	// Having only filters columns of grouped rows.
+	having, havingColumns, err := parseCompactFilter("having", "amount:gt:1")
+	suite.Require().Nil(err)
+	opt.Having = &having
+	opt.compactColumns = append(groupColumns, havingColumns...)
+	suite.Require().Equal(&ParseError{
+		Param: "having", Pos: 1, Reason: "unknown_column", Token: "amount",
+	}, opt.validateProjection(suite.validColumnMap))
+
+	// Having requires grouped rows.
+	suite.Require().NotNil((&SQLOptions{Having: &having}).validateProjection(
+		suite.validColumnMap))
+
+	// Endpoints validate columns and aggregates.
+	opt = SQLOptions{
+		GroupBy:    []string{"status"},
+		Aggregates: []Aggregate{{Function: "sum", Column: "password"}},
+	}
+	err = opt.ValidateProjection(func(column, function string) error {
+		if column == "password" {
+			return errors.New("attempt to project password")
+		}
+		return nil
+	})
+	suite.Require().NotNil(err)
+}
+
+func (suite *ProjectionSuite) TestHaving() {
+	having, _, err := parseCompactFilter("having",
+		"count:gte:10,sum_amount:between:1|100,status:ne:canceled")
+	suite.Require().Nil(err)
+	opt := SQLOptions{
+		GroupBy: []string{"status"},
+		Aggregates: []Aggregate{
+			{Function: "count"},
+			{Function: "sum", Column: "amount"},
+		},
+	}
+	format, values, err := evaluateFilter(having, opt.havingColumnFormat())
+	suite.Require().Nil(err)
+	suite.Require().Equal(`(COUNT(*) >= ?)`+
+		` AND (CAST(SUM("amount") AS NUMERIC) BETWEEN ? AND ?)`+
+		` AND ("status" != ?)`, format)
+	suite.Require().Equal([]interface{}{"10", "1", "100", "canceled"}, values)
+
+	// Grouped rows are ordered by aliases.
+	orderColumnMap, err := opt.orderColumnMap(nil)
+	suite.Require().Nil(err)
+	suite.Require().Equal(map[string]struct{}{
+		"status":     struct{}{},
+		"count":      struct{}{},
+		"sum_amount": struct{}{},
+	}, orderColumnMap)
+}
+
+func TestProjection(t *testing.T) {
+	suite.Run(t, new(ProjectionSuite))
+}
@@ -0,0 +1,284 @@
+package customquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jiarung/gorm"
+)
+
+// AllowRelations allows filters on columns of associations of the model by
+// dotted paths, like "user.email" for column email of relation "user".
+// Associations are fields with gorm ForeignKey tags, named by their snake
+// case field names. Only the given columns of associations are allowed, and
+// they should be valid columns of the associated models.
+func (opt *SQLOptions) AllowRelations(columns ...string) {
+	if opt.relations == nil {
+		opt.relations = map[string]map[string]struct{}{}
+	}
+	for _, c := range columns {
+		name, column := c, ""
+		if i := strings.IndexByte(c, '.'); i >= 0 {
+			name, column = c[:i], c[i+1:]
+		}
+		if opt.relations[name] == nil {
+			opt.relations[name] = map[string]struct{}{}
+		}
+		if len(column) > 0 {
+			opt.relations[name][column] = struct{}{}
+		}
+	}
+}
+
+// AllowJSONColumns allows filters on JSONB paths of columns, like
+// "metadata->address->city" for column "metadata". Columns of associations
+// are allowed by dotted paths like "user.metadata".
+func (opt *SQLOptions) AllowJSONColumns(columns ...string) {
+	if opt.jsonColumns == nil {
+		opt.jsonColumns = map[string]struct{}{}
+	}
+	for _, c := range columns {
+		opt.jsonColumns[c] = struct{}{}
+	}
+}
+
+// columnPath is a parsed column of filters, like
+// relation.column->key->key.
+type columnPath struct {
+	relation string
+	column   string
+	keys     []string
+}
+
+// parseColumnPath parses column into columnPath, and returns false if any
+// part of it isn't a plain name.
+func parseColumnPath(column string) (columnPath, bool) {
+	var p columnPath
+	parts := strings.Split(column, "->")
+	p.column, p.keys = parts[0], parts[1:]
+	if i := strings.IndexByte(p.column, '.'); i >= 0 {
+		p.relation, p.column = p.column[:i], p.column[i+1:]
+		if !isColumn(p.relation) {
+			return p, false
+		}
+	}
+	if !isColumn(p.column) {
+		return p, false
+	}
+	for _, key := range p.keys {
+		// Keys are quoted in SQL literals, so they're plain names too.
+		if !isColumn(key) {
+			return p, false
+		}
+	}
+	return p, true
+}
+
+// isColumnPath returns whether s is a plain column, or a path of relation
+// and JSONB keys.
+func isColumnPath(s string) bool {
+	_, ok := parseColumnPath(s)
+	return ok
+}
+
+// relation is an association of a model, which is joined by EXISTS, so
+// rows of has-many associations don't duplicate rows of the model.
+type relation struct {
+	name    string
+	table   string
+	columns map[string]struct{}
+	// on is the condition of joining the association.
+	on string
+}
+
+// format returns the format of filter conditions of expr, a column
+// expression of the association.
+func (r *relation) format(expr string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM \"%s\" AS \"%s\" WHERE %s AND %s %%s)",
+		r.table, r.name, r.on, expr)
+}
+
+// fieldColumn returns the column of field by its gorm column tag or name.
+func fieldColumn(field reflect.StructField) string {
+	for _, str := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if splited := strings.Split(str, ":"); len(splited) == 2 &&
+			strings.ToLower(splited[0]) == "column" {
+			return splited[1]
+		}
+	}
+	return gorm.ToDBName(field.Name)
+}
+
+// gormSetting returns the value of key of the gorm tag of field.
+func gormSetting(field reflect.StructField, key string) string {
+	for _, str := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if splited := strings.Split(str, ":"); len(splited) == 2 &&
+			strings.EqualFold(splited[0], key) {
+			return splited[1]
+		}
+	}
+	return ""
+}
+
+func structType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+// resolveRelation resolves the association name of model with allowed
+// columns, where tableName returns table names of models. Columns not in
+// validColumnMap of the associated model are rejected.
+func (opt *SQLOptions) resolveRelation(model interface{}, name string,
+	columns map[string]struct{}, tableName func(interface{}) string) (
+	*relation, error) {
+	if model == nil {
+		return nil, fmt.Errorf("nil model")
+	}
+	modelType := structType(reflect.TypeOf(model))
+	if modelType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("invalid kind other than struct. %s",
+			modelType.Kind())
+	}
+
+	var field reflect.StructField
+	found := false
+	for i := 0; i < modelType.NumField(); i++ {
+		f := modelType.Field(i)
+		if !f.Anonymous && gorm.ToDBName(f.Name) == name &&
+			len(gormSetting(f, "ForeignKey")) > 0 {
+			field, found = f, true
+			break
+		}
+	}
+	if !found || structType(field.Type).Kind() != reflect.Struct {
+		return nil, fmt.Errorf("invalid relation. %s", name)
+	}
+	relatedType := structType(field.Type)
+	related := reflect.New(relatedType).Interface()
+	validColumnMap, err := opt.validColumnMap(related)
+	if err != nil {
+		return nil, err
+	}
+	for column := range columns {
+		if _, ok := validColumnMap[column]; !ok {
+			return nil, fmt.Errorf("invalid column of relation %s. %s",
+				name, column)
+		}
+	}
+	r := &relation{name: name, table: tableName(related), columns: columns}
+
+	// Like gorm, the foreign key is in the association if it has one or
+	// many of the model, or in the model if it belongs to the association.
+	foreignKey := gormSetting(field, "ForeignKey")
+	associationKey := gormSetting(field, "AssociationForeignKey")
+	modelKey := "id"
+	relatedKey := "id"
+	if f, ok := relatedType.FieldByName(foreignKey); ok {
+		relatedKey = fieldColumn(f)
+		if f, ok := modelType.FieldByName(associationKey); ok {
+			modelKey = fieldColumn(f)
+		}
+	} else if f, ok := modelType.FieldByName(foreignKey); ok &&
+		field.Type.Kind() != reflect.Slice {
+		modelKey = fieldColumn(f)
+		if f, ok := relatedType.FieldByName(associationKey); ok {
+			relatedKey = fieldColumn(f)
+		}
+	} else {
+		return nil, fmt.Errorf("invalid foreign key of relation %s. %s",
+			name, foreignKey)
+	}
+	r.on = fmt.Sprintf("\"%s\".\"%s\" = \"%s\".\"%s\"",
+		name, relatedKey, tableName(model), modelKey)
+	return r, nil
+}
+
+// filterColumnFormat returns a function of the format of filter conditions
+// of columns compared with values, which returns false for invalid columns.
+// Conditions fill "%s" of formats.
+func (opt *SQLOptions) filterColumnFormat(db *gorm.DB) (
+	func(string, interface{}) (string, bool), error) {
+	validColumnMap, err := opt.validColumnMap(db.Value)
+	if err != nil {
+		return nil, err
+	}
+	relations := map[string]*relation{}
+	for name, columns := range opt.relations {
+		r, err := opt.resolveRelation(db.Value, name, columns,
+			func(model interface{}) string {
+				return db.NewScope(model).TableName()
+			})
+		if err != nil {
+			return nil, err
+		}
+		relations[name] = r
+	}
+	return columnFormatFunc(validColumnMap, relations, opt.jsonColumns), nil
+}
+
+// jsonPathCast returns the cast of JSONB paths compared with value, or of
+// all values of IN and BETWEEN, so numbers and booleans aren't compared as
+// text. Paths of other values stay text.
+func jsonPathCast(value interface{}) string {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	cast := ""
+	for i, v := range values {
+		var c string
+		switch v.(type) {
+		case float64, float32, int, int8, int16, int32, int64, uint, uint8,
+			uint16, uint32, uint64, json.Number:
+			c = "::numeric"
+		case bool:
+			c = "::boolean"
+		}
+		if i > 0 && c != cast {
+			return ""
+		}
+		cast = c
+	}
+	return cast
+}
+
+func columnFormatFunc(validColumnMap map[string]struct{},
+	relations map[string]*relation, jsonColumns map[string]struct{}) func(
+	string, interface{}) (string, bool) {
+	return func(column string, value interface{}) (string, bool) {
+		p, ok := parseColumnPath(column)
+		if !ok {
+			return "", false
+		}
+		expr := fmt.Sprintf("\"%s\"", p.column)
+		name := p.column
+		var r *relation
+		if len(p.relation) > 0 {
+			if r, ok = relations[p.relation]; !ok {
+				return "", false
+			}
+			if _, ok = r.columns[p.column]; !ok {
+				return "", false
+			}
+			expr = fmt.Sprintf("\"%s\".%s", p.relation, expr)
+			name = p.relation + "." + p.column
+		} else if _, ok = validColumnMap[p.column]; !ok {
+			return "", false
+		}
+		if len(p.keys) > 0 {
+			if _, ok = jsonColumns[name]; !ok {
+				return "", false
+			}
+			expr = fmt.Sprintf("(%s #>> '{%s}')%s", expr,
+				strings.Join(p.keys, ","), jsonPathCast(value))
+		}
+		if r != nil {
+			return r.format(expr), true
+		}
+		return expr + " %s", true
+	}
+}
@@ -0,0 +1,203 @@
+package customquery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type relationUser struct {
+	ID       string `gorm:"column:id"`
+	Email    string `gorm:"column:email"`
+	Password string `gorm:"column:password"`
+	Metadata string `gorm:"column:metadata"`
+}
+
+func (relationUser) TableName() string {
+	return "user"
+}
+
+type relationKYC struct {
+	ID       string           `gorm:"column:id"`
+	UserID   string           `gorm:"column:user_id"`
+	User     relationUser     `gorm:"ForeignKey:UserID"`
+	Files    []relationFile   `gorm:"ForeignKey:KYCID"`
+	Document relationDocument `gorm:"ForeignKey:KYCID;AssociationForeignKey:ID"`
+	Payload  string           `gorm:"column:payload"`
+}
+
+func (relationKYC) TableName() string {
+	return "kyc"
+}
+
+type relationFile struct {
+	ID    string `gorm:"column:id"`
+	KYCID string `gorm:"column:kyc_id"`
+	Name  string `gorm:"column:name"`
+}
+
+func (relationFile) TableName() string {
+	return "kyc_file"
+}
+
+type relationDocument struct {
+	ID    string `gorm:"column:id"`
+	KYCID string `gorm:"column:kyc_ref"`
+	Type  string `gorm:"column:type"`
+}
+
+func (relationDocument) TableName() string {
+	return "kyc_document"
+}
+
+// RelationSuite tests filters of relations and JSONB columns.
+type RelationSuite struct {
+	suite.Suite
+}
+
+func (suite *RelationSuite) tableName(model interface{}) string {
+	return model.(interface{ TableName() string }).TableName()
+}
+
+func (suite *RelationSuite) columnFormat(opt *SQLOptions) func(string,
+	interface{}) (string, bool) {
+	validColumnMap, err := opt.validColumnMap(&relationKYC{})
+	suite.Require().Nil(err)
+	relations := map[string]*relation{}
+	for name, columns := range opt.relations {
+		r, err := opt.resolveRelation(&relationKYC{}, name, columns,
+			suite.tableName)
+		suite.Require().Nil(err)
+		relations[name] = r
+	}
+	return columnFormatFunc(validColumnMap, relations, opt.jsonColumns)
+}
+
+func (suite *RelationSuite) TestParseColumnPath() {
+	p, ok := parseColumnPath("user.metadata->address->city")
+	suite.Require().True(ok)
+	suite.Require().Equal(columnPath{
+		relation: "user",
+		column:   "metadata",
+		keys:     []string{"address", "city"},
+	}, p)
+
+	invalids := []string{
+		"user.",
+		".email",
+		"user.profile.email",
+		"metadata->",
+		"metadata->'a'",
+		`user"."email`,
+	}
+	for _, column := range invalids {
+		_, ok := parseColumnPath(column)
+		suite.Require().False(ok, column)
+	}
+}
+
+func (suite *RelationSuite) TestResolveRelation() {
+	opt := SQLOptions{}
+
+	// The foreign key belongs to the model.
+	email := map[string]struct{}{"email": struct{}{}}
+	r, err := opt.resolveRelation(&relationKYC{}, "user", email,
+		suite.tableName)
+	suite.Require().Nil(err)
+	suite.Require().Equal("user", r.table)
+	suite.Require().Equal(`"user"."id" = "kyc"."user_id"`, r.on)
+	suite.Require().Equal(email, r.columns)
+
+	// Only columns of the associated model are allowed.
+	_, err = opt.resolveRelation(&relationKYC{}, "user",
+		map[string]struct{}{"secret": struct{}{}}, suite.tableName)
+	suite.Require().NotNil(err)
+
+	// The foreign key is in the association which has many of the model.
+	r, err = opt.resolveRelation(&relationKYC{}, "files", nil,
+		suite.tableName)
+	suite.Require().Nil(err)
+	suite.Require().Equal(`"files"."kyc_id" = "kyc"."id"`, r.on)
+
+	r, err = opt.resolveRelation(&relationKYC{}, "document", nil,
+		suite.tableName)
+	suite.Require().Nil(err)
+	suite.Require().Equal(`"document"."kyc_ref" = "kyc"."id"`, r.on)
+
+	// Only associations are relations.
+	_, err = opt.resolveRelation(&relationKYC{}, "payload", nil,
+		suite.tableName)
+	suite.Require().NotNil(err)
+	_, err = opt.resolveRelation(&relationKYC{}, "users", nil,
+		suite.tableName)
+	suite.Require().NotNil(err)
+}
+
+func (suite *RelationSuite) TestFilter() {
+	opt := SQLOptions{}
+	opt.AllowRelations("user.email", "user.metadata", "files.name",
+		"document")
+	opt.AllowJSONColumns("payload", "user.metadata")
+	columnFormat := suite.columnFormat(&opt)
+
+	filter, err := ParseCompactFilter(
+		"user.email:eq:a@b.c,files.name:like:id%,payload->document->type:eq:passport," +
+			"user.metadata->level:in:1|2")
+	suite.Require().Nil(err)
+	format, values, err := evaluateFilter(filter, columnFormat)
+	suite.Require().Nil(err)
+	suite.Require().Equal(`(EXISTS (SELECT 1 FROM "user" AS "user"`+
+		` WHERE "user"."id" = "kyc"."user_id" AND "user"."email" = ?))`+
+		` AND (EXISTS (SELECT 1 FROM "kyc_file" AS "files"`+
+		` WHERE "files"."kyc_id" = "kyc"."id" AND "files"."name" LIKE ?))`+
+		` AND (("payload" #>> '{document,type}') = ?)`+
+		` AND (EXISTS (SELECT 1 FROM "user" AS "user"`+
+		` WHERE "user"."id" = "kyc"."user_id"`+
+		` AND ("user"."metadata" #>> '{level}') IN (?)))`, format)
+	suite.Require().Equal([]interface{}{
+		"a@b.c", "id%", "passport", []interface{}{"1", "2"},
+	}, values)
+
+	// JSONB paths are cast to the types of numbers and booleans.
+	casts := []struct {
+		op       string
+		column   string
+		value    interface{}
+		expected string
+	}{
+		{"greater_than", "payload->level", float64(1),
+			`("payload" #>> '{level}')::numeric > ?`},
+		{"equal", "payload->vip", true,
+			`("payload" #>> '{vip}')::boolean = ?`},
+		{"in", "payload->level", []interface{}{1.0, 2.0},
+			`("payload" #>> '{level}')::numeric IN (?)`},
+		{"in", "payload->level", []interface{}{1.0, "2"},
+			`("payload" #>> '{level}') IN (?)`},
+	}
+	for _, c := range casts {
+		format, _, err := evaluateFilter(map[string]interface{}{
+			c.op: map[string]interface{}{"column": c.column, "value": c.value},
+		}, columnFormat)
+		suite.Require().Nil(err)
+		suite.Require().Equal(c.expected, format)
+	}
+
+	// Columns of relations and JSONB paths should be allowed.
+	invalids := []string{
+		"document.type",
+		"user.password",
+		"user.secret",
+		"user.email->a",
+		"metadata->a",
+		"user_id->a",
+		"email",
+	}
+	for _, column := range invalids {
+		_, ok := columnFormat(column, nil)
+		suite.Require().False(ok, column)
+	}
+}
+
+func TestRelation(t *testing.T) {
+	suite.Run(t, new(RelationSuite))
+}
@@ -1,10 +1,14 @@
 package customquery
 
-// Result defines a struct for custom query result.
+// Result defines a struct for custom query result. In cursor mode, Page and
+// TotalPage are 0, TotalCount is -1 unless it's counted, and cursors of the
+// adjacent pages are set if they exist.
 type Result struct {
 	Limit      int         `json:"limit"`
 	Page       int         `json:"page"`
 	TotalPage  int         `json:"total_page"`
 	TotalCount int         `json:"total_count"`
+	NextCursor *string     `json:"next_cursor,omitempty"`
+	PrevCursor *string     `json:"prev_cursor,omitempty"`
 	Data       interface{} `json:"-"`
 }
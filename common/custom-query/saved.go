@@ -0,0 +1,341 @@
+package customquery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jiarung/gorm"
+	"github.com/satori/go.uuid"
+)
+
+// ErrSavedQueryNotFound is returned if a saved query doesn't exist or isn't
+// visible to the user.
+var ErrSavedQueryNotFound = errors.New("saved query not found")
+
+// paramPattern matches placeholders of parameters in filter values, like
+// "${user_id}".
+var paramPattern = regexp.MustCompile(`^\$\{([a-z_][a-z0-9_]*)\}$`)
+
+// SavedOptions are SQLOptions in JSON, which are responded as objects.
+type SavedOptions string
+
+// MarshalJSON implements json.Marshaler.
+func (o SavedOptions) MarshalJSON() ([]byte, error) {
+	if len(o) == 0 {
+		return []byte("null"), nil
+	}
+	return []byte(o), nil
+}
+
+// SavedQuery is SQLOptions saved by a user, which could be shared to other
+// users by its ID and executed with parameters.
+type SavedQuery struct {
+	ID     uuid.UUID `json:"id" gorm:"primary_key"`
+	UserID uuid.UUID `json:"user_id" gorm:"index"`
+	Name   string    `json:"name"`
+	// Target is the endpoint whose model validated the options, like kycs.
+	Target string `json:"target" gorm:"index"`
+	// Shared queries are visible to all users.
+	Shared    bool         `json:"shared"`
+	Options   SavedOptions `json:"options" sql:"type:jsonb"`
+	Params    []string     `json:"params" sql:"-"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// TableName returns the table of saved queries.
+func (q *SavedQuery) TableName() string {
+	return "custom_query_saved_queries"
+}
+
+// NewSavedQuery validates opt by query of the model of target, and returns
+// the saved query of opt. Limit, page and cursor aren't saved since they're
+// given when the query is executed. Filter values could be placeholders of
+// parameters like "${user_id}", which are elements of "in" and "between"
+// values.
+func NewSavedQuery(userID uuid.UUID, name, target string, opt *SQLOptions,
+	query *gorm.DB) (*SavedQuery, error) {
+	saved := *opt
+	saved.Limit = nil
+	saved.Page = nil
+	saved.Cursor = nil
+	saved.Count = nil
+	if _, err := saved.ApplyUnpaged(query); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&saved)
+	if err != nil {
+		return nil, err
+	}
+	q := &SavedQuery{
+		ID:      uuid.NewV4(),
+		UserID:  userID,
+		Name:    name,
+		Target:  target,
+		Options: SavedOptions(data),
+	}
+	q.Params = saved.Params()
+	return q, nil
+}
+
+// Visible returns whether q is visible to userID.
+func (q *SavedQuery) Visible(userID uuid.UUID) bool {
+	return q.Shared || uuid.Equal(q.UserID, userID)
+}
+
+// SQLOptions returns options of q whose placeholders are replaced by
+// params. All parameters of q should be given.
+func (q *SavedQuery) SQLOptions(params map[string]interface{}) (
+	*SQLOptions, error) {
+	var opt SQLOptions
+	if err := json.Unmarshal([]byte(q.Options), &opt); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal options. err: %v", err)
+	}
+	return opt.Substitute(params)
+}
+
+// Params returns names of parameters in filter and having of opt.
+func (opt *SQLOptions) Params() []string {
+	names := map[string]struct{}{}
+	for _, f := range []*Filter{opt.Filter, opt.Having} {
+		if f == nil {
+			continue
+		}
+		substituteParams(map[string]interface{}(*f),
+			func(name string) (interface{}, error) {
+				names[name] = struct{}{}
+				return nil, nil
+			})
+	}
+	params := make([]string, 0, len(names))
+	for name := range names {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	return params
+}
+
+// Substitute returns a copy of opt whose placeholders of parameters in
+// filter and having are replaced by params.
+func (opt *SQLOptions) Substitute(params map[string]interface{}) (
+	*SQLOptions, error) {
+	lookup := func(name string) (interface{}, error) {
+		v, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("missing param. %s", name)
+		}
+		return v, nil
+	}
+	result := *opt
+	for _, f := range []**Filter{&result.Filter, &result.Having} {
+		if *f == nil {
+			continue
+		}
+		v, err := substituteParams(map[string]interface{}(**f), lookup)
+		if err != nil {
+			return nil, err
+		}
+		filter := Filter(v.(map[string]interface{}))
+		*f = &filter
+	}
+	return &result, nil
+}
+
+// substituteParams returns a copy of v whose placeholders are replaced by
+// lookup. Columns aren't replaced, so parameters are only bound as values.
+func substituteParams(v interface{},
+	lookup func(name string) (interface{}, error)) (interface{}, error) {
+	switch v := v.(type) {
+	case Filter:
+		return substituteParams(map[string]interface{}(v), lookup)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if key == "column" {
+				m[key] = value
+				continue
+			}
+			substituted, err := substituteParams(value, lookup)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = substituted
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			substituted, err := substituteParams(value, lookup)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = substituted
+		}
+		return s, nil
+	case string:
+		if match := paramPattern.FindStringSubmatch(v); match != nil {
+			return lookup(match[1])
+		}
+	}
+	return v, nil
+}
+
+// SavedQueryStore stores saved queries.
+type SavedQueryStore interface {
+	// SavedQuery returns query id, or ErrSavedQueryNotFound.
+	SavedQuery(id uuid.UUID) (*SavedQuery, error)
+	// SavedQueries returns queries of target visible to userID.
+	SavedQueries(userID uuid.UUID, target string) ([]*SavedQuery, error)
+	SaveQuery(q *SavedQuery) error
+	DeleteQuery(id uuid.UUID) error
+}
+
+// VisibleSavedQuery returns query id of store visible to userID, or
+// ErrSavedQueryNotFound.
+func VisibleSavedQuery(store SavedQueryStore, userID, id uuid.UUID) (
+	*SavedQuery, error) {
+	q, err := store.SavedQuery(id)
+	if err != nil {
+		return nil, err
+	}
+	if !q.Visible(userID) {
+		return nil, ErrSavedQueryNotFound
+	}
+	return q, nil
+}
+
+// MemorySavedQueries keeps saved queries in memory for tests and local
+// development.
+type MemorySavedQueries struct {
+	mutex   sync.Mutex
+	queries map[uuid.UUID]*SavedQuery
+}
+
+// NewMemorySavedQueries returns an empty MemorySavedQueries.
+func NewMemorySavedQueries() *MemorySavedQueries {
+	return &MemorySavedQueries{queries: make(map[uuid.UUID]*SavedQuery)}
+}
+
+// SavedQuery implements SavedQueryStore.
+func (m *MemorySavedQueries) SavedQuery(id uuid.UUID) (*SavedQuery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	q, ok := m.queries[id]
+	if !ok {
+		return nil, ErrSavedQueryNotFound
+	}
+	copied := *q
+	return &copied, nil
+}
+
+// SavedQueries implements SavedQueryStore.
+func (m *MemorySavedQueries) SavedQueries(userID uuid.UUID, target string) (
+	[]*SavedQuery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var queries []*SavedQuery
+	for _, q := range m.queries {
+		if q.Target == target && q.Visible(userID) {
+			copied := *q
+			queries = append(queries, &copied)
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].CreatedAt.Before(queries[j].CreatedAt)
+	})
+	return queries, nil
+}
+
+// SaveQuery implements SavedQueryStore.
+func (m *MemorySavedQueries) SaveQuery(q *SavedQuery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if q.CreatedAt.IsZero() {
+		q.CreatedAt = now
+	}
+	q.UpdatedAt = now
+	copied := *q
+	m.queries[q.ID] = &copied
+	return nil
+}
+
+// DeleteQuery implements SavedQueryStore.
+func (m *MemorySavedQueries) DeleteQuery(id uuid.UUID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.queries[id]; !ok {
+		return ErrSavedQueryNotFound
+	}
+	delete(m.queries, id)
+	return nil
+}
+
+// DBSavedQueries stores saved queries in table custom_query_saved_queries.
+type DBSavedQueries struct {
+	DB *gorm.DB
+}
+
+// SavedQuery implements SavedQueryStore.
+func (d *DBSavedQueries) SavedQuery(id uuid.UUID) (*SavedQuery, error) {
+	var q SavedQuery
+	result := d.DB.Where("id = ?", id).First(&q)
+	if result.RecordNotFound() {
+		return nil, ErrSavedQueryNotFound
+	} else if result.Error != nil {
+		return nil, result.Error
+	}
+	if err := q.loadParams(); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// SavedQueries implements SavedQueryStore.
+func (d *DBSavedQueries) SavedQueries(userID uuid.UUID, target string) (
+	[]*SavedQuery, error) {
+	var queries []*SavedQuery
+	err := d.DB.Where("target = ? AND (user_id = ? OR shared)", target, userID).
+		Order("created_at").Find(&queries).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range queries {
+		if err := q.loadParams(); err != nil {
+			return nil, err
+		}
+	}
+	return queries, nil
+}
+
+// SaveQuery implements SavedQueryStore.
+func (d *DBSavedQueries) SaveQuery(q *SavedQuery) error {
+	return d.DB.Save(q).Error
+}
+
+// DeleteQuery implements SavedQueryStore.
+func (d *DBSavedQueries) DeleteQuery(id uuid.UUID) error {
+	result := d.DB.Where("id = ?", id).Delete(&SavedQuery{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSavedQueryNotFound
+	}
+	return nil
+}
+
+// loadParams sets Params of q loaded from tables.
+func (q *SavedQuery) loadParams() error {
+	var opt SQLOptions
+	if err := json.Unmarshal([]byte(q.Options), &opt); err != nil {
+		return fmt.Errorf("failed to unmarshal options. err: %v", err)
+	}
+	q.Params = opt.Params()
+	return nil
+}
@@ -0,0 +1,123 @@
+package customquery
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+// SavedSuite tests parameters and stores of saved queries.
+type SavedSuite struct {
+	suite.Suite
+}
+
+func (suite *SavedSuite) options() *SQLOptions {
+	filter := Filter{"and": []interface{}{
+		map[string]interface{}{"equal": map[string]interface{}{
+			"column": "user_id", "value": "${user_id}"}},
+		map[string]interface{}{"between": map[string]interface{}{
+			"column": "amount", "value": []interface{}{"${min}", 1000}}},
+		map[string]interface{}{"in": map[string]interface{}{
+			"column": "status", "value": []interface{}{"${status}", "done"}}},
+	}}
+	return &SQLOptions{Filter: &filter}
+}
+
+func (suite *SavedSuite) TestParams() {
+	suite.Require().Equal([]string{"min", "status", "user_id"},
+		suite.options().Params())
+	suite.Require().Empty((&SQLOptions{}).Params())
+}
+
+func (suite *SavedSuite) TestSubstitute() {
+	opt := suite.options()
+	substituted, err := opt.Substitute(map[string]interface{}{
+		"user_id": "u1", "min": 10, "status": "new"})
+	suite.Require().Nil(err)
+	format, values, err := evaluateFilterWithValidator(*substituted.Filter,
+		map[string]struct{}{
+			"user_id": struct{}{}, "amount": struct{}{}, "status": struct{}{}})
+	suite.Require().Nil(err)
+	suite.Require().Contains(format, `"user_id" = ?`)
+	suite.Require().Contains(values, "u1")
+	suite.Require().Contains(values, 10)
+	suite.Require().Empty(substituted.Params())
+
+	// opt isn't changed.
+	suite.Require().Len(opt.Params(), 3)
+
+	_, err = opt.Substitute(map[string]interface{}{"user_id": "u1"})
+	suite.Require().NotNil(err)
+
+	// Columns aren't parameters.
+	filter := Filter{"equal": map[string]interface{}{
+		"column": "${column}", "value": "${value}"}}
+	opt = &SQLOptions{Filter: &filter}
+	suite.Require().Equal([]string{"value"}, opt.Params())
+	substituted, err = opt.Substitute(map[string]interface{}{"value": 1})
+	suite.Require().Nil(err)
+	suite.Require().Equal(Filter{"equal": map[string]interface{}{
+		"column": "${column}", "value": 1}}, *substituted.Filter)
+}
+
+func (suite *SavedSuite) TestSQLOptions() {
+	data, err := json.Marshal(suite.options())
+	suite.Require().Nil(err)
+	q := &SavedQuery{Options: SavedOptions(data)}
+	opt, err := q.SQLOptions(map[string]interface{}{
+		"user_id": "u1", "min": 10, "status": "new"})
+	suite.Require().Nil(err)
+	suite.Require().NotNil(opt.Filter)
+	suite.Require().Empty(opt.Params())
+
+	_, err = q.SQLOptions(nil)
+	suite.Require().NotNil(err)
+
+	data, err = json.Marshal(q)
+	suite.Require().Nil(err)
+	var m map[string]interface{}
+	suite.Require().Nil(json.Unmarshal(data, &m))
+	suite.Require().IsType(map[string]interface{}{}, m["options"])
+}
+
+func (suite *SavedSuite) TestMemoryStore() {
+	store := NewMemorySavedQueries()
+	owner, other := uuid.NewV4(), uuid.NewV4()
+	private := &SavedQuery{ID: uuid.NewV4(), UserID: owner, Target: "kycs"}
+	shared := &SavedQuery{ID: uuid.NewV4(), UserID: owner, Target: "kycs",
+		Shared: true}
+	trades := &SavedQuery{ID: uuid.NewV4(), UserID: owner, Target: "trades"}
+	for _, q := range []*SavedQuery{private, shared, trades} {
+		suite.Require().Nil(store.SaveQuery(q))
+		time.Sleep(time.Millisecond)
+	}
+
+	queries, err := store.SavedQueries(owner, "kycs")
+	suite.Require().Nil(err)
+	suite.Require().Len(queries, 2)
+	suite.Require().Equal(private.ID, queries[0].ID)
+	queries, err = store.SavedQueries(other, "kycs")
+	suite.Require().Nil(err)
+	suite.Require().Len(queries, 1)
+	suite.Require().Equal(shared.ID, queries[0].ID)
+
+	q, err := VisibleSavedQuery(store, other, shared.ID)
+	suite.Require().Nil(err)
+	suite.Require().Equal(shared.ID, q.ID)
+	_, err = VisibleSavedQuery(store, other, private.ID)
+	suite.Require().Equal(ErrSavedQueryNotFound, err)
+	_, err = VisibleSavedQuery(store, owner, uuid.NewV4())
+	suite.Require().Equal(ErrSavedQueryNotFound, err)
+
+	suite.Require().Nil(store.DeleteQuery(private.ID))
+	suite.Require().Equal(ErrSavedQueryNotFound, store.DeleteQuery(private.ID))
+	_, err = store.SavedQuery(private.ID)
+	suite.Require().Equal(ErrSavedQueryNotFound, err)
+}
+
+func TestSaved(t *testing.T) {
+	suite.Run(t, new(SavedSuite))
+}
@@ -24,6 +24,30 @@ type SQLOptions struct {
 	Order  *Order  `json:"order;omitempty"`
 	Limit  *int    `json:"limit;omitempty"`
 	Page   *int    `json:"page;omitempty"`
+
+	// Cursor is the cursor of the page in cursor mode, which is empty for
+	// the first page.
+	Cursor *string `json:"cursor,omitempty"`
+	// Count is whether to count total rows in cursor mode.
+	Count *bool `json:"count,omitempty"`
+
+	// Fields are the columns to select, which are all columns if it's empty.
+	Fields []string `json:"fields,omitempty"`
+	// GroupBy are the columns to group rows by.
+	GroupBy []string `json:"group_by,omitempty"`
+	// Aggregates are the aggregates of grouped rows.
+	Aggregates []Aggregate `json:"aggregates,omitempty"`
+	// Having filters grouped rows by group_by columns and aliases of
+	// aggregates.
+	Having *Filter `json:"having,omitempty"`
+
+	// compactColumns are columns of compact filter and sort, which are
+	// validated with their positions.
+	compactColumns []compactColumn
+	// relations are allowed columns of relations by AllowRelations, and
+	// jsonColumns are allowed by AllowJSONColumns.
+	relations   map[string]map[string]struct{}
+	jsonColumns map[string]struct{}
 }
 
 // NewSQLOptionsFromAppCtxBody returns a new instance of SQLOptions,
@@ -45,14 +69,32 @@ func NewSQLOptionsFromAppCtxQuery(appCtx *apicontext.AppContext) (
 	*SQLOptions, error) {
 	opt := SQLOptions{}
 
-	if filterStr, exists := appCtx.GetQuery("filter"); exists {
-		filterB := []byte(filterStr)
+	for _, param := range []string{"filter", "having"} {
+		filterStr, exists := appCtx.GetQuery(param)
+		if !exists {
+			continue
+		}
 		var filter Filter
-		err := json.Unmarshal(filterB, &filter)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal filter. err: %v", err)
+		if !isCompactFilter(filterStr) {
+			filterB := []byte(filterStr)
+			err := json.Unmarshal(filterB, &filter)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal %s. err: %v", param, err)
+			}
+		} else {
+			var columns []compactColumn
+			var err error
+			filter, columns, err = parseCompactFilter(param, filterStr)
+			if err != nil {
+				return nil, err
+			}
+			opt.compactColumns = append(opt.compactColumns, columns...)
+		}
+		if param == "having" {
+			opt.Having = &filter
+		} else {
+			opt.Filter = &filter
 		}
-		opt.Filter = &filter
 	}
 
 	if orderStr, exists := appCtx.GetQuery("order"); exists {
@@ -65,6 +107,46 @@ func NewSQLOptionsFromAppCtxQuery(appCtx *apicontext.AppContext) (
 		opt.Order = &order
 	}
 
+	if sortStr, exists := appCtx.GetQuery("sort"); exists {
+		if opt.Order != nil {
+			return nil, &ParseError{
+				Param: "sort", Pos: 1, Reason: "conflicts_with_order",
+				Token: sortStr}
+		}
+		order, columns, err := parseSort(sortStr)
+		if err != nil {
+			return nil, err
+		}
+		opt.compactColumns = append(opt.compactColumns, columns...)
+		opt.Order = &order
+	}
+
+	for _, param := range []string{"fields", "group_by"} {
+		fieldsStr, exists := appCtx.GetQuery(param)
+		if !exists {
+			continue
+		}
+		fields, columns, err := parseFields(param, fieldsStr)
+		if err != nil {
+			return nil, err
+		}
+		opt.compactColumns = append(opt.compactColumns, columns...)
+		if param == "group_by" {
+			opt.GroupBy = fields
+		} else {
+			opt.Fields = fields
+		}
+	}
+
+	if aggregatesStr, exists := appCtx.GetQuery("aggregates"); exists {
+		aggregates, columns, err := parseAggregates(aggregatesStr)
+		if err != nil {
+			return nil, err
+		}
+		opt.compactColumns = append(opt.compactColumns, columns...)
+		opt.Aggregates = aggregates
+	}
+
 	if limitStr, exists := appCtx.GetQuery("limit"); exists {
 		limit, err := strconv.Atoi(limitStr)
 		if err != nil {
@@ -81,6 +163,18 @@ func NewSQLOptionsFromAppCtxQuery(appCtx *apicontext.AppContext) (
 		opt.Page = &page
 	}
 
+	if cursor, exists := appCtx.GetQuery("cursor"); exists {
+		opt.Cursor = &cursor
+	}
+
+	if countStr, exists := appCtx.GetQuery("count"); exists {
+		count, err := strconv.ParseBool(countStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse count. err: %v", err)
+		}
+		opt.Count = &count
+	}
+
 	return &opt, nil
 }
 
@@ -106,6 +200,11 @@ func (opt *SQLOptions) Apply(db *gorm.DB, defaultLimit, defaultPage, maxRecords
 		return nil, -1, -1, -1, -1, err
 	}
 
+	result, err = opt.applyProjectionIfNeeded(result)
+	if err != nil {
+		return nil, -1, -1, -1, -1, err
+	}
+
 	result, err = opt.applyOrderIfNeeded(result)
 	if err != nil {
 		return nil, -1, -1, -1, -1, err
@@ -124,6 +223,14 @@ func (opt *SQLOptions) Apply(db *gorm.DB, defaultLimit, defaultPage, maxRecords
 	var count int
 	if maxRecords != 0 {
 		count = maxRecords
+	} else if opt.grouped() && len(opt.GroupBy) == 0 {
+		// Aggregates of all rows are a single row.
+		count = 1
+	} else if opt.grouped() {
+		if count, err = countGroups(result); err != nil {
+			err = fmt.Errorf("failed to count groups. err: %v", err)
+			return nil, -1, -1, -1, 1, err
+		}
 	} else {
 		countResult := result.Count(&count)
 		if countResult.Error != nil {
@@ -148,18 +255,79 @@ func (opt *SQLOptions) Apply(db *gorm.DB, defaultLimit, defaultPage, maxRecords
 	return
 }
 
+// counted returns whether Apply counts rows of opt, instead of taking
+// maxRecords or a single row of aggregates of all rows.
+func (opt *SQLOptions) counted(maxRecords int) bool {
+	return maxRecords == 0 && !(opt.grouped() && len(opt.GroupBy) == 0)
+}
+
+// countGroups counts rows of grouped query by a subquery, since Count of a
+// grouped query counts rows of the first group instead.
+func countGroups(query *gorm.DB) (int, error) {
+	var count int
+	err := query.New().Raw("SELECT count(*) FROM (?) AS grouped",
+		query.QueryExpr()).Row().Scan(&count)
+	return count, err
+}
+
+// ApplyUnpaged applies db with filter, projection and order of opt without
+// counting and paging, e.g. to stream all rows by Rows.
+func (opt *SQLOptions) ApplyUnpaged(db *gorm.DB) (*gorm.DB, error) {
+	result, err := opt.applyFilterIfNeeded(db)
+	if err != nil {
+		return nil, err
+	}
+	result, err = opt.applyProjectionIfNeeded(result)
+	if err != nil {
+		return nil, err
+	}
+	return opt.applyOrderIfNeeded(result)
+}
+
+// validateCompactColumns validates columns of compact params like filter
+// and sort, so errors of invalid columns carry their positions.
+func (opt *SQLOptions) validateCompactColumns(param string,
+	validColumnMap map[string]struct{}) error {
+	return opt.validateCompactColumnsFor(param, validColumnMap, "unknown_column")
+}
+
+// validateCompactColumnsFor validates columns of param like
+// validateCompactColumns with reason of errors.
+func (opt *SQLOptions) validateCompactColumnsFor(param string,
+	validColumnMap map[string]struct{}, reason string) error {
+	for _, c := range opt.compactColumns {
+		if c.param != param {
+			continue
+		}
+		if err := c.validate(validColumnMap, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (opt *SQLOptions) applyFilterIfNeeded(db *gorm.DB) (
 	*gorm.DB, error) {
 	if opt.Filter != nil {
-		validColumnMap, err := opt.validColumnMap(db.Value)
+		columnFormat, err := opt.filterColumnFormat(db)
 		if err != nil {
 			return nil, err
 		}
-		result, err := opt.Filter.applyDB(db, validColumnMap)
+		// Columns of compact filters are validated first for positions.
+		for _, c := range opt.compactColumns {
+			if c.param != "filter" {
+				continue
+			}
+			if _, ok := columnFormat(c.column, nil); !ok {
+				return nil, &ParseError{Param: c.param, Pos: c.pos,
+					Reason: "unknown_column", Token: c.column}
+			}
+		}
+		format, values, err := evaluateFilter(*opt.Filter, columnFormat)
 		if err != nil {
 			return nil, err
 		}
-		return result, nil
+		return db.Where(format, values...), nil
 	}
 
 	return db, nil
@@ -168,10 +336,13 @@ func (opt *SQLOptions) applyFilterIfNeeded(db *gorm.DB) (
 func (opt *SQLOptions) applyOrderIfNeeded(db *gorm.DB) (
 	*gorm.DB, error) {
 	if opt.Order != nil {
-		validColumnMap, err := opt.validColumnMap(db.Value)
+		validColumnMap, err := opt.orderColumnMap(db.Value)
 		if err != nil {
 			return nil, err
 		}
+		if err := opt.validateCompactColumns("sort", validColumnMap); err != nil {
+			return nil, err
+		}
 		result, err := opt.Order.applyDB(db, validColumnMap)
 		if err != nil {
 			return nil, err
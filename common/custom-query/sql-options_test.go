@@ -2,8 +2,10 @@ package customquery
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jiarung/gorm"
+	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/jiarung/mochi/database"
@@ -205,6 +207,199 @@ func (suite *SQLOptionsTestSuite) TestLimit() {
 	}
 }
 
+func (suite *SQLOptionsTestSuite) findCursor(opt *SQLOptions, order Order) (
+	[]models.User, *Result) {
+	query, page, err := opt.ApplyCursor(
+		suite.db.Model(&models.User{}), 2, order, DefaultPrimaryKey)
+	suite.Require().Nil(err)
+	var users []models.User
+	suite.Require().Nil(query.Find(&users).Error)
+	result, err := page.Result(&users)
+	suite.Require().Nil(err)
+	return users, result
+}
+
+func (suite *SQLOptionsTestSuite) TestApplyCursor() {
+	exchangetest.CreateTestingUser(suite.db, 5)
+	var expected []models.User
+	suite.Require().Nil(suite.db.Order("created_at DESC, id DESC").
+		Find(&expected).Error)
+	order, err := ParseOrder("created_at DESC")
+	suite.Require().Nil(err)
+
+	count := true
+	opt := SQLOptions{Count: &count}
+	var cursors []*string
+	var found []models.User
+	for {
+		users, result := suite.findCursor(&opt, order)
+		if opt.Cursor == nil {
+			suite.Require().Equal(5, result.TotalCount)
+			suite.Require().Nil(result.PrevCursor)
+		} else {
+			suite.Require().NotNil(result.PrevCursor)
+		}
+		found = append(found, users...)
+		cursors = append(cursors, result.PrevCursor)
+		if result.NextCursor == nil {
+			break
+		}
+		opt = SQLOptions{Cursor: result.NextCursor}
+	}
+	suite.Require().Len(cursors, 3)
+	suite.Require().Len(found, len(expected))
+	for i := range expected {
+		suite.Require().Equal(expected[i].ID, found[i].ID)
+	}
+
+	// Go back to the first page.
+	users, result := suite.findCursor(&SQLOptions{Cursor: cursors[1]}, order)
+	suite.Require().Len(users, 2)
+	suite.Require().Equal(expected[0].ID, users[0].ID)
+	suite.Require().Equal(expected[1].ID, users[1].ID)
+	suite.Require().Nil(result.PrevCursor)
+	suite.Require().NotNil(result.NextCursor)
+	suite.Require().Equal(-1, result.TotalCount)
+
+	// Cursors are bound to the order.
+	order, err = ParseOrder("created_at ASC")
+	suite.Require().Nil(err)
+	_, _, err = (&SQLOptions{Cursor: cursors[1]}).ApplyCursor(
+		suite.db.Model(&models.User{}), 2, order, DefaultPrimaryKey)
+	suite.Require().Equal(ErrInvalidCursor, err)
+	// The primary key must be a column of the model.
+	_, _, err = (&SQLOptions{}).ApplyCursor(
+		suite.db.Model(&models.User{}), 2, order, "no_such_column")
+	suite.Require().Error(err)
+}
+
+func (suite *SQLOptionsTestSuite) TestApplyAggregates() {
+	exchangetest.CreateTestingUser(suite.db, 3)
+	type row struct {
+		Count int64 `gorm:"column:count"`
+	}
+
+	// Aggregates of all rows are a single row.
+	opt := SQLOptions{Aggregates: []Aggregate{{Function: "count"}}}
+	query, _, _, _, totalCount, err := opt.Apply(
+		suite.db.Model(&models.User{}), 50, 1, 0)
+	suite.Require().Nil(err)
+	suite.Require().Equal(1, totalCount)
+	var rows []row
+	suite.Require().Nil(query.Scan(&rows).Error)
+	suite.Require().Equal([]row{{Count: 3}}, rows)
+
+	having, columns, err := parseCompactFilter("having", "count:eq:1")
+	suite.Require().Nil(err)
+	order, sortColumns, err := parseSort("-count")
+	suite.Require().Nil(err)
+	opt = SQLOptions{
+		GroupBy:        []string{"id"},
+		Aggregates:     []Aggregate{{Function: "count"}},
+		Having:         &having,
+		Order:          &order,
+		compactColumns: append(columns, sortColumns...),
+	}
+	query, _, _, _, totalCount, err = opt.Apply(
+		suite.db.Model(&models.User{}), 50, 1, 0)
+	suite.Require().Nil(err)
+	suite.Require().Equal(3, totalCount)
+	rows = nil
+	suite.Require().Nil(query.Scan(&rows).Error)
+	suite.Require().Equal([]row{{Count: 1}, {Count: 1}, {Count: 1}}, rows)
+
+	// Groups are counted instead of rows.
+	having, columns, err = parseCompactFilter("having", "count:gt:1")
+	suite.Require().Nil(err)
+	opt.Having = &having
+	opt.compactColumns = append(columns, sortColumns...)
+	_, _, _, _, totalCount, err = opt.Apply(
+		suite.db.Model(&models.User{}), 50, 1, 0)
+	suite.Require().Nil(err)
+	suite.Require().Equal(0, totalCount)
+
+	// Grouped rows can't be ordered by other columns.
+	order = Order{{Column: "created_at", Keyword: "desc"}}
+	_, _, _, _, _, err = opt.Apply(suite.db.Model(&models.User{}), 50, 1, 0)
+	suite.Require().NotNil(err)
+}
+
+func (suite *SQLOptionsTestSuite) TestCostGuard() {
+	exchangetest.CreateTestingUser(suite.db, 3)
+	query := suite.db.Model(&models.User{})
+
+	suite.Require().Nil((&CostGuard{}).Check(query))
+	err := (&CostGuard{MaxCost: 0.001}).Check(query)
+	suite.Require().IsType(&CostError{}, err)
+	err = (&CostGuard{ProtectedTables: []string{"user"}}).Check(query)
+	suite.Require().Equal("user", err.(*CostError).Table)
+
+	var users []models.User
+	guard := &CostGuard{StatementTimeout: time.Second}
+	err = guard.Run(query, func(tx *gorm.DB) error {
+		return tx.Find(&users).Error
+	})
+	suite.Require().Nil(err)
+	suite.Require().Len(users, 3)
+
+	guard = &CostGuard{StatementTimeout: 10 * time.Millisecond}
+	err = guard.Run(suite.db, func(tx *gorm.DB) error {
+		return tx.Exec("SELECT pg_sleep(1)").Error
+	})
+	suite.Require().NotNil(err)
+
+	// Queries run in read only transactions.
+	err = guard.Run(suite.db, func(tx *gorm.DB) error {
+		return tx.Model(&models.User{}).Update("email", "qq@jiarung.com").Error
+	})
+	suite.Require().NotNil(err)
+
+	// Queries in transactions run in savepoints, which are rolled back to
+	// so the transactions are still usable.
+	tx := suite.db.Begin()
+	defer tx.Rollback()
+	err = guard.Run(tx, func(tx *gorm.DB) error {
+		return tx.Exec("SELECT pg_sleep(1)").Error
+	})
+	suite.Require().NotNil(err)
+	err = guard.Run(tx, func(tx *gorm.DB) error {
+		return tx.Model(&models.User{}).Update("email", "qq@jiarung.com").Error
+	})
+	suite.Require().NotNil(err)
+	suite.Require().Nil(
+		tx.Model(&models.User{}).Update("email", "tx@jiarung.com").Error)
+}
+
+func (suite *SQLOptionsTestSuite) TestSavedQuery() {
+	user := exchangetest.CreateTestingUser(suite.db, 2)[0]
+	err := suite.db.Model(user).Update("email", "saved@jiarung.com").Error
+	suite.Require().Nil(err)
+
+	filter := Filter{"equal": map[string]interface{}{
+		"column": "email", "value": "${email}"}}
+	limit := 1
+	opt := &SQLOptions{Filter: &filter, Limit: &limit}
+	q, err := NewSavedQuery(uuid.NewV4(), "by email", "users", opt,
+		suite.db.Model(&models.User{}))
+	suite.Require().Nil(err)
+	suite.Require().Equal([]string{"email"}, q.Params)
+
+	saved, err := q.SQLOptions(map[string]interface{}{
+		"email": "saved@jiarung.com"})
+	suite.Require().Nil(err)
+	suite.Require().Nil(saved.Limit)
+	_, _, _, _, totalCount, err := saved.Apply(
+		suite.db.Model(&models.User{}), 50, 1, 0)
+	suite.Require().Nil(err)
+	suite.Require().Equal(1, totalCount)
+
+	filter = Filter{"equal": map[string]interface{}{
+		"column": "secret", "value": "${secret}"}}
+	_, err = NewSavedQuery(uuid.NewV4(), "invalid", "users", opt,
+		suite.db.Model(&models.User{}))
+	suite.Require().NotNil(err)
+}
+
 func TestSQLOptions(t *testing.T) {
 	suite.Run(t, new(SQLOptionsTestSuite))
 }
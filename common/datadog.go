@@ -6,21 +6,34 @@ import (
 
 	"github.com/DataDog/datadog-go/statsd"
 
+	"github.com/jiarung/mochi/common/telemetry"
 	"github.com/jiarung/mochi/common/utils"
 )
 
-// TracerEnabled returns whether or not Tracer is enabled.
+// TracerEnabled returns whether or not Tracer is enabled, which is set up by
+// telemetry.Init. It's enabled except in local development if telemetry isn't
+// initialized.
 func TracerEnabled() bool {
-	return utils.Environment() != utils.LocalDevelopment
+	if telemetry.CurrentConfig() == nil {
+		return utils.Environment() != utils.LocalDevelopment
+	}
+	return telemetry.Enabled()
 }
 
-// GetStatsdClient returns a statsd client of dd-agent in k8s.
+// GetStatsdClient returns a statsd client of StatsdAddr of the telemetry
+// config, which is the dd-agent in k8s with the Datadog exporter. It returns
+// nil if statsd isn't configured. The dd-agent in k8s is used if telemetry
+// isn't initialized.
 func GetStatsdClient() (*statsd.Client, error) {
-	if TracerEnabled() {
-		return statsd.New(
-			fmt.Sprint(os.Getenv(
-				"KUBERNETES_KUBELET_HOST"), ":", "8125"),
-		)
+	cfg := telemetry.CurrentConfig()
+	if cfg == nil {
+		if !TracerEnabled() {
+			return nil, nil
+		}
+		return statsd.New(fmt.Sprint(os.Getenv("KUBERNETES_KUBELET_HOST"), ":", "8125"))
+	}
+	if len(cfg.StatsdAddr) == 0 {
+		return nil, nil
 	}
-	return nil, nil
+	return statsd.New(cfg.StatsdAddr)
 }
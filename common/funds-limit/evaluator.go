@@ -0,0 +1,289 @@
+package fundslimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Transfer defines a transfer counted in limits.
+type Transfer struct {
+	Scope
+	// Amount is the amount in Currency, which is counted in limits of a
+	// currency.
+	Amount decimal.Decimal `json:"amount"`
+	// Value is the value of the transfer in the unit of class-wide limits,
+	// e.g. TWD for fiat and BTC for crypto.
+	Value decimal.Decimal `json:"value"`
+	Time  time.Time       `json:"time"`
+}
+
+// counted returns the amount of t counted in limits of rule.
+func (t *Transfer) counted(rule *Rule) decimal.Decimal {
+	if len(rule.Currency) > 0 {
+		return t.Amount
+	}
+	return t.Value
+}
+
+// Ledger returns past transfers of users.
+type Ledger interface {
+	// Transfers returns transfers of userID in direction since since.
+	Transfers(userID string, direction Direction, since time.Time) (
+		[]*Transfer, error)
+}
+
+// LedgerFunc is an adapter to use a function as Ledger.
+type LedgerFunc func(userID string, direction Direction, since time.Time) (
+	[]*Transfer, error)
+
+// Transfers implements Ledger.
+func (f LedgerFunc) Transfers(userID string, direction Direction,
+	since time.Time) ([]*Transfer, error) {
+	return f(userID, direction, since)
+}
+
+// Source defines where a limit comes from.
+type Source string
+
+// Sources of limits.
+const (
+	SourcePolicy   Source = "policy"
+	SourceOverride Source = "override"
+	// SourceNone means no rule applies, and transfers are denied.
+	SourceNone Source = "none"
+)
+
+// Allowance defines the remaining allowance of a window.
+type Allowance struct {
+	Window Window `json:"window"`
+	// Scope is the scope of the applied limit, which may be wider than the
+	// evaluated scope.
+	Scope     Scope           `json:"scope"`
+	Source    Source          `json:"source"`
+	Unlimited bool            `json:"unlimited"`
+	Limit     decimal.Decimal `json:"limit"`
+	Used      decimal.Decimal `json:"used"`
+	Remaining decimal.Decimal `json:"remaining"`
+	// ExpiresAt is the expiry of the applied override.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	rule *Rule
+}
+
+// ReasonCode defines why a transfer is denied.
+type ReasonCode string
+
+// Reason codes.
+const (
+	ReasonLimitExceeded ReasonCode = "limit_exceeded"
+	ReasonNoLimit       ReasonCode = "no_limit_defined"
+)
+
+// Reason defines why a transfer exceeds the limit of a window.
+type Reason struct {
+	Code      ReasonCode      `json:"code"`
+	Window    Window          `json:"window"`
+	Scope     Scope           `json:"scope"`
+	Source    Source          `json:"source"`
+	Limit     decimal.Decimal `json:"limit"`
+	Used      decimal.Decimal `json:"used"`
+	Requested decimal.Decimal `json:"requested"`
+	Remaining decimal.Decimal `json:"remaining"`
+}
+
+func (r *Reason) String() string {
+	if r.Code == ReasonNoLimit {
+		return fmt.Sprintf("no %s %s %s limit defined",
+			r.Window, r.Scope.Class, r.Scope.Direction)
+	}
+	return fmt.Sprintf("%s %s %s limit exceeded: requested %s, remaining %s of %s",
+		r.Window, r.Scope.Class, r.Scope.Direction, r.Requested, r.Remaining,
+		r.Limit)
+}
+
+// Result defines the result of evaluating a transfer.
+type Result struct {
+	Allowed    bool         `json:"allowed"`
+	Allowances []*Allowance `json:"allowances"`
+	Reasons    []*Reason    `json:"reasons,omitempty"`
+}
+
+// Evaluator evaluates transfers against a policy and overrides of users
+// over rolling windows.
+type Evaluator struct {
+	mutex     sync.RWMutex
+	policy    *Policy
+	ledger    Ledger
+	overrides OverrideStore
+	now       func() time.Time
+}
+
+// NewEvaluator returns an evaluator counting transfers in ledger. overrides
+// can be nil if no override is used.
+func NewEvaluator(policy *Policy, ledger Ledger,
+	overrides OverrideStore) *Evaluator {
+	return &Evaluator{
+		policy:    policy,
+		ledger:    ledger,
+		overrides: overrides,
+		now:       time.Now,
+	}
+}
+
+// Policy returns the current policy.
+func (e *Evaluator) Policy() *Policy {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.policy
+}
+
+// SetPolicy replaces the policy, e.g. when rules are reloaded.
+func (e *Evaluator) SetPolicy(policy *Policy) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.policy = policy
+}
+
+// newAllowance returns the allowance of window limited by rule of source.
+func newAllowance(window Window, source Source, rule *Rule) *Allowance {
+	a := &Allowance{
+		Window:    window,
+		Scope:     rule.Scope(),
+		Source:    source,
+		Unlimited: rule.Unlimited(),
+		rule:      rule,
+	}
+	if !a.Unlimited {
+		a.Limit = rule.Amount
+	}
+	return a
+}
+
+// limits returns the limits of windows applying to scope by subject. The
+// most specific override of a window replaces rules of the policy, otherwise
+// every matching rule is a limit of the window.
+func (e *Evaluator) limits(subject *Subject, scope Scope, now time.Time) (
+	[]*Allowance, error) {
+	var overrides []*Override
+	if e.overrides != nil {
+		var err error
+		overrides, err = e.overrides.Overrides(subject.UserID, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get funds limit overrides. err: %v",
+				err)
+		}
+	}
+	// Prefer the most specific override like rules.
+	sort.SliceStable(overrides, func(i, j int) bool {
+		return overrides[i].rule().specificity() >
+			overrides[j].rule().specificity()
+	})
+
+	policy := e.Policy()
+	allowances := make([]*Allowance, 0, len(Windows))
+	for _, window := range Windows {
+		var limits []*Allowance
+		for _, o := range overrides {
+			rule := o.rule()
+			if o.Window == window && o.Active(now) &&
+				rule.Scope().contains(scope) {
+				a := newAllowance(window, SourceOverride, rule)
+				expiresAt := o.ExpiresAt
+				a.ExpiresAt = &expiresAt
+				limits = append(limits, a)
+				break
+			}
+		}
+		if len(limits) == 0 {
+			for _, rule := range policy.Matches(subject, scope, window) {
+				limits = append(limits, newAllowance(window, SourcePolicy, rule))
+			}
+		}
+		if len(limits) == 0 {
+			limits = append(limits,
+				&Allowance{Window: window, Source: SourceNone, Scope: scope})
+		}
+		allowances = append(allowances, limits...)
+	}
+	return allowances, nil
+}
+
+// Allowances returns the remaining allowances of windows for transfers of
+// scope by subject. A window has an allowance per applied limit, and
+// transfers are allowed only within all of them.
+func (e *Evaluator) Allowances(subject *Subject, scope Scope) (
+	[]*Allowance, error) {
+	now := e.now()
+	allowances, err := e.limits(subject, scope, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var longest time.Duration
+	for _, a := range allowances {
+		if d := a.Window.Duration(); d > longest {
+			longest = d
+		}
+	}
+	transfers, err := e.ledger.Transfers(
+		subject.UserID, scope.Direction, now.Add(-longest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transfers. err: %v", err)
+	}
+
+	for _, a := range allowances {
+		if a.rule == nil {
+			continue
+		}
+		since := now.Add(-a.Window.Duration())
+		for _, t := range transfers {
+			if t.Time.After(since) && a.Scope.contains(t.Scope) {
+				a.Used = a.Used.Add(t.counted(a.rule))
+			}
+		}
+		if !a.Unlimited {
+			a.Remaining = decimal.Max(decimal.Zero, a.Limit.Sub(a.Used))
+		}
+	}
+	return allowances, nil
+}
+
+// Evaluate returns whether subject is allowed to make transfer t, with the
+// reasons of all exceeded limits if it isn't.
+func (e *Evaluator) Evaluate(subject *Subject, t *Transfer) (*Result, error) {
+	allowances, err := e.Allowances(subject, t.Scope)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Allowances: allowances}
+	for _, a := range allowances {
+		if a.Unlimited {
+			continue
+		}
+		reason := &Reason{
+			Code:      ReasonLimitExceeded,
+			Window:    a.Window,
+			Scope:     a.Scope,
+			Source:    a.Source,
+			Limit:     a.Limit,
+			Used:      a.Used,
+			Remaining: a.Remaining,
+		}
+		if a.rule == nil {
+			reason.Code = ReasonNoLimit
+			reason.Requested = t.Value
+			result.Reasons = append(result.Reasons, reason)
+			continue
+		}
+		reason.Requested = t.counted(a.rule)
+		if reason.Requested.GreaterThan(a.Remaining) {
+			result.Reasons = append(result.Reasons, reason)
+		}
+	}
+	result.Allowed = len(result.Reasons) == 0
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package fundslimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jiarung/gorm"
+	"github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Override defines a limit of a user replacing rules of the policy until it
+// expires.
+type Override struct {
+	ID        uuid.UUID       `json:"id" gorm:"primary_key"`
+	UserID    string          `json:"user_id" gorm:"index"`
+	Direction Direction       `json:"direction"`
+	Class     AssetClass      `json:"class"`
+	Currency  string          `json:"currency,omitempty"`
+	Channel   string          `json:"channel,omitempty"`
+	Window    Window          `json:"window"`
+	Amount    decimal.Decimal `json:"amount" sql:"type:decimal(36,18)"`
+	Reason    string          `json:"reason"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// TableName returns the table of overrides.
+func (o *Override) TableName() string {
+	return "funds_limit_overrides"
+}
+
+// Active returns whether o hasn't expired at now.
+func (o *Override) Active(now time.Time) bool {
+	return now.Before(o.ExpiresAt)
+}
+
+func (o *Override) rule() *Rule {
+	return &Rule{
+		Direction: o.Direction,
+		Class:     o.Class,
+		Currency:  o.Currency,
+		Channel:   o.Channel,
+		Window:    o.Window,
+		Amount:    o.Amount,
+	}
+}
+
+// OverrideStore stores overrides of users.
+type OverrideStore interface {
+	// Overrides returns overrides of userID active at now.
+	Overrides(userID string, now time.Time) ([]*Override, error)
+}
+
+// MemoryOverrides keeps overrides in memory for tests and local development.
+type MemoryOverrides struct {
+	mutex     sync.Mutex
+	overrides map[string][]*Override
+}
+
+// NewMemoryOverrides returns an empty MemoryOverrides.
+func NewMemoryOverrides() *MemoryOverrides {
+	return &MemoryOverrides{overrides: make(map[string][]*Override)}
+}
+
+// Add adds o.
+func (m *MemoryOverrides) Add(o *Override) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	copied := *o
+	m.overrides[o.UserID] = append(m.overrides[o.UserID], &copied)
+}
+
+// Overrides implements OverrideStore.
+func (m *MemoryOverrides) Overrides(userID string, now time.Time) (
+	[]*Override, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var overrides []*Override
+	for _, o := range m.overrides[userID] {
+		if o.Active(now) {
+			copied := *o
+			overrides = append(overrides, &copied)
+		}
+	}
+	return overrides, nil
+}
+
+// DBOverrides loads overrides from table funds_limit_overrides.
+type DBOverrides struct {
+	DB *gorm.DB
+}
+
+// Overrides implements OverrideStore.
+func (d *DBOverrides) Overrides(userID string, now time.Time) (
+	[]*Override, error) {
+	var overrides []*Override
+	err := d.DB.Where("user_id = ? AND expires_at > ?", userID, now).
+		Order("created_at").Find(&overrides).Error
+	return overrides, err
+}
@@ -0,0 +1,214 @@
+package fundslimit
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Errors of policies.
+var (
+	ErrInvalidRule   = errors.New("invalid funds limit rule")
+	ErrDuplicateRule = errors.New("duplicate funds limit rule")
+)
+
+// Direction defines the direction of a transfer.
+type Direction string
+
+// Directions of transfers.
+const (
+	Deposit    Direction = "deposit"
+	Withdrawal Direction = "withdrawal"
+)
+
+// AssetClass defines the class of transferred currencies.
+type AssetClass string
+
+// Asset classes.
+const (
+	Fiat   AssetClass = "fiat"
+	Crypto AssetClass = "crypto"
+)
+
+// Window defines the rolling window of a limit.
+type Window string
+
+// Windows of limits.
+const (
+	Daily   Window = "24h"
+	Monthly Window = "30d"
+)
+
+// Windows lists all windows.
+var Windows = []Window{Daily, Monthly}
+
+// Duration returns the duration of w.
+func (w Window) Duration() time.Duration {
+	switch w {
+	case Daily:
+		return 24 * time.Hour
+	case Monthly:
+		return 30 * 24 * time.Hour
+	}
+	return 0
+}
+
+// Scope defines the kind of transfers a limit applies to.
+type Scope struct {
+	Direction Direction  `json:"direction"`
+	Class     AssetClass `json:"class"`
+	// Currency is the currency ID of transfers, or empty for all
+	// currencies of Class.
+	Currency string `json:"currency,omitempty"`
+	// Channel is the channel of transfers, e.g. bank or card, or empty for
+	// all channels.
+	Channel string `json:"channel,omitempty"`
+}
+
+// contains returns whether transfers of t are counted in s.
+func (s Scope) contains(t Scope) bool {
+	return s.Direction == t.Direction && s.Class == t.Class &&
+		(len(s.Currency) == 0 || s.Currency == t.Currency) &&
+		(len(s.Channel) == 0 || s.Channel == t.Channel)
+}
+
+// Subject defines the user a limit applies to.
+type Subject struct {
+	UserID   string `json:"user_id"`
+	KYCLevel int    `json:"kyc_level"`
+	Tier     string `json:"tier"`
+	Country  string `json:"country"`
+}
+
+// Rule defines the limit of transfers in a window. Empty fields match any
+// value, and all matching rules of a window apply, so the strictest one
+// limits transfers. Limits of users are lifted by overrides instead.
+type Rule struct {
+	KYCLevel  *int       `json:"kyc_level,omitempty"`
+	Tier      string     `json:"tier,omitempty"`
+	Country   string     `json:"country,omitempty"`
+	Direction Direction  `json:"direction"`
+	Class     AssetClass `json:"class"`
+	Currency  string     `json:"currency,omitempty"`
+	Channel   string     `json:"channel,omitempty"`
+	Window    Window     `json:"window"`
+	// Amount is the limit in Currency if it's set, otherwise in the value of
+	// transfers. A negative amount means unlimited.
+	Amount decimal.Decimal `json:"amount"`
+}
+
+// Unlimited returns whether r doesn't limit transfers.
+func (r *Rule) Unlimited() bool {
+	return r.Amount.Sign() < 0
+}
+
+// Scope returns the scope of transfers r limits.
+func (r *Rule) Scope() Scope {
+	return Scope{
+		Direction: r.Direction,
+		Class:     r.Class,
+		Currency:  r.Currency,
+		Channel:   r.Channel,
+	}
+}
+
+// specificity ranks rules and overrides. Currency and channel outweigh the
+// user fields, since they narrow down the limited transfers.
+func (r *Rule) specificity() int {
+	score := 0
+	if r.KYCLevel != nil {
+		score++
+	}
+	if len(r.Tier) > 0 {
+		score += 2
+	}
+	if len(r.Country) > 0 {
+		score += 4
+	}
+	if len(r.Channel) > 0 {
+		score += 8
+	}
+	if len(r.Currency) > 0 {
+		score += 16
+	}
+	return score
+}
+
+func (r *Rule) key() string {
+	level := "*"
+	if r.KYCLevel != nil {
+		level = fmt.Sprint(*r.KYCLevel)
+	}
+	return strings.Join([]string{level, r.Tier, r.Country, string(r.Direction),
+		string(r.Class), r.Currency, r.Channel, string(r.Window)}, "|")
+}
+
+func (r *Rule) validate() error {
+	if (r.Direction != Deposit && r.Direction != Withdrawal) ||
+		(r.Class != Fiat && r.Class != Crypto) || r.Window.Duration() == 0 {
+		return fmt.Errorf("%v: %s", ErrInvalidRule, r.key())
+	}
+	return nil
+}
+
+// matches returns whether r applies to transfers of scope by subject.
+func (r *Rule) matches(subject *Subject, scope Scope) bool {
+	return (r.KYCLevel == nil || *r.KYCLevel == subject.KYCLevel) &&
+		(len(r.Tier) == 0 || r.Tier == subject.Tier) &&
+		(len(r.Country) == 0 || r.Country == subject.Country) &&
+		r.Scope().contains(scope)
+}
+
+// Policy defines rules of funds limits. It's immutable once created.
+type Policy struct {
+	rules []*Rule
+}
+
+// NewPolicy returns a policy of rules. Rules of the same fields are rejected.
+func NewPolicy(rules []*Rule) (*Policy, error) {
+	seen := make(map[string]bool, len(rules))
+	copied := make([]*Rule, 0, len(rules))
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		key := r.key()
+		if seen[key] {
+			return nil, fmt.Errorf("%v: %s", ErrDuplicateRule, key)
+		}
+		seen[key] = true
+		rule := *r
+		copied = append(copied, &rule)
+	}
+	// Sort rules by specificity, so matches are listed most specific first.
+	sort.SliceStable(copied, func(i, j int) bool {
+		return copied[i].specificity() > copied[j].specificity()
+	})
+	return &Policy{rules: copied}, nil
+}
+
+// Rules returns the rules of p by specificity.
+func (p *Policy) Rules() []Rule {
+	rules := make([]Rule, 0, len(p.rules))
+	for _, r := range p.rules {
+		rules = append(rules, *r)
+	}
+	return rules
+}
+
+// Matches returns all rules of window applying to transfers of scope by
+// subject, most specific first, or nil if there's none.
+func (p *Policy) Matches(subject *Subject, scope Scope, window Window) []*Rule {
+	var rules []*Rule
+	for _, r := range p.rules {
+		if r.Window == window && r.matches(subject, scope) {
+			rule := *r
+			rules = append(rules, &rule)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,307 @@
+package fundslimit
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/suite"
+
+	cdecimal "github.com/jiarung/mochi/common/decimal"
+)
+
+type PolicyTestSuite struct {
+	suite.Suite
+
+	now       time.Time
+	transfers []*Transfer
+	overrides *MemoryOverrides
+	evaluator *Evaluator
+	subject   *Subject
+}
+
+func (s *PolicyTestSuite) SetupTest() {
+	s.now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.transfers = nil
+	s.overrides = NewMemoryOverrides()
+	policy, err := NewPolicy(DefaultRules())
+	s.Require().NoError(err)
+	s.evaluator = NewEvaluator(policy, LedgerFunc(
+		func(userID string, direction Direction, since time.Time) (
+			[]*Transfer, error) {
+			var transfers []*Transfer
+			for _, t := range s.transfers {
+				if t.Direction == direction && !t.Time.Before(since) {
+					transfers = append(transfers, t)
+				}
+			}
+			return transfers, nil
+		}), s.overrides)
+	s.evaluator.now = func() time.Time { return s.now }
+	s.subject = &Subject{UserID: "u1", KYCLevel: 2, Country: "TW"}
+}
+
+func (s *PolicyTestSuite) transfer(scope Scope, amount, value string,
+	ago time.Duration) *Transfer {
+	return &Transfer{
+		Scope:  scope,
+		Amount: decimal.RequireFromString(amount),
+		Value:  decimal.RequireFromString(value),
+		Time:   s.now.Add(-ago),
+	}
+}
+
+var fiatWithdrawal = Scope{
+	Direction: Withdrawal, Class: Fiat, Currency: "TWD", Channel: "bank",
+}
+
+func (s *PolicyTestSuite) TestDefaultRules() {
+	generated := map[Scope]map[Window]func(int) (decimal.Decimal, error){
+		{Direction: Deposit, Class: Fiat}: {
+			Daily:   GetDailyFiatDepositLimit,
+			Monthly: GetMonthlyFiatDepositLimit,
+		},
+		{Direction: Withdrawal, Class: Fiat}: {
+			Daily:   GetDailyFiatWithdrawalLimit,
+			Monthly: GetMonthlyFiatWithdrawalLimit,
+		},
+		{Direction: Deposit, Class: Crypto}: {
+			Daily:   GetDailyCryptoDepositLimit,
+			Monthly: GetMonthlyCryptoDepositLimit,
+		},
+		{Direction: Withdrawal, Class: Crypto}: {
+			Daily:   GetDailyCryptoWithdrawalLimit,
+			Monthly: GetMonthlyCryptoWithdrawalLimit,
+		},
+	}
+	policy := s.evaluator.Policy()
+	for _, level := range kycLevels {
+		subject := &Subject{KYCLevel: level}
+		for scope, windows := range generated {
+			for window, get := range windows {
+				rules := policy.Matches(subject, scope, window)
+				s.Require().Len(rules, 1)
+				limit, err := get(level)
+				s.Require().NoError(err)
+				cdecimal.RequireEqual(s.T(), limit, rules[0].Amount)
+			}
+		}
+
+		rules := policy.Matches(subject,
+			Scope{Direction: Deposit, Class: Crypto}, Daily)
+		s.Require().True(rules[0].Unlimited())
+	}
+	s.Require().Nil(policy.Matches(&Subject{KYCLevel: 4}, fiatWithdrawal, Daily))
+}
+
+func (s *PolicyTestSuite) TestNewPolicy() {
+	level := 1
+	_, err := NewPolicy([]*Rule{
+		{KYCLevel: &level, Direction: Deposit, Class: Fiat, Window: Daily},
+		{KYCLevel: &level, Direction: Deposit, Class: Fiat, Window: Daily},
+	})
+	s.Require().Error(err)
+	s.Require().True(strings.HasPrefix(err.Error(), ErrDuplicateRule.Error()))
+
+	_, err = NewPolicy([]*Rule{{Direction: Deposit, Class: Fiat, Window: "1h"}})
+	s.Require().Error(err)
+}
+
+func (s *PolicyTestSuite) TestMatches() {
+	rules, err := LoadRules(strings.NewReader(`[
+		{"direction": "withdrawal", "class": "fiat", "window": "24h",
+		 "amount": "1000"},
+		{"kyc_level": 2, "direction": "withdrawal", "class": "fiat",
+		 "window": "24h", "amount": 2000},
+		{"tier": "vip", "direction": "withdrawal", "class": "fiat",
+		 "window": "24h", "amount": "5000.5"},
+		{"kyc_level": 2, "country": "TW", "direction": "withdrawal",
+		 "class": "fiat", "channel": "bank", "window": "24h", "amount": "300"},
+		{"currency": "TWD", "direction": "withdrawal", "class": "fiat",
+		 "window": "24h", "amount": "-1"}
+	]`))
+	s.Require().NoError(err)
+	policy, err := NewPolicy(rules)
+	s.Require().NoError(err)
+
+	amounts := func(rules []*Rule) []string {
+		var amounts []string
+		for _, r := range rules {
+			amounts = append(amounts, r.Amount.String())
+		}
+		return amounts
+	}
+	scope := Scope{Direction: Withdrawal, Class: Fiat, Currency: "USD"}
+	s.Require().Equal([]string{"1000"},
+		amounts(policy.Matches(&Subject{KYCLevel: 1}, scope, Daily)))
+	s.Require().Equal([]string{"5000.5", "2000", "1000"}, amounts(
+		policy.Matches(&Subject{KYCLevel: 2, Tier: "vip"}, scope, Daily)))
+	scope.Channel = "bank"
+	s.Require().Equal([]string{"300", "2000", "1000"}, amounts(
+		policy.Matches(&Subject{KYCLevel: 2, Country: "TW"}, scope, Daily)))
+	s.Require().Equal([]string{"-1", "300", "2000", "1000"}, amounts(
+		policy.Matches(&Subject{KYCLevel: 2, Country: "TW"}, fiatWithdrawal,
+			Daily)))
+	s.Require().Nil(policy.Matches(&Subject{}, scope, Monthly))
+}
+
+func (s *PolicyTestSuite) TestStrictestRule() {
+	rules, err := LoadRules(strings.NewReader(`[
+		{"kyc_level": 2, "direction": "withdrawal", "class": "fiat",
+		 "window": "24h", "amount": "1000"},
+		{"kyc_level": 2, "direction": "withdrawal", "class": "fiat",
+		 "currency": "TWD", "window": "24h", "amount": "5000"},
+		{"kyc_level": 2, "direction": "withdrawal", "class": "fiat",
+		 "window": "30d", "amount": "-1"}
+	]`))
+	s.Require().NoError(err)
+	policy, err := NewPolicy(rules)
+	s.Require().NoError(err)
+	s.evaluator.SetPolicy(policy)
+
+	// The looser currency rule doesn't lift the class-wide limit.
+	result, err := s.evaluator.Evaluate(s.subject,
+		s.transfer(fiatWithdrawal, "2000", "2000", 0))
+	s.Require().NoError(err)
+	s.Require().False(result.Allowed)
+	s.Require().Len(result.Allowances, 3)
+	s.Require().Len(result.Reasons, 1)
+	s.Require().Empty(result.Reasons[0].Scope.Currency)
+	cdecimal.RequireEqual(s.T(), decimal.New(1000, 0), result.Reasons[0].Limit)
+
+	// Both rules of the window are exceeded.
+	result, err = s.evaluator.Evaluate(s.subject,
+		s.transfer(fiatWithdrawal, "6000", "6000", 0))
+	s.Require().NoError(err)
+	s.Require().False(result.Allowed)
+	s.Require().Len(result.Reasons, 2)
+
+	result, err = s.evaluator.Evaluate(s.subject,
+		s.transfer(fiatWithdrawal, "1000", "1000", 0))
+	s.Require().NoError(err)
+	s.Require().True(result.Allowed)
+}
+
+func (s *PolicyTestSuite) TestRulesFromEnv() {
+	defer os.Unsetenv(rulesEnv)
+	rules, err := RulesFromEnv()
+	s.Require().NoError(err)
+	s.Require().Len(rules, len(DefaultRules()))
+
+	os.Setenv(rulesEnv, `[{"direction": "deposit", "class": "crypto",
+		"window": "30d", "amount": "0.1"}]`)
+	rules, err = RulesFromEnv()
+	s.Require().NoError(err)
+	s.Require().Len(rules, 1)
+	s.Require().Equal("0.1", rules[0].Amount.String())
+
+	os.Setenv(rulesEnv, `{`)
+	_, err = RulesFromEnv()
+	s.Require().Error(err)
+}
+
+func (s *PolicyTestSuite) TestEvaluate() {
+	s.transfers = []*Transfer{
+		s.transfer(fiatWithdrawal, "3000", "3000", 2*time.Hour),
+		// Out of the daily window.
+		s.transfer(fiatWithdrawal, "10000", "10000", 48*time.Hour),
+		// Out of the monthly window.
+		s.transfer(fiatWithdrawal, "50000", "50000", 31*24*time.Hour),
+		s.transfer(Scope{Direction: Deposit, Class: Fiat}, "4000", "4000",
+			time.Hour),
+	}
+
+	result, err := s.evaluator.Evaluate(s.subject,
+		s.transfer(fiatWithdrawal, "1000", "1000", 0))
+	s.Require().NoError(err)
+	s.Require().True(result.Allowed)
+	s.Require().Len(result.Allowances, 2)
+	daily := result.Allowances[0]
+	s.Require().Equal(SourcePolicy, daily.Source)
+	cdecimal.RequireEqual(s.T(), decimal.New(3000, 0), daily.Used)
+	cdecimal.RequireEqual(s.T(), decimal.New(1000, 0), daily.Remaining)
+	monthly := result.Allowances[1]
+	cdecimal.RequireEqual(s.T(), decimal.New(13000, 0), monthly.Used)
+	cdecimal.RequireEqual(s.T(), decimal.New(47000, 0), monthly.Remaining)
+
+	result, err = s.evaluator.Evaluate(s.subject,
+		s.transfer(fiatWithdrawal, "1000.01", "1000.01", 0))
+	s.Require().NoError(err)
+	s.Require().False(result.Allowed)
+	s.Require().Len(result.Reasons, 1)
+	reason := result.Reasons[0]
+	s.Require().Equal(ReasonLimitExceeded, reason.Code)
+	s.Require().Equal(Daily, reason.Window)
+	s.Require().Equal("1000.01", reason.Requested.String())
+	s.Require().Equal(
+		"24h fiat withdrawal limit exceeded: requested 1000.01, remaining 1000 of 4000",
+		reason.String())
+
+	// Unlimited crypto deposits.
+	result, err = s.evaluator.Evaluate(s.subject, s.transfer(
+		Scope{Direction: Deposit, Class: Crypto, Currency: "BTC"},
+		"1000", "1000", 0))
+	s.Require().NoError(err)
+	s.Require().True(result.Allowed)
+	s.Require().True(result.Allowances[0].Unlimited)
+
+	// No rule of unknown levels.
+	result, err = s.evaluator.Evaluate(&Subject{UserID: "u2", KYCLevel: 9},
+		s.transfer(fiatWithdrawal, "1", "1", 0))
+	s.Require().NoError(err)
+	s.Require().False(result.Allowed)
+	s.Require().Len(result.Reasons, 2)
+	s.Require().Equal(ReasonNoLimit, result.Reasons[0].Code)
+	s.Require().Equal(SourceNone, result.Allowances[0].Source)
+}
+
+func (s *PolicyTestSuite) TestOverride() {
+	s.transfers = []*Transfer{
+		s.transfer(fiatWithdrawal, "3000", "3000", 2*time.Hour),
+	}
+	s.overrides.Add(&Override{
+		UserID:    "u1",
+		Direction: Withdrawal,
+		Class:     Fiat,
+		Currency:  "TWD",
+		Window:    Daily,
+		Amount:    decimal.RequireFromString("100000"),
+		ExpiresAt: s.now.Add(time.Hour),
+	})
+	s.overrides.Add(&Override{
+		UserID:    "u1",
+		Direction: Withdrawal,
+		Class:     Fiat,
+		Window:    Monthly,
+		Amount:    decimal.RequireFromString("1"),
+		ExpiresAt: s.now.Add(-time.Hour),
+	})
+
+	allowances, err := s.evaluator.Allowances(s.subject, fiatWithdrawal)
+	s.Require().NoError(err)
+	daily := allowances[0]
+	s.Require().Equal(SourceOverride, daily.Source)
+	s.Require().Equal("TWD", daily.Scope.Currency)
+	s.Require().Equal(s.now.Add(time.Hour), *daily.ExpiresAt)
+	cdecimal.RequireEqual(s.T(), decimal.New(97000, 0), daily.Remaining)
+	// The expired override is ignored.
+	s.Require().Equal(SourcePolicy, allowances[1].Source)
+
+	// Overrides of other currencies don't apply.
+	allowances, err = s.evaluator.Allowances(s.subject, Scope{
+		Direction: Withdrawal, Class: Fiat, Currency: "USD",
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(SourcePolicy, allowances[0].Source)
+
+	s.now = s.now.Add(2 * time.Hour)
+	allowances, err = s.evaluator.Allowances(s.subject, fiatWithdrawal)
+	s.Require().NoError(err)
+	s.Require().Equal(SourcePolicy, allowances[0].Source)
+}
+
+func TestPolicy(t *testing.T) {
+	suite.Run(t, new(PolicyTestSuite))
+}
@@ -0,0 +1,123 @@
+package fundslimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jiarung/gorm"
+	"github.com/shopspring/decimal"
+)
+
+// rulesEnv is the env of rules in JSON, which overrides DefaultRules.
+const rulesEnv = "FUNDS_LIMIT_RULES"
+
+// kycLevels are the KYC levels of the generated limits.
+var kycLevels = []int{0, 1, 2, 3}
+
+// defaultLimits are the getters of the generated limits, where -1 is
+// unlimited.
+var defaultLimits = []struct {
+	direction Direction
+	class     AssetClass
+	window    Window
+	get       func(kycLevel int) (decimal.Decimal, error)
+}{
+	{Deposit, Fiat, Daily, GetDailyFiatDepositLimit},
+	{Withdrawal, Fiat, Daily, GetDailyFiatWithdrawalLimit},
+	{Deposit, Fiat, Monthly, GetMonthlyFiatDepositLimit},
+	{Withdrawal, Fiat, Monthly, GetMonthlyFiatWithdrawalLimit},
+	{Deposit, Crypto, Daily, GetDailyCryptoDepositLimit},
+	{Withdrawal, Crypto, Daily, GetDailyCryptoWithdrawalLimit},
+	{Deposit, Crypto, Monthly, GetMonthlyCryptoDepositLimit},
+	{Withdrawal, Crypto, Monthly, GetMonthlyCryptoWithdrawalLimit},
+}
+
+// DefaultRules returns rules of the generated limits per KYC level, limiting
+// the value of transfers of all currencies and channels. It panics if a
+// level in kycLevels isn't generated.
+func DefaultRules() []*Rule {
+	var rules []*Rule
+	for _, l := range defaultLimits {
+		for _, level := range kycLevels {
+			level := level
+			amount, err := l.get(level)
+			if err != nil {
+				panic(fmt.Sprintf(
+					"failed to get funds limit of %s %s %s. err: %v",
+					l.window, l.class, l.direction, err))
+			}
+			rules = append(rules, &Rule{
+				KYCLevel:  &level,
+				Direction: l.direction,
+				Class:     l.class,
+				Window:    l.window,
+				Amount:    amount,
+			})
+		}
+	}
+	return rules
+}
+
+// LoadRules decodes rules in a JSON array from r. Amounts are decoded from
+// strings or numbers exactly.
+func LoadRules(r io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("failed to decode funds limit rules. err: %v", err)
+	}
+	return rules, nil
+}
+
+// RulesFromEnv returns rules in env FUNDS_LIMIT_RULES, or DefaultRules if
+// it's empty.
+func RulesFromEnv() ([]*Rule, error) {
+	rules := os.Getenv(rulesEnv)
+	if len(rules) == 0 {
+		return DefaultRules(), nil
+	}
+	return LoadRules(strings.NewReader(rules))
+}
+
+// ruleRecord is the row of table funds_limit_rules.
+type ruleRecord struct {
+	ID        int64           `gorm:"primary_key"`
+	KYCLevel  *int            `gorm:"column:kyc_level"`
+	Tier      string          `gorm:"column:tier"`
+	Country   string          `gorm:"column:country"`
+	Direction Direction       `gorm:"column:direction"`
+	Class     AssetClass      `gorm:"column:class"`
+	Currency  string          `gorm:"column:currency"`
+	Channel   string          `gorm:"column:channel"`
+	Window    Window          `gorm:"column:window"`
+	Amount    decimal.Decimal `gorm:"column:amount" sql:"type:decimal(36,18)"`
+}
+
+func (ruleRecord) TableName() string {
+	return "funds_limit_rules"
+}
+
+// LoadRulesFromDB loads rules from table funds_limit_rules.
+func LoadRulesFromDB(db *gorm.DB) ([]*Rule, error) {
+	var records []ruleRecord
+	if err := db.Order("id").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to find funds limit rules. err: %v", err)
+	}
+	rules := make([]*Rule, 0, len(records))
+	for _, r := range records {
+		rules = append(rules, &Rule{
+			KYCLevel:  r.KYCLevel,
+			Tier:      r.Tier,
+			Country:   r.Country,
+			Direction: r.Direction,
+			Class:     r.Class,
+			Currency:  r.Currency,
+			Channel:   r.Channel,
+			Window:    r.Window,
+			Amount:    r.Amount,
+		})
+	}
+	return rules, nil
+}
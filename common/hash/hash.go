@@ -0,0 +1,55 @@
+// Package hash defines consistent hashing of keys to hosts, implemented by
+// maglev and rendezvous.
+package hash
+
+import "errors"
+
+var (
+	// ErrEmptyHosts is returned if there would be no hosts.
+	ErrEmptyHosts = errors.New("empty hosts")
+	// ErrHostNotFound is returned if the removed host doesn't exist.
+	ErrHostNotFound = errors.New("host not found")
+	// ErrInvalidWeight is returned if the weight of a host isn't positive.
+	ErrInvalidWeight = errors.New("invalid weight")
+)
+
+// Host is a backend of consistent hashing, which gets keys in proportion to
+// its weight.
+type Host struct {
+	Name   string
+	Weight float64
+}
+
+// ConsistentHash maps keys to hosts, remapping few keys when hosts change.
+// Implementations are safe for concurrent use.
+type ConsistentHash interface {
+	// Get returns the host of key.
+	Get(key []byte) []byte
+	// Add adds host, or updates its weight if it exists, and returns the
+	// fraction of keys remapped.
+	Add(host Host) (float64, error)
+	// Remove removes host of name, and returns the fraction of keys
+	// remapped.
+	Remove(name string) (float64, error)
+	// Hosts returns hosts in order of addition.
+	Hosts() []Host
+}
+
+// ValidateHosts returns an error if hosts are empty, duplicated or have
+// non-positive weights.
+func ValidateHosts(hosts []Host) error {
+	if len(hosts) == 0 {
+		return ErrEmptyHosts
+	}
+	names := make(map[string]struct{}, len(hosts))
+	for _, host := range hosts {
+		if !(host.Weight > 0) {
+			return ErrInvalidWeight
+		}
+		if _, ok := names[host.Name]; ok {
+			return errors.New("duplicated host " + host.Name)
+		}
+		names[host.Name] = struct{}{}
+	}
+	return nil
+}
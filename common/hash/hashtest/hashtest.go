@@ -0,0 +1,92 @@
+// Package hashtest provides benchmarks shared by implementations of
+// hash.ConsistentHash.
+package hashtest
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	chash "github.com/jiarung/mochi/common/hash"
+)
+
+// NewFunc creates a consistent hash of hosts.
+type NewFunc func(hosts []chash.Host) (chash.ConsistentHash, error)
+
+// benchmarkSizes are the numbers of hosts benchmarked.
+var benchmarkSizes = []int{4, 16, 64}
+
+// Hosts returns n hosts of weight 1.
+func Hosts(n int) []chash.Host {
+	result := make([]chash.Host, n)
+	for i := range result {
+		result[i] = chash.Host{Name: fmt.Sprintf("10.0.0.%d:8080", i), Weight: 1}
+	}
+	return result
+}
+
+// Keys returns n distinct keys.
+func Keys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	return keys
+}
+
+func create(b *testing.B, newHash NewFunc, n int) chash.ConsistentHash {
+	h, err := newHash(Hosts(n))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return h
+}
+
+// BenchmarkGet benchmarks lookups of hashes created by newHash.
+func BenchmarkGet(b *testing.B, newHash NewFunc) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("hosts-%d", n), func(b *testing.B) {
+			h := create(b, newHash, n)
+			keys := Keys(1024)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.Get(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+// BenchmarkDistribution reports the max load of hosts relative to the mean.
+func BenchmarkDistribution(b *testing.B, newHash NewFunc) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("hosts-%d", n), func(b *testing.B) {
+			h := create(b, newHash, n)
+			keys := Keys(100000)
+			maxLoad := 0.0
+			for i := 0; i < b.N; i++ {
+				counts := map[string]int{}
+				for _, key := range keys {
+					counts[string(h.Get(key))]++
+				}
+				for _, count := range counts {
+					maxLoad = math.Max(maxLoad, float64(count*n)/float64(len(keys)))
+				}
+			}
+			b.ReportMetric(maxLoad, "max/mean")
+		})
+	}
+}
+
+// BenchmarkDisruption reports the fraction of keys remapped by adding a host.
+func BenchmarkDisruption(b *testing.B, newHash NewFunc) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("hosts-%d", n), func(b *testing.B) {
+			fraction := 0.0
+			for i := 0; i < b.N; i++ {
+				h := create(b, newHash, n)
+				fraction, _ = h.Add(chash.Host{Name: "new", Weight: 1})
+			}
+			b.ReportMetric(fraction, "remapped")
+		})
+	}
+}
@@ -4,22 +4,37 @@ import (
 	"bytes"
 	"errors"
 	"hash/crc32"
-	"math/rand"
+	"hash/fnv"
+	"sort"
+	"sync"
 
+	chash "github.com/jiarung/mochi/common/hash"
 	"github.com/jiarung/mochi/common/logging"
 )
 
 const (
-	defaultSeed int64 = 5566
-
-	defaultPrime   = 59
-	primeThreshold = 1 << 3
+	// tableFactor is the number of entries per host of new lookup tables.
+	// Maglev needs many entries per host, or removing a host remaps much
+	// more than its share of keys.
+	tableFactor = 100
+	// minTableFactor is the number of entries per host below which the
+	// lookup table is resized.
+	minTableFactor = 10
 )
 
 // New returns maglev according to given hosts
 func New(hosts []string) (m *Maglev, err error) {
-	if len(hosts) == 0 {
-		err = errors.New("Empty hosts")
+	weighted := make([]chash.Host, len(hosts))
+	for i, host := range hosts {
+		weighted[i] = chash.Host{Name: host, Weight: 1}
+	}
+	return NewWeighted(weighted)
+}
+
+// NewWeighted returns maglev according to given hosts, which get entries of
+// the lookup table in proportion to their weights.
+func NewWeighted(hosts []chash.Host) (m *Maglev, err error) {
+	if err = chash.ValidateHosts(hosts); err != nil {
 		return
 	}
 
@@ -28,10 +43,12 @@ func New(hosts []string) (m *Maglev, err error) {
 	m = &Maglev{
 		b:           new(bytes.Buffer),
 		hosts:       make([][]byte, 0),
+		weights:     make([]float64, 0),
 		preferences: make([]int, 0),
 	}
 	for i := 0; i < len(hosts); i++ {
-		m.hosts = append(m.hosts, []byte(hosts[i]))
+		m.hosts = append(m.hosts, []byte(hosts[i].Name))
+		m.weights = append(m.weights, hosts[i].Weight)
 	}
 	m.computePreferences()
 	logger.Info(
@@ -43,6 +60,8 @@ func New(hosts []string) (m *Maglev, err error) {
 	return
 }
 
+var _ chash.ConsistentHash = (*Maglev)(nil)
+
 // Maglev is a consistent hashing algorithm, developed by Google, keeps
 // constant time/size lookup table.
 // We adapt this struct to meet Go's `hash.Hash` interface for general
@@ -50,11 +69,14 @@ func New(hosts []string) (m *Maglev, err error) {
 // Ref: https://research.google.com/pubs/pub44824.html
 type Maglev struct {
 	// NOTE(Cliff): the prime number should be greater than backends number by
-	// 4 times for more evenly distributing.
+	// 100 times for more evenly distributing.
 	prime int
 
-	b           *bytes.Buffer
+	b *bytes.Buffer
+	// mutex guards hosts, weights and the lookup table.
+	mutex       sync.RWMutex
 	hosts       [][]byte
+	weights     []float64
 	preferences []int
 }
 
@@ -89,24 +111,19 @@ func (m *Maglev) BlockSize() int {
 
 // ------ Following are custom methods ------
 
-// Select prime according to bakends numbers.
+// Select prime according to bakends numbers, which is the largest prime
+// less than tableFactor times of them.
 func (m *Maglev) selectPrime() {
-	if len(m.hosts) < primeThreshold {
-		m.prime = defaultPrime
-		return
-	}
-
 	// Sieve Of Eratosthenes
-	n := len(m.hosts)
-	n2 := n * n
-	checks := make([]bool, n2)
+	n := tableFactor * len(m.hosts)
+	checks := make([]bool, n)
 	primes := make([]int, 0)
-	for i := 2; i < n2; i++ {
+	for i := 2; i < n; i++ {
 		if checks[i] {
 			continue
 		}
 		primes = append(primes, i)
-		for j := i * i; j < n2; j = j + i {
+		for j := i * i; j < n; j = j + i {
 			checks[j] = true
 		}
 	}
@@ -116,45 +133,191 @@ func (m *Maglev) selectPrime() {
 	m.prime = primes[len(primes)-1]
 }
 
+// permutation returns offset and skip of the preference list of host, which
+// is (offset + j*skip) % prime for the j-th preference. Lists depend only on
+// hosts, so other hosts keep their entries when a host is added or removed.
+func (m *Maglev) permutation(host []byte) (offset, skip int) {
+	h := fnv.New64a()
+	h.Write(host)
+	offset = int(h.Sum64() % uint64(m.prime))
+	skip = int(crc32.ChecksumIEEE(host)%uint32(m.prime-1)) + 1
+	return
+}
+
 func (m *Maglev) computePreferences() {
 	m.selectPrime()
+	m.fillPreferences()
+}
+
+func (m *Maglev) fillPreferences() {
 	N := len(m.hosts)
-	pTable := make([][]int, N)
-	rand.Seed(defaultSeed)
+	offsets := make([]int, N)
+	skips := make([]int, N)
+	maxWeight := 0.0
 	for i := 0; i < N; i++ {
-		pTable[i] = rand.Perm(m.prime)
+		offsets[i], skips[i] = m.permutation(m.hosts[i])
+		if m.weights[i] > maxWeight {
+			maxWeight = m.weights[i]
+		}
+	}
+	// Fill in order of names, so the table doesn't depend on the order of
+	// hosts.
+	order := make([]int, N)
+	for i := range order {
+		order[i] = i
 	}
+	sort.Slice(order, func(i, j int) bool {
+		return bytes.Compare(m.hosts[order[i]], m.hosts[order[j]]) < 0
+	})
 
 	m.preferences = make([]int, m.prime)
 	for i := 0; i < len(m.preferences); i++ {
 		m.preferences[i] = -1
 	}
 
-	col, count := 0, 0
-	for {
-		if count == m.prime {
-			break
-		}
-	FILLLOOP:
-		for ind, ele := range pTable {
-			if m.preferences[ele[col]] != -1 {
-				continue FILLLOOP
+	// Hosts take turns to fill their next preferred empty entry. Hosts of
+	// the max weight take an entry every turn, while others take entries
+	// once their credits of weights reach one.
+	next := make([]int, N)
+	credits := make([]float64, N)
+	count := 0
+	for count < m.prime {
+		for _, ind := range order {
+			credits[ind] += m.weights[ind] / maxWeight
+			if credits[ind] < 1 {
+				continue
+			}
+			credits[ind]--
+			for {
+				c := (offsets[ind] + next[ind]*skips[ind]) % m.prime
+				next[ind]++
+				if m.preferences[c] == -1 {
+					m.preferences[c] = ind
+					count++
+					break
+				}
+			}
+			if count == m.prime {
+				break
 			}
-			m.preferences[ele[col]] = ind
-			count++
 		}
-		col++
 	}
-	return
 }
 
 // Index returns payload's computing sequence.
 func (m *Maglev) Index(b []byte) int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.index(b)
+}
+
+func (m *Maglev) index(b []byte) int {
 	msCRC := int(crc32.ChecksumIEEE(b))
 	return m.preferences[msCRC%m.prime]
 }
 
 // Get host according to given payload.
 func (m *Maglev) Get(b []byte) []byte {
-	return m.hosts[m.Index(b)]
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.hosts[m.index(b)]
+}
+
+// Hosts returns hosts with their weights.
+func (m *Maglev) Hosts() []chash.Host {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	hosts := make([]chash.Host, len(m.hosts))
+	for i := range m.hosts {
+		hosts[i] = chash.Host{Name: string(m.hosts[i]), Weight: m.weights[i]}
+	}
+	return hosts
+}
+
+// Add adds host, or updates its weight if it exists, and rebuilds the lookup
+// table. It returns the fraction of keys remapped.
+func (m *Maglev) Add(host chash.Host) (float64, error) {
+	if !(host.Weight > 0) {
+		return 0, chash.ErrInvalidWeight
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.rebuild(func() {
+		for i := range m.hosts {
+			if string(m.hosts[i]) == host.Name {
+				m.weights[i] = host.Weight
+				return
+			}
+		}
+		m.hosts = append(m.hosts, []byte(host.Name))
+		m.weights = append(m.weights, host.Weight)
+	}), nil
+}
+
+// Remove removes host of name, and rebuilds the lookup table. It returns the
+// fraction of keys remapped.
+func (m *Maglev) Remove(name string) (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ind := -1
+	for i := range m.hosts {
+		if string(m.hosts[i]) == name {
+			ind = i
+			break
+		}
+	}
+	if ind < 0 {
+		return 0, chash.ErrHostNotFound
+	}
+	if len(m.hosts) == 1 {
+		return 0, chash.ErrEmptyHosts
+	}
+	return m.rebuild(func() {
+		m.hosts = append(m.hosts[:ind:ind], m.hosts[ind+1:]...)
+		m.weights = append(m.weights[:ind:ind], m.weights[ind+1:]...)
+	}), nil
+}
+
+// rebuild updates hosts by update and rebuilds the lookup table. The prime
+// is kept unless it's less than minTableFactor times the number of hosts,
+// since changing the prime remaps most keys. It returns the fraction of keys remapped,
+// assuming checksums of keys are uniform. It should be called with mutex
+// locked.
+func (m *Maglev) rebuild(update func()) float64 {
+	oldPrime := m.prime
+	oldTable := make([]string, len(m.preferences))
+	for i, ind := range m.preferences {
+		oldTable[i] = string(m.hosts[ind])
+	}
+
+	update()
+	if m.prime < minTableFactor*len(m.hosts) {
+		m.computePreferences()
+	} else {
+		m.fillPreferences()
+	}
+
+	if oldPrime == m.prime {
+		remapped := 0
+		for i, ind := range m.preferences {
+			if oldTable[i] != string(m.hosts[ind]) {
+				remapped++
+			}
+		}
+		return float64(remapped) / float64(m.prime)
+	}
+
+	// Entries of distinct primes are independent by the Chinese remainder
+	// theorem, so a key stays if both entries are the same host.
+	oldShares := make(map[string]float64)
+	for _, host := range oldTable {
+		oldShares[host] += 1 / float64(oldPrime)
+	}
+	stay := 0.0
+	for _, ind := range m.preferences {
+		stay += oldShares[string(m.hosts[ind])] / float64(m.prime)
+	}
+	return 1 - stay
 }
@@ -1,15 +1,26 @@
 package maglev
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
+
+	chash "github.com/jiarung/mochi/common/hash"
 )
 
 type TestMaglevSuite struct {
 	suite.Suite
 }
 
+func testKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	return keys
+}
+
 func (s *TestMaglevSuite) TestMaglevNaively() {
 	m, err := New([]string{})
 	s.Require().NotNil(err)
@@ -29,6 +40,162 @@ func (s *TestMaglevSuite) TestMaglevNaively() {
 	s.Require().Equal(m.Sum(i), m.Get(i))
 }
 
+func (s *TestMaglevSuite) TestOrder() {
+	m1, err := New([]string{"a", "b", "c"})
+	s.Require().Nil(err)
+	m2, err := New([]string{"c", "a", "b"})
+	s.Require().Nil(err)
+	for _, key := range testKeys(1000) {
+		s.Require().Equal(m1.Get(key), m2.Get(key))
+	}
+
+	m1, err = NewWeighted([]chash.Host{
+		{Name: "a", Weight: 1}, {Name: "b", Weight: 2}, {Name: "c", Weight: 1}})
+	s.Require().Nil(err)
+	m2, err = NewWeighted([]chash.Host{
+		{Name: "c", Weight: 1}, {Name: "a", Weight: 1}, {Name: "b", Weight: 2}})
+	s.Require().Nil(err)
+	for _, key := range testKeys(1000) {
+		s.Require().Equal(m1.Get(key), m2.Get(key))
+	}
+}
+
+func (s *TestMaglevSuite) TestRemoveAny() {
+	names := make([]string, 10)
+	for i := range names {
+		names[i] = fmt.Sprintf("10.0.0.%d:8080", i)
+	}
+	keys := testKeys(10000)
+
+	// Removing any host, or changing its weight, moves about 1/N of keys.
+	for _, removed := range []int{0, 4, 9} {
+		m, err := New(names)
+		s.Require().Nil(err)
+		before := make([]string, len(keys))
+		for i, key := range keys {
+			before[i] = string(m.Get(key))
+		}
+
+		_, err = m.Remove(names[removed])
+		s.Require().Nil(err)
+		moved := 0
+		for i, key := range keys {
+			host := string(m.Get(key))
+			if host != before[i] {
+				moved++
+				s.Require().NotEqual(names[removed], host)
+			}
+		}
+		s.Require().InDelta(0.1, float64(moved)/float64(len(keys)), 0.04,
+			names[removed])
+
+		_, err = m.Add(chash.Host{Name: names[removed], Weight: 2})
+		s.Require().Nil(err)
+		moved = 0
+		for i, key := range keys {
+			if string(m.Get(key)) != before[i] {
+				moved++
+			}
+		}
+		s.Require().InDelta(0.1, float64(moved)/float64(len(keys)), 0.04,
+			names[removed])
+	}
+}
+
+func (s *TestMaglevSuite) TestWeighted() {
+	_, err := NewWeighted([]chash.Host{{Name: "a", Weight: 0}})
+	s.Require().Equal(chash.ErrInvalidWeight, err)
+	_, err = NewWeighted([]chash.Host{
+		{Name: "a", Weight: 1}, {Name: "a", Weight: 1}})
+	s.Require().NotNil(err)
+
+	m, err := NewWeighted([]chash.Host{
+		{Name: "a", Weight: 1},
+		{Name: "b", Weight: 3},
+	})
+	s.Require().Nil(err)
+	entries := map[int]int{}
+	for _, ind := range m.preferences {
+		entries[ind]++
+	}
+	s.Require().InDelta(len(m.preferences)/4, entries[0], 1)
+	s.Require().InDelta(len(m.preferences)*3/4, entries[1], 1)
+}
+
+func (s *TestMaglevSuite) TestAddRemove() {
+	m, err := New([]string{"a", "b", "c", "d"})
+	s.Require().Nil(err)
+	keys := testKeys(10000)
+	before := make([]string, len(keys))
+	for i, key := range keys {
+		before[i] = string(m.Get(key))
+	}
+	remapped := func() float64 {
+		count := 0
+		for i, key := range keys {
+			if string(m.Get(key)) != before[i] {
+				count++
+			}
+		}
+		return float64(count) / float64(len(keys))
+	}
+
+	fraction, err := m.Add(chash.Host{Name: "e", Weight: 1})
+	s.Require().Nil(err)
+	s.Require().Len(m.Hosts(), 5)
+	s.Require().InDelta(fraction, remapped(), 0.02)
+	// Keys are mostly moved to the new host.
+	s.Require().InDelta(0.2, fraction, 0.1)
+
+	// Removing the added host restores the table.
+	fraction, err = m.Remove("e")
+	s.Require().Nil(err)
+	s.Require().Len(m.Hosts(), 4)
+	s.Require().Equal(0.0, remapped())
+
+	fraction, err = m.Add(chash.Host{Name: "a", Weight: 2})
+	s.Require().Nil(err)
+	s.Require().Len(m.Hosts(), 4)
+	s.Require().InDelta(fraction, remapped(), 0.02)
+
+	// The prime is kept until it's less than minTableFactor times the number
+	// of hosts.
+	prime := m.prime
+	s.Require().Equal(397, prime)
+	for len(m.Hosts()) < prime/minTableFactor {
+		_, err = m.Add(chash.Host{
+			Name: fmt.Sprintf("host-%d", len(m.Hosts())), Weight: 1})
+		s.Require().Nil(err)
+	}
+	s.Require().Equal(prime, m.prime)
+	for i, key := range keys {
+		before[i] = string(m.Get(key))
+	}
+	fraction, err = m.Add(chash.Host{Name: "h", Weight: 1})
+	s.Require().Nil(err)
+	s.Require().NotEqual(prime, m.prime)
+	s.Require().InDelta(fraction, remapped(), 0.02)
+
+	for i, key := range keys {
+		before[i] = string(m.Get(key))
+	}
+	prime = m.prime
+	fraction, err = m.Remove("h")
+	s.Require().Nil(err)
+	s.Require().Equal(prime, m.prime)
+	s.Require().InDelta(fraction, remapped(), 0.02)
+
+	_, err = m.Remove("h")
+	s.Require().Equal(chash.ErrHostNotFound, err)
+	_, err = m.Add(chash.Host{Name: "h", Weight: -1})
+	s.Require().Equal(chash.ErrInvalidWeight, err)
+
+	m, err = New([]string{"a"})
+	s.Require().Nil(err)
+	_, err = m.Remove("a")
+	s.Require().Equal(chash.ErrEmptyHosts, err)
+}
+
 func TestMaglev(t *testing.T) {
 	suite.Run(t, new(TestMaglevSuite))
 }
@@ -0,0 +1,24 @@
+package rendezvous
+
+import (
+	"testing"
+
+	chash "github.com/jiarung/mochi/common/hash"
+	"github.com/jiarung/mochi/common/hash/hashtest"
+)
+
+func newHash(hosts []chash.Host) (chash.ConsistentHash, error) {
+	return New(hosts)
+}
+
+func BenchmarkGet(b *testing.B) {
+	hashtest.BenchmarkGet(b, newHash)
+}
+
+func BenchmarkDistribution(b *testing.B) {
+	hashtest.BenchmarkDistribution(b, newHash)
+}
+
+func BenchmarkDisruption(b *testing.B) {
+	hashtest.BenchmarkDisruption(b, newHash)
+}
@@ -0,0 +1,140 @@
+package rendezvous
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+
+	chash "github.com/jiarung/mochi/common/hash"
+)
+
+var _ chash.ConsistentHash = (*Rendezvous)(nil)
+
+// Rendezvous is highest random weight hashing, which maps a key to the host
+// of the highest score of the key. Only keys of changed hosts are remapped,
+// while lookups take time linear to the number of hosts.
+// Ref: https://en.wikipedia.org/wiki/Rendezvous_hashing
+type Rendezvous struct {
+	mutex sync.RWMutex
+	hosts []chash.Host
+	names [][]byte
+	// seeds are hashes of names of hosts.
+	seeds []uint64
+}
+
+// New returns rendezvous hashing of hosts.
+func New(hosts []chash.Host) (*Rendezvous, error) {
+	if err := chash.ValidateHosts(hosts); err != nil {
+		return nil, err
+	}
+	r := &Rendezvous{}
+	for _, host := range hosts {
+		r.append(host)
+	}
+	return r, nil
+}
+
+func (r *Rendezvous) append(host chash.Host) {
+	r.hosts = append(r.hosts, host)
+	r.names = append(r.names, []byte(host.Name))
+	r.seeds = append(r.seeds, hash64([]byte(host.Name)))
+}
+
+func hash64(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// mix is the finalizer of splitmix64, which scatters bits of x.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// score returns the weighted score of a key of hash h for host i, which is
+// -weight / ln(u) for u uniform in (0, 1), so hosts win keys in proportion to
+// their weights.
+func (r *Rendezvous) score(h uint64, i int) float64 {
+	u := (float64(mix(h^r.seeds[i])>>11) + 0.5) / (1 << 53)
+	return -r.hosts[i].Weight / math.Log(u)
+}
+
+// Get returns the host of key.
+func (r *Rendezvous) Get(key []byte) []byte {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	h := hash64(key)
+	best, bestScore := 0, math.Inf(-1)
+	for i := range r.hosts {
+		if s := r.score(h, i); s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return r.names[best]
+}
+
+// Hosts returns hosts in order of addition.
+func (r *Rendezvous) Hosts() []chash.Host {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]chash.Host{}, r.hosts...)
+}
+
+// totalWeight should be called with mutex locked.
+func (r *Rendezvous) totalWeight() float64 {
+	total := 0.0
+	for _, host := range r.hosts {
+		total += host.Weight
+	}
+	return total
+}
+
+// Add adds host, or updates its weight if it exists. It returns the expected
+// fraction of keys remapped, which are only keys moved from or to host.
+func (r *Rendezvous) Add(host chash.Host) (float64, error) {
+	if !(host.Weight > 0) {
+		return 0, chash.ErrInvalidWeight
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	total := r.totalWeight()
+	for i := range r.hosts {
+		if r.hosts[i].Name == host.Name {
+			share := r.hosts[i].Weight / total
+			total += host.Weight - r.hosts[i].Weight
+			r.hosts[i].Weight = host.Weight
+			return math.Abs(host.Weight/total - share), nil
+		}
+	}
+	r.append(host)
+	return host.Weight / (total + host.Weight), nil
+}
+
+// Remove removes host of name. It returns the expected fraction of keys
+// remapped, which are keys of the host.
+func (r *Rendezvous) Remove(name string) (float64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i := range r.hosts {
+		if r.hosts[i].Name != name {
+			continue
+		}
+		if len(r.hosts) == 1 {
+			return 0, chash.ErrEmptyHosts
+		}
+		share := r.hosts[i].Weight / r.totalWeight()
+		r.hosts = append(r.hosts[:i:i], r.hosts[i+1:]...)
+		r.names = append(r.names[:i:i], r.names[i+1:]...)
+		r.seeds = append(r.seeds[:i:i], r.seeds[i+1:]...)
+		return share, nil
+	}
+	return 0, chash.ErrHostNotFound
+}
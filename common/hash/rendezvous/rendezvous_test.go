@@ -0,0 +1,113 @@
+package rendezvous
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	chash "github.com/jiarung/mochi/common/hash"
+)
+
+type RendezvousSuite struct {
+	suite.Suite
+}
+
+func testKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	return keys
+}
+
+func hosts(names ...string) []chash.Host {
+	result := make([]chash.Host, len(names))
+	for i, name := range names {
+		result[i] = chash.Host{Name: name, Weight: 1}
+	}
+	return result
+}
+
+func (s *RendezvousSuite) TestNew() {
+	_, err := New(nil)
+	s.Require().Equal(chash.ErrEmptyHosts, err)
+	_, err = New([]chash.Host{{Name: "a"}})
+	s.Require().Equal(chash.ErrInvalidWeight, err)
+
+	r1, err := New(hosts("a", "b", "c"))
+	s.Require().Nil(err)
+	r2, err := New(hosts("c", "b", "a"))
+	s.Require().Nil(err)
+	for _, key := range testKeys(1000) {
+		s.Require().Equal(r1.Get(key), r2.Get(key))
+	}
+}
+
+func (s *RendezvousSuite) TestWeighted() {
+	r, err := New([]chash.Host{
+		{Name: "a", Weight: 1},
+		{Name: "b", Weight: 3},
+	})
+	s.Require().Nil(err)
+	keys := testKeys(20000)
+	counts := map[string]int{}
+	for _, key := range keys {
+		counts[string(r.Get(key))]++
+	}
+	s.Require().InDelta(0.25, float64(counts["a"])/float64(len(keys)), 0.02)
+	s.Require().InDelta(0.75, float64(counts["b"])/float64(len(keys)), 0.02)
+}
+
+func (s *RendezvousSuite) TestAddRemove() {
+	r, err := New(hosts("a", "b", "c", "d"))
+	s.Require().Nil(err)
+	keys := testKeys(20000)
+	before := make([]string, len(keys))
+	for i, key := range keys {
+		before[i] = string(r.Get(key))
+	}
+	// remapped returns the fraction of remapped keys, which should be moved
+	// from or to host.
+	remapped := func(host string) float64 {
+		count := 0
+		for i, key := range keys {
+			after := string(r.Get(key))
+			if after != before[i] {
+				s.Require().True(after == host || before[i] == host)
+				count++
+			}
+		}
+		return float64(count) / float64(len(keys))
+	}
+
+	fraction, err := r.Add(chash.Host{Name: "e", Weight: 1})
+	s.Require().Nil(err)
+	s.Require().Equal(0.2, fraction)
+	s.Require().InDelta(fraction, remapped("e"), 0.02)
+
+	fraction, err = r.Remove("e")
+	s.Require().Nil(err)
+	s.Require().Equal(0.2, fraction)
+	s.Require().Equal(0.0, remapped("e"))
+
+	fraction, err = r.Add(chash.Host{Name: "a", Weight: 4})
+	s.Require().Nil(err)
+	s.Require().Len(r.Hosts(), 4)
+	s.Require().InDelta(4.0/7-1.0/4, fraction, 1e-9)
+	s.Require().InDelta(fraction, remapped("a"), 0.02)
+
+	_, err = r.Remove("e")
+	s.Require().Equal(chash.ErrHostNotFound, err)
+	_, err = r.Add(chash.Host{Name: "e"})
+	s.Require().Equal(chash.ErrInvalidWeight, err)
+
+	r, err = New(hosts("a"))
+	s.Require().Nil(err)
+	_, err = r.Remove("a")
+	s.Require().Equal(chash.ErrEmptyHosts, err)
+}
+
+func TestRendezvous(t *testing.T) {
+	suite.Run(t, new(RendezvousSuite))
+}
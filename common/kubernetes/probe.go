@@ -3,12 +3,15 @@ package kubernetes
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/jiarung/mochi/common/config/misc"
 	"github.com/jiarung/mochi/common/global"
 	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+	"github.com/jiarung/mochi/common/telemetry"
 )
 
 // Response response the JSON response to the k8s probes.
@@ -66,6 +69,55 @@ func RegisterShutdownHandler(
 	}()
 }
 
+// ShutdownFunc releases a resource on graceful shutdown.
+type ShutdownFunc func(ctx context.Context) error
+
+// shutdownTimeout is the timeout of a ShutdownFunc.
+const shutdownTimeout = 30 * time.Second
+
+// RegisterShutdownFunc register fn to be called on graceful shutdown, e.g.
+// flushing buffered metrics with metric.CloseDefaultWriter.
+func RegisterShutdownFunc(
+	ctx context.Context,
+	logger logging.Logger,
+	fn ShutdownFunc) {
+	newLogger := logger.CloneLogger()
+	go func() {
+		logger := newLogger
+
+		<-ctx.Done()
+		global.IsShuttingDown = true
+		// ctx is done, use a new context to let fn finish its work.
+		shutdownCtx, cancel := context.WithTimeout(
+			context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := fn(shutdownCtx); err != nil {
+			logger.Error("Failed to shutdown: %s", err.Error())
+		}
+	}()
+}
+
+// InitTelemetry sets up telemetry by the config of environment variables,
+// see telemetry.ConfigFromEnv, and flushes it on graceful shutdown. It should
+// be called by main of the service before serving. The
+// service name is read from TELEMETRY_SERVICE_NAME if serviceName is empty.
+// Telemetry stays disabled if the config is invalid.
+func InitTelemetry(
+	ctx context.Context,
+	logger logging.Logger,
+	serviceName string) {
+	cfg, err := telemetry.ConfigFromEnv(serviceName)
+	if err != nil {
+		logger.Warn("Telemetry disabled: %s", err.Error())
+		return
+	}
+	if err := telemetry.Init(ctx, cfg); err != nil {
+		logger.Error("Failed to init telemetry: %s", err.Error())
+		return
+	}
+	RegisterShutdownFunc(ctx, logger, telemetry.Shutdown)
+}
+
 // StartHealthCheckServer starts kubernetes health check HTTP server.
 func StartHealthCheckServer(ctx context.Context, logger logging.Logger) {
 	router := gin.New()
@@ -89,8 +141,13 @@ func StartHealthCheckServer(ctx context.Context, logger logging.Logger) {
 	router.GET("/alive", LivenessProbe)
 	router.GET("/ready", ReadinessProbe)
 
+	// Register Prometheus Metrics.
+	router.GET("/metrics", gin.WrapH(metric.Handler()))
+
 	// Register shutdown handler.
 	RegisterShutdownHandler(ctx, logger, server)
+	// Flush buffered metrics on shutdown.
+	RegisterShutdownFunc(ctx, logger, metric.CloseDefaultWriter)
 
 	newLogger := logger.CloneLogger()
 	go func() {
@@ -1,6 +1,8 @@
 package limiters
 
 import (
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -11,6 +13,14 @@ import (
 
 var logger = logging.NewLoggerTag("api-limiter")
 
+// authLimiterKeyBase is the key prefix of limiters by user id only.
+const authLimiterKeyBase = "waf-auth-limiter:"
+
+// AuthLimiterKey returns the limiter key of userID.
+func AuthLimiterKey(userID string) string {
+	return authLimiterKeyBase + userID
+}
+
 // Result result the reached limiter count and limit.
 type Result struct {
 	Reached   bool
@@ -94,3 +104,29 @@ func ReachLimitation(cLimiter Limiter, key string) Result {
 	logger.Debug("Limitation result: %v, %v\n", key, result)
 	return result
 }
+
+// GetCount returns the count of key in the current period, 0 if key isn't
+// counted.
+func GetCount(key string) (int64, error) {
+	data, err := cache.GetRedis().Get(key)
+	if err != nil {
+		if cache.ParseCacheErrorCode(err) == cache.ErrNilKey {
+			return 0, nil
+		}
+		return 0, err
+	}
+	val, ok := data.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid count of %v: %v", key, data)
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// ClearCount clears the count of key.
+func ClearCount(key string) error {
+	err := cache.GetRedis().Delete(key)
+	if err != nil {
+		logger.Error("Fail to clear count of key: %v. Err: %v\n", key, err)
+	}
+	return err
+}
@@ -18,18 +18,21 @@ const (
 	WSConnLimitKey = "ws-conn-limit-key"
 )
 
+// WebsocketAPIIPKey returns the limiter key of ip on API entries.
+func WebsocketAPIIPKey(ip string) string {
+	return fmt.Sprintf("websocket-ip-rate-limit:%v", ip)
+}
+
 // ReachWebsocketAPIIP10RPS returns boolean indicates particular IP
 // reaches 10 requests/second on API entries or not.
 func ReachWebsocketAPIIP10RPS(ip string) bool {
-	key := fmt.Sprintf("websocket-ip-rate-limit:%v", ip)
-	ret := ReachLimitation(NewLimiter(10, 1), key)
+	ret := ReachLimitation(NewLimiter(10, 1), WebsocketAPIIPKey(ip))
 	return ret.Reached
 }
 
 // ClearWebsocketAPIIP10RPS by ip if neccessary.
 func ClearWebsocketAPIIP10RPS(ip string) error {
-	key := fmt.Sprintf("websocket-ip-rate-limit:%v", ip)
-	err := cache.GetRedis().Delete(key)
+	err := cache.GetRedis().Delete(WebsocketAPIIPKey(ip))
 	if err != nil {
 		logger.Error("Fail to clear api limit by ip: %v. Err: %v\n", ip, err)
 	}
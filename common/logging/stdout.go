@@ -10,6 +10,7 @@ import (
 
 	"github.com/ttacon/chalk"
 
+	"github.com/jiarung/mochi/common/collections"
 	"github.com/jiarung/mochi/common/utils"
 )
 
@@ -48,7 +49,7 @@ type stdOutput struct {
 	ctx        context.Context
 	cancel     context.CancelFunc
 	defaultOpt *StdoutOption
-	workerChan *utils.UnlimitedChannel
+	workerChan *collections.UnboundedChan[[]byte]
 	closeChan  chan struct{}
 }
 
@@ -60,7 +61,7 @@ func newStdOutput() *stdOutput {
 	o := &stdOutput{
 		Writer:     os.Stdout,
 		defaultOpt: opt,
-		workerChan: utils.NewUnlimitedChannel(),
+		workerChan: collections.NewUnboundedChan[[]byte](),
 		closeChan:  make(chan struct{}),
 	}
 	o.ctx, o.cancel = context.WithCancel(context.Background())
@@ -115,8 +116,7 @@ func (o *stdOutput) work() {
 			<-o.workerChan.Done()
 			o.flush()
 			return
-		case b := <-o.workerChan.Out():
-			bytes := b.([]byte)
+		case bytes := <-o.workerChan.Out():
 			if len(bytes) <= 0 {
 				continue
 			}
@@ -127,7 +127,7 @@ func (o *stdOutput) work() {
 
 func (o *stdOutput) flush() {
 	for _, bytes := range o.workerChan.Dump() {
-		o.Writer.Write(bytes.([]byte))
+		o.Writer.Write(bytes)
 	}
 }
 
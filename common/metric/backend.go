@@ -0,0 +1,45 @@
+package metric
+
+import (
+	"context"
+
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+// MaxTimeSeriesPerRequest is the maximum number of time series stackdriver
+// accepts in a single CreateTimeSeries request.
+const MaxTimeSeriesPerRequest = 200
+
+// Backend defines the store that time series are written to.
+type Backend interface {
+	// CreateTimeSeries writes series. Each series contains a single point and
+	// series are unique in a call.
+	CreateTimeSeries(ctx context.Context, series []*monitoring.TimeSeries) error
+}
+
+// stackdriverBackend writes time series to stackdriver.
+type stackdriverBackend struct {
+	service *monitoring.Service
+	project string
+}
+
+// NewStackdriverBackend returns a backend that writes to stackdriver with
+// the compute engine credentials.
+func NewStackdriverBackend() (Backend, error) {
+	service, err := getStackDriverService()
+	if err != nil {
+		return nil, err
+	}
+	return &stackdriverBackend{
+		service: service,
+		project: projectResource(),
+	}, nil
+}
+
+// CreateTimeSeries writes series to stackdriver.
+func (b *stackdriverBackend) CreateTimeSeries(
+	ctx context.Context, series []*monitoring.TimeSeries) error {
+	_, err := b.service.Projects.TimeSeries.Create(b.project,
+		&monitoring.CreateTimeSeriesRequest{TimeSeries: series}).Context(ctx).Do()
+	return err
+}
@@ -1,31 +1,40 @@
 package metric
 
 import (
-	"time"
+	"context"
+	"sync"
 
 	gce "cloud.google.com/go/compute/metadata"
-	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	monitoring "google.golang.org/api/monitoring/v3"
 
 	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/telemetry"
 )
 
 //CreateMetric will create a Metric in stackdriver,
 //which can be found in web monitor
 func (m *Metric) CreateMetric() {
 	logger := logging.NewLoggerTag("metric")
+
+	// The telemetry exporter creates descriptors on first write.
+	if telemetry.Enabled() {
+		return
+	}
+
 	stackdriver, err := getStackDriverService()
 
 	if err != nil {
 		logger.Error("get stackdriver err:%v", err)
+		return
 	}
 
 	err = m.CheckConfig()
 
 	if err != nil {
 		logger.Error("check metric config fail: %v", err)
+		return
 	}
 
 	md := monitoring.MetricDescriptor{
@@ -49,67 +58,108 @@ func (m *Metric) CreateMetric() {
 //WriteMetric will send time series metric data to
 //the metric has already created in stackdriver
 //type is the metric url
+//If telemetry is enabled, the data is recorded through
+//the telemetry meter provider instead
+//Points are buffered and written in batches by the
+//default writer, see SetDefaultWriter
 func (m *Metric) WriteMetric(value interface{}, label ResourceLabel) {
 	logger := logging.NewLoggerTag("metric")
-	stackdriver, err := getStackDriverService()
 
+	// Prefer the telemetry meter provider, and fall back to stackdriver for
+	// value types unsupported by OpenTelemetry.
+	if telemetry.Enabled() {
+		recorded, err := m.record(value, label)
+		if err != nil {
+			logger.Error("record metric err:%v", err)
+			return
+		}
+		if recorded {
+			return
+		}
+	}
+
+	dataPointValue := m.typedValue(value)
+	if dataPointValue == nil {
+		return
+	}
+
+	w, err := DefaultWriter()
 	if err != nil {
-		logger.Error("get stackdriver err:%v", err)
+		logger.Error("get metric writer err:%v", err)
 		return
 	}
-	var dataPointValue *monitoring.TypedValue
+	w.Write(m, dataPointValue, label)
+}
+
+// typedValue converts value to the stackdriver value of m.ValueType.
+func (m *Metric) typedValue(value interface{}) *monitoring.TypedValue {
 	switch m.ValueType {
 	case "INT64":
-		dataPointValue = &monitoring.TypedValue{
+		return &monitoring.TypedValue{
 			Int64Value: value.(*int64),
 		}
 	case "BOOL":
-		dataPointValue = &monitoring.TypedValue{
+		return &monitoring.TypedValue{
 			BoolValue: value.(*bool),
 		}
 	case "DOUBLE":
-		dataPointValue = &monitoring.TypedValue{
+		return &monitoring.TypedValue{
 			DoubleValue: value.(*float64),
 		}
 	case "STRING":
-		dataPointValue = &monitoring.TypedValue{
+		return &monitoring.TypedValue{
 			StringValue: value.(*string),
 		}
 	case "DISTRIBUTION":
-		dataPointValue = &monitoring.TypedValue{
+		return &monitoring.TypedValue{
 			DistributionValue: value.(*monitoring.Distribution),
 		}
-
-	default:
-		return
 	}
+	return nil
+}
+
+var (
+	defaultWriterMutex sync.Mutex
+	defaultWriter      *Writer
+)
 
-	dataPoint := &monitoring.Point{
-		Interval: &monitoring.TimeInterval{
-			EndTime: time.Now().Format(time.RFC3339),
-		},
-		Value: dataPointValue,
+// DefaultWriter returns the writer used by WriteMetric. A stackdriver
+// writer with DefaultWriterOption is created on first use.
+func DefaultWriter() (*Writer, error) {
+	defaultWriterMutex.Lock()
+	defer defaultWriterMutex.Unlock()
+
+	if defaultWriter != nil {
+		return defaultWriter, nil
+	}
+	backend, err := NewStackdriverBackend()
+	if err != nil {
+		return nil, err
 	}
+	defaultWriter = NewWriter(backend, nil)
+	return defaultWriter, nil
+}
+
+// SetDefaultWriter replaces the writer used by WriteMetric. The replaced
+// writer is not closed.
+func SetDefaultWriter(w *Writer) {
+	defaultWriterMutex.Lock()
+	defer defaultWriterMutex.Unlock()
+	defaultWriter = w
+}
+
+// CloseDefaultWriter flushes and closes the default writer if it's created.
+// It's a kubernetes.ShutdownFunc.
+func CloseDefaultWriter(ctx context.Context) error {
+	defaultWriterMutex.Lock()
+	w := defaultWriter
+	defaultWriter = nil
+	defaultWriterMutex.Unlock()
 
-	// Write time series data.
-	request := &monitoring.CreateTimeSeriesRequest{
-		TimeSeries: []*monitoring.TimeSeries{
-			{
-				Metric: &monitoring.Metric{
-					Type: m.Type,
-				},
-				Resource: &monitoring.MonitoredResource{
-					Type:   label.ResourceType(),
-					Labels: label.Label(),
-				},
-				Points: []*monitoring.Point{
-					dataPoint,
-				},
-			},
-		},
+	if w == nil {
+		return nil
 	}
-	projectName := projectResource()
-	_, err = stackdriver.Projects.TimeSeries.Create(projectName, request).Do()
+	return w.Close(ctx)
 }
 
 func getStackDriverService() (*monitoring.Service, error) {
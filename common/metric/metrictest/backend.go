@@ -0,0 +1,62 @@
+package metrictest
+
+import (
+	"context"
+	"sync"
+
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+// FakeBackend is an in-memory metric.Backend for tests.
+type FakeBackend struct {
+	mutex    sync.Mutex
+	requests [][]*monitoring.TimeSeries
+	failures []error
+}
+
+// NewFakeBackend returns a fake backend.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{}
+}
+
+// FailNext makes the next len(errs) requests fail with errs in order.
+func (b *FakeBackend) FailNext(errs ...error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = append(b.failures, errs...)
+}
+
+// CreateTimeSeries records series, or fails with the queued error.
+func (b *FakeBackend) CreateTimeSeries(
+	ctx context.Context, series []*monitoring.TimeSeries) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.failures) > 0 {
+		err := b.failures[0]
+		b.failures = b.failures[1:]
+		return err
+	}
+	copied := make([]*monitoring.TimeSeries, len(series))
+	copy(copied, series)
+	b.requests = append(b.requests, copied)
+	return nil
+}
+
+// Requests returns the successful requests.
+func (b *FakeBackend) Requests() [][]*monitoring.TimeSeries {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.requests
+}
+
+// Series returns all series of the successful requests.
+func (b *FakeBackend) Series() []*monitoring.TimeSeries {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var series []*monitoring.TimeSeries
+	for _, r := range b.requests {
+		series = append(series, r...)
+	}
+	return series
+}
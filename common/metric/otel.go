@@ -0,0 +1,126 @@
+package metric
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+
+	"github.com/jiarung/mochi/common/telemetry"
+)
+
+// instruments caches the OpenTelemetry instrument of each metric type.
+var instruments sync.Map
+
+// instrument records a single value of a metric.
+type instrument func(ctx context.Context, value interface{},
+	opt otelmetric.MeasurementOption) bool
+
+// otelName returns the OpenTelemetry instrument name of the metric.
+func (m *Metric) otelName() string {
+	return strings.TrimPrefix(m.Type, "custom.googleapis.com/")
+}
+
+// instrument returns the cached instrument of m, or creates one.
+func (m *Metric) instrument() (instrument, error) {
+	if v, ok := instruments.Load(m.Type); ok {
+		return v.(instrument), nil
+	}
+
+	meter := telemetry.Meter()
+	name := m.otelName()
+	var inst instrument
+
+	switch {
+	case m.MetricKind == "DELTA" && m.ValueType == "INT64":
+		c, err := meter.Int64Counter(name, otelmetric.WithDescription(
+			m.Description), otelmetric.WithUnit(m.Unit))
+		if err != nil {
+			return nil, err
+		}
+		inst = func(ctx context.Context, v interface{},
+			opt otelmetric.MeasurementOption) bool {
+			c.Add(ctx, *v.(*int64), opt)
+			return true
+		}
+	case m.MetricKind == "DELTA" && m.ValueType == "DOUBLE":
+		c, err := meter.Float64Counter(name, otelmetric.WithDescription(
+			m.Description), otelmetric.WithUnit(m.Unit))
+		if err != nil {
+			return nil, err
+		}
+		inst = func(ctx context.Context, v interface{},
+			opt otelmetric.MeasurementOption) bool {
+			c.Add(ctx, *v.(*float64), opt)
+			return true
+		}
+	case m.ValueType == "INT64":
+		g, err := meter.Int64Gauge(name, otelmetric.WithDescription(
+			m.Description), otelmetric.WithUnit(m.Unit))
+		if err != nil {
+			return nil, err
+		}
+		inst = func(ctx context.Context, v interface{},
+			opt otelmetric.MeasurementOption) bool {
+			g.Record(ctx, *v.(*int64), opt)
+			return true
+		}
+	case m.ValueType == "DOUBLE":
+		g, err := meter.Float64Gauge(name, otelmetric.WithDescription(
+			m.Description), otelmetric.WithUnit(m.Unit))
+		if err != nil {
+			return nil, err
+		}
+		inst = func(ctx context.Context, v interface{},
+			opt otelmetric.MeasurementOption) bool {
+			g.Record(ctx, *v.(*float64), opt)
+			return true
+		}
+	case m.ValueType == "BOOL":
+		g, err := meter.Int64Gauge(name, otelmetric.WithDescription(
+			m.Description))
+		if err != nil {
+			return nil, err
+		}
+		inst = func(ctx context.Context, v interface{},
+			opt otelmetric.MeasurementOption) bool {
+			var i int64
+			if *v.(*bool) {
+				i = 1
+			}
+			g.Record(ctx, i, opt)
+			return true
+		}
+	default:
+		// STRING and DISTRIBUTION have no OpenTelemetry counterpart.
+		inst = func(context.Context, interface{},
+			otelmetric.MeasurementOption) bool {
+			return false
+		}
+	}
+
+	v, _ := instruments.LoadOrStore(m.Type, inst)
+	return v.(instrument), nil
+}
+
+// record records value through the telemetry meter provider. It returns
+// false if the value type isn't supported by OpenTelemetry.
+func (m *Metric) record(value interface{}, label ResourceLabel) (bool, error) {
+	inst, err := m.instrument()
+	if err != nil {
+		return false, fmt.Errorf("create instrument %s: %v", m.Type, err)
+	}
+
+	labels := label.Label()
+	attrs := make([]attribute.KeyValue, 0, len(labels)+1)
+	attrs = append(attrs, attribute.String("resource_type", label.ResourceType()))
+	for k, v := range labels {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	return inst(context.Background(), value,
+		otelmetric.WithAttributes(attrs...)), nil
+}
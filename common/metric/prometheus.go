@@ -0,0 +1,91 @@
+package metric
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the prometheus namespace of all metrics created in this
+// package.
+const Namespace = "mochi"
+
+// DefaultLatencyBuckets are the histogram buckets, in seconds, used for
+// request latencies.
+var DefaultLatencyBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+var registry = newRegistry()
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}
+
+// Registry returns the prometheus registry served by Handler.
+func Registry() *prometheus.Registry {
+	return registry
+}
+
+// Handler returns the HTTP handler that exposes the registry in the
+// prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// register registers c, or returns the collector which is already registered
+// with the same descriptor so packages can declare the same metric safely.
+func register(c prometheus.Collector) prometheus.Collector {
+	if err := registry.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+// NewCounter creates and registers a counter vector.
+func NewCounter(
+	subsystem, name, help string, labels ...string) *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}, labels)).(*prometheus.CounterVec)
+}
+
+// NewGauge creates and registers a gauge vector.
+func NewGauge(
+	subsystem, name, help string, labels ...string) *prometheus.GaugeVec {
+	return register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}, labels)).(*prometheus.GaugeVec)
+}
+
+// NewHistogram creates and registers a histogram vector. It uses
+// DefaultLatencyBuckets if buckets is nil.
+func NewHistogram(subsystem, name, help string, buckets []float64,
+	labels ...string) *prometheus.HistogramVec {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	return register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+		Buckets:   buckets,
+	}, labels)).(*prometheus.HistogramVec)
+}
@@ -0,0 +1,45 @@
+package metric
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type PrometheusTestSuite struct {
+	suite.Suite
+}
+
+func (s *PrometheusTestSuite) TestRegisterTwice() {
+	c1 := NewCounter("test", "register_twice_total", "test", "label")
+	c2 := NewCounter("test", "register_twice_total", "test", "label")
+	s.Require().True(c1 == c2)
+
+	c1.WithLabelValues("a").Inc()
+	c2.WithLabelValues("a").Inc()
+	s.Require().Equal(2.0, testutil.ToFloat64(c1.WithLabelValues("a")))
+}
+
+func (s *PrometheusTestSuite) TestHandler() {
+	g := NewGauge("test", "handler_gauge", "test gauge", "label")
+	g.WithLabelValues("a").Set(3)
+	h := NewHistogram("test", "handler_seconds", "test histogram", nil)
+	h.WithLabelValues().Observe(0.3)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(rec.Body)
+	s.Require().Nil(err)
+	s.Require().True(strings.Contains(string(body),
+		`mochi_test_handler_gauge{label="a"} 3`))
+	s.Require().True(strings.Contains(string(body),
+		`mochi_test_handler_seconds_count 1`))
+}
+
+func TestPrometheus(t *testing.T) {
+	suite.Run(t, new(PrometheusTestSuite))
+}
@@ -0,0 +1,385 @@
+package metric
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	monitoring "google.golang.org/api/monitoring/v3"
+
+	"github.com/jiarung/mochi/common/logging"
+)
+
+// ErrWriterClosed is returned when writing to a closed Writer.
+var ErrWriterClosed = errors.New("metric writer is closed")
+
+var (
+	writerFlushesTotal = NewCounter("metric_writer", "flushes_total",
+		"Total number of metric writer flushes.", "result")
+	writerPointsTotal = NewCounter("metric_writer", "points_total",
+		"Total number of points handled by metric writers.", "result")
+)
+
+// WriterOption defines the options of Writer.
+type WriterOption struct {
+	// FlushInterval is the interval between periodic flushes.
+	FlushInterval time.Duration
+	// BatchSize is the number of pending series that triggers a flush, and
+	// the number of series sent in a single request.
+	BatchSize int
+	// MaxPending is the maximum number of pending series. Points of new
+	// series are dropped when it's reached.
+	MaxPending int
+	// MaxRetries is the number of retries of a failed request.
+	MaxRetries int
+	// RetryBackoff is the initial backoff between retries, which doubles on
+	// each retry.
+	RetryBackoff time.Duration
+	// RequestTimeout is the timeout of a single backend request.
+	RequestTimeout time.Duration
+}
+
+// DefaultWriterOption returns the default writer option.
+func DefaultWriterOption() *WriterOption {
+	return &WriterOption{
+		FlushInterval:  time.Minute,
+		BatchSize:      MaxTimeSeriesPerRequest,
+		MaxPending:     10 * MaxTimeSeriesPerRequest,
+		MaxRetries:     3,
+		RetryBackoff:   time.Second,
+		RequestTimeout: 30 * time.Second,
+	}
+}
+
+// WriterStats is a snapshot of the counters of a Writer.
+type WriterStats struct {
+	// Written is the number of points written to the backend.
+	Written uint64
+	// Dropped is the number of points dropped because of overflow, backend
+	// errors or writes after close.
+	Dropped uint64
+	// Flushes is the number of successful backend requests.
+	Flushes uint64
+	// FailedFlushes is the number of backend requests that failed after all
+	// retries.
+	FailedFlushes uint64
+}
+
+// pendingSeries is a series aggregated from one or more points.
+type pendingSeries struct {
+	metric *Metric
+	label  ResourceLabel
+	labels map[string]string
+	value  *monitoring.TypedValue
+	start  time.Time
+	end    time.Time
+	count  uint64
+}
+
+// Writer buffers metric points in the background and writes them to a
+// Backend in batches. Points of the same metric and label set are
+// aggregated between flushes: DELTA INT64/DOUBLE values are summed and the
+// latest value wins for others.
+type Writer struct {
+	backend Backend
+	opt     WriterOption
+	logger  logging.Logger
+
+	mutex   sync.Mutex
+	pending map[string]*pendingSeries
+	closed  bool
+
+	flushChan chan struct{}
+	flushLock sync.Mutex
+	doneChan  chan struct{}
+	wg        sync.WaitGroup
+	// ctx is the context of background flushes, which is canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	written, dropped, flushes, failedFlushes uint64
+}
+
+// NewWriter creates a writer and starts its background flusher.
+func NewWriter(backend Backend, opt *WriterOption) *Writer {
+	if opt == nil {
+		opt = DefaultWriterOption()
+	}
+	o := *opt
+	if o.BatchSize <= 0 || o.BatchSize > MaxTimeSeriesPerRequest {
+		o.BatchSize = MaxTimeSeriesPerRequest
+	}
+	if o.MaxPending < o.BatchSize {
+		o.MaxPending = o.BatchSize
+	}
+
+	w := &Writer{
+		backend:   backend,
+		opt:       o,
+		logger:    logging.NewLoggerTag("metric:writer"),
+		pending:   make(map[string]*pendingSeries),
+		flushChan: make(chan struct{}, 1),
+		doneChan:  make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+// seriesKey returns the aggregation key of a metric and label set.
+func seriesKey(m *Metric, resourceType string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sb := strings.Builder{}
+	sb.WriteString(m.Type)
+	sb.WriteByte('|')
+	sb.WriteString(resourceType)
+	for _, k := range keys {
+		sb.WriteByte('|')
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+	}
+	return sb.String()
+}
+
+// Write adds a point to the buffer. It returns false if the point is
+// dropped.
+func (w *Writer) Write(
+	m *Metric, value *monitoring.TypedValue, label ResourceLabel) bool {
+	now := time.Now()
+	labels := label.Label()
+	key := seriesKey(m, label.ResourceType(), labels)
+
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		w.drop(1, "closed")
+		return false
+	}
+
+	s, ok := w.pending[key]
+	if !ok {
+		if len(w.pending) >= w.opt.MaxPending {
+			w.mutex.Unlock()
+			w.drop(1, "overflow")
+			return false
+		}
+		s = &pendingSeries{
+			metric: m,
+			label:  label,
+			labels: labels,
+			start:  now,
+		}
+		w.pending[key] = s
+	}
+	s.value = aggregate(m, s.value, value)
+	s.end = now
+	s.count++
+	full := len(w.pending) >= w.opt.BatchSize
+	w.mutex.Unlock()
+
+	if full {
+		w.triggerFlush()
+	}
+	return true
+}
+
+// aggregate merges value into current.
+func aggregate(m *Metric, current, value *monitoring.TypedValue) *monitoring.TypedValue {
+	if current == nil || m.MetricKind != "DELTA" {
+		return value
+	}
+	switch m.ValueType {
+	case "INT64":
+		if current.Int64Value != nil && value.Int64Value != nil {
+			sum := *current.Int64Value + *value.Int64Value
+			return &monitoring.TypedValue{Int64Value: &sum}
+		}
+	case "DOUBLE":
+		if current.DoubleValue != nil && value.DoubleValue != nil {
+			sum := *current.DoubleValue + *value.DoubleValue
+			return &monitoring.TypedValue{DoubleValue: &sum}
+		}
+	}
+	return value
+}
+
+func (w *Writer) triggerFlush() {
+	select {
+	case w.flushChan <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Writer) loop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.opt.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.doneChan:
+			return
+		case <-ticker.C:
+		case <-w.flushChan:
+		}
+		if err := w.Flush(w.ctx); err != nil {
+			w.logger.Error("flush metrics err: %v", err)
+		}
+	}
+}
+
+// Flush writes all pending series to the backend.
+func (w *Writer) Flush(ctx context.Context) error {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+
+	w.mutex.Lock()
+	pending := w.pending
+	w.pending = make(map[string]*pendingSeries)
+	w.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	series := make([]*monitoring.TimeSeries, 0, len(pending))
+	counts := make([]uint64, 0, len(pending))
+	for _, s := range pending {
+		series = append(series, s.timeSeries())
+		counts = append(counts, s.count)
+	}
+
+	var firstErr error
+	for i := 0; i < len(series); i += w.opt.BatchSize {
+		j := i + w.opt.BatchSize
+		if j > len(series) {
+			j = len(series)
+		}
+		var points uint64
+		for _, c := range counts[i:j] {
+			points += c
+		}
+
+		if err := w.send(ctx, series[i:j]); err != nil {
+			atomic.AddUint64(&w.failedFlushes, 1)
+			writerFlushesTotal.WithLabelValues("failure").Inc()
+			w.drop(points, "backend")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		atomic.AddUint64(&w.flushes, 1)
+		atomic.AddUint64(&w.written, points)
+		writerFlushesTotal.WithLabelValues("success").Inc()
+		writerPointsTotal.WithLabelValues("written").Add(float64(points))
+	}
+	return firstErr
+}
+
+// send writes a batch with retries.
+func (w *Writer) send(ctx context.Context, batch []*monitoring.TimeSeries) error {
+	backoff := w.opt.RetryBackoff
+	var err error
+	for i := 0; ; i++ {
+		reqCtx, cancel := context.WithTimeout(ctx, w.opt.RequestTimeout)
+		err = w.backend.CreateTimeSeries(reqCtx, batch)
+		cancel()
+		if err == nil || i >= w.opt.MaxRetries {
+			return err
+		}
+
+		w.logger.Warn("write %d series failed, retry in %v. err: %v",
+			len(batch), backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *Writer) drop(points uint64, reason string) {
+	atomic.AddUint64(&w.dropped, points)
+	writerPointsTotal.WithLabelValues("dropped_" + reason).Add(float64(points))
+}
+
+// Stats returns the counters of the writer.
+func (w *Writer) Stats() WriterStats {
+	return WriterStats{
+		Written:       atomic.LoadUint64(&w.written),
+		Dropped:       atomic.LoadUint64(&w.dropped),
+		Flushes:       atomic.LoadUint64(&w.flushes),
+		FailedFlushes: atomic.LoadUint64(&w.failedFlushes),
+	}
+}
+
+// Close stops the background flusher and flushes pending series. Writes
+// after Close are dropped. It returns ctx.Err() if ctx is done before the
+// background flusher stops, and the background flush is canceled.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return ErrWriterClosed
+	}
+	w.closed = true
+	w.mutex.Unlock()
+	defer w.cancel()
+
+	close(w.doneChan)
+	stopped := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return w.Flush(ctx)
+}
+
+// timeSeries returns the stackdriver time series of s.
+func (s *pendingSeries) timeSeries() *monitoring.TimeSeries {
+	interval := &monitoring.TimeInterval{
+		EndTime: s.end.Format(time.RFC3339Nano),
+	}
+	if s.metric.MetricKind == "DELTA" {
+		// Delta intervals must not be empty.
+		start := s.start
+		if !start.Before(s.end) {
+			start = s.end.Add(-time.Millisecond)
+		}
+		interval.StartTime = start.Format(time.RFC3339Nano)
+	}
+
+	return &monitoring.TimeSeries{
+		Metric: &monitoring.Metric{
+			Type: s.metric.Type,
+		},
+		Resource: &monitoring.MonitoredResource{
+			Type:   s.label.ResourceType(),
+			Labels: s.labels,
+		},
+		Points: []*monitoring.Point{
+			{
+				Interval: interval,
+				Value:    s.value,
+			},
+		},
+	}
+}
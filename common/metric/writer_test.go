@@ -0,0 +1,195 @@
+package metric
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	monitoring "google.golang.org/api/monitoring/v3"
+
+	"github.com/jiarung/mochi/common/metric/metrictest"
+)
+
+type testLabel struct {
+	pod string
+}
+
+func (l *testLabel) Label() map[string]string {
+	return map[string]string{"pod_id": l.pod}
+}
+
+func (l *testLabel) ResourceType() string {
+	return "gke_container"
+}
+
+func int64Value(v int64) *monitoring.TypedValue {
+	return &monitoring.TypedValue{Int64Value: &v}
+}
+
+type WriterTestSuite struct {
+	suite.Suite
+
+	backend *metrictest.FakeBackend
+	opt     *WriterOption
+}
+
+func (s *WriterTestSuite) SetupTest() {
+	s.backend = metrictest.NewFakeBackend()
+	s.opt = &WriterOption{
+		FlushInterval:  time.Hour,
+		BatchSize:      10,
+		MaxPending:     20,
+		MaxRetries:     2,
+		RetryBackoff:   time.Millisecond,
+		RequestTimeout: time.Second,
+	}
+}
+
+func (s *WriterTestSuite) TestAggregate() {
+	w := NewWriter(s.backend, s.opt)
+	delta := &Metric{Type: "custom.googleapis.com/delta",
+		MetricKind: "DELTA", ValueType: "INT64"}
+	gauge := &Metric{Type: "custom.googleapis.com/gauge",
+		MetricKind: "GAUGE", ValueType: "INT64"}
+
+	for i := int64(1); i <= 3; i++ {
+		s.Require().True(w.Write(delta, int64Value(i), &testLabel{"a"}))
+		s.Require().True(w.Write(gauge, int64Value(i), &testLabel{"a"}))
+	}
+	s.Require().True(w.Write(delta, int64Value(10), &testLabel{"b"}))
+	s.Require().Nil(w.Close(context.Background()))
+
+	values := map[string]int64{}
+	for _, ts := range s.backend.Series() {
+		s.Require().Len(ts.Points, 1)
+		key := ts.Metric.Type + ":" + ts.Resource.Labels["pod_id"]
+		values[key] = *ts.Points[0].Value.Int64Value
+	}
+	s.Require().Equal(map[string]int64{
+		"custom.googleapis.com/delta:a": 6,
+		"custom.googleapis.com/delta:b": 10,
+		"custom.googleapis.com/gauge:a": 3,
+	}, values)
+
+	stats := w.Stats()
+	s.Require().Equal(uint64(7), stats.Written)
+	s.Require().Equal(uint64(0), stats.Dropped)
+	s.Require().Equal(uint64(1), stats.Flushes)
+}
+
+func (s *WriterTestSuite) TestFlushOnBatchSize() {
+	w := NewWriter(s.backend, s.opt)
+	defer w.Close(context.Background())
+	m := &Metric{Type: "custom.googleapis.com/gauge",
+		MetricKind: "GAUGE", ValueType: "INT64"}
+
+	for i := 0; i < s.opt.BatchSize; i++ {
+		w.Write(m, int64Value(1), &testLabel{strconv.Itoa(i)})
+	}
+	s.Require().Eventually(func() bool {
+		return len(s.backend.Requests()) == 1
+	}, time.Second, 10*time.Millisecond)
+	s.Require().Len(s.backend.Requests()[0], s.opt.BatchSize)
+}
+
+func (s *WriterTestSuite) TestRetry() {
+	w := NewWriter(s.backend, s.opt)
+	m := &Metric{Type: "custom.googleapis.com/gauge",
+		MetricKind: "GAUGE", ValueType: "INT64"}
+
+	s.backend.FailNext(errors.New("unavailable"), errors.New("unavailable"))
+	w.Write(m, int64Value(1), &testLabel{"a"})
+	s.Require().Nil(w.Flush(context.Background()))
+	s.Require().Len(s.backend.Series(), 1)
+
+	s.backend.FailNext(errors.New("1"), errors.New("2"), errors.New("3"))
+	w.Write(m, int64Value(1), &testLabel{"a"})
+	s.Require().NotNil(w.Flush(context.Background()))
+	s.Require().Nil(w.Close(context.Background()))
+
+	stats := w.Stats()
+	s.Require().Equal(uint64(1), stats.Written)
+	s.Require().Equal(uint64(1), stats.Dropped)
+	s.Require().Equal(uint64(1), stats.FailedFlushes)
+}
+
+func (s *WriterTestSuite) TestOverflowAndClose() {
+	s.opt.BatchSize = MaxTimeSeriesPerRequest
+	s.opt.MaxPending = MaxTimeSeriesPerRequest
+	w := NewWriter(s.backend, s.opt)
+	m := &Metric{Type: "custom.googleapis.com/gauge",
+		MetricKind: "GAUGE", ValueType: "INT64"}
+
+	// Block the flusher so pending series pile up.
+	w.flushLock.Lock()
+	for i := 0; i < s.opt.MaxPending; i++ {
+		s.Require().True(w.Write(m, int64Value(1), &testLabel{strconv.Itoa(i)}))
+	}
+	s.Require().False(w.Write(m, int64Value(1), &testLabel{"overflow"}))
+	// Existing series are still aggregated.
+	s.Require().True(w.Write(m, int64Value(2), &testLabel{"0"}))
+	w.flushLock.Unlock()
+
+	s.Require().Nil(w.Close(context.Background()))
+	s.Require().Equal(ErrWriterClosed, w.Close(context.Background()))
+	s.Require().False(w.Write(m, int64Value(1), &testLabel{"a"}))
+
+	stats := w.Stats()
+	s.Require().Equal(uint64(s.opt.MaxPending+1), stats.Written)
+	s.Require().Equal(uint64(2), stats.Dropped)
+}
+
+func (s *WriterTestSuite) TestCloseTimeout() {
+	s.opt.BatchSize = 1
+	w := NewWriter(s.backend, s.opt)
+	m := &Metric{Type: "custom.googleapis.com/gauge",
+		MetricKind: "GAUGE", ValueType: "INT64"}
+
+	// Block the flusher in a flush triggered by the batch size.
+	w.flushLock.Lock()
+	s.Require().True(w.Write(m, int64Value(1), &testLabel{"a"}))
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	s.Require().Equal(context.DeadlineExceeded, w.Close(ctx))
+	w.flushLock.Unlock()
+}
+
+func (s *WriterTestSuite) TestCloseCancel() {
+	s.opt.BatchSize = 1
+	s.opt.RetryBackoff = time.Hour
+	w := NewWriter(s.backend, s.opt)
+	m := &Metric{Type: "custom.googleapis.com/gauge",
+		MetricKind: "GAUGE", ValueType: "INT64"}
+
+	// Block the flusher in the backoff of a failed flush.
+	s.backend.FailNext(errors.New("unavailable"))
+	s.Require().True(w.Write(m, int64Value(1), &testLabel{"a"}))
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	s.Require().Equal(context.DeadlineExceeded, w.Close(ctx))
+
+	// The background flush is canceled by Close.
+	stopped := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		s.Fail("background flush isn't canceled")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	suite.Run(t, new(WriterTestSuite))
+}
@@ -0,0 +1,404 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/jiarung/mochi/types"
+)
+
+// Ticker is a market update of a trading pair.
+type Ticker struct {
+	TradingPairID string
+	Price         decimal.Decimal
+	// Volume is the volume traded since the previous ticker.
+	Volume decimal.Decimal
+	Time   time.Time
+}
+
+// ConditionType defines the type of conditions.
+type ConditionType string
+
+// Condition types.
+const (
+	PriceCross    ConditionType = "price_cross"
+	PercentChange ConditionType = "percent_change"
+	MACDCross     ConditionType = "macd_cross"
+	EMACross      ConditionType = "ema_cross"
+	VolumeSpike   ConditionType = "volume_spike"
+)
+
+// Direction defines the direction of price movements.
+type Direction string
+
+// Directions. Empty direction matches both.
+const (
+	Up   Direction = "up"
+	Down Direction = "down"
+)
+
+func (d Direction) match(rising bool) bool {
+	return len(d) == 0 || (d == Up) == rising
+}
+
+// Signal is fired by a condition.
+type Signal struct {
+	Type     ConditionType
+	Price    decimal.Decimal
+	IsRising bool
+	// ChangeRate is the percentage of price change of PercentChange, or the
+	// ratio of volume to the average of VolumeSpike.
+	ChangeRate decimal.Decimal
+	// CrossType is the cross of MACDCross and EMACross.
+	CrossType types.CrossType
+	Period    time.Duration
+}
+
+// Condition is evaluated incrementally over tickers of a trading pair.
+// Conditions fire on edges, e.g. when price crosses a level, rather than
+// on every ticker the condition holds.
+type Condition interface {
+	Evaluate(t *Ticker) *Signal
+}
+
+// Spec defines a condition, which is stored with rules.
+type Spec struct {
+	Type ConditionType `json:"type"`
+	// Direction filters signals of PriceCross, PercentChange, MACDCross and
+	// EMACross. Up matches golden crosses.
+	Direction Direction `json:"direction,omitempty"`
+	// Price is the level of PriceCross.
+	Price decimal.Decimal `json:"price"`
+	// Percent and Window define PercentChange.
+	Percent decimal.Decimal `json:"percent"`
+	Window  time.Duration   `json:"window,omitempty"`
+	// Period is the candle period of MACDCross, EMACross and VolumeSpike.
+	Period time.Duration `json:"period,omitempty"`
+	// Fast, Slow and Signal are EMA periods in candles. MACDCross defaults
+	// to 12, 26 and 9.
+	Fast   int `json:"fast,omitempty"`
+	Slow   int `json:"slow,omitempty"`
+	Signal int `json:"signal,omitempty"`
+	// Lookback and Multiplier define VolumeSpike, which fires if the volume
+	// of a candle exceeds Multiplier times the average of Lookback candles.
+	Lookback   int             `json:"lookback,omitempty"`
+	Multiplier decimal.Decimal `json:"multiplier"`
+}
+
+// ErrInvalidSpec is returned if a spec is invalid.
+var ErrInvalidSpec = errors.New("invalid alert condition")
+
+// Limits of specs, which bound the tickers and candles kept by conditions.
+const (
+	MaxPercentChangeWindow = 24 * time.Hour
+	MaxVolumeLookback      = 500
+	// maxPercentChangeTickers bounds the tickers kept by percentChange. The
+	// oldest tickers are dropped beyond it, so the change of a busy pair is
+	// measured over a shorter window.
+	maxPercentChangeTickers = 10000
+)
+
+func invalidSpec(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSpec, fmt.Sprintf(format, args...))
+}
+
+// Build returns the condition of s.
+func (s *Spec) Build() (Condition, error) {
+	if len(s.Direction) > 0 && s.Direction != Up && s.Direction != Down {
+		return nil, invalidSpec("direction %q", s.Direction)
+	}
+	switch s.Type {
+	case PriceCross:
+		if !s.Price.IsPositive() {
+			return nil, invalidSpec("price %v", s.Price)
+		}
+		return &priceCross{spec: *s}, nil
+	case PercentChange:
+		if !s.Percent.IsPositive() || s.Window <= 0 ||
+			s.Window > MaxPercentChangeWindow {
+			return nil, invalidSpec("percent %v in %v", s.Percent, s.Window)
+		}
+		return &percentChange{spec: *s}, nil
+	case MACDCross:
+		spec := *s
+		if spec.Fast == 0 && spec.Slow == 0 && spec.Signal == 0 {
+			spec.Fast, spec.Slow, spec.Signal = 12, 26, 9
+		}
+		if spec.Period <= 0 || spec.Fast <= 0 || spec.Fast >= spec.Slow ||
+			spec.Signal <= 0 {
+			return nil, invalidSpec("macd %d/%d/%d of %v",
+				spec.Fast, spec.Slow, spec.Signal, spec.Period)
+		}
+		return &macdCross{
+			spec:    spec,
+			candles: candles{period: spec.Period},
+			fast:    newEMA(spec.Fast),
+			slow:    newEMA(spec.Slow),
+			signal:  newEMA(spec.Signal),
+		}, nil
+	case EMACross:
+		if s.Period <= 0 || s.Fast <= 0 || s.Fast >= s.Slow {
+			return nil, invalidSpec("ema %d/%d of %v", s.Fast, s.Slow, s.Period)
+		}
+		return &emaCross{
+			spec:    *s,
+			candles: candles{period: s.Period},
+			fast:    newEMA(s.Fast),
+			slow:    newEMA(s.Slow),
+		}, nil
+	case VolumeSpike:
+		if s.Period <= 0 || s.Lookback <= 0 || s.Lookback > MaxVolumeLookback ||
+			!s.Multiplier.IsPositive() {
+			return nil, invalidSpec("volume %vx of %d %v",
+				s.Multiplier, s.Lookback, s.Period)
+		}
+		return &volumeSpike{spec: *s, candles: candles{period: s.Period}}, nil
+	}
+	return nil, invalidSpec("type %q", s.Type)
+}
+
+// priceCross fires when price crosses a level.
+type priceCross struct {
+	spec Spec
+	prev *decimal.Decimal
+}
+
+func (c *priceCross) Evaluate(t *Ticker) *Signal {
+	prev := c.prev
+	price := t.Price
+	c.prev = &price
+	if prev == nil {
+		return nil
+	}
+
+	level := c.spec.Price
+	rising := prev.LessThan(level) && price.GreaterThanOrEqual(level)
+	falling := prev.GreaterThan(level) && price.LessThanOrEqual(level)
+	if (!rising && !falling) || !c.spec.Direction.match(rising) {
+		return nil
+	}
+	return &Signal{Type: PriceCross, Price: price, IsRising: rising}
+}
+
+// percentChange fires when price changes by percent within window.
+type percentChange struct {
+	spec      Spec
+	window    []*Ticker
+	triggered bool
+}
+
+func (c *percentChange) Evaluate(t *Ticker) *Signal {
+	c.window = append(c.window, t)
+	start := t.Time.Add(-c.spec.Window)
+	for len(c.window) > 1 && (c.window[0].Time.Before(start) ||
+		len(c.window) > maxPercentChangeTickers) {
+		c.window = c.window[1:]
+	}
+	base := c.window[0].Price
+	if base.IsZero() {
+		return nil
+	}
+
+	change := t.Price.Sub(base).Div(base).Mul(decimal.New(100, 0))
+	exceeded := change.Abs().GreaterThanOrEqual(c.spec.Percent)
+	rising := change.IsPositive()
+	fired := exceeded && !c.triggered && c.spec.Direction.match(rising)
+	c.triggered = exceeded
+	if !fired {
+		return nil
+	}
+	return &Signal{
+		Type:       PercentChange,
+		Price:      t.Price,
+		IsRising:   rising,
+		ChangeRate: change.Abs().Round(2),
+	}
+}
+
+// candles aggregates tickers into candles of period.
+type candles struct {
+	period time.Duration
+	start  time.Time
+	close  decimal.Decimal
+	volume decimal.Decimal
+}
+
+// add adds t, and returns the close price and volume of the previous candle
+// if t starts a new candle.
+func (c *candles) add(t *Ticker) (closed bool, price, volume decimal.Decimal) {
+	start := t.Time.Truncate(c.period)
+	if !c.start.IsZero() && start.After(c.start) {
+		closed, price, volume = true, c.close, c.volume
+		c.volume = decimal.Zero
+	}
+	if c.start.IsZero() || closed {
+		c.start = start
+	}
+	c.close = t.Price
+	c.volume = c.volume.Add(t.Volume)
+	return
+}
+
+// ema is an exponential moving average of n periods, seeded by the simple
+// average of the first n values.
+type ema struct {
+	n     int
+	count int
+	value float64
+}
+
+func newEMA(n int) *ema {
+	return &ema{n: n}
+}
+
+func (e *ema) add(v float64) {
+	e.count++
+	if e.count <= e.n {
+		e.value += (v - e.value) / float64(e.count)
+		return
+	}
+	k := 2 / float64(e.n+1)
+	e.value = v*k + e.value*(1-k)
+}
+
+func (e *ema) ready() bool {
+	return e.count >= e.n
+}
+
+// crossOf returns the cross of diff, which changes its sign from prev.
+func crossOf(prev, diff float64) (cross types.CrossType, ok bool) {
+	if prev <= 0 && diff > 0 {
+		return types.GoldenCross, true
+	}
+	if prev >= 0 && diff < 0 {
+		return types.DeathCross, true
+	}
+	return
+}
+
+// macdCross fires when MACD crosses its signal line on candle close.
+type macdCross struct {
+	spec    Spec
+	candles candles
+	fast    *ema
+	slow    *ema
+	signal  *ema
+	prev    *float64
+}
+
+func (c *macdCross) Evaluate(t *Ticker) *Signal {
+	closed, price, _ := c.candles.add(t)
+	if !closed {
+		return nil
+	}
+	v, _ := price.Float64()
+	c.fast.add(v)
+	c.slow.add(v)
+	if !c.slow.ready() {
+		return nil
+	}
+	c.signal.add(c.fast.value - c.slow.value)
+	if !c.signal.ready() {
+		return nil
+	}
+
+	hist := c.fast.value - c.slow.value - c.signal.value
+	prev := c.prev
+	c.prev = &hist
+	if prev == nil {
+		return nil
+	}
+	cross, ok := crossOf(*prev, hist)
+	if !ok || !c.spec.Direction.match(cross == types.GoldenCross) {
+		return nil
+	}
+	return &Signal{
+		Type:      MACDCross,
+		Price:     price,
+		IsRising:  cross == types.GoldenCross,
+		CrossType: cross,
+		Period:    c.spec.Period,
+	}
+}
+
+// emaCross fires when the fast EMA crosses the slow EMA on candle close.
+type emaCross struct {
+	spec    Spec
+	candles candles
+	fast    *ema
+	slow    *ema
+	prev    *float64
+}
+
+func (c *emaCross) Evaluate(t *Ticker) *Signal {
+	closed, price, _ := c.candles.add(t)
+	if !closed {
+		return nil
+	}
+	v, _ := price.Float64()
+	c.fast.add(v)
+	c.slow.add(v)
+	if !c.slow.ready() {
+		return nil
+	}
+
+	diff := c.fast.value - c.slow.value
+	prev := c.prev
+	c.prev = &diff
+	if prev == nil {
+		return nil
+	}
+	cross, ok := crossOf(*prev, diff)
+	if !ok || !c.spec.Direction.match(cross == types.GoldenCross) {
+		return nil
+	}
+	return &Signal{
+		Type:      EMACross,
+		Price:     price,
+		IsRising:  cross == types.GoldenCross,
+		CrossType: cross,
+		Period:    c.spec.Period,
+	}
+}
+
+// volumeSpike fires when the volume of a candle exceeds the average of
+// previous candles by multiplier.
+type volumeSpike struct {
+	spec    Spec
+	candles candles
+	volumes []decimal.Decimal
+}
+
+func (c *volumeSpike) Evaluate(t *Ticker) *Signal {
+	closed, price, volume := c.candles.add(t)
+	if !closed {
+		return nil
+	}
+	history := c.volumes
+	c.volumes = append(c.volumes, volume)
+	if len(c.volumes) > c.spec.Lookback {
+		c.volumes = c.volumes[1:]
+	}
+	if len(history) < c.spec.Lookback {
+		return nil
+	}
+
+	sum := decimal.Zero
+	for _, v := range history {
+		sum = sum.Add(v)
+	}
+	average := sum.Div(decimal.New(int64(len(history)), 0))
+	if !average.IsPositive() ||
+		volume.LessThan(average.Mul(c.spec.Multiplier)) {
+		return nil
+	}
+	return &Signal{
+		Type:       VolumeSpike,
+		Price:      price,
+		ChangeRate: volume.Div(average).Round(2),
+		Period:     c.spec.Period,
+	}
+}
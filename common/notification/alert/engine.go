@@ -0,0 +1,289 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	apierrors "github.com/jiarung/mochi/common/api/errors"
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+)
+
+// ErrTooManyRules is returned by Add if the user has too many rules. Its
+// message is the API error code.
+var ErrTooManyRules = errors.New(apierrors.PriceAlertTooMany)
+
+// ErrRuleNotFound is returned if a rule doesn't exist.
+var ErrRuleNotFound = errors.New("alert rule not found")
+
+var alertsTotal = metric.NewCounter("notification_alert", "alerts_total",
+	"Total number of market alerts by result.", "type", "result")
+
+// Rule is an alert condition of a user on a trading pair.
+type Rule struct {
+	ID            uuid.UUID `json:"id"`
+	UserID        uuid.UUID `json:"user_id"`
+	TradingPairID string    `json:"trading_pair_id"`
+	Spec          Spec      `json:"spec"`
+	// Cooldown suppresses the rule after it fires. Zero uses the cooldown
+	// of the engine.
+	Cooldown time.Duration `json:"cooldown,omitempty"`
+	// OneShot removes the rule after it fires.
+	OneShot bool `json:"one_shot,omitempty"`
+}
+
+// Alert is a signal fired by a rule.
+type Alert struct {
+	Signal
+	// ID is derived from the rule and the ticker time, so replaying the same
+	// tickers results in the same IDs.
+	ID            uuid.UUID
+	RuleID        uuid.UUID
+	UserID        uuid.UUID
+	TradingPairID string
+	Spec          Spec
+	Time          time.Time
+}
+
+// Handler handles alerts fired by the engine.
+type Handler interface {
+	Handle(ctx context.Context, alert *Alert) error
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(ctx context.Context, alert *Alert) error
+
+// Handle calls f.
+func (f HandlerFunc) Handle(ctx context.Context, alert *Alert) error {
+	return f(ctx, alert)
+}
+
+// Config defines the limits of the engine.
+type Config struct {
+	// MaxRulesPerUser caps the rules of a user. Zero means no limit.
+	MaxRulesPerUser int
+	// Cooldown is the default cooldown of rules.
+	Cooldown time.Duration
+	// DedupWindow drops alerts of the same user, trading pair, type and
+	// direction fired by different rules within the window.
+	DedupWindow time.Duration
+}
+
+// DefaultConfig returns the default config.
+func DefaultConfig() Config {
+	return Config{
+		MaxRulesPerUser: 20,
+		Cooldown:        time.Hour,
+		DedupWindow:     10 * time.Minute,
+	}
+}
+
+type ruleState struct {
+	rule      Rule
+	condition Condition
+	fired     time.Time
+}
+
+// Engine evaluates rules over a stream of tickers. Rules are kept in memory;
+// callers load stored rules by Add on start.
+type Engine struct {
+	cfg     Config
+	handler Handler
+	logger  logging.Logger
+
+	mutex  sync.Mutex
+	rules  map[uuid.UUID]*ruleState
+	pairs  map[string]map[uuid.UUID]*ruleState
+	users  map[uuid.UUID]int
+	recent map[string]time.Time
+	swept  time.Time
+}
+
+// NewEngine returns an engine sending alerts to handler.
+func NewEngine(cfg Config, handler Handler) *Engine {
+	return &Engine{
+		cfg:     cfg,
+		handler: handler,
+		logger:  logging.NewLoggerTag("notification:alert"),
+		rules:   make(map[uuid.UUID]*ruleState),
+		pairs:   make(map[string]map[uuid.UUID]*ruleState),
+		users:   make(map[uuid.UUID]int),
+		recent:  make(map[string]time.Time),
+	}
+}
+
+// Add adds rule, or replaces the rule of the same ID. A new ID is assigned
+// to rule if it's empty.
+func (e *Engine) Add(rule *Rule) error {
+	condition, err := rule.Spec.Build()
+	if err != nil {
+		return err
+	}
+	if len(rule.TradingPairID) == 0 {
+		return invalidSpec("empty trading pair")
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	old, replace := e.rules[rule.ID]
+	if replace && !uuid.Equal(old.rule.UserID, rule.UserID) {
+		return fmt.Errorf("rule<%s> belongs to another user", rule.ID)
+	}
+	if !replace && e.cfg.MaxRulesPerUser > 0 &&
+		e.users[rule.UserID] >= e.cfg.MaxRulesPerUser {
+		return ErrTooManyRules
+	}
+	if replace {
+		e.remove(old)
+	}
+	if uuid.Equal(rule.ID, uuid.Nil) {
+		rule.ID = uuid.NewV4()
+	}
+
+	state := &ruleState{rule: *rule, condition: condition}
+	e.rules[rule.ID] = state
+	pair := e.pairs[rule.TradingPairID]
+	if pair == nil {
+		pair = make(map[uuid.UUID]*ruleState)
+		e.pairs[rule.TradingPairID] = pair
+	}
+	pair[rule.ID] = state
+	e.users[rule.UserID]++
+	return nil
+}
+
+// Remove removes the rule of ruleID.
+func (e *Engine) Remove(ruleID uuid.UUID) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	state, ok := e.rules[ruleID]
+	if !ok {
+		return ErrRuleNotFound
+	}
+	e.remove(state)
+	return nil
+}
+
+func (e *Engine) remove(state *ruleState) {
+	rule := &state.rule
+	delete(e.rules, rule.ID)
+	delete(e.pairs[rule.TradingPairID], rule.ID)
+	if len(e.pairs[rule.TradingPairID]) == 0 {
+		delete(e.pairs, rule.TradingPairID)
+	}
+	e.users[rule.UserID]--
+	if e.users[rule.UserID] <= 0 {
+		delete(e.users, rule.UserID)
+	}
+}
+
+// Rules returns the rules of userID.
+func (e *Engine) Rules(userID uuid.UUID) []Rule {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	var rules []Rule
+	for _, state := range e.rules {
+		if uuid.Equal(state.rule.UserID, userID) {
+			rules = append(rules, state.rule)
+		}
+	}
+	return rules
+}
+
+// Update evaluates the rules of the trading pair of t, and returns the fired
+// alerts. Cooldowns and dedup are measured by ticker time, so tickers of a
+// trading pair must be in order.
+func (e *Engine) Update(t *Ticker) []*Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.sweep(t.Time)
+
+	var alerts []*Alert
+	for _, state := range e.pairs[t.TradingPairID] {
+		// Conditions are evaluated during cooldowns to keep their states.
+		signal := state.condition.Evaluate(t)
+		if signal == nil {
+			continue
+		}
+		rule := &state.rule
+		cooldown := rule.Cooldown
+		if cooldown == 0 {
+			cooldown = e.cfg.Cooldown
+		}
+		if !state.fired.IsZero() && t.Time.Sub(state.fired) < cooldown {
+			alertsTotal.WithLabelValues(string(signal.Type), "cooldown").Inc()
+			continue
+		}
+		state.fired = t.Time
+
+		key := fmt.Sprintf("%s:%s:%s:%v",
+			rule.UserID, t.TradingPairID, signal.Type, signal.IsRising)
+		if last, ok := e.recent[key]; ok &&
+			t.Time.Sub(last) < e.cfg.DedupWindow {
+			alertsTotal.WithLabelValues(string(signal.Type), "duplicate").Inc()
+			continue
+		}
+		e.recent[key] = t.Time
+
+		alerts = append(alerts, &Alert{
+			Signal: *signal,
+			ID: uuid.NewV5(rule.ID,
+				t.Time.UTC().Format(time.RFC3339Nano)),
+			RuleID:        rule.ID,
+			UserID:        rule.UserID,
+			TradingPairID: t.TradingPairID,
+			Spec:          rule.Spec,
+			Time:          t.Time,
+		})
+		if rule.OneShot {
+			e.remove(state)
+		}
+	}
+	return alerts
+}
+
+// sweep drops expired dedup entries once per window.
+func (e *Engine) sweep(now time.Time) {
+	if now.Sub(e.swept) < e.cfg.DedupWindow {
+		return
+	}
+	for key, last := range e.recent {
+		if now.Sub(last) >= e.cfg.DedupWindow {
+			delete(e.recent, key)
+		}
+	}
+	e.swept = now
+}
+
+// Process evaluates t and sends the fired alerts to the handler.
+func (e *Engine) Process(ctx context.Context, t *Ticker) {
+	for _, alert := range e.Update(t) {
+		result := "sent"
+		if err := e.handler.Handle(ctx, alert); err != nil {
+			e.logger.Error("handle alert<%s> of rule<%s> err: %v",
+				alert.ID, alert.RuleID, err)
+			result = "failed"
+		}
+		alertsTotal.WithLabelValues(string(alert.Type), result).Inc()
+	}
+}
+
+// Run processes tickers until ctx is done or tickers is closed.
+func (e *Engine) Run(ctx context.Context, tickers <-chan *Ticker) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t, ok := <-tickers:
+			if !ok {
+				return nil
+			}
+			e.Process(ctx, t)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/types"
+)
+
+const testPair = "ETH-USDT"
+
+type EngineTestSuite struct {
+	suite.Suite
+
+	engine *Engine
+	userID uuid.UUID
+	now    time.Time
+}
+
+func (s *EngineTestSuite) SetupTest() {
+	s.engine = NewEngine(Config{
+		MaxRulesPerUser: 3,
+		Cooldown:        time.Hour,
+		DedupWindow:     10 * time.Minute,
+	}, nil)
+	s.userID = uuid.NewV4()
+	s.now = time.Date(2018, time.June, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// tick updates the engine with price and volume after d.
+func (s *EngineTestSuite) tick(
+	d time.Duration, price, volume float64) []*Alert {
+	s.now = s.now.Add(d)
+	return s.engine.Update(&Ticker{
+		TradingPairID: testPair,
+		Price:         decimal.NewFromFloat(price),
+		Volume:        decimal.NewFromFloat(volume),
+		Time:          s.now,
+	})
+}
+
+func (s *EngineTestSuite) add(spec Spec) *Rule {
+	rule := &Rule{UserID: s.userID, TradingPairID: testPair, Spec: spec}
+	s.Require().NoError(s.engine.Add(rule))
+	return rule
+}
+
+func (s *EngineTestSuite) TestBuild() {
+	invalid := []Spec{
+		{Type: "unknown"},
+		{Type: PriceCross},
+		{Type: PriceCross, Price: decimal.New(1, 0), Direction: "sideways"},
+		{Type: PercentChange, Percent: decimal.New(5, 0)},
+		{Type: PercentChange, Percent: decimal.New(5, 0), Window: 48 * time.Hour},
+		{Type: MACDCross},
+		{Type: EMACross, Period: time.Hour, Fast: 26, Slow: 12},
+		{Type: VolumeSpike, Period: time.Hour, Lookback: 3},
+		{Type: VolumeSpike, Period: time.Hour, Lookback: MaxVolumeLookback + 1,
+			Multiplier: decimal.New(2, 0)},
+	}
+	for _, spec := range invalid {
+		_, err := spec.Build()
+		s.Require().Error(err, spec.Type)
+		s.Require().True(errors.Is(err, ErrInvalidSpec), err.Error())
+	}
+
+	condition, err := (&Spec{Type: MACDCross, Period: time.Hour}).Build()
+	s.Require().NoError(err)
+	macd := condition.(*macdCross)
+	s.Require().Equal(12, macd.spec.Fast)
+	s.Require().Equal(26, macd.spec.Slow)
+	s.Require().Equal(9, macd.spec.Signal)
+}
+
+func (s *EngineTestSuite) TestPriceCross() {
+	rule := s.add(Spec{Type: PriceCross, Price: decimal.New(100, 0)})
+
+	s.Require().Empty(s.tick(0, 101, 0))
+	alerts := s.tick(time.Minute, 99, 0)
+	s.Require().Len(alerts, 1)
+	s.Require().Equal(PriceCross, alerts[0].Type)
+	s.Require().False(alerts[0].IsRising)
+	s.Require().Equal(rule.ID, alerts[0].RuleID)
+	s.Require().Equal(s.userID, alerts[0].UserID)
+	s.Require().Equal(uuid.NewV5(rule.ID,
+		s.now.Format(time.RFC3339Nano)), alerts[0].ID)
+
+	// Staying below doesn't fire, and crossing again is in cooldown.
+	s.Require().Empty(s.tick(time.Minute, 98, 0))
+	s.Require().Empty(s.tick(time.Minute, 100, 0))
+	s.Require().Empty(s.tick(time.Minute, 99, 0))
+
+	alerts = s.tick(time.Hour, 101, 0)
+	s.Require().Len(alerts, 1)
+	s.Require().True(alerts[0].IsRising)
+}
+
+func (s *EngineTestSuite) TestPriceCrossDirection() {
+	s.add(Spec{Type: PriceCross, Price: decimal.New(100, 0), Direction: Up})
+
+	s.Require().Empty(s.tick(0, 101, 0))
+	s.Require().Empty(s.tick(time.Minute, 99, 0))
+	alerts := s.tick(time.Minute, 100, 0)
+	s.Require().Len(alerts, 1)
+	s.Require().True(alerts[0].IsRising)
+}
+
+func (s *EngineTestSuite) TestPercentChange() {
+	s.add(Spec{
+		Type:    PercentChange,
+		Percent: decimal.New(5, 0),
+		Window:  time.Hour,
+	})
+	s.engine.cfg.Cooldown = time.Minute
+
+	s.Require().Empty(s.tick(0, 100, 0))
+	s.Require().Empty(s.tick(10*time.Minute, 103, 0))
+	alerts := s.tick(10*time.Minute, 106, 0)
+	s.Require().Len(alerts, 1)
+	s.Require().True(alerts[0].IsRising)
+	s.Require().Equal("6", alerts[0].ChangeRate.String())
+
+	// Still exceeding doesn't fire until it goes back under the threshold.
+	s.Require().Empty(s.tick(10*time.Minute, 107, 0))
+	s.Require().Empty(s.tick(10*time.Minute, 101, 0))
+
+	// The window slides past 103, and the drop from 106 fires.
+	alerts = s.tick(35*time.Minute, 100, 0)
+	s.Require().Len(alerts, 1)
+	s.Require().False(alerts[0].IsRising)
+	s.Require().Equal("5.66", alerts[0].ChangeRate.String())
+}
+
+func (s *EngineTestSuite) TestPercentChangeBound() {
+	condition, err := (&Spec{
+		Type:    PercentChange,
+		Percent: decimal.New(5, 0),
+		Window:  time.Hour,
+	}).Build()
+	s.Require().NoError(err)
+	c := condition.(*percentChange)
+	now := time.Now()
+	for i := 0; i < maxPercentChangeTickers+10; i++ {
+		c.Evaluate(&Ticker{
+			Price: decimal.New(100, 0),
+			Time:  now.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+	s.Require().Len(c.window, maxPercentChangeTickers)
+}
+
+func (s *EngineTestSuite) TestMACDCross() {
+	s.add(Spec{Type: MACDCross, Period: time.Hour,
+		Fast: 3, Slow: 6, Signal: 3})
+
+	var alerts []*Alert
+	price := 100.0
+	for i := 0; i < 20; i++ {
+		price--
+		alerts = append(alerts, s.tick(time.Hour, price, 0)...)
+	}
+	s.Require().Empty(alerts)
+	for i := 0; i < 10; i++ {
+		price += 3
+		alerts = append(alerts, s.tick(time.Hour, price, 0)...)
+	}
+	s.Require().Len(alerts, 1)
+	s.Require().Equal(MACDCross, alerts[0].Type)
+	s.Require().Equal(types.GoldenCross, alerts[0].CrossType)
+	s.Require().Equal(time.Hour, alerts[0].Period)
+}
+
+func (s *EngineTestSuite) TestEMACross() {
+	s.add(Spec{Type: EMACross, Period: time.Hour, Fast: 2, Slow: 4,
+		Direction: Down})
+
+	var alerts []*Alert
+	price := 100.0
+	for i := 0; i < 10; i++ {
+		price++
+		alerts = append(alerts, s.tick(time.Hour, price, 0)...)
+	}
+	for i := 0; i < 10; i++ {
+		price -= 2
+		alerts = append(alerts, s.tick(time.Hour, price, 0)...)
+	}
+	s.Require().Len(alerts, 1)
+	s.Require().Equal(types.DeathCross, alerts[0].CrossType)
+	s.Require().False(alerts[0].IsRising)
+}
+
+func (s *EngineTestSuite) TestVolumeSpike() {
+	s.add(Spec{Type: VolumeSpike, Period: time.Hour, Lookback: 3,
+		Multiplier: decimal.New(3, 0)})
+
+	// Candles of 10, 10 and 10 by two tickers each.
+	for i := 0; i < 3; i++ {
+		s.Require().Empty(s.tick(time.Hour, 100, 5))
+		s.Require().Empty(s.tick(time.Minute, 100, 5))
+		s.now = s.now.Add(-time.Minute)
+	}
+	s.Require().Empty(s.tick(time.Hour, 100, 40))
+	alerts := s.tick(time.Hour, 100, 1)
+	s.Require().Len(alerts, 1)
+	s.Require().Equal(VolumeSpike, alerts[0].Type)
+	s.Require().Equal("4", alerts[0].ChangeRate.String())
+}
+
+func (s *EngineTestSuite) TestDedup() {
+	s.add(Spec{Type: PriceCross, Price: decimal.New(100, 0)})
+	s.add(Spec{Type: PriceCross, Price: decimal.New(101, 0)})
+
+	s.Require().Empty(s.tick(0, 99, 0))
+	s.Require().Len(s.tick(time.Minute, 102, 0), 1)
+
+	other := &Rule{UserID: uuid.NewV4(), TradingPairID: testPair,
+		Spec: Spec{Type: PriceCross, Price: decimal.New(103, 0)}}
+	s.Require().NoError(s.engine.Add(other))
+	s.Require().Empty(s.tick(time.Minute, 102, 0))
+	s.Require().Len(s.tick(time.Minute, 104, 0), 1)
+}
+
+func (s *EngineTestSuite) TestRules() {
+	for i := 0; i < 3; i++ {
+		s.add(Spec{Type: PriceCross, Price: decimal.New(int64(100+i), 0)})
+	}
+	rule := &Rule{UserID: s.userID, TradingPairID: testPair,
+		Spec: Spec{Type: PriceCross, Price: decimal.New(1, 0)}}
+	s.Require().Equal(ErrTooManyRules, s.engine.Add(rule))
+	s.Require().Equal("price_alert_too_many", ErrTooManyRules.Error())
+	s.Require().Len(s.engine.Rules(s.userID), 3)
+
+	// Replacing a rule doesn't count.
+	rules := s.engine.Rules(s.userID)
+	rules[0].Spec.Price = decimal.New(200, 0)
+	s.Require().NoError(s.engine.Add(&rules[0]))
+	s.Require().Len(s.engine.Rules(s.userID), 3)
+
+	s.Require().NoError(s.engine.Remove(rules[0].ID))
+	s.Require().Equal(ErrRuleNotFound, s.engine.Remove(rules[0].ID))
+	s.Require().NoError(s.engine.Add(rule))
+	s.Require().Len(s.engine.Rules(s.userID), 3)
+
+	stolen := rules[1]
+	stolen.UserID = uuid.NewV4()
+	s.Require().Error(s.engine.Add(&stolen))
+}
+
+func (s *EngineTestSuite) TestOneShot() {
+	rule := &Rule{UserID: s.userID, TradingPairID: testPair, OneShot: true,
+		Spec: Spec{Type: PriceCross, Price: decimal.New(100, 0)}}
+	s.Require().NoError(s.engine.Add(rule))
+
+	s.Require().Empty(s.tick(0, 99, 0))
+	s.Require().Len(s.tick(time.Minute, 100, 0), 1)
+	s.Require().Empty(s.engine.Rules(s.userID))
+	s.Require().Empty(s.engine.pairs)
+	s.Require().Empty(s.engine.users)
+}
+
+func (s *EngineTestSuite) TestRun() {
+	var handled []*Alert
+	s.engine.handler = HandlerFunc(func(ctx context.Context, a *Alert) error {
+		handled = append(handled, a)
+		return errors.New("failed")
+	})
+	s.add(Spec{Type: PriceCross, Price: decimal.New(100, 0)})
+
+	tickers := make(chan *Ticker, 2)
+	tickers <- &Ticker{TradingPairID: testPair, Price: decimal.New(99, 0),
+		Time: s.now}
+	tickers <- &Ticker{TradingPairID: testPair, Price: decimal.New(100, 0),
+		Time: s.now.Add(time.Second)}
+	close(tickers)
+	s.Require().NoError(s.engine.Run(context.Background(), tickers))
+	s.Require().Len(handled, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Require().Equal(context.Canceled,
+		s.engine.Run(ctx, make(chan *Ticker)))
+}
+
+func TestEngine(t *testing.T) {
+	suite.Run(t, new(EngineTestSuite))
+}
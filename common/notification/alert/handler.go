@@ -0,0 +1,104 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/common/notification/preference"
+	"github.com/jiarung/mochi/types"
+)
+
+// SendFunc sends the push notification generated for alert.
+type SendFunc func(ctx context.Context, alert *Alert,
+	req *notification.AppRequest) error
+
+// AppHandler returns the handler generating push notifications of alerts by
+// app and sending them by send. If send is nil, notifications are delivered
+// by the default preference center as CategoryPriceAlert, so opt-outs and
+// quiet hours apply.
+func AppHandler(app *notification.AppStruct, send SendFunc) Handler {
+	if send == nil {
+		send = notifyPreference
+	}
+	return HandlerFunc(func(ctx context.Context, alert *Alert) error {
+		req, err := Generate(app, alert)
+		if err != nil {
+			return err
+		}
+		return send(ctx, alert, req)
+	})
+}
+
+func notifyPreference(ctx context.Context, alert *Alert,
+	req *notification.AppRequest) error {
+	center := preference.Default()
+	if center == nil {
+		return preference.ErrNoCenter
+	}
+	_, err := center.Notify(ctx, alert.UserID, preference.CategoryPriceAlert,
+		&preference.Payload{
+			ID:   fmt.Sprintf("alert:%s", alert.ID),
+			Push: req,
+		})
+	return err
+}
+
+// Generate generates the push notification of alert by the generator of its
+// condition type.
+func Generate(app *notification.AppStruct, alert *Alert) (
+	*notification.AppRequest, error) {
+	userID := alert.UserID.String()
+	eventID := alert.ID.String()
+	switch alert.Type {
+	case PriceCross:
+		return app.GenPriceAlert(alert.UserID, alert.TradingPairID, alert.Price)
+	case PercentChange:
+		return app.GenViolentPriceChangesAlert(
+			notification.ViolentPriceMovementInfo{
+				Pair:       alert.TradingPairID,
+				UserID:     userID,
+				IsRising:   alert.IsRising,
+				ChangeRate: alert.ChangeRate.String(),
+				EventID:    eventID,
+			})
+	case MACDCross:
+		return app.GenMACDCrossAlert(notification.MACDCrossInfo{
+			Pair:       alert.TradingPairID,
+			UserID:     userID,
+			CrossType:  alert.CrossType,
+			TimePeriod: timeframe(alert.Period),
+			EventID:    eventID,
+		})
+	case EMACross:
+		return app.GenEMACrossAlert(notification.EMACrossInfo{
+			Pair:      alert.TradingPairID,
+			UserID:    userID,
+			CrossType: alert.CrossType,
+			Fast:      alert.Spec.Fast,
+			Slow:      alert.Spec.Slow,
+			EventID:   eventID,
+		})
+	case VolumeSpike:
+		return app.GenVolumeSpikeAlert(notification.VolumeSpikeInfo{
+			Pair:    alert.TradingPairID,
+			UserID:  userID,
+			Ratio:   alert.ChangeRate.String(),
+			EventID: eventID,
+		})
+	}
+	return nil, fmt.Errorf("unknown alert type %q", alert.Type)
+}
+
+// timeframe returns the timeframe of period, which is empty if period isn't
+// daily or hourly.
+func timeframe(period time.Duration) (tf types.Timeframe) {
+	switch period {
+	case 24 * time.Hour:
+		tf = types.OneDay
+	case time.Hour:
+		tf = types.OneHour
+	}
+	return
+}
@@ -0,0 +1,284 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/notification"
+)
+
+type recordSink struct {
+	mutex    sync.Mutex
+	maxBatch int
+	batches  [][]*Event
+}
+
+func (s *recordSink) Name() string {
+	return "record"
+}
+
+func (s *recordSink) MaxBatch() int {
+	return s.maxBatch
+}
+
+func (s *recordSink) Send(ctx context.Context, events []*Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *recordSink) sizes() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var sizes []int
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+type AnalyticsTestSuite struct {
+	suite.Suite
+
+	userID uuid.UUID
+	server *httptest.Server
+	header http.Header
+	bodies []string
+}
+
+func (s *AnalyticsTestSuite) SetupTest() {
+	s.userID = uuid.NewV4()
+	s.header = nil
+	s.bodies = nil
+	s.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			s.header = r.Header
+			s.bodies = append(s.bodies, string(body))
+			w.Write([]byte(`{"id":"1"}`))
+		}))
+}
+
+func (s *AnalyticsTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *AnalyticsTestSuite) event(name EventName) *Event {
+	e := NewEvent(name, s.userID)
+	e.Time = time.Unix(1528000000, 0)
+	return e
+}
+
+func (s *AnalyticsTestSuite) TestHash() {
+	s.Require().Equal(HashEmail("foo@example.com"),
+		HashEmail(" Foo@Example.COM "))
+	s.Require().Len(HashEmail("foo@example.com"), 64)
+	s.Require().Equal(HashPhone("886912345678"),
+		HashPhone("+886 912-345-678"))
+	s.Require().Empty(HashEmail(" "))
+
+	e := s.event(SignUp).SetEmail("Foo@example.com").SetPhone("+886912345678")
+	data, err := json.Marshal(e)
+	s.Require().NoError(err)
+	s.Require().NotContains(string(data), "example.com")
+	s.Require().NotContains(string(data), "912345678")
+}
+
+func (s *AnalyticsTestSuite) TestDispatcher() {
+	sink := &recordSink{maxBatch: 2}
+	d := NewDispatcher(&Option{
+		QueueSize:     10,
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		SendTimeout:   time.Second,
+	}, nil)
+	s.Require().NoError(d.Register(sink))
+	s.Require().NoError(d.Start())
+	s.Require().Equal(ErrDispatcherState, d.Register(&recordSink{}))
+
+	for i := 0; i < 5; i++ {
+		s.Require().NoError(d.Track(s.event(SignUp)))
+	}
+	s.Require().Eventually(func() bool {
+		return len(sink.sizes()) == 2
+	}, time.Second, 10*time.Millisecond)
+	s.Require().NoError(d.Close(context.Background()))
+	s.Require().Equal([]int{2, 2, 1}, sink.sizes())
+	s.Require().Equal(ErrDispatcherState, d.Track(s.event(SignUp)))
+}
+
+func (s *AnalyticsTestSuite) TestFlushInterval() {
+	sink := &recordSink{}
+	d := NewDispatcher(&Option{
+		QueueSize:     10,
+		BatchSize:     10,
+		FlushInterval: 10 * time.Millisecond,
+		SendTimeout:   time.Second,
+	}, nil)
+	s.Require().NoError(d.Register(sink))
+	s.Require().NoError(d.Start())
+	defer d.Close(context.Background())
+
+	s.Require().NoError(d.Track(s.event(SignUp)))
+	s.Require().Eventually(func() bool {
+		return len(sink.sizes()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Zero intervals are defaulted.
+	d = NewDispatcher(&Option{QueueSize: 10, BatchSize: 10}, nil)
+	s.Require().NoError(d.Start())
+	s.Require().NoError(d.Close(context.Background()))
+}
+
+func (s *AnalyticsTestSuite) TestConsent() {
+	sink := &recordSink{}
+	denied := uuid.NewV4()
+	d := NewDispatcher(&Option{
+		QueueSize:     1,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		SendTimeout:   time.Second,
+	}, ConsentFunc(func(userID uuid.UUID) (bool, error) {
+		if uuid.Equal(userID, uuid.Nil) {
+			return false, errors.New("unknown user")
+		}
+		return !uuid.Equal(userID, denied), nil
+	}))
+	s.Require().NoError(d.Register(sink))
+
+	s.Require().NoError(d.Track(NewEvent(SignUp, denied)))
+	s.Require().Error(d.Track(NewEvent(SignUp, uuid.Nil)))
+	s.Require().NoError(d.Track(s.event(SignUp)))
+	// The queue is full before start.
+	s.Require().NoError(d.Track(s.event(SignUp)))
+
+	s.Require().NoError(d.Start())
+	s.Require().NoError(d.Close(context.Background()))
+	s.Require().Equal([]int{1}, sink.sizes())
+	s.Require().Equal(s.userID, sink.batches[0][0].UserID)
+}
+
+func (s *AnalyticsTestSuite) TestFBSink() {
+	defer func(endpoint string) { fbEndpoint = endpoint }(fbEndpoint)
+	fbEndpoint = s.server.URL + "/%s/events"
+
+	sink := NewFBSink(notification.FBConfig{
+		OfflineEventSetID: "set",
+		AccessToken:       "token",
+	})
+	deposited := s.event(Deposited)
+	deposited.Value = decimal.New(15, 7)
+	events := []*Event{
+		s.event(SignUp).SetEmail("foo@example.com"),
+		deposited,
+	}
+	s.Require().NoError(sink.Send(context.Background(), events))
+
+	form, err := url.ParseQuery(s.bodies[0])
+	s.Require().NoError(err)
+	s.Require().Equal("token", form.Get("access_token"))
+	s.Require().Equal("from_server", form.Get("upload_tag"))
+	s.Require().JSONEq(`[{
+		"event_time": 1528000000,
+		"event_name": "CompleteRegistration",
+		"match_keys": {
+			"extern_id": "`+s.userID.String()+`",
+			"email": ["`+HashEmail("foo@example.com")+`"]
+		}
+	}, {
+		"event_time": 1528000000,
+		"event_name": "Other",
+		"match_keys": {"extern_id": "`+s.userID.String()+`"},
+		"custom_data": {"custom_event_name": "Deposited", "value": 150000000}
+	}]`, form.Get("data"))
+}
+
+func (s *AnalyticsTestSuite) TestGASink() {
+	defer func(endpoint string) { gaBatchEndpoint = endpoint }(gaBatchEndpoint)
+	gaBatchEndpoint = s.server.URL
+
+	completed := s.event(OrderCompleted)
+	completed.Value = decimal.New(100, 0)
+	sink := NewGASink(notification.GAConfig{TrackingID: "UA-1"})
+	s.Require().NoError(sink.Send(context.Background(),
+		[]*Event{s.event(SignUp), completed}))
+
+	lines := strings.Split(strings.TrimSpace(s.bodies[0]), "\n")
+	s.Require().Len(lines, 2)
+	hit, err := url.ParseQuery(lines[0])
+	s.Require().NoError(err)
+	s.Require().Equal("UA-1", hit.Get("tid"))
+	s.Require().Equal(s.userID.String(), hit.Get("uid"))
+	s.Require().Equal("Account", hit.Get("ec"))
+	s.Require().Equal("SignUp", hit.Get("ea"))
+	s.Require().Empty(hit.Get("ev"))
+	hit, err = url.ParseQuery(lines[1])
+	s.Require().NoError(err)
+	s.Require().Equal("Order", hit.Get("ec"))
+	s.Require().Equal("Completed", hit.Get("ea"))
+	s.Require().Equal("100", hit.Get("ev"))
+
+	// Queue times are the milliseconds since events.
+	event := s.event(SignUp)
+	hit = sink.hit(event, event.Time.Add(1500*time.Millisecond))
+	s.Require().Equal("1500", hit.Get("qt"))
+	hit = sink.hit(event, event.Time.Add(-time.Second))
+	s.Require().Equal("0", hit.Get("qt"))
+}
+
+func (s *AnalyticsTestSuite) TestHTTPSink() {
+	sink := NewHTTPSink(s.server.URL,
+		http.Header{"Authorization": {"Bearer token"}})
+	event := s.event(SignUp)
+	s.Require().NoError(sink.Send(context.Background(), []*Event{event}))
+	s.Require().Equal("Bearer token", s.header.Get("Authorization"))
+
+	body := map[string][]*Event{}
+	s.Require().NoError(json.Unmarshal([]byte(s.bodies[0]), &body))
+	s.Require().Len(body["events"], 1)
+	s.Require().Equal(event.ID, body["events"][0].ID)
+}
+
+func (s *AnalyticsTestSuite) TestFileSink() {
+	dir, err := ioutil.TempDir("", "analytics")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	sink := NewFileSink(filepath.Join(dir, "events", "events.jsonl"))
+	events := []*Event{s.event(SignUp), s.event(Deposited)}
+	s.Require().NoError(sink.Send(context.Background(), events))
+	s.Require().NoError(sink.Send(context.Background(), events[:1]))
+
+	f, err := os.Open(filepath.Join(dir, "events", "events.jsonl"))
+	s.Require().NoError(err)
+	defer f.Close()
+	var names []EventName
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &Event{}
+		s.Require().NoError(json.Unmarshal(scanner.Bytes(), e))
+		names = append(names, e.Name)
+	}
+	s.Require().Equal([]EventName{SignUp, Deposited, SignUp}, names)
+}
+
+func TestAnalytics(t *testing.T) {
+	suite.Run(t, new(AnalyticsTestSuite))
+}
@@ -0,0 +1,262 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+)
+
+// Errors of the dispatcher.
+var (
+	ErrNoDispatcher    = errors.New("analytics dispatcher isn't set")
+	ErrDispatcherState = errors.New("invalid analytics dispatcher state")
+)
+
+var eventsTotal = metric.NewCounter("notification_analytics", "events_total",
+	"Total number of analytics events by result.", "sink", "result")
+
+// Sink sends batches of events to an analytics service.
+type Sink interface {
+	Name() string
+	// MaxBatch returns the maximum number of events per Send.
+	MaxBatch() int
+	Send(ctx context.Context, events []*Event) error
+}
+
+// Consent reports if a user consented to analytics tracking.
+type Consent interface {
+	Consented(userID uuid.UUID) (bool, error)
+}
+
+// ConsentFunc adapts a function to Consent.
+type ConsentFunc func(userID uuid.UUID) (bool, error)
+
+// Consented calls f.
+func (f ConsentFunc) Consented(userID uuid.UUID) (bool, error) {
+	return f(userID)
+}
+
+// Option defines the options of Dispatcher.
+type Option struct {
+	// QueueSize is the number of buffered events per sink. Events are
+	// dropped if the queue of a sink is full.
+	QueueSize int
+	// BatchSize is the number of events to flush a batch, which is capped by
+	// the max batch of sinks.
+	BatchSize int
+	// FlushInterval is the interval to flush partial batches. The default
+	// interval is used if it's not positive.
+	FlushInterval time.Duration
+	// SendTimeout is the timeout of a batch.
+	SendTimeout time.Duration
+}
+
+// DefaultOption returns the default option.
+func DefaultOption() *Option {
+	return &Option{
+		QueueSize:     10000,
+		BatchSize:     50,
+		FlushInterval: 5 * time.Second,
+		SendTimeout:   30 * time.Second,
+	}
+}
+
+type sinkQueue struct {
+	sink   Sink
+	events chan *Event
+}
+
+// Dispatcher fans out events to sinks in batches. Sending is best effort;
+// failed batches are logged and dropped.
+type Dispatcher struct {
+	opt     Option
+	consent Consent
+	logger  logging.Logger
+
+	mutex   sync.RWMutex
+	sinks   []*sinkQueue
+	started bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher. Events of all users are tracked if
+// consent is nil.
+func NewDispatcher(opt *Option, consent Consent) *Dispatcher {
+	if opt == nil {
+		opt = DefaultOption()
+	}
+	if opt.FlushInterval <= 0 {
+		o := *opt
+		o.FlushInterval = DefaultOption().FlushInterval
+		opt = &o
+	}
+	return &Dispatcher{
+		opt:     *opt,
+		consent: consent,
+		logger:  logging.NewLoggerTag("notification:analytics"),
+	}
+}
+
+// Register adds sink. It must be called before Start.
+func (d *Dispatcher) Register(sink Sink) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.started {
+		return ErrDispatcherState
+	}
+	d.sinks = append(d.sinks, &sinkQueue{
+		sink:   sink,
+		events: make(chan *Event, d.opt.QueueSize),
+	})
+	return nil
+}
+
+// Start starts a worker per sink.
+func (d *Dispatcher) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.started || d.closed {
+		return ErrDispatcherState
+	}
+	d.started = true
+	for _, q := range d.sinks {
+		d.wg.Add(1)
+		go d.work(q)
+	}
+	return nil
+}
+
+// Close flushes queued events and stops workers, or returns the error of ctx
+// if it's done first.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return ErrDispatcherState
+	}
+	d.closed = true
+	for _, q := range d.sinks {
+		close(q.events)
+	}
+	d.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Track queues event to all sinks if its user consented.
+func (d *Dispatcher) Track(event *Event) error {
+	if d.consent != nil {
+		consented, err := d.consent.Consented(event.UserID)
+		if err != nil {
+			return err
+		}
+		if !consented {
+			eventsTotal.WithLabelValues("", "no_consent").Inc()
+			return nil
+		}
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.closed {
+		return ErrDispatcherState
+	}
+	for _, q := range d.sinks {
+		select {
+		case q.events <- event:
+		default:
+			eventsTotal.WithLabelValues(q.sink.Name(), "dropped").Inc()
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) work(q *sinkQueue) {
+	defer d.wg.Done()
+	size := d.opt.BatchSize
+	if max := q.sink.MaxBatch(); max > 0 && max < size {
+		size = max
+	}
+	ticker := time.NewTicker(d.opt.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, size)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		d.send(q.sink, batch)
+		batch = make([]*Event, 0, size)
+	}
+	for {
+		select {
+		case event, ok := <-q.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (d *Dispatcher) send(sink Sink, batch []*Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.opt.SendTimeout)
+	defer cancel()
+	if err := sink.Send(ctx, batch); err != nil {
+		d.logger.Error("send %d events to %s err: %v", len(batch), sink.Name(), err)
+		eventsTotal.WithLabelValues(sink.Name(), "failed").Add(float64(len(batch)))
+		return
+	}
+	eventsTotal.WithLabelValues(sink.Name(), "sent").Add(float64(len(batch)))
+}
+
+var (
+	defaultMutex      sync.Mutex
+	defaultDispatcher *Dispatcher
+)
+
+// Default returns the dispatcher set by SetDefault, or nil if it's not set.
+func Default() *Dispatcher {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	return defaultDispatcher
+}
+
+// SetDefault sets the dispatcher used by Track.
+func SetDefault(d *Dispatcher) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultDispatcher = d
+}
+
+// Track queues event by the default dispatcher.
+func Track(event *Event) error {
+	d := Default()
+	if d == nil {
+		return ErrNoDispatcher
+	}
+	return d.Track(event)
+}
@@ -0,0 +1,112 @@
+package analytics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/jiarung/mochi/common/notification"
+	models "github.com/jiarung/mochi/models/exchange"
+)
+
+// EventName defines the name of analytics events.
+type EventName string
+
+// Event names.
+const (
+	SignUp         EventName = "SignUp"
+	Deposited      EventName = "Deposited"
+	OrderCompleted EventName = "OrderCompleted"
+)
+
+// Event is a typed analytics event, which sinks translate into their own
+// formats.
+type Event struct {
+	ID     uuid.UUID `json:"id"`
+	Name   EventName `json:"name"`
+	UserID uuid.UUID `json:"user_id"`
+	Time   time.Time `json:"time"`
+	// Value is the value of the event in satoshi.
+	Value decimal.Decimal `json:"value"`
+	// Properties are extra attributes sent by sinks supporting them.
+	Properties map[string]string `json:"properties,omitempty"`
+	// EmailHash and PhoneHash are set by SetEmail and SetPhone, so raw PII
+	// never reaches sinks.
+	EmailHash string `json:"email_hash,omitempty"`
+	PhoneHash string `json:"phone_hash,omitempty"`
+}
+
+// NewEvent creates an event of userID happened now.
+func NewEvent(name EventName, userID uuid.UUID) *Event {
+	return &Event{
+		ID:     uuid.NewV4(),
+		Name:   name,
+		UserID: userID,
+		Time:   time.Now(),
+	}
+}
+
+// NewSignUp creates the event of a registration.
+func NewSignUp(registration *models.Registration) *Event {
+	return NewEvent(SignUp, registration.UserID)
+}
+
+// NewDeposited creates the event of a deposit.
+func NewDeposited(deposit *models.Deposit) *Event {
+	e := NewEvent(Deposited, deposit.UserID)
+	e.Value = notification.BTCToSatoshi(deposit.BTCValue)
+	return e
+}
+
+// NewOrderCompleted creates the event of a completed order.
+func NewOrderCompleted(order *models.Order) (*Event, error) {
+	satoshi, err := notification.OrderSatoshi(order)
+	if err != nil {
+		return nil, err
+	}
+	e := NewEvent(OrderCompleted, order.UserID)
+	e.Value = satoshi
+	e.Properties = map[string]string{"trading_pair_id": order.TradingPairID}
+	return e, nil
+}
+
+// SetEmail sets the hash of email.
+func (e *Event) SetEmail(email string) *Event {
+	e.EmailHash = HashEmail(email)
+	return e
+}
+
+// SetPhone sets the hash of phone, which includes the country code.
+func (e *Event) SetPhone(phone string) *Event {
+	e.PhoneHash = HashPhone(phone)
+	return e
+}
+
+func hash(s string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashEmail returns the hex SHA-256 of the trimmed and lowercased email,
+// which is the format of facebook match keys.
+func HashEmail(email string) string {
+	return hash(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// HashPhone returns the hex SHA-256 of the digits of phone.
+func HashPhone(phone string) string {
+	return hash(strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone))
+}
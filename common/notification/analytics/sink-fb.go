@@ -0,0 +1,115 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/jiarung/mochi/common/notification"
+)
+
+// fbEndpoint is the offline conversion endpoint, which is a variable for
+// tests.
+var fbEndpoint = "https://graph.facebook.com/v3.1/%s/events"
+
+// fbEventNames maps events to the predefined event names of facebook.
+// Others are sent as "Other" with custom_event_name.
+var fbEventNames = map[EventName]string{
+	SignUp: "CompleteRegistration",
+}
+
+// FBSink sends events to facebook offline conversions.
+type FBSink struct {
+	cfg    notification.FBConfig
+	client *http.Client
+}
+
+// NewFBSink returns a facebook sink.
+func NewFBSink(cfg notification.FBConfig) *FBSink {
+	return &FBSink{cfg: cfg, client: &http.Client{}}
+}
+
+// Name returns the name of the sink.
+func (s *FBSink) Name() string {
+	return "fb"
+}
+
+// MaxBatch returns the max number of events per upload.
+func (s *FBSink) MaxBatch() int {
+	return 2000
+}
+
+func fbEvent(e *Event) map[string]interface{} {
+	matchKeys := map[string]interface{}{"extern_id": e.UserID.String()}
+	if len(e.EmailHash) > 0 {
+		matchKeys["email"] = []string{e.EmailHash}
+	}
+	if len(e.PhoneHash) > 0 {
+		matchKeys["phone"] = []string{e.PhoneHash}
+	}
+	data := map[string]interface{}{
+		"event_time": e.Time.Unix(),
+		"match_keys": matchKeys,
+	}
+	if name, ok := fbEventNames[e.Name]; ok {
+		data["event_name"] = name
+		return data
+	}
+
+	// The value is set in custom_data, since facebook requires ISO 4217
+	// currency with value at top level.
+	data["event_name"] = "Other"
+	value, _ := e.Value.Float64()
+	customData := map[string]interface{}{
+		"custom_event_name": string(e.Name),
+		"value":             value,
+	}
+	for k, v := range e.Properties {
+		customData[k] = v
+	}
+	data["custom_data"] = customData
+	return data
+}
+
+// Send uploads events.
+func (s *FBSink) Send(ctx context.Context, events []*Event) error {
+	data := make([]map[string]interface{}, 0, len(events))
+	for _, e := range events {
+		data = append(data, fbEvent(e))
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("upload_tag", "from_server")
+	v.Set("access_token", s.cfg.AccessToken)
+	v.Set("data", string(b))
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf(fbEndpoint, s.cfg.OfflineEventSetID),
+		bytes.NewBufferString(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("parse response %q: %v", body, err)
+	}
+	if result["error"] != nil {
+		return fmt.Errorf("upload err: %s", body)
+	}
+	return nil
+}
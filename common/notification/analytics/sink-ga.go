@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jiarung/mochi/common/notification"
+)
+
+// gaBatchEndpoint is the batch endpoint of the measurement protocol, which is
+// a variable for tests.
+var gaBatchEndpoint = "https://www.google-analytics.com/batch"
+
+// gaCategories maps events to GA event categories and actions.
+var gaCategories = map[EventName][2]string{
+	SignUp:         {"Account", "SignUp"},
+	Deposited:      {"Balance", "Deposited"},
+	OrderCompleted: {"Order", "Completed"},
+}
+
+// GASink sends events to google analytics by the measurement protocol.
+// Reference: https://developers.google.com/analytics/devguides/collection/protocol/v1/reference
+type GASink struct {
+	cfg    notification.GAConfig
+	client *http.Client
+}
+
+// NewGASink returns a google analytics sink.
+func NewGASink(cfg notification.GAConfig) *GASink {
+	return &GASink{cfg: cfg, client: &http.Client{}}
+}
+
+// Name returns the name of the sink.
+func (s *GASink) Name() string {
+	return "ga"
+}
+
+// MaxBatch returns the max number of hits per batch request.
+func (s *GASink) MaxBatch() int {
+	return 20
+}
+
+// hit returns the hit of e sent at now, whose queue time is the time since e.
+func (s *GASink) hit(e *Event, now time.Time) url.Values {
+	category, action := "Other", string(e.Name)
+	if names, ok := gaCategories[e.Name]; ok {
+		category, action = names[0], names[1]
+	}
+	v := url.Values{}
+	v.Set("t", "event")
+	v.Set("v", "1")
+	v.Set("uid", e.UserID.String())
+	v.Set("tid", s.cfg.TrackingID)
+	v.Set("ec", category)
+	v.Set("ea", action)
+	if !e.Value.IsZero() {
+		v.Set("ev", e.Value.StringFixed(0))
+	}
+	queueTime := now.Sub(e.Time)
+	if queueTime < 0 {
+		queueTime = 0
+	}
+	v.Set("qt", strconv.FormatInt(int64(queueTime/time.Millisecond), 10))
+	return v
+}
+
+// Send sends events as hits of a batch request.
+func (s *GASink) Send(ctx context.Context, events []*Event) error {
+	var body bytes.Buffer
+	now := time.Now()
+	for _, e := range events {
+		body.WriteString(s.hit(e, now).Encode())
+		body.WriteString("\n")
+	}
+	req, err := http.NewRequest(http.MethodPost, gaBatchEndpoint, &body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("batch status %d", resp.StatusCode)
+	}
+	return nil
+}
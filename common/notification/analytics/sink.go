@@ -0,0 +1,114 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jiarung/mochi/common/fileio"
+)
+
+// HTTPSink posts events to a generic collector as JSON:
+//
+//	{"events": [<event>, ...]}
+type HTTPSink struct {
+	endpoint string
+	header   http.Header
+	client   *http.Client
+}
+
+// NewHTTPSink returns a sink posting to endpoint with header, e.g.
+// Authorization.
+func NewHTTPSink(endpoint string, header http.Header) *HTTPSink {
+	return &HTTPSink{endpoint: endpoint, header: header, client: &http.Client{}}
+}
+
+// Name returns the name of the sink.
+func (s *HTTPSink) Name() string {
+	return "http"
+}
+
+// MaxBatch returns 0 since the collector accepts any batch.
+func (s *HTTPSink) MaxBatch() int {
+	return 0
+}
+
+// Send posts events.
+func (s *HTTPSink) Send(ctx context.Context, events []*Event) error {
+	body, err := json.Marshal(map[string][]*Event{"events": events})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FileSink appends events to a JSON lines file.
+type FileSink struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewFileSink returns a sink appending to path.
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Name returns the name of the sink.
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+// MaxBatch returns 0 since files accept any batch.
+func (s *FileSink) MaxBatch() int {
+	return 0
+}
+
+// Send appends events, one per line.
+func (s *FileSink) Send(ctx context.Context, events []*Event) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err = os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return
+	}
+	f, err := os.OpenFile(s.path, fileio.AppendFlag, fileio.AppendMode)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	enc := json.NewEncoder(f)
+	for _, e := range events {
+		if err = enc.Encode(e); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,381 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/jiarung/mochi/cache"
+	"github.com/jiarung/mochi/types"
+)
+
+const (
+	// APNsSymbol defines the app service type of apple push notification
+	// service.
+	APNsSymbol = "APNs"
+
+	// apnsTokenTTL is the lifetime of provider tokens, which must be
+	// refreshed within an hour.
+	apnsTokenTTL = 50 * time.Minute
+	// apnsMaxBatchTags is the max number of tags per batch.
+	apnsMaxBatchTags = 100
+	// apnsDeviceTokenTTL is the lifetime of device tokens in redis, which
+	// are registered again when apps launch.
+	apnsDeviceTokenTTL = 90 * 24 * time.Hour
+)
+
+var (
+	apnsProductionURL  = "https://api.push.apple.com"
+	apnsDevelopmentURL = "https://api.sandbox.push.apple.com"
+)
+
+// APNsConfig defines config of apple push notification service.
+type APNsConfig struct {
+	KeyID  string `config:"ApnsKeyId"`
+	TeamID string `config:"ApnsTeamId"`
+	// PrivateKey is the .p8 signing key in PEM.
+	PrivateKey string `config:"ApnsPrivateKey"`
+	// Topic is the bundle ID of the app.
+	Topic      string `config:"ApnsTopic"`
+	Production bool   `config:"ApnsProduction"`
+}
+
+// DeviceTokenStore maps notification tags to APNs device tokens, which are
+// registered by apps.
+type DeviceTokenStore interface {
+	DeviceTokens(tag string) ([]string, error)
+	// RemoveDeviceToken removes token which is no longer valid.
+	RemoveDeviceToken(tag, token string) error
+}
+
+// DeviceTokenRegistry registers device tokens of notification tags.
+type DeviceTokenRegistry interface {
+	// AddDeviceToken adds token of tag, and removes it from the tag
+	// registered before.
+	AddDeviceToken(tag, token string) error
+	RemoveDeviceToken(tag, token string) error
+}
+
+// MemoryDeviceTokenStore keeps device tokens in memory.
+type MemoryDeviceTokenStore struct {
+	mutex  sync.Mutex
+	tokens map[string]map[string]bool
+	tags   map[string]string
+}
+
+// NewMemoryDeviceTokenStore returns an empty store of device tokens. It's for
+// tests and local development.
+func NewMemoryDeviceTokenStore() *MemoryDeviceTokenStore {
+	return &MemoryDeviceTokenStore{
+		tokens: make(map[string]map[string]bool),
+		tags:   make(map[string]string),
+	}
+}
+
+// AddDeviceToken adds token of tag.
+func (s *MemoryDeviceTokenStore) AddDeviceToken(tag, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if prev, ok := s.tags[token]; ok {
+		delete(s.tokens[prev], token)
+	}
+	if s.tokens[tag] == nil {
+		s.tokens[tag] = make(map[string]bool)
+	}
+	s.tokens[tag][token] = true
+	s.tags[token] = tag
+	return nil
+}
+
+// DeviceTokens returns tokens of tag.
+func (s *MemoryDeviceTokenStore) DeviceTokens(tag string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tokens := make([]string, 0, len(s.tokens[tag]))
+	for token := range s.tokens[tag] {
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// RemoveDeviceToken removes token of tag.
+func (s *MemoryDeviceTokenStore) RemoveDeviceToken(tag, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tokens[tag], token)
+	if s.tags[token] == tag {
+		delete(s.tags, token)
+	}
+	return nil
+}
+
+// RedisDeviceTokenStore keeps device tokens in redis. Tokens of a tag expire
+// if none of them is registered again in ttl.
+type RedisDeviceTokenStore struct {
+	redis *cache.Redis
+	ttl   int
+}
+
+// NewRedisDeviceTokenStore returns a store which keeps device tokens in
+// redis for ttl.
+func NewRedisDeviceTokenStore(
+	redis *cache.Redis, ttl time.Duration) *RedisDeviceTokenStore {
+	return &RedisDeviceTokenStore{redis: redis, ttl: int(ttl.Seconds())}
+}
+
+func redisDeviceTokensKey(tag string) string {
+	return fmt.Sprintf("notification:apns:tokens:%s", tag)
+}
+
+func redisDeviceTagKey(token string) string {
+	return fmt.Sprintf("notification:apns:tag:%s", token)
+}
+
+// AddDeviceToken adds token of tag. Devices registered by another tag, e.g.
+// used by another user, are moved to tag.
+func (s *RedisDeviceTokenStore) AddDeviceToken(tag, token string) error {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	prev, err := redis.String(rCli.Do("GETSET", redisDeviceTagKey(token), tag))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	if len(prev) > 0 && prev != tag {
+		if _, err = rCli.Do("SREM", redisDeviceTokensKey(prev), token); err != nil {
+			return err
+		}
+	}
+	if _, err = rCli.Do("EXPIRE", redisDeviceTagKey(token), s.ttl); err != nil {
+		return err
+	}
+	if _, err = rCli.Do("SADD", redisDeviceTokensKey(tag), token); err != nil {
+		return err
+	}
+	_, err = rCli.Do("EXPIRE", redisDeviceTokensKey(tag), s.ttl)
+	return err
+}
+
+// DeviceTokens returns tokens of tag.
+func (s *RedisDeviceTokenStore) DeviceTokens(tag string) ([]string, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	return redis.Strings(rCli.Do("SMEMBERS", redisDeviceTokensKey(tag)))
+}
+
+// RemoveDeviceToken removes token of tag.
+func (s *RedisDeviceTokenStore) RemoveDeviceToken(tag, token string) error {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	if _, err := rCli.Do("SREM", redisDeviceTokensKey(tag), token); err != nil {
+		return err
+	}
+	current, err := redis.String(rCli.Do("GET", redisDeviceTagKey(token)))
+	if err == redis.ErrNil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if current == tag {
+		_, err = rCli.Do("DEL", redisDeviceTagKey(token))
+	}
+	return err
+}
+
+// APNs sends notifications by APNs HTTP/2 API with token based
+// authentication. Only English contents are sent since APNs doesn't localize
+// by device.
+type APNs struct {
+	cfg    APNsConfig
+	key    *ecdsa.PrivateKey
+	store  DeviceTokenStore
+	client *http.Client
+
+	mutex     sync.Mutex
+	token     string
+	tokenTime time.Time
+}
+
+// NewAPNs returns APNs sending to devices in store.
+func NewAPNs(cfg APNsConfig, store DeviceTokenStore) (*APNs, error) {
+	if store == nil {
+		return nil, errors.New("nil device token store")
+	}
+	key, err := parseAPNsKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	// net/http negotiates HTTP/2 with APNs over TLS.
+	return &APNs{
+		cfg:    cfg,
+		key:    key,
+		store:  store,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// parseAPNsKey parses the .p8 key, which is an ECDSA key in PKCS8.
+func parseAPNsKey(key string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("invalid apns private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	ecKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apns private key isn't ECDSA")
+	}
+	return ecKey, nil
+}
+
+// Name returns the provider name.
+func (a *APNs) Name() string {
+	return APNsSymbol
+}
+
+// MaxBatchTags returns the max number of tags per batch.
+func (a *APNs) MaxBatchTags() int {
+	return apnsMaxBatchTags
+}
+
+// providerToken returns the cached provider token.
+func (a *APNs) providerToken() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	if len(a.token) > 0 && now.Sub(a.tokenTime) < apnsTokenTTL {
+		return a.token, nil
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.cfg.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.cfg.KeyID
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.tokenTime = signed, now
+	return signed, nil
+}
+
+// errAPNsUnregistered is returned if the device token is no longer valid.
+var errAPNsUnregistered = errors.New("apns device token unregistered")
+
+func (a *APNs) push(ctx context.Context, deviceToken string, body []byte) error {
+	providerToken, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+	url := apnsDevelopmentURL
+	if a.cfg.Production {
+		url = apnsProductionURL
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		url+"/3/device/"+deviceToken, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", a.cfg.Topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	rsp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if rsp.StatusCode == http.StatusGone {
+		return errAPNsUnregistered
+	}
+	rspBody, _ := ioutil.ReadAll(rsp.Body)
+	return fmt.Errorf("error in apns response (status: %d, body: %s)",
+		rsp.StatusCode, rspBody)
+}
+
+func apnsPayload(subject, message string, data interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": subject,
+				"body":  message,
+			},
+			"sound": "default",
+		},
+		"data": data,
+	})
+}
+
+// sendTags sends body to devices of tags and returns the number of devices
+// sent to. Unregistered tokens are removed from the store.
+func (a *APNs) sendTags(ctx context.Context, tags []string, body []byte) (
+	recipients int, err error) {
+	for _, tag := range tags {
+		tokens, tokensErr := a.store.DeviceTokens(tag)
+		if tokensErr != nil {
+			return recipients, tokensErr
+		}
+		for _, token := range tokens {
+			switch pushErr := a.push(ctx, token, body); pushErr {
+			case nil:
+				recipients++
+			case errAPNsUnregistered:
+				a.store.RemoveDeviceToken(tag, token)
+			default:
+				if err == nil {
+					err = pushErr
+				}
+			}
+		}
+	}
+	return
+}
+
+// Send sends req to devices of tag req.ID.
+func (a *APNs) Send(ctx context.Context, req *AppRequest) error {
+	body, err := apnsPayload(req.Subject[types.English],
+		req.Message[types.English], req.ReturnData)
+	if err != nil {
+		return err
+	}
+	_, err = a.sendTags(ctx, []string{req.ID}, body)
+	return err
+}
+
+// BatchSend sends req to devices of tags in req.Filters. APNs has no
+// notification ID so it's always empty.
+func (a *APNs) BatchSend(ctx context.Context, req *BatchAppRequest) (
+	notificationID string, recipients int, err error) {
+	if req.Schedule != nil {
+		return "", 0, ErrPushScheduleUnsupported
+	}
+	tags, ok := req.Tags()
+	if !ok {
+		return "", 0, ErrPushTargetUnsupported
+	}
+	body, err := apnsPayload(req.Subjects[types.English],
+		req.Contents[types.English], req.ReturnData)
+	if err != nil {
+		return
+	}
+	recipients, err = a.sendTags(ctx, tags, body)
+	return
+}
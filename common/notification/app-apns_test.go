@@ -0,0 +1,135 @@
+package notification
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/types"
+)
+
+type APNsTestSuite struct {
+	suite.Suite
+
+	key     *ecdsa.PrivateKey
+	server  *httptest.Server
+	mutex   sync.Mutex
+	devices []string
+	store   *MemoryDeviceTokenStore
+	apns    *APNs
+	origURL string
+}
+
+func (s *APNsTestSuite) SetupTest() {
+	var err error
+	s.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	der, err := x509.MarshalPKCS8PrivateKey(s.key)
+	s.Require().NoError(err)
+
+	s.devices = nil
+	s.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			token, err := jwt.Parse(
+				strings.TrimPrefix(r.Header.Get("authorization"), "bearer "),
+				func(t *jwt.Token) (interface{}, error) {
+					return &s.key.PublicKey, nil
+				})
+			s.Require().NoError(err)
+			s.Require().Equal("KEY", token.Header["kid"])
+			s.Require().Equal("com.mochi.app", r.Header.Get("apns-topic"))
+
+			device := strings.TrimPrefix(r.URL.Path, "/3/device/")
+			if device == "gone" {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			s.mutex.Lock()
+			s.devices = append(s.devices, device)
+			s.mutex.Unlock()
+		}))
+	s.origURL = apnsDevelopmentURL
+	apnsDevelopmentURL = s.server.URL
+
+	s.store = NewMemoryDeviceTokenStore()
+	s.apns, err = NewAPNs(APNsConfig{
+		KeyID:  "KEY",
+		TeamID: "TEAM",
+		PrivateKey: string(pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		Topic: "com.mochi.app",
+	}, s.store)
+	s.Require().NoError(err)
+}
+
+func (s *APNsTestSuite) TearDownTest() {
+	s.server.Close()
+	apnsDevelopmentURL = s.origURL
+}
+
+func (s *APNsTestSuite) TestSend() {
+	s.store.AddDeviceToken("tag", "device1")
+	s.store.AddDeviceToken("tag", "gone")
+	s.Require().NoError(s.apns.Send(context.Background(), &AppRequest{
+		ID:      "tag",
+		Subject: map[types.OneSignalLanguage]string{types.English: "Hi"},
+		Message: map[types.OneSignalLanguage]string{types.English: "Hello"},
+	}))
+	s.Require().Equal([]string{"device1"}, s.devices)
+
+	// Unregistered tokens are removed.
+	tokens, err := s.store.DeviceTokens("tag")
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"device1"}, tokens)
+}
+
+func (s *APNsTestSuite) TestBatchSend() {
+	s.store.AddDeviceToken("a", "device1")
+	s.store.AddDeviceToken("b", "device2")
+	_, recipients, err := s.apns.BatchSend(context.Background(), &BatchAppRequest{
+		Subjects: map[types.OneSignalLanguage]string{types.English: "Hi"},
+		Contents: map[types.OneSignalLanguage]string{types.English: "Hello"},
+		Filters:  NewTagFilters([]string{"a", "b", "c"}),
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(2, recipients)
+	s.Require().ElementsMatch([]string{"device1", "device2"}, s.devices)
+}
+
+func (s *APNsTestSuite) TestDeviceTokenMoved() {
+	s.Require().NoError(s.store.AddDeviceToken("a", "device1"))
+	s.Require().NoError(s.store.AddDeviceToken("b", "device1"))
+	tokens, err := s.store.DeviceTokens("a")
+	s.Require().NoError(err)
+	s.Require().Empty(tokens)
+	tokens, err = s.store.DeviceTokens("b")
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"device1"}, tokens)
+
+	// Removing the token of a stale tag keeps it registered.
+	s.Require().NoError(s.store.RemoveDeviceToken("a", "device1"))
+	s.Require().Equal("b", s.store.tags["device1"])
+}
+
+func (s *APNsTestSuite) TestProviderTokenCached() {
+	first, err := s.apns.providerToken()
+	s.Require().NoError(err)
+	second, err := s.apns.providerToken()
+	s.Require().NoError(err)
+	s.Require().Equal(first, second)
+}
+
+func TestAPNs(t *testing.T) {
+	suite.Run(t, new(APNsTestSuite))
+}
@@ -0,0 +1,189 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/jiarung/mochi/types"
+)
+
+const (
+	// FCMSymbol defines the app service type of firebase cloud messaging.
+	FCMSymbol = "FCM"
+
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+	// fcmMaxConditionTopics is the max number of topics in a condition.
+	fcmMaxConditionTopics = 5
+)
+
+var (
+	fcmSendURL = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+)
+
+// FCMConfig defines config of firebase cloud messaging.
+type FCMConfig struct {
+	ProjectID string `config:"FcmProjectId"`
+	// CredentialsJSON is the service account key in JSON.
+	CredentialsJSON string `config:"FcmCredentialsJson"`
+}
+
+// FCM sends notifications by FCM HTTP v1 API. Devices subscribe to topic
+// FCMTopic(tag) of their notification tags. Only English contents are sent
+// since FCM doesn't localize by device.
+type FCM struct {
+	cfg    FCMConfig
+	client *http.Client
+}
+
+// NewFCM returns FCM authenticated by service account credentials.
+func NewFCM(ctx context.Context, cfg FCMConfig) (*FCM, error) {
+	creds, err := google.CredentialsFromJSON(
+		ctx, []byte(cfg.CredentialsJSON), fcmScope)
+	if err != nil {
+		return nil, err
+	}
+	return &FCM{
+		cfg:    cfg,
+		client: oauth2.NewClient(ctx, creds.TokenSource),
+	}, nil
+}
+
+// FCMTopic returns the topic of notification tag, which is tag in URL safe
+// base64 since topics can't contain '+', '/' and '='.
+func FCMTopic(tag string) string {
+	if data, err := base64.StdEncoding.DecodeString(tag); err == nil {
+		tag = base64.RawURLEncoding.EncodeToString(data)
+	}
+	return notificationTagKey + "_" + tag
+}
+
+// Name returns the provider name.
+func (f *FCM) Name() string {
+	return FCMSymbol
+}
+
+// MaxBatchTags returns the max number of topics in a condition.
+func (f *FCM) MaxBatchTags() int {
+	return fcmMaxConditionTopics
+}
+
+type fcmMessage struct {
+	Topic        string            `json:"topic,omitempty"`
+	Condition    string            `json:"condition,omitempty"`
+	Notification map[string]string `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+	Webpush      interface{}       `json:"webpush,omitempty"`
+}
+
+// fcmData converts returned data into FCM data, which only has string
+// values. Data other than map[string]string is kept in JSON under "data".
+func fcmData(data interface{}) (map[string]string, error) {
+	switch v := data.(type) {
+	case nil:
+		return nil, nil
+	case map[string]string:
+		return v, nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"data": string(encoded)}, nil
+}
+
+func (f *FCM) send(ctx context.Context, msg *fcmMessage) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{"message": msg})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf(fcmSendURL, f.cfg.ProjectID), bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rsp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	rspBody, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return "", err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error in fcm response (status: %d, body: %s)",
+			rsp.StatusCode, rspBody)
+	}
+
+	var rspJSON struct {
+		Name string `json:"name"`
+	}
+	if err = json.Unmarshal(rspBody, &rspJSON); err != nil {
+		return "", err
+	}
+	return rspJSON.Name, nil
+}
+
+// Send sends req to the topic of req.ID.
+func (f *FCM) Send(ctx context.Context, req *AppRequest) error {
+	data, err := fcmData(req.ReturnData)
+	if err != nil {
+		return err
+	}
+	_, err = f.send(ctx, &fcmMessage{
+		Topic: FCMTopic(req.ID),
+		Notification: map[string]string{
+			"title": req.Subject[types.English],
+			"body":  req.Message[types.English],
+		},
+		Data: data,
+	})
+	return err
+}
+
+// BatchSend sends req to topics of tags in req.Filters. Recipients are
+// unknown for topics so it's always 0.
+func (f *FCM) BatchSend(ctx context.Context, req *BatchAppRequest) (
+	notificationID string, recipients int, err error) {
+	if req.Schedule != nil {
+		return "", 0, ErrPushScheduleUnsupported
+	}
+	tags, ok := req.Tags()
+	if !ok || len(tags) > fcmMaxConditionTopics {
+		return "", 0, ErrPushTargetUnsupported
+	}
+	data, err := fcmData(req.ReturnData)
+	if err != nil {
+		return
+	}
+
+	conditions := make([]string, len(tags))
+	for i, tag := range tags {
+		conditions[i] = fmt.Sprintf("'%s' in topics", FCMTopic(tag))
+	}
+	msg := &fcmMessage{
+		Condition: strings.Join(conditions, " || "),
+		Notification: map[string]string{
+			"title": req.Subjects[types.English],
+			"body":  req.Contents[types.English],
+		},
+		Data: data,
+	}
+	if req.URL != nil {
+		msg.Webpush = map[string]interface{}{
+			"fcm_options": map[string]string{"link": *req.URL},
+		}
+	}
+	notificationID, err = f.send(ctx, msg)
+	return
+}
@@ -0,0 +1,93 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/types"
+)
+
+type FCMTestSuite struct {
+	suite.Suite
+
+	server   *httptest.Server
+	messages []map[string]interface{}
+	fcm      *FCM
+	origURL  string
+}
+
+func (s *FCMTestSuite) SetupTest() {
+	s.messages = nil
+	s.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			s.Require().Equal("/v1/projects/mochi/messages:send", r.URL.Path)
+			body, err := ioutil.ReadAll(r.Body)
+			s.Require().NoError(err)
+			var req struct {
+				Message map[string]interface{} `json:"message"`
+			}
+			s.Require().NoError(json.Unmarshal(body, &req))
+			s.messages = append(s.messages, req.Message)
+			w.Write([]byte(`{"name":"projects/mochi/messages/1"}`))
+		}))
+	s.origURL = fcmSendURL
+	fcmSendURL = s.server.URL + "/v1/projects/%s/messages:send"
+	s.fcm = &FCM{cfg: FCMConfig{ProjectID: "mochi"}, client: http.DefaultClient}
+}
+
+func (s *FCMTestSuite) TearDownTest() {
+	s.server.Close()
+	fcmSendURL = s.origURL
+}
+
+func (s *FCMTestSuite) TestTopic() {
+	topic := FCMTopic("a+b/cw==")
+	s.Require().Equal("notification_tag_a-b_cw", topic)
+	s.Require().False(strings.ContainsAny(topic, "+/="))
+}
+
+func (s *FCMTestSuite) TestSend() {
+	s.Require().NoError(s.fcm.Send(context.Background(), &AppRequest{
+		ID:         "a+b/cw==",
+		Subject:    map[types.OneSignalLanguage]string{types.English: "Hi"},
+		Message:    map[types.OneSignalLanguage]string{types.English: "Hello"},
+		ReturnData: map[string]interface{}{"target": "/wallet"},
+	}))
+	s.Require().Len(s.messages, 1)
+	s.Require().Equal("notification_tag_a-b_cw", s.messages[0]["topic"])
+	s.Require().Equal(map[string]interface{}{
+		"title": "Hi", "body": "Hello",
+	}, s.messages[0]["notification"])
+	s.Require().Equal(map[string]interface{}{
+		"data": `{"target":"/wallet"}`,
+	}, s.messages[0]["data"])
+}
+
+func (s *FCMTestSuite) TestBatchSend() {
+	id, _, err := s.fcm.BatchSend(context.Background(), &BatchAppRequest{
+		Subjects: map[types.OneSignalLanguage]string{types.English: "Hi"},
+		Contents: map[types.OneSignalLanguage]string{types.English: "Hello"},
+		Filters:  NewTagFilters([]string{"a", "b"}),
+	})
+	s.Require().NoError(err)
+	s.Require().Equal("projects/mochi/messages/1", id)
+	s.Require().Equal(
+		"'notification_tag_a' in topics || 'notification_tag_b' in topics",
+		s.messages[0]["condition"])
+
+	_, _, err = s.fcm.BatchSend(context.Background(), &BatchAppRequest{
+		Filters: []map[string]string{{"field": "country", "value": "TW"}},
+	})
+	s.Require().Equal(ErrPushTargetUnsupported, err)
+}
+
+func TestFCM(t *testing.T) {
+	suite.Run(t, new(FCMTestSuite))
+}
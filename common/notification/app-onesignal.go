@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -20,6 +21,10 @@ const (
 	OnesignalSymbol = "Onesignal"
 )
 
+// oneSignalMaxBatchTags keeps tag filters with OR operators under the limit
+// of 200 filters.
+const oneSignalMaxBatchTags = 100
+
 var (
 	oneSignalRestURL = "https://onesignal.com/api/v1/notifications"
 )
@@ -30,6 +35,16 @@ type OneSignalConfig struct {
 	AppID  string `config:"OnesignalAppId"`
 }
 
+// Name returns the provider name.
+func (o *OneSignalConfig) Name() string {
+	return OnesignalSymbol
+}
+
+// MaxBatchTags returns the max number of tags per BatchSend.
+func (o *OneSignalConfig) MaxBatchTags() int {
+	return oneSignalMaxBatchTags
+}
+
 func onesignalContentsCheck(subject, message map[types.OneSignalLanguage]string) bool {
 	if _, ok := subject[types.English]; !ok {
 		return false
@@ -131,35 +146,114 @@ type viewNotificationResp struct {
 	PlatformDeliveryStats map[string]interface{} `json:"platform_delivery_stats"`
 }
 
-func (o *OneSignalConfig) viewNotificationOnesignal(
-	ctx context.Context, notificationID string) {
+// onesignalError returns the error of a response with errors.
+func onesignalError(statusCode int, errs []string) error {
+	if len(errs) > 0 {
+		return fmt.Errorf("error in onesignal response (status: %d, message: %s)",
+			statusCode, strings.Join(errs, " "))
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("error in onesignal response (status: %d)", statusCode)
+	}
+	return nil
+}
 
-	client := &http.Client{}
+// onesignalNotificationRequest returns a request of notification
+// notificationID.
+func (o *OneSignalConfig) onesignalNotificationRequest(ctx context.Context,
+	method, notificationID string) (*http.Request, error) {
 	req, err := http.NewRequest(
-		http.MethodGet, oneSignalRestURL+"/"+notificationID, nil)
+		method, oneSignalRestURL+"/"+url.PathEscape(notificationID), nil)
 	if err != nil {
-		return
+		return nil, err
 	}
 	req.Header.Add("Authorization", "Basic "+o.APIKey)
 
 	q := req.URL.Query()
 	q.Add("app_id", o.AppID)
 	req.URL.RawQuery = q.Encode()
+	return req.WithContext(ctx), nil
+}
 
-	req = req.WithContext(ctx)
-	rsp, err := client.Do(req)
-
+// ViewNotification returns the delivery stats of notificationID.
+func (o *OneSignalConfig) ViewNotification(ctx context.Context,
+	notificationID string) (*PushStats, error) {
+	req, err := o.onesignalNotificationRequest(
+		ctx, http.MethodGet, notificationID)
 	if err != nil {
-		return
+		return nil, err
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
+	defer rsp.Body.Close()
 
-	var rspJSON viewNotificationResp
+	var rspJSON struct {
+		viewNotificationResp
+		Errors []string `json:"errors"`
+	}
 	rspBody, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(rspBody, &rspJSON); err != nil {
+		return nil, fmt.Errorf("parse onesignal response (status: %d): %v",
+			rsp.StatusCode, err)
+	}
+	if err = onesignalError(rsp.StatusCode, rspJSON.Errors); err != nil {
+		return nil, err
+	}
+
+	stats := &PushStats{
+		Successful: rspJSON.Successful,
+		Failed:     rspJSON.Failed,
+		Converted:  rspJSON.Converted,
+		Remaining:  rspJSON.Remaining,
+		Canceled:   rspJSON.Canceled,
+	}
+	if rspJSON.SendAfter > 0 {
+		stats.SendAfter = time.Unix(rspJSON.SendAfter, 0)
+	}
+	if rspJSON.CompletedAt > 0 {
+		stats.CompletedAt = time.Unix(rspJSON.CompletedAt, 0)
+	}
+	return stats, nil
+}
+
+// CancelNotification cancels the scheduled notification notificationID.
+func (o *OneSignalConfig) CancelNotification(ctx context.Context,
+	notificationID string) error {
+	req, err := o.onesignalNotificationRequest(
+		ctx, http.MethodDelete, notificationID)
+	if err != nil {
+		return err
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
 	defer rsp.Body.Close()
+
+	var rspJSON = struct {
+		Success bool     `json:"success"`
+		Errors  []string `json:"errors"`
+	}{}
+	rspBody, err := ioutil.ReadAll(rsp.Body)
 	if err != nil {
-		return
+		return err
 	}
-	json.Unmarshal(rspBody, &rspJSON)
+	if err = json.Unmarshal(rspBody, &rspJSON); err != nil {
+		return fmt.Errorf("parse onesignal response (status: %d): %v",
+			rsp.StatusCode, err)
+	}
+	if err = onesignalError(rsp.StatusCode, rspJSON.Errors); err != nil {
+		return err
+	}
+	if !rspJSON.Success {
+		return ErrPushNotCancelable
+	}
+	return nil
 }
 
 // BatchSend sends notification via Onesignal in filter mode to specify the
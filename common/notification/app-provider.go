@@ -0,0 +1,191 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jiarung/mochi/common/utils"
+	"github.com/jiarung/mochi/types"
+)
+
+// PushProvider defines a push notification provider. Devices are targeted
+// by notification tags generated by AppTag.GetNotificationTag.
+type PushProvider interface {
+	Name() string
+	// Send sends req to devices of tag req.ID.
+	Send(ctx context.Context, req *AppRequest) error
+	// BatchSend sends req to devices of tags in req.Filters.
+	BatchSend(ctx context.Context, req *BatchAppRequest) (
+		notificationID string, recipients int, err error)
+	// MaxBatchTags returns the max number of tags per BatchSend.
+	MaxBatchTags() int
+}
+
+// Errors of push providers.
+var (
+	ErrInvalidPushService    = errors.New("Invalid app service type")
+	ErrPushTargetUnsupported = errors.New(
+		"push provider only supports notification tag filters")
+	ErrPushScheduleUnsupported = errors.New(
+		"push provider doesn't support scheduled notifications")
+)
+
+// pushRateLimits are the default requests per second of providers.
+var pushRateLimits = map[string]int{
+	OnesignalSymbol: 10,
+	FCMSymbol:       100,
+	APNsSymbol:      100,
+}
+
+// notificationTagKey is the key of notification tags in filters.
+const notificationTagKey = "notification_tag"
+
+// NewTagFilters returns filters of BatchAppRequest targeting devices with
+// any of tags.
+func NewTagFilters(tags []string) []map[string]string {
+	filters := make([]map[string]string, 0, len(tags)*2)
+	for i, tag := range tags {
+		if i > 0 {
+			filters = append(filters, map[string]string{"operator": "OR"})
+		}
+		filters = append(filters, map[string]string{
+			"field":    "tag",
+			"key":      notificationTagKey,
+			"relation": "=",
+			"value":    tag,
+		})
+	}
+	return filters
+}
+
+// Tags returns notification tags in r.Filters. It returns false if there're
+// filters other than notification tags joined by OR.
+func (r *BatchAppRequest) Tags() ([]string, bool) {
+	var tags []string
+	for _, filter := range r.Filters {
+		if len(filter) == 1 && filter["operator"] == "OR" {
+			continue
+		}
+		if filter["field"] != "tag" || filter["key"] != notificationTagKey ||
+			filter["relation"] != "=" {
+			return nil, false
+		}
+		tags = append(tags, filter["value"])
+	}
+	return tags, len(tags) > 0
+}
+
+// pushEnvironment returns the subject suffix of the environment, and whether
+// notifications shouldn't be sent. Batch notifications are still sent in
+// the stress environment.
+func pushEnvironment(batch bool) (suffix string, skip bool) {
+	switch utils.Environment() {
+	case utils.Staging:
+		return " [staging]", false
+	case utils.Development:
+		return " [dev]", false
+	case utils.LocalDevelopment:
+		return " [localdev]", true
+	case utils.CI:
+		return "", true
+	case utils.Stress:
+		return "", !batch
+	}
+	return "", false
+}
+
+// withSuffix returns a copy of subjects with suffix, so retries of the same
+// request don't append it again.
+func withSuffix(subjects map[types.OneSignalLanguage]string,
+	suffix string) map[types.OneSignalLanguage]string {
+	if len(suffix) == 0 {
+		return subjects
+	}
+	copied := make(map[types.OneSignalLanguage]string, len(subjects))
+	for k, v := range subjects {
+		copied[k] = v + suffix
+	}
+	return copied
+}
+
+// pushRateLimiter spaces requests to a provider evenly.
+type pushRateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newPushRateLimiter(perSecond int) *pushRateLimiter {
+	if perSecond <= 0 {
+		return &pushRateLimiter{}
+	}
+	return &pushRateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// Wait blocks until the next request is allowed.
+func (l *pushRateLimiter) Wait(ctx context.Context) error {
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// batchSend sends req by provider in chunks of its MaxBatchTags, and returns
+// notification IDs of chunks sent successfully.
+func batchSend(ctx context.Context, provider PushProvider,
+	limiter *pushRateLimiter, req *BatchAppRequest) (
+	notificationIDs []string, recipients int, err error) {
+	tags, ok := req.Tags()
+	size := provider.MaxBatchTags()
+	if !ok || size <= 0 || len(tags) <= size {
+		if err = limiter.Wait(ctx); err != nil {
+			return
+		}
+		var id string
+		id, recipients, err = provider.BatchSend(ctx, req)
+		if len(id) > 0 {
+			notificationIDs = []string{id}
+		}
+		return
+	}
+
+	for start := 0; start < len(tags); start += size {
+		end := start + size
+		if end > len(tags) {
+			end = len(tags)
+		}
+		chunk := *req
+		chunk.Filters = NewTagFilters(tags[start:end])
+		if err = limiter.Wait(ctx); err != nil {
+			break
+		}
+		id, n, chunkErr := provider.BatchSend(ctx, &chunk)
+		if chunkErr != nil {
+			err = chunkErr
+			break
+		}
+		if len(id) > 0 {
+			notificationIDs = append(notificationIDs, id)
+		}
+		recipients += n
+	}
+	return
+}
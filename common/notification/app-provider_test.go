@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/types"
+)
+
+type fakePushProvider struct {
+	mutex   sync.Mutex
+	maxTags int
+	batches [][]string
+}
+
+func (p *fakePushProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakePushProvider) MaxBatchTags() int {
+	return p.maxTags
+}
+
+func (p *fakePushProvider) Send(ctx context.Context, req *AppRequest) error {
+	return nil
+}
+
+func (p *fakePushProvider) BatchSend(ctx context.Context,
+	req *BatchAppRequest) (string, int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	tags, _ := req.Tags()
+	p.batches = append(p.batches, tags)
+	return fmt.Sprintf("id%d", len(p.batches)), len(tags), nil
+}
+
+type PushProviderTestSuite struct {
+	suite.Suite
+}
+
+func (s *PushProviderTestSuite) TestTagFilters() {
+	req := &BatchAppRequest{Filters: NewTagFilters([]string{"a", "b", "c"})}
+	s.Require().Len(req.Filters, 5)
+	s.Require().Equal("OR", req.Filters[1]["operator"])
+	tags, ok := req.Tags()
+	s.Require().True(ok)
+	s.Require().Equal([]string{"a", "b", "c"}, tags)
+
+	req.Filters = append(req.Filters, map[string]string{
+		"field": "last_session", "relation": ">", "hours_ago": "1",
+	})
+	_, ok = req.Tags()
+	s.Require().False(ok)
+}
+
+func (s *PushProviderTestSuite) TestBatchSendChunks() {
+	provider := &fakePushProvider{maxTags: 2}
+	req := &BatchAppRequest{
+		Filters: NewTagFilters([]string{"a", "b", "c", "d", "e"}),
+	}
+	ids, recipients, err := batchSend(
+		context.Background(), provider, newPushRateLimiter(0), req)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"id1", "id2", "id3"}, ids)
+	s.Require().Equal(5, recipients)
+	s.Require().Equal([][]string{{"a", "b"}, {"c", "d"}, {"e"}}, provider.batches)
+	// The request isn't modified.
+	s.Require().Len(req.Filters, 9)
+}
+
+func (s *PushProviderTestSuite) TestWithSuffix() {
+	subjects := map[types.OneSignalLanguage]string{types.English: "Notice"}
+	suffixed := withSuffix(subjects, " [dev]")
+	s.Require().Equal("Notice [dev]", suffixed[types.English])
+	s.Require().Equal("Notice", subjects[types.English])
+}
+
+func (s *PushProviderTestSuite) TestRateLimiter() {
+	limiter := newPushRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		s.Require().NoError(limiter.Wait(context.Background()))
+	}
+	s.Require().True(time.Since(start) >= 40*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter = newPushRateLimiter(1)
+	s.Require().NoError(limiter.Wait(ctx))
+	s.Require().Equal(context.Canceled, limiter.Wait(ctx))
+}
+
+func (s *PushProviderTestSuite) TestProviderRetry() {
+	app := &AppStruct{Service: "unknown"}
+	_, err := app.pushProvider(context.Background())
+	s.Require().Equal(ErrInvalidPushService, err)
+
+	// Errors aren't cached, so the provider is created by the next call.
+	app.Service = OnesignalSymbol
+	provider, err := app.pushProvider(context.Background())
+	s.Require().NoError(err)
+	s.Require().Same(&app.OneSignalConfig, provider)
+	s.Require().NotNil(app.limiter)
+}
+
+func TestPushProvider(t *testing.T) {
+	suite.Run(t, new(PushProviderTestSuite))
+}
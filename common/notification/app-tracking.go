@@ -0,0 +1,156 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PushTracker defines a push provider which reports delivery outcomes of
+// batch notifications and cancels scheduled ones.
+type PushTracker interface {
+	// ViewNotification returns the delivery stats of notificationID.
+	ViewNotification(ctx context.Context, notificationID string) (
+		*PushStats, error)
+	// CancelNotification cancels the scheduled notification notificationID.
+	CancelNotification(ctx context.Context, notificationID string) error
+}
+
+// Errors of push tracking.
+var (
+	ErrPushTrackingUnsupported = errors.New(
+		"push provider doesn't support delivery tracking")
+	ErrPushNotCancelable = errors.New(
+		"push notification isn't scheduled or has been sent")
+)
+
+// PushCancelError is returned by AppStruct.CancelNotification when some
+// chunks are canceled and the others fail.
+type PushCancelError struct {
+	// Canceled are the notification IDs of canceled chunks.
+	Canceled []string
+	// Err is the first error of failed chunks.
+	Err error
+}
+
+func (e *PushCancelError) Error() string {
+	return fmt.Sprintf("only %s are canceled: %v",
+		strings.Join(e.Canceled, ","), e.Err)
+}
+
+// Unwrap returns the first error of failed chunks.
+func (e *PushCancelError) Unwrap() error {
+	return e.Err
+}
+
+// PushStats defines delivery outcomes of a batch notification.
+type PushStats struct {
+	Successful int  `json:"successful"`
+	Failed     int  `json:"failed"`
+	Converted  int  `json:"converted"`
+	Remaining  int  `json:"remaining"`
+	Canceled   bool `json:"canceled"`
+	// SendAfter is the scheduled time, which is zero if it's sent
+	// immediately.
+	SendAfter time.Time `json:"send_after"`
+	// CompletedAt is zero until all devices are sent.
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Completed returns whether all devices are sent.
+func (s *PushStats) Completed() bool {
+	return !s.CompletedAt.IsZero()
+}
+
+// merge adds stats of another chunk of the same notification. The merged
+// stats are completed when all chunks are completed.
+func (s *PushStats) merge(other *PushStats) {
+	s.Successful += other.Successful
+	s.Failed += other.Failed
+	s.Converted += other.Converted
+	s.Remaining += other.Remaining
+	s.Canceled = s.Canceled || other.Canceled
+	if other.SendAfter.After(s.SendAfter) {
+		s.SendAfter = other.SendAfter
+	}
+	if !other.Completed() {
+		s.CompletedAt = time.Time{}
+	} else if s.Completed() && other.CompletedAt.After(s.CompletedAt) {
+		s.CompletedAt = other.CompletedAt
+	}
+}
+
+// pushTracker returns the provider of Service if it supports tracking.
+func (a *AppStruct) pushTracker(ctx context.Context) (PushTracker, error) {
+	provider, err := a.pushProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tracker, ok := provider.(PushTracker)
+	if !ok {
+		return nil, ErrPushTrackingUnsupported
+	}
+	return tracker, nil
+}
+
+// ViewNotification returns the delivery stats of notificationIDs returned by
+// BatchSend, which are summed up over the chunks.
+func (a *AppStruct) ViewNotification(ctx context.Context,
+	notificationIDs []string) (*PushStats, error) {
+	tracker, err := a.pushTracker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var stats *PushStats
+	for _, id := range notificationIDs {
+		if err = a.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		chunk, err := tracker.ViewNotification(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if stats == nil {
+			stats = chunk
+		} else {
+			stats.merge(chunk)
+		}
+	}
+	if stats == nil {
+		stats = &PushStats{}
+	}
+	return stats, nil
+}
+
+// CancelNotification cancels the scheduled notificationIDs returned by
+// BatchSend. Chunks are all tried even if some of them fail, and the first
+// error is returned. If some chunks are canceled, the error is a
+// *PushCancelError with IDs of the canceled chunks.
+func (a *AppStruct) CancelNotification(ctx context.Context,
+	notificationIDs []string) error {
+	tracker, err := a.pushTracker(ctx)
+	if err != nil {
+		return err
+	}
+	var canceled []string
+	for _, id := range notificationIDs {
+		if waitErr := a.limiter.Wait(ctx); waitErr != nil {
+			if err == nil {
+				err = waitErr
+			}
+			break
+		}
+		cancelErr := tracker.CancelNotification(ctx, id)
+		if cancelErr == nil {
+			canceled = append(canceled, id)
+		} else if err == nil {
+			err = cancelErr
+		}
+	}
+	if err != nil && len(canceled) > 0 {
+		return &PushCancelError{Canceled: canceled, Err: err}
+	}
+	return err
+}
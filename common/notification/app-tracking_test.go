@@ -0,0 +1,114 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PushTrackingTestSuite struct {
+	suite.Suite
+
+	app      *AppStruct
+	server   *httptest.Server
+	canceled []string
+}
+
+func (s *PushTrackingTestSuite) SetupTest() {
+	s.app = &AppStruct{
+		OneSignalConfig: OneSignalConfig{APIKey: "key", AppID: "app"},
+		Service:         OnesignalSymbol,
+	}
+	s.canceled = nil
+	s.server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Require().Equal("Basic key", r.Header.Get("Authorization"))
+			s.Require().Equal("app", r.URL.Query().Get("app_id"))
+			id := strings.TrimPrefix(r.URL.Path, "/")
+			switch {
+			case r.Method == http.MethodDelete && id == "sent":
+				w.Write([]byte(`{"success": false}`))
+			case r.Method == http.MethodDelete:
+				s.canceled = append(s.canceled, id)
+				w.Write([]byte(`{"success": true}`))
+			case id == "malformed":
+				w.Write([]byte(`<html>`))
+			case id == "n1":
+				w.Write([]byte(`{"id": "n1", "successful": 10, "failed": 1,
+					"converted": 2, "remaining": 0, "send_after": 1588320000,
+					"completed_at": 1588320060}`))
+			case id == "n2":
+				w.Write([]byte(`{"id": "n2", "successful": 5, "failed": 0,
+					"converted": 1, "remaining": 7, "send_after": 1588320000,
+					"completed_at": null}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": ["Notification not found"]}`))
+			}
+		}))
+	oneSignalRestURL = s.server.URL
+}
+
+func (s *PushTrackingTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *PushTrackingTestSuite) TestViewNotification() {
+	stats, err := s.app.ViewNotification(context.Background(), []string{"n1"})
+	s.Require().NoError(err)
+	s.Require().Equal(10, stats.Successful)
+	s.Require().True(stats.Completed())
+	s.Require().Equal(time.Unix(1588320060, 0), stats.CompletedAt)
+
+	// Stats of chunks are summed up, and completed if all chunks are.
+	stats, err = s.app.ViewNotification(context.Background(),
+		[]string{"n1", "n2"})
+	s.Require().NoError(err)
+	s.Require().Equal(15, stats.Successful)
+	s.Require().Equal(3, stats.Converted)
+	s.Require().Equal(7, stats.Remaining)
+	s.Require().False(stats.Completed())
+	s.Require().Equal(time.Unix(1588320000, 0), stats.SendAfter)
+
+	_, err = s.app.ViewNotification(context.Background(), []string{"unknown"})
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "Notification not found")
+
+	_, err = s.app.ViewNotification(context.Background(),
+		[]string{"malformed"})
+	s.Require().Error(err)
+}
+
+func (s *PushTrackingTestSuite) TestCancelNotification() {
+	s.Require().NoError(
+		s.app.CancelNotification(context.Background(), []string{"n1", "n2"}))
+	s.Require().Equal([]string{"n1", "n2"}, s.canceled)
+
+	s.Require().Equal(ErrPushNotCancelable,
+		s.app.CancelNotification(context.Background(), []string{"sent"}))
+
+	err := s.app.CancelNotification(context.Background(),
+		[]string{"sent", "n3"})
+	s.Require().Equal(&PushCancelError{
+		Canceled: []string{"n3"},
+		Err:      ErrPushNotCancelable,
+	}, err)
+	s.Require().True(errors.Is(err, ErrPushNotCancelable))
+	s.Require().Equal([]string{"n1", "n2", "n3"}, s.canceled)
+}
+
+func (s *PushTrackingTestSuite) TestUnsupported() {
+	app := &AppStruct{Service: OnesignalSymbol, Provider: &FCM{}}
+	_, err := app.ViewNotification(context.Background(), []string{"n1"})
+	s.Require().Equal(ErrPushTrackingUnsupported, err)
+}
+
+func TestPushTracking(t *testing.T) {
+	suite.Run(t, new(PushTrackingTestSuite))
+}
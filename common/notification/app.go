@@ -5,13 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/satori/go.uuid"
 	"github.com/shopspring/decimal"
 
 	cobxtypes "github.com/jiarung/mochi/apps/exchange/cobx-types"
-	"github.com/jiarung/mochi/common/utils"
+	"github.com/jiarung/mochi/cache"
 	models "github.com/jiarung/mochi/models/exchange"
 	"github.com/jiarung/mochi/types"
 )
@@ -19,8 +20,22 @@ import (
 // AppStruct defines struct of app.
 type AppStruct struct {
 	OneSignalConfig
+	FCM     FCMConfig
+	APNs    APNsConfig
 	Tag     AppTag
 	Service string `config:"AppNotificationService"`
+
+	// Provider overrides the provider of Service if it's set.
+	Provider PushProvider
+	// DeviceTokens maps notification tags to device tokens for APNs. Tokens
+	// in redis are used if it's nil.
+	DeviceTokens DeviceTokenStore
+
+	// providerMutex guards provider and limiter, which are set once the
+	// provider is created successfully.
+	providerMutex sync.Mutex
+	provider      PushProvider
+	limiter       *pushRateLimiter
 }
 
 // AppRequest defines the sending message which generates from template.
@@ -52,33 +67,59 @@ func (a *AppStruct) ServiceName() string {
 	return a.Service
 }
 
+// pushProvider returns the provider of Service. Only the created provider
+// is cached, so errors, e.g. of fetching credentials, are retried by later
+// calls.
+func (a *AppStruct) pushProvider(ctx context.Context) (PushProvider, error) {
+	a.providerMutex.Lock()
+	defer a.providerMutex.Unlock()
+	if a.provider != nil {
+		return a.provider, nil
+	}
+
+	provider, err := a.Provider, error(nil)
+	if provider == nil {
+		switch a.Service {
+		case OnesignalSymbol:
+			provider = &a.OneSignalConfig
+		case FCMSymbol:
+			provider, err = NewFCM(ctx, a.FCM)
+		case APNsSymbol:
+			store := a.DeviceTokens
+			if store == nil {
+				store = NewRedisDeviceTokenStore(
+					cache.GetRedis(), apnsDeviceTokenTTL)
+			}
+			provider, err = NewAPNs(a.APNs, store)
+		default:
+			err = ErrInvalidPushService
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.limiter = newPushRateLimiter(pushRateLimits[provider.Name()])
+	a.provider = provider
+	return provider, nil
+}
+
 // Send calls push notification service with req.
 func (a *AppStruct) Send(ctx context.Context, req *AppRequest) (err error) {
-	switch utils.Environment() {
-	case utils.Production:
-	case utils.Staging:
-		for k := range req.Subject {
-			req.Subject[k] += " [staging]"
-		}
-	case utils.Development:
-		for k := range req.Subject {
-			req.Subject[k] += " [dev]"
-		}
-	case utils.LocalDevelopment:
-		for k := range req.Subject {
-			req.Subject[k] += " [localdev]"
-		}
+	suffix, skip := pushEnvironment(false)
+	if skip {
 		return
-	case utils.CI, utils.Stress:
+	}
+	provider, err := a.pushProvider(ctx)
+	if err != nil {
 		return
 	}
 
-	switch a.Service {
-	case OnesignalSymbol:
-		return a.OneSignalConfig.Send(ctx, req)
-	default:
-		return errors.New("Invalid app service type")
+	suffixed := *req
+	suffixed.Subject = withSuffix(req.Subject, suffix)
+	if err = a.limiter.Wait(ctx); err != nil {
+		return
 	}
+	return provider.Send(ctx, &suffixed)
 }
 
 // GenDepositConfirmed generates deposit confirmed message and packs into
@@ -373,32 +414,116 @@ func (a *AppStruct) GenMACDCrossAlert(
 	return req, nil
 }
 
-// BatchSend calls push notification service with req.
+// EMACrossInfo defines struct for EMA cross.
+type EMACrossInfo struct {
+	Pair      string
+	UserID    string
+	CrossType types.CrossType
+	Fast      int
+	Slow      int
+	EventID   string
+}
+
+// GenEMACrossAlert generates messages to notify user the fast EMA crossed the
+// slow EMA.
+func (a *AppStruct) GenEMACrossAlert(info EMACrossInfo) (*AppRequest, error) {
+	var crossTypeStr string
+	var crossTypeZhTwStr string
+	var sideEmoji string
+	if info.CrossType == types.DeathCross {
+		crossTypeStr = "Death Cross"
+		crossTypeZhTwStr = "死亡交叉訊號"
+		sideEmoji = "📉"
+	} else if info.CrossType == types.GoldenCross {
+		crossTypeStr = "Golden Cross"
+		crossTypeZhTwStr = "黃金交叉訊號"
+		sideEmoji = "📈"
+	} else {
+		return nil, errors.New("unknown cross type")
+	}
+
+	pair := strings.Replace(info.Pair, "-USDT", "", -1)
+	req := &AppRequest{
+		ID: a.Tag.GetNotificationTag(info.UserID, cobxtypes.APICobx),
+		Subject: map[types.OneSignalLanguage]string{
+			types.English: fmt.Sprintf("🚨 EMA(%v/%v) %v of %v %v",
+				info.Fast, info.Slow, crossTypeStr, pair, sideEmoji),
+			types.ChineseTraditional: fmt.Sprintf("🚨 %v出現EMA(%v/%v)%v %v",
+				pair, info.Fast, info.Slow, crossTypeZhTwStr, sideEmoji),
+		},
+		Message: map[types.OneSignalLanguage]string{
+			types.English:            fmt.Sprint("Check out details>>"),
+			types.ChineseTraditional: fmt.Sprint("查看詳情>>"),
+		},
+		UserID: info.UserID,
+		ReturnData: map[string]interface{}{
+			"target": fmt.Sprintf("/trade/%v", info.Pair),
+			"forecast_info": map[string]string{
+				"trading_pair_id": info.Pair,
+				"type":            "ema cross",
+				"event_id":        info.EventID,
+			},
+		},
+	}
+
+	return req, nil
+}
+
+// VolumeSpikeInfo defines struct for volume spike.
+type VolumeSpikeInfo struct {
+	Pair    string
+	UserID  string
+	Ratio   string
+	EventID string
+}
+
+// GenVolumeSpikeAlert generates messages to notify user trading volume
+// suddenly rose.
+func (a *AppStruct) GenVolumeSpikeAlert(info VolumeSpikeInfo) (
+	*AppRequest, error) {
+	pair := strings.Replace(info.Pair, "-USDT", "", -1)
+	req := &AppRequest{
+		ID: a.Tag.GetNotificationTag(info.UserID, cobxtypes.APICobx),
+		Subject: map[types.OneSignalLanguage]string{
+			types.English: fmt.Sprintf(
+				"🚨 %v volume %vx of average 📊", pair, info.Ratio),
+			types.ChineseTraditional: fmt.Sprintf(
+				"🚨 %v成交量達平均%v倍 📊", pair, info.Ratio),
+		},
+		Message: map[types.OneSignalLanguage]string{
+			types.English:            fmt.Sprint("Check out details>>"),
+			types.ChineseTraditional: fmt.Sprint("查看詳情>>"),
+		},
+		UserID: info.UserID,
+		ReturnData: map[string]interface{}{
+			"target": fmt.Sprintf("/trade/%v", info.Pair),
+			"forecast_info": map[string]string{
+				"trading_pair_id": info.Pair,
+				"type":            "volume spike",
+				"event_id":        info.EventID,
+			},
+		},
+	}
+
+	return req, nil
+}
+
+// BatchSend calls push notification service with req. Requests targeting
+// notification tags are sent in chunks by the limit of the provider, and the
+// notification IDs of chunks are returned, including chunks sent before an
+// error.
 func (a *AppStruct) BatchSend(ctx context.Context, req *BatchAppRequest) (
-	notificationID string, recipients int, err error) {
-	switch utils.Environment() {
-	case utils.Production:
-	case utils.Staging:
-		for k := range req.Subjects {
-			req.Subjects[k] += " [staging]"
-		}
-	case utils.Development:
-		for k := range req.Subjects {
-			req.Subjects[k] += " [dev]"
-		}
-	case utils.LocalDevelopment:
-		for k := range req.Subjects {
-			req.Subjects[k] += " [localdev]"
-		}
+	notificationIDs []string, recipients int, err error) {
+	suffix, skip := pushEnvironment(true)
+	if skip {
 		return
-	case utils.CI:
+	}
+	provider, err := a.pushProvider(ctx)
+	if err != nil {
 		return
 	}
 
-	switch a.Service {
-	case OnesignalSymbol:
-		return a.OneSignalConfig.BatchSend(ctx, req)
-	default:
-		return "", 0, errors.New("Invalid app service type")
-	}
+	suffixed := *req
+	suffixed.Subjects = withSuffix(req.Subjects, suffix)
+	return batchSend(ctx, provider, a.limiter, &suffixed)
 }
@@ -0,0 +1,137 @@
+// Package campaign tracks batch push notifications sent by
+// notification.AppStruct.BatchSend, and polls the provider for their
+// delivery outcomes.
+package campaign
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/types"
+)
+
+// Errors of campaigns.
+var (
+	ErrCampaignNotFound = errors.New("push campaign not found")
+	ErrNotCancelable    = errors.New("push campaign isn't scheduled")
+)
+
+// Status defines the status of a campaign.
+type Status string
+
+// Statuses of campaigns.
+const (
+	// StatusScheduled means the campaign waits for its schedule.
+	StatusScheduled Status = "scheduled"
+	// StatusSending means the provider is sending the campaign.
+	StatusSending Status = "sending"
+	// StatusCompleted means all devices are sent.
+	StatusCompleted Status = "completed"
+	// StatusCanceled means the scheduled campaign is canceled.
+	StatusCanceled Status = "canceled"
+	// StatusFailed means the provider rejected the campaign.
+	StatusFailed Status = "failed"
+	// StatusExpired means the provider didn't complete the campaign within
+	// Option.MaxAge, and it isn't polled anymore.
+	StatusExpired Status = "expired"
+)
+
+// Final returns whether the status won't change anymore.
+func (s Status) Final() bool {
+	switch s {
+	case StatusCompleted, StatusCanceled, StatusFailed, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// Schedule defines the schedule of a campaign.
+type Schedule struct {
+	SendAfter   time.Time `json:"send_after"`
+	IsOptimized bool      `json:"is_optimized"`
+}
+
+// Campaign defines a tracked batch notification.
+type Campaign struct {
+	ID              uuid.UUID              `json:"id"`
+	Name            string                 `json:"name"`
+	Provider        string                 `json:"provider"`
+	NotificationIDs []string               `json:"notification_ids"`
+	CanceledIDs     []string               `json:"canceled_ids,omitempty"`
+	Filters         []map[string]string    `json:"filters"`
+	Options         []types.DevicePlatform `json:"options,omitempty"`
+	Schedule        *Schedule              `json:"schedule,omitempty"`
+	ContentHash     string                 `json:"content_hash"`
+	Recipients      int                    `json:"recipients"`
+	Status          Status                 `json:"status"`
+	Stats           notification.PushStats `json:"stats"`
+	Error           string                 `json:"error,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+	PolledAt        *time.Time             `json:"polled_at,omitempty"`
+}
+
+// Cancelable returns whether c is scheduled and can be canceled.
+func (c *Campaign) Cancelable() bool {
+	return c.Status == StatusScheduled && c.Schedule != nil &&
+		len(c.NotificationIDs) > 0
+}
+
+// uncanceledIDs returns notification IDs which aren't canceled yet.
+func (c *Campaign) uncanceledIDs() []string {
+	canceled := make(map[string]bool, len(c.CanceledIDs))
+	for _, id := range c.CanceledIDs {
+		canceled[id] = true
+	}
+	var ids []string
+	for _, id := range c.NotificationIDs {
+		if !canceled[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// sendAfter returns the time c is supposed to be sent.
+func (c *Campaign) sendAfter() time.Time {
+	if c.Schedule != nil && c.Schedule.SendAfter.After(c.CreatedAt) {
+		return c.Schedule.SendAfter
+	}
+	return c.CreatedAt
+}
+
+// refresh updates c by stats polled at now.
+func (c *Campaign) refresh(stats *notification.PushStats, now time.Time) {
+	c.Stats = *stats
+	c.PolledAt = &now
+	c.UpdatedAt = now
+	switch {
+	case stats.Canceled:
+		c.Status = StatusCanceled
+	case stats.Completed():
+		c.Status = StatusCompleted
+	case stats.SendAfter.After(now):
+		c.Status = StatusScheduled
+	default:
+		c.Status = StatusSending
+	}
+}
+
+// ContentHash returns the hash of contents of req, which identifies
+// campaigns sending the same message.
+func ContentHash(req *notification.BatchAppRequest) string {
+	data, _ := json.Marshal(struct {
+		Subjects   map[types.OneSignalLanguage]string `json:"subjects"`
+		Contents   map[types.OneSignalLanguage]string `json:"contents"`
+		ReturnData interface{}                        `json:"return_data"`
+		URL        *string                            `json:"url"`
+	}{req.Subjects, req.Contents, req.ReturnData, req.URL})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,298 @@
+package campaign
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/types"
+)
+
+type fakePusher struct {
+	ids        []string
+	recipients int
+	err        error
+	stats      map[string]*notification.PushStats
+	canceled   []string
+	cancelErr  error
+	// cancelFail fails cancelling of the chunks.
+	cancelFail map[string]error
+	// onView is called by ViewNotification if it's set.
+	onView func()
+}
+
+func (p *fakePusher) ServiceName() string {
+	return notification.OnesignalSymbol
+}
+
+func (p *fakePusher) BatchSend(ctx context.Context,
+	req *notification.BatchAppRequest) ([]string, int, error) {
+	return p.ids, p.recipients, p.err
+}
+
+func (p *fakePusher) ViewNotification(ctx context.Context,
+	notificationIDs []string) (*notification.PushStats, error) {
+	if p.onView != nil {
+		p.onView()
+	}
+	stats, ok := p.stats[strings.Join(notificationIDs, ",")]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	copied := *stats
+	return &copied, nil
+}
+
+func (p *fakePusher) CancelNotification(ctx context.Context,
+	notificationIDs []string) error {
+	if p.cancelErr != nil {
+		return p.cancelErr
+	}
+	var canceled []string
+	var err error
+	for _, id := range notificationIDs {
+		if failErr, ok := p.cancelFail[id]; ok {
+			err = failErr
+			continue
+		}
+		canceled = append(canceled, id)
+	}
+	if len(canceled) == 0 {
+		return err
+	}
+	p.canceled = append(p.canceled, strings.Join(canceled, ","))
+	if err != nil {
+		return &notification.PushCancelError{Canceled: canceled, Err: err}
+	}
+	return nil
+}
+
+type CampaignTestSuite struct {
+	suite.Suite
+
+	pusher  *fakePusher
+	tracker *Tracker
+	now     time.Time
+}
+
+func (s *CampaignTestSuite) SetupTest() {
+	s.pusher = &fakePusher{
+		ids:        []string{"n1", "n2"},
+		recipients: 300,
+		stats:      make(map[string]*notification.PushStats),
+	}
+	s.tracker = NewTracker(s.pusher, NewMemoryStore(), nil)
+	s.now = time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+	s.tracker.now = func() time.Time { return s.now }
+}
+
+func (s *CampaignTestSuite) request(sendAfter *time.Time) *notification.BatchAppRequest {
+	req := &notification.BatchAppRequest{
+		Subjects: map[types.OneSignalLanguage]string{types.English: "Hi"},
+		Contents: map[types.OneSignalLanguage]string{types.English: "News"},
+		Filters:  notification.NewTagFilters([]string{"a", "b"}),
+	}
+	if sendAfter != nil {
+		req.Schedule = &struct {
+			SendAfter   time.Time
+			IsOptimized bool
+		}{SendAfter: *sendAfter}
+	}
+	return req
+}
+
+func (s *CampaignTestSuite) TestContentHash() {
+	req := s.request(nil)
+	hash := ContentHash(req)
+	req.Filters = nil
+	s.Require().Equal(hash, ContentHash(req))
+	req.Contents[types.English] = "Other"
+	s.Require().NotEqual(hash, ContentHash(req))
+}
+
+func (s *CampaignTestSuite) TestSend() {
+	c, err := s.tracker.Send(context.Background(), "news", s.request(nil))
+	s.Require().NoError(err)
+	s.Require().Equal(StatusSending, c.Status)
+	s.Require().Equal([]string{"n1", "n2"}, c.NotificationIDs)
+	s.Require().Equal(300, c.Recipients)
+	s.Require().Equal(ContentHash(s.request(nil)), c.ContentHash)
+
+	stored, err := s.tracker.Campaign(c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(c.Filters, stored.Filters)
+	pending, err := s.tracker.Store().Pending()
+	s.Require().NoError(err)
+	s.Require().Len(pending, 1)
+
+	// Rejected campaigns are stored as failed.
+	s.pusher.ids, s.pusher.err = nil, errors.New("invalid filters")
+	c, err = s.tracker.Send(context.Background(), "bad", s.request(nil))
+	s.Require().Equal(s.pusher.err, err)
+	s.Require().Equal(StatusFailed, c.Status)
+	s.Require().Equal("invalid filters", c.Error)
+
+	// Partially sent campaigns are still tracked.
+	s.pusher.ids = []string{"n3"}
+	c, err = s.tracker.Send(context.Background(), "partial", s.request(nil))
+	s.Require().Error(err)
+	s.Require().Equal(StatusSending, c.Status)
+
+	campaigns, err := s.tracker.Store().Campaigns(2)
+	s.Require().NoError(err)
+	s.Require().Len(campaigns, 2)
+	pending, err = s.tracker.Store().Pending()
+	s.Require().NoError(err)
+	s.Require().Len(pending, 2)
+}
+
+func (s *CampaignTestSuite) TestPoll() {
+	c, err := s.tracker.Send(context.Background(), "news", s.request(nil))
+	s.Require().NoError(err)
+
+	s.pusher.stats["n1,n2"] = &notification.PushStats{
+		Successful: 100,
+		Remaining:  200,
+	}
+	s.now = s.now.Add(time.Minute)
+	s.Require().NoError(s.tracker.Poll(context.Background()))
+	c, err = s.tracker.Campaign(c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusSending, c.Status)
+	s.Require().Equal(100, c.Stats.Successful)
+	s.Require().Equal(s.now, c.PolledAt.UTC())
+
+	s.pusher.stats["n1,n2"] = &notification.PushStats{
+		Successful:  280,
+		Failed:      20,
+		Converted:   12,
+		CompletedAt: s.now,
+	}
+	c, err = s.tracker.Refresh(context.Background(), c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusCompleted, c.Status)
+	s.Require().Equal(12, c.Stats.Converted)
+	pending, err := s.tracker.Store().Pending()
+	s.Require().NoError(err)
+	s.Require().Empty(pending)
+
+	// Final campaigns aren't polled anymore.
+	delete(s.pusher.stats, "n1,n2")
+	_, err = s.tracker.Refresh(context.Background(), c.ID)
+	s.Require().NoError(err)
+}
+
+func (s *CampaignTestSuite) TestExpire() {
+	c, err := s.tracker.Send(context.Background(), "news", s.request(nil))
+	s.Require().NoError(err)
+	s.pusher.stats["n1,n2"] = &notification.PushStats{Remaining: 300}
+
+	s.now = s.now.Add(DefaultOption().MaxAge + time.Minute)
+	c, err = s.tracker.Refresh(context.Background(), c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusExpired, c.Status)
+
+	// Campaigns are expired even if polling fails.
+	c, err = s.tracker.Send(context.Background(), "news", s.request(nil))
+	s.Require().NoError(err)
+	delete(s.pusher.stats, "n1,n2")
+	_, err = s.tracker.Refresh(context.Background(), c.ID)
+	s.Require().Error(err)
+	s.now = s.now.Add(DefaultOption().MaxAge + time.Minute)
+	c, err = s.tracker.Refresh(context.Background(), c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusExpired, c.Status)
+}
+
+func (s *CampaignTestSuite) TestCancelWhilePolling() {
+	sendAfter := s.now.Add(time.Hour)
+	c, err := s.tracker.Send(
+		context.Background(), "later", s.request(&sendAfter))
+	s.Require().NoError(err)
+	s.pusher.stats["n1,n2"] = &notification.PushStats{
+		Remaining: 300,
+		SendAfter: sendAfter,
+	}
+
+	// The campaign is canceled while the pusher is polled, which doesn't
+	// block on the tracker, and the stale stats are dropped.
+	var canceled *Campaign
+	s.pusher.onView = func() {
+		s.pusher.onView = nil
+		canceled, err = s.tracker.Cancel(context.Background(), c.ID)
+	}
+	s.Require().NoError(s.tracker.Poll(context.Background()))
+	s.Require().NoError(err)
+	s.Require().Equal(StatusCanceled, canceled.Status)
+	c, err = s.tracker.Campaign(c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusCanceled, c.Status)
+	s.Require().Nil(c.PolledAt)
+}
+
+func (s *CampaignTestSuite) TestCancel() {
+	sendAfter := s.now.Add(time.Hour)
+	c, err := s.tracker.Send(
+		context.Background(), "later", s.request(&sendAfter))
+	s.Require().NoError(err)
+	s.Require().Equal(StatusScheduled, c.Status)
+	s.Require().True(c.Cancelable())
+
+	s.pusher.stats["n1,n2"] = &notification.PushStats{
+		Remaining: 300,
+		SendAfter: sendAfter,
+	}
+	c, err = s.tracker.Refresh(context.Background(), c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusScheduled, c.Status)
+
+	s.pusher.cancelErr = notification.ErrPushNotCancelable
+	_, err = s.tracker.Cancel(context.Background(), c.ID)
+	s.Require().Equal(ErrNotCancelable, err)
+
+	s.pusher.cancelErr = nil
+	c, err = s.tracker.Cancel(context.Background(), c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusCanceled, c.Status)
+	s.Require().Equal([]string{"n1,n2"}, s.pusher.canceled)
+
+	_, err = s.tracker.Cancel(context.Background(), c.ID)
+	s.Require().Equal(ErrNotCancelable, err)
+
+	// Campaigns sent immediately can't be canceled.
+	c, err = s.tracker.Send(context.Background(), "now", s.request(nil))
+	s.Require().NoError(err)
+	_, err = s.tracker.Cancel(context.Background(), c.ID)
+	s.Require().Equal(ErrNotCancelable, err)
+}
+
+func (s *CampaignTestSuite) TestCancelPartially() {
+	sendAfter := s.now.Add(time.Hour)
+	c, err := s.tracker.Send(
+		context.Background(), "later", s.request(&sendAfter))
+	s.Require().NoError(err)
+
+	unavailable := errors.New("unavailable")
+	s.pusher.cancelFail = map[string]error{"n2": unavailable}
+	c, err = s.tracker.Cancel(context.Background(), c.ID)
+	s.Require().True(errors.Is(err, unavailable))
+	s.Require().Equal(StatusScheduled, c.Status)
+	s.Require().Equal([]string{"n1"}, c.CanceledIDs)
+
+	// Only the other chunks are retried.
+	s.pusher.cancelFail = nil
+	c, err = s.tracker.Cancel(context.Background(), c.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusCanceled, c.Status)
+	s.Require().Equal([]string{"n1", "n2"}, c.CanceledIDs)
+	s.Require().Equal([]string{"n1", "n2"}, s.pusher.canceled)
+}
+
+func TestCampaign(t *testing.T) {
+	suite.Run(t, new(CampaignTestSuite))
+}
@@ -0,0 +1,106 @@
+package campaign
+
+import (
+	"strconv"
+
+	"github.com/satori/go.uuid"
+
+	apicontext "github.com/jiarung/mochi/common/api/context"
+	apierrors "github.com/jiarung/mochi/common/api/errors"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+func (t *Tracker) campaignID(appCtx *apicontext.AppContext) (
+	id uuid.UUID, ok bool) {
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	id = uuid.FromStringOrNil(appCtx.Param("campaign_id"))
+	if uuid.Equal(uuid.Nil, id) {
+		appCtx.SetError(apierrors.ParameterError)
+		return
+	}
+	ok = true
+	return
+}
+
+func (t *Tracker) setError(appCtx *apicontext.AppContext, err error) {
+	switch err {
+	case ErrCampaignNotFound:
+		appCtx.SetError(apierrors.PushCampaignNotFound)
+	case ErrNotCancelable:
+		appCtx.SetError(apierrors.PushCampaignNotCancelable)
+	default:
+		appCtx.Logger().Error("push campaign err: %v", err)
+		appCtx.SetError(apierrors.UnexpectedError)
+	}
+}
+
+// ListCampaignsHandler lists the latest campaigns. The number of campaigns
+// is given by query limit.
+// /v1/crm/push-campaigns [GET]
+func (t *Tracker) ListCampaignsHandler(appCtx *apicontext.AppContext) {
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	limit := defaultListLimit
+	if limitStr, exists := appCtx.GetQuery("limit"); exists {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			appCtx.SetError(apierrors.ParameterError)
+			return
+		}
+	}
+	campaigns, err := t.store.Campaigns(limit)
+	if err != nil {
+		t.setError(appCtx, err)
+		return
+	}
+	if campaigns == nil {
+		campaigns = []*Campaign{}
+	}
+	appCtx.SetJSON(campaigns)
+}
+
+// GetCampaignHandler returns a campaign. Its delivery stats are polled from
+// the provider if query refresh is true, otherwise the last polled stats
+// are returned.
+// /v1/crm/push-campaigns/:campaign_id [GET]
+func (t *Tracker) GetCampaignHandler(appCtx *apicontext.AppContext) {
+	id, ok := t.campaignID(appCtx)
+	if !ok {
+		return
+	}
+	var c *Campaign
+	var err error
+	if refresh, _ := strconv.ParseBool(appCtx.Query("refresh")); refresh {
+		c, err = t.Refresh(appCtx, id)
+	} else {
+		c, err = t.Campaign(id)
+	}
+	if err != nil {
+		t.setError(appCtx, err)
+		return
+	}
+	appCtx.SetJSON(c)
+}
+
+// CancelCampaignHandler cancels a scheduled campaign.
+// /v1/crm/push-campaigns/:campaign_id/cancel [POST]
+func (t *Tracker) CancelCampaignHandler(appCtx *apicontext.AppContext) {
+	id, ok := t.campaignID(appCtx)
+	if !ok {
+		return
+	}
+	c, err := t.Cancel(appCtx, id)
+	if err != nil {
+		t.setError(appCtx, err)
+		return
+	}
+	appCtx.SetJSON(c)
+}
@@ -0,0 +1,225 @@
+package campaign
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/cache"
+)
+
+// Store stores campaigns.
+type Store interface {
+	// Campaign returns campaign id, or ErrCampaignNotFound.
+	Campaign(id uuid.UUID) (*Campaign, error)
+	// Campaigns returns the latest limit campaigns, latest first.
+	Campaigns(limit int) ([]*Campaign, error)
+	// Pending returns campaigns whose status isn't final.
+	Pending() ([]*Campaign, error)
+	SaveCampaign(c *Campaign) error
+}
+
+func sortCampaigns(campaigns []*Campaign) {
+	sort.Slice(campaigns, func(i, j int) bool {
+		return campaigns[i].CreatedAt.After(campaigns[j].CreatedAt)
+	})
+}
+
+func unmarshalCampaigns(values [][]byte) ([]*Campaign, error) {
+	campaigns := make([]*Campaign, 0, len(values))
+	for _, data := range values {
+		c := &Campaign{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, nil
+}
+
+// memoryStore keeps campaigns in memory.
+type memoryStore struct {
+	mutex     sync.Mutex
+	campaigns map[uuid.UUID][]byte
+	pending   map[uuid.UUID]bool
+}
+
+// NewMemoryStore returns a store which keeps every campaign in memory and
+// never evicts finished ones, so it's only for tests and local development.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		campaigns: make(map[uuid.UUID][]byte),
+		pending:   make(map[uuid.UUID]bool),
+	}
+}
+
+func (s *memoryStore) Campaign(id uuid.UUID) (*Campaign, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.campaigns[id]
+	if !ok {
+		return nil, ErrCampaignNotFound
+	}
+	c := &Campaign{}
+	return c, json.Unmarshal(data, c)
+}
+
+func (s *memoryStore) Campaigns(limit int) ([]*Campaign, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	s.mutex.Lock()
+	values := make([][]byte, 0, len(s.campaigns))
+	for _, data := range s.campaigns {
+		values = append(values, data)
+	}
+	s.mutex.Unlock()
+
+	campaigns, err := unmarshalCampaigns(values)
+	if err != nil {
+		return nil, err
+	}
+	sortCampaigns(campaigns)
+	if len(campaigns) > limit {
+		campaigns = campaigns[:limit]
+	}
+	return campaigns, nil
+}
+
+func (s *memoryStore) Pending() ([]*Campaign, error) {
+	s.mutex.Lock()
+	values := make([][]byte, 0, len(s.pending))
+	for id := range s.pending {
+		values = append(values, s.campaigns[id])
+	}
+	s.mutex.Unlock()
+
+	campaigns, err := unmarshalCampaigns(values)
+	if err != nil {
+		return nil, err
+	}
+	sortCampaigns(campaigns)
+	return campaigns, nil
+}
+
+func (s *memoryStore) SaveCampaign(c *Campaign) error {
+	// Keep a copy so callers can't modify stored campaigns.
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.campaigns[c.ID] = data
+	if c.Status.Final() {
+		delete(s.pending, c.ID)
+	} else {
+		s.pending[c.ID] = true
+	}
+	return nil
+}
+
+// redisStore keeps campaigns in a hash, indexed by a sorted set of creation
+// time and a set of pending campaigns.
+type redisStore struct {
+	redis *cache.Redis
+}
+
+// NewRedisStore returns a store backed by redis.
+func NewRedisStore(redis *cache.Redis) Store {
+	return &redisStore{redis: redis}
+}
+
+const (
+	redisCampaignsKey = "notification:campaign:campaigns"
+	redisIndexKey     = "notification:campaign:index"
+	redisPendingKey   = "notification:campaign:pending"
+)
+
+func (s *redisStore) Campaign(id uuid.UUID) (*Campaign, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	data, err := redis.Bytes(rCli.Do("HGET", redisCampaignsKey, id.String()))
+	if err == redis.ErrNil {
+		return nil, ErrCampaignNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	c := &Campaign{}
+	return c, json.Unmarshal(data, c)
+}
+
+// campaigns returns campaigns of ids, skipping missing ones.
+func (s *redisStore) campaigns(ids []string) ([]*Campaign, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	rCli, release := s.redis.GetConn()
+	defer release()
+	args := redis.Args{}.Add(redisCampaignsKey).AddFlat(ids)
+	values, err := redis.ByteSlices(rCli.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	existing := values[:0]
+	for _, data := range values {
+		if data != nil {
+			existing = append(existing, data)
+		}
+	}
+	return unmarshalCampaigns(existing)
+}
+
+func (s *redisStore) Campaigns(limit int) ([]*Campaign, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	rCli, release := s.redis.GetConn()
+	ids, err := redis.Strings(rCli.Do("ZREVRANGE", redisIndexKey, 0, limit-1))
+	release()
+	if err != nil {
+		return nil, err
+	}
+	return s.campaigns(ids)
+}
+
+func (s *redisStore) Pending() ([]*Campaign, error) {
+	rCli, release := s.redis.GetConn()
+	ids, err := redis.Strings(rCli.Do("SMEMBERS", redisPendingKey))
+	release()
+	if err != nil {
+		return nil, err
+	}
+	campaigns, err := s.campaigns(ids)
+	if err != nil {
+		return nil, err
+	}
+	sortCampaigns(campaigns)
+	return campaigns, nil
+}
+
+func (s *redisStore) SaveCampaign(c *Campaign) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	id := c.ID.String()
+	rCli, release := s.redis.GetConn()
+	defer release()
+	if _, err = rCli.Do("HSET", redisCampaignsKey, id, data); err != nil {
+		return err
+	}
+	if _, err = rCli.Do(
+		"ZADD", redisIndexKey, c.CreatedAt.Unix(), id); err != nil {
+		return err
+	}
+	if c.Status.Final() {
+		_, err = rCli.Do("SREM", redisPendingKey, id)
+	} else {
+		_, err = rCli.Do("SADD", redisPendingKey, id)
+	}
+	return err
+}
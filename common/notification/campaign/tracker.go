@@ -0,0 +1,272 @@
+package campaign
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+	"github.com/jiarung/mochi/common/notification"
+)
+
+var campaignsTotal = metric.NewCounter("notification_campaign",
+	"campaigns_total", "Total number of push campaigns by final status.",
+	"status")
+
+// Pusher defines the push service of campaigns, which is implemented by
+// *notification.AppStruct.
+type Pusher interface {
+	ServiceName() string
+	BatchSend(ctx context.Context, req *notification.BatchAppRequest) (
+		notificationIDs []string, recipients int, err error)
+	ViewNotification(ctx context.Context, notificationIDs []string) (
+		*notification.PushStats, error)
+	CancelNotification(ctx context.Context, notificationIDs []string) error
+}
+
+// Option defines the options of Tracker.
+type Option struct {
+	// PollInterval is the interval of Run polling pending campaigns.
+	PollInterval time.Duration
+	// MaxAge is the age of a pending campaign, after its schedule, to stop
+	// polling it.
+	MaxAge time.Duration
+}
+
+// DefaultOption returns the default option.
+func DefaultOption() *Option {
+	return &Option{
+		PollInterval: time.Minute,
+		MaxAge:       72 * time.Hour,
+	}
+}
+
+// Tracker sends batch notifications as campaigns and tracks their delivery
+// outcomes.
+type Tracker struct {
+	opt    Option
+	pusher Pusher
+	store  Store
+	logger logging.Logger
+	now    func() time.Time
+
+	// mutex serializes updates of campaigns by polling and cancelling. It
+	// isn't held while calling the pusher, so campaigns are reloaded before
+	// updates.
+	mutex sync.Mutex
+}
+
+// NewTracker returns a tracker sending campaigns by pusher.
+func NewTracker(pusher Pusher, store Store, opt *Option) *Tracker {
+	if opt == nil {
+		opt = DefaultOption()
+	}
+	return &Tracker{
+		opt:    *opt,
+		pusher: pusher,
+		store:  store,
+		logger: logging.NewLoggerTag("notification:campaign"),
+		now:    time.Now,
+	}
+}
+
+// Store returns the store of t.
+func (t *Tracker) Store() Store {
+	return t.store
+}
+
+// save saves c, and counts it if its status becomes final.
+func (t *Tracker) save(c *Campaign, prev Status) error {
+	if err := t.store.SaveCampaign(c); err != nil {
+		return err
+	}
+	if c.Status.Final() && !prev.Final() {
+		campaignsTotal.WithLabelValues(string(c.Status)).Inc()
+	}
+	return nil
+}
+
+// Send sends req by the pusher, and stores it as campaign name. The campaign
+// is stored as failed if the pusher rejects it, and the error is returned
+// along with the campaign.
+func (t *Tracker) Send(ctx context.Context, name string,
+	req *notification.BatchAppRequest) (*Campaign, error) {
+	now := t.now()
+	c := &Campaign{
+		ID:          uuid.NewV4(),
+		Name:        name,
+		Provider:    t.pusher.ServiceName(),
+		Filters:     req.Filters,
+		Options:     req.Options,
+		ContentHash: ContentHash(req),
+		Status:      StatusSending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if req.Schedule != nil {
+		c.Schedule = &Schedule{
+			SendAfter:   req.Schedule.SendAfter,
+			IsOptimized: req.Schedule.IsOptimized,
+		}
+		if req.Schedule.SendAfter.After(now) {
+			c.Status = StatusScheduled
+		}
+	}
+
+	ids, recipients, sendErr := t.pusher.BatchSend(ctx, req)
+	// Chunks sent before an error are still tracked.
+	c.NotificationIDs = ids
+	c.Recipients = recipients
+	switch {
+	case sendErr != nil && len(c.NotificationIDs) == 0:
+		c.Status = StatusFailed
+		c.Error = sendErr.Error()
+	case sendErr != nil:
+		c.Error = sendErr.Error()
+	case len(c.NotificationIDs) == 0:
+		// Nothing is sent in environments skipping notifications.
+		c.Status = StatusCompleted
+	}
+	if err := t.save(c, ""); err != nil {
+		return nil, err
+	}
+	return c, sendErr
+}
+
+// Campaign returns campaign id.
+func (t *Tracker) Campaign(id uuid.UUID) (*Campaign, error) {
+	return t.store.Campaign(id)
+}
+
+// Refresh polls the delivery stats of campaign id, and returns it.
+// Campaigns of final status are returned as is.
+func (t *Tracker) Refresh(ctx context.Context, id uuid.UUID) (
+	*Campaign, error) {
+	return t.refresh(ctx, id)
+}
+
+func (t *Tracker) refresh(ctx context.Context, id uuid.UUID) (
+	*Campaign, error) {
+	c, err := t.store.Campaign(id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status.Final() {
+		return c, nil
+	}
+	now := t.now()
+	// Campaigns are expired even if the pusher fails, so they aren't polled
+	// forever.
+	expired := now.Sub(c.sendAfter()) > t.opt.MaxAge
+	stats, viewErr := t.pusher.ViewNotification(ctx, c.NotificationIDs)
+	if viewErr != nil {
+		if !expired {
+			return c, viewErr
+		}
+		t.logger.Warn("failed to poll expired campaign %s: %v", id, viewErr)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// Reload c, since it may be canceled while polling.
+	if c, err = t.store.Campaign(id); err != nil {
+		return nil, err
+	}
+	if c.Status.Final() {
+		return c, nil
+	}
+	prev := c.Status
+	if stats != nil {
+		c.refresh(stats, now)
+	}
+	if expired && !c.Status.Final() {
+		c.Status = StatusExpired
+		c.UpdatedAt = now
+	}
+	return c, t.save(c, prev)
+}
+
+// Poll refreshes all pending campaigns. Errors of campaigns are logged, and
+// the others are still polled.
+func (t *Tracker) Poll(ctx context.Context) error {
+	campaigns, err := t.store.Pending()
+	if err != nil {
+		return err
+	}
+	for _, c := range campaigns {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := t.refresh(ctx, c.ID); err != nil {
+			t.logger.Warn("failed to poll campaign %s: %v", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// Run polls pending campaigns every PollInterval until ctx is done.
+func (t *Tracker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.opt.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := t.Poll(ctx); err != nil && ctx.Err() == nil {
+				t.logger.Error("failed to poll campaigns: %v", err)
+			}
+		}
+	}
+}
+
+// Cancel cancels the scheduled campaign id, or returns ErrNotCancelable.
+// If only some chunks are canceled, their IDs are added to CanceledIDs of
+// the campaign, which is returned along with a *notification.PushCancelError.
+// The campaign is still scheduled, and cancelling it again retries the other
+// chunks.
+func (t *Tracker) Cancel(ctx context.Context, id uuid.UUID) (
+	*Campaign, error) {
+	c, err := t.store.Campaign(id)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Cancelable() || !c.Schedule.SendAfter.After(t.now()) {
+		return nil, ErrNotCancelable
+	}
+	pending := c.uncanceledIDs()
+	canceled := pending
+	cancelErr := t.pusher.CancelNotification(ctx, pending)
+	if cancelErr != nil {
+		var partial *notification.PushCancelError
+		if !errors.As(cancelErr, &partial) {
+			if cancelErr == notification.ErrPushNotCancelable {
+				cancelErr = ErrNotCancelable
+			}
+			return nil, cancelErr
+		}
+		canceled = partial.Canceled
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// Reload c, since it may be polled while cancelling.
+	if c, err = t.store.Campaign(id); err != nil {
+		return nil, err
+	}
+	prev := c.Status
+	c.CanceledIDs = append(c.CanceledIDs, canceled...)
+	c.UpdatedAt = t.now()
+	if cancelErr == nil {
+		c.Status = StatusCanceled
+		c.Stats.Canceled = true
+	}
+	if err = t.save(c, prev); err != nil {
+		return nil, err
+	}
+	return c, cancelErr
+}
@@ -0,0 +1,343 @@
+package chatops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/types"
+)
+
+// Platform defines the chat platform of requests.
+type Platform string
+
+// Supported platforms.
+const (
+	PlatformSlack Platform = "slack"
+	PlatformLINE  Platform = "line"
+)
+
+// Errors of the bot.
+var (
+	ErrDuplicateCommand = errors.New("duplicate chat-ops command")
+	ErrInvalidCommand   = errors.New("invalid chat-ops command")
+)
+
+// Request is a parsed command.
+type Request struct {
+	Platform Platform
+	// UserID and Channel are the IDs of the chat platform.
+	UserID  string
+	Channel string
+	Command string
+	Args    []string
+	// Flags are set by --name=value or --name arguments.
+	Flags map[string]string
+	Roles []types.Role
+
+	command *Command
+}
+
+// Arg returns the positional argument of name defined by the command.
+func (r *Request) Arg(name string) string {
+	for i, argName := range r.command.Args {
+		if argName == name && i < len(r.Args) {
+			return r.Args[i]
+		}
+	}
+	return ""
+}
+
+// Field is a name and value pair of responses.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Response is the result of a command, which adapters render in the message
+// format of each platform.
+type Response struct {
+	Text   string
+	Fields []Field
+	// Public shows the response to the channel on Slack instead of the
+	// caller only.
+	Public bool
+}
+
+// String renders r in plain text.
+func (r *Response) String() string {
+	lines := []string{r.Text}
+	for _, f := range r.Fields {
+		lines = append(lines, fmt.Sprintf("%s: %s", f.Name, f.Value))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Handler runs a command.
+type Handler func(ctx context.Context, req *Request) (*Response, error)
+
+// Command defines a chat-ops command.
+type Command struct {
+	// Name is the command name without "/".
+	Name        string
+	Description string
+	// Args are the names of required positional arguments. Extra arguments
+	// are rejected unless Variadic.
+	Args     []string
+	Variadic bool
+	// Scopes authorize callers having any of the scopes by their roles,
+	// like scope-auth of APIs. Commands without scopes are denied, public
+	// commands should have types.ScopePublic.
+	Scopes  []types.Scope
+	Handler Handler
+}
+
+// Usage returns the usage of c.
+func (c *Command) Usage() string {
+	parts := []string{"/" + c.Name}
+	for _, arg := range c.Args {
+		parts = append(parts, "<"+arg+">")
+	}
+	if c.Variadic {
+		parts = append(parts, "...")
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *Command) authorized(roles []types.Role) bool {
+	required := make(map[types.Scope]bool)
+	for _, s := range c.Scopes {
+		if s == types.ScopePublic {
+			return true
+		}
+		required[s] = true
+	}
+	for _, role := range roles {
+		for _, s := range types.GetScopesOfRole(role) {
+			if required[s] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Directory resolves the roles of chat users.
+type Directory interface {
+	Roles(platform Platform, userID string) ([]types.Role, error)
+}
+
+// StaticDirectory maps "<platform>:<user ID>" to roles, e.g.
+//
+//	{"slack:U024BE7LH": ["cs_member"]}
+type StaticDirectory map[string][]types.Role
+
+// Roles returns the roles of userID.
+func (d StaticDirectory) Roles(platform Platform, userID string) (
+	[]types.Role, error) {
+	return d[string(platform)+":"+userID], nil
+}
+
+// DirectoryFromEnv returns the static directory in JSON of CHATOPS_USERS.
+func DirectoryFromEnv() (StaticDirectory, error) {
+	d := StaticDirectory{}
+	if v := os.Getenv("CHATOPS_USERS"); len(v) > 0 {
+		if err := json.Unmarshal([]byte(v), &d); err != nil {
+			return nil, fmt.Errorf("parse CHATOPS_USERS: %v", err)
+		}
+	}
+	return d, nil
+}
+
+// Bot dispatches commands to handlers.
+type Bot struct {
+	// Timeout is the timeout of a command.
+	Timeout time.Duration
+
+	directory Directory
+	logger    logging.Logger
+
+	mutex    sync.RWMutex
+	commands map[string]*Command
+}
+
+// NewBot returns a bot authorizing users by directory, with the help
+// command registered.
+func NewBot(directory Directory) *Bot {
+	b := &Bot{
+		Timeout:   10 * time.Second,
+		directory: directory,
+		logger:    logging.NewLoggerTag("notification:chatops"),
+		commands:  make(map[string]*Command),
+	}
+	b.Register(&Command{
+		Name:        "help",
+		Description: "List available commands.",
+		Scopes:      []types.Scope{types.ScopePublic},
+		Handler:     b.help,
+	})
+	return b
+}
+
+// Register adds cmd.
+func (b *Bot) Register(cmd *Command) error {
+	if len(cmd.Name) == 0 || strings.ContainsAny(cmd.Name, " /") ||
+		cmd.Handler == nil {
+		return ErrInvalidCommand
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.commands[cmd.Name]; ok {
+		return ErrDuplicateCommand
+	}
+	b.commands[cmd.Name] = cmd
+	return nil
+}
+
+// Command returns the command of name, or nil if it doesn't exist.
+func (b *Bot) Command(name string) *Command {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.commands[name]
+}
+
+func (b *Bot) help(ctx context.Context, req *Request) (*Response, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	resp := &Response{Text: "Available commands:"}
+	for _, cmd := range b.commands {
+		if cmd.authorized(req.Roles) {
+			resp.Fields = append(resp.Fields,
+				Field{Name: cmd.Usage(), Value: cmd.Description})
+		}
+	}
+	sort.Slice(resp.Fields, func(i, j int) bool {
+		return resp.Fields[i].Name < resp.Fields[j].Name
+	})
+	return resp, nil
+}
+
+// Split splits text into arguments by spaces. Arguments can be quoted by
+// single or double quotes.
+func Split(text string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range text {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// parse parses text into req.
+func parse(text string, req *Request) error {
+	args, err := Split(strings.TrimPrefix(strings.TrimSpace(text), "/"))
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return ErrInvalidCommand
+	}
+	req.Command = strings.ToLower(args[0])
+	req.Flags = make(map[string]string)
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			req.Args = append(req.Args, arg)
+			continue
+		}
+		kv := strings.SplitN(arg[2:], "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "true")
+		}
+		req.Flags[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// Handle runs the command in text sent by userID of platform, and returns
+// the response to reply. Errors are replied as responses.
+func (b *Bot) Handle(ctx context.Context, platform Platform, userID,
+	channel, text string) *Response {
+	req := &Request{Platform: platform, UserID: userID, Channel: channel}
+	if err := parse(text, req); err != nil {
+		return &Response{Text: fmt.Sprintf("Invalid command: %v", err)}
+	}
+	cmd := b.Command(req.Command)
+	if cmd == nil {
+		return &Response{Text: fmt.Sprintf(
+			"Unknown command /%s. Try /help.", req.Command)}
+	}
+	req.command = cmd
+
+	roles, err := b.directory.Roles(platform, userID)
+	if err != nil {
+		b.logger.Error("get roles of %s user<%s> err: %v", platform, userID, err)
+		return &Response{Text: "Failed to authorize, please try again later."}
+	}
+	req.Roles = roles
+	if !cmd.authorized(roles) {
+		b.logger.Warn("%s user<%s> isn't allowed to run %s", platform, userID, text)
+		return &Response{Text: fmt.Sprintf(
+			"You are not allowed to run /%s.", cmd.Name)}
+	}
+	if len(req.Args) < len(cmd.Args) ||
+		(!cmd.Variadic && len(req.Args) > len(cmd.Args)) {
+		return &Response{Text: "Usage: " + cmd.Usage()}
+	}
+
+	b.logger.Info("%s user<%s> in <%s> runs %s", platform, userID, channel, text)
+	ctx, cancel := context.WithTimeout(ctx, b.Timeout)
+	defer cancel()
+	resp, err := b.run(ctx, cmd, req)
+	if err != nil {
+		b.logger.Warn("run %s err: %v", text, err)
+		return &Response{Text: fmt.Sprintf("/%s failed: %v", cmd.Name, err)}
+	}
+	if resp == nil {
+		resp = &Response{Text: "Done."}
+	}
+	return resp
+}
+
+func (b *Bot) run(ctx context.Context, cmd *Command, req *Request) (
+	resp *Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Critical("/%s panic: %v", cmd.Name, r)
+			resp, err = nil, fmt.Errorf("internal error")
+		}
+	}()
+	return cmd.Handler(ctx, req)
+}
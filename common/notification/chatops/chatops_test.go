@@ -0,0 +1,293 @@
+package chatops
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/types"
+)
+
+type ChatOpsTestSuite struct {
+	suite.Suite
+
+	bot      *Bot
+	router   *gin.Engine
+	replies  map[string]string
+	released chan struct{}
+}
+
+func (s *ChatOpsTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	s.bot = NewBot(StaticDirectory{
+		"slack:admin": {types.RoleKYCAuditor},
+		"line:admin":  {types.RoleKYCAuditor},
+	})
+	s.Require().NoError(s.bot.Register(&Command{
+		Name:        "jail",
+		Description: "Show jail status of an IP.",
+		Args:        []string{"ip"},
+		Scopes:      types.GetScopesOfRole(types.RoleKYCAuditor),
+		Handler: func(ctx context.Context, req *Request) (*Response, error) {
+			return &Response{
+				Text:   "IP " + req.Arg("ip"),
+				Fields: []Field{{Name: "jailed", Value: req.Flags["jailed"]}},
+			}, nil
+		},
+	}))
+	s.Require().NoError(s.bot.Register(&Command{
+		Name:     "echo",
+		Variadic: true,
+		Scopes:   []types.Scope{types.ScopePublic},
+		Handler: func(ctx context.Context, req *Request) (*Response, error) {
+			if len(req.Args) == 0 {
+				return nil, errors.New("nothing to echo")
+			}
+			return &Response{Text: strings.Join(req.Args, "|"), Public: true}, nil
+		},
+	}))
+	s.released = make(chan struct{})
+	s.Require().NoError(s.bot.Register(&Command{
+		Name:   "slow",
+		Scopes: []types.Scope{types.ScopePublic},
+		Handler: func(ctx context.Context, req *Request) (*Response, error) {
+			<-s.released
+			return &Response{Text: "finally"}, nil
+		},
+	}))
+	s.Require().NoError(s.bot.Register(&Command{
+		Name:   "panic",
+		Scopes: []types.Scope{types.ScopePublic},
+		Handler: func(ctx context.Context, req *Request) (*Response, error) {
+			panic("boom")
+		},
+	}))
+
+	s.replies = make(map[string]string)
+	s.router = gin.New()
+	s.router.POST("/slack", SlackHandler(s.bot, "slack-secret"))
+	s.router.POST("/line", LineHandler(s.bot, "line-secret",
+		func(replyToken, text string) error {
+			s.replies[replyToken] = text
+			return nil
+		}))
+}
+
+func (s *ChatOpsTestSuite) handle(userID, text string) *Response {
+	return s.bot.Handle(context.Background(), PlatformSlack, userID, "C1", text)
+}
+
+func (s *ChatOpsTestSuite) TestSplit() {
+	args, err := Split(` jail  "1.2.3.4 x" 'a "b"' --flag=1 `)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"jail", "1.2.3.4 x", `a "b"`, "--flag=1"}, args)
+	args, err = Split(`echo ""`)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"echo", ""}, args)
+	_, err = Split(`echo "open`)
+	s.Require().Error(err)
+}
+
+func (s *ChatOpsTestSuite) TestRegister() {
+	s.Require().Equal(ErrDuplicateCommand, s.bot.Register(&Command{
+		Name:    "jail",
+		Handler: s.bot.help,
+	}))
+	s.Require().Equal(ErrInvalidCommand, s.bot.Register(&Command{
+		Name:    "/bad name",
+		Handler: s.bot.help,
+	}))
+	s.Require().Equal(ErrInvalidCommand, s.bot.Register(&Command{Name: "nil"}))
+	s.Require().Equal("/jail <ip>", s.bot.Command("jail").Usage())
+	s.Require().Equal("/echo ...", s.bot.Command("echo").Usage())
+}
+
+func (s *ChatOpsTestSuite) TestHandle() {
+	resp := s.handle("admin", "/JAIL 1.2.3.4 --jailed")
+	s.Require().Equal("IP 1.2.3.4\njailed: true", resp.String())
+	s.Require().Equal("Usage: /jail <ip>", s.handle("admin", "/jail").Text)
+	s.Require().Equal("Usage: /jail <ip>", s.handle("admin", "/jail a b").Text)
+	s.Require().Equal("You are not allowed to run /jail.",
+		s.handle("guest", "/jail 1.2.3.4").Text)
+	s.Require().Equal("Unknown command /nope. Try /help.",
+		s.handle("guest", "/nope").Text)
+	s.Require().Contains(s.handle("guest", `/echo "`).Text, "Invalid command")
+	s.Require().Equal("/echo failed: nothing to echo", s.handle("guest", "echo").Text)
+	s.Require().Equal("/panic failed: internal error", s.handle("guest", "panic").Text)
+
+	resp = s.handle("admin", "/help")
+	s.Require().Len(resp.Fields, 5)
+	s.Require().Equal("/jail <ip>", resp.Fields[2].Name)
+	resp = s.handle("guest", "/help")
+	s.Require().Len(resp.Fields, 4)
+
+	// Commands without scopes are denied.
+	s.Require().NoError(s.bot.Register(&Command{
+		Name:    "private",
+		Handler: s.bot.help,
+	}))
+	s.Require().Equal("You are not allowed to run /private.",
+		s.handle("admin", "/private").Text)
+}
+
+func (s *ChatOpsTestSuite) TestOpsCommands() {
+	bot := NewBot(StaticDirectory{})
+	s.Require().NoError(RegisterOpsCommands(bot))
+	s.Require().Error(RegisterOpsCommands(bot))
+	for _, text := range []string{
+		"/jail 1.2.3.4", "/ratelimit ip 1.2.3.4", "/service status"} {
+		s.Require().Contains(bot.Handle(context.Background(), PlatformSlack,
+			"guest", "C1", text).Text, "You are not allowed")
+	}
+	resp := bot.Handle(context.Background(), PlatformSlack, "guest", "C1", "/help")
+	s.Require().Len(resp.Fields, 1)
+
+	req := func(name string, args ...string) *Request {
+		return &Request{Args: args, Flags: map[string]string{},
+			command: bot.Command(name)}
+	}
+	_, err := jail(context.Background(), req("jail", "1.2.3"))
+	s.Require().Error(err)
+	_, err = rateLimit(context.Background(), req("ratelimit", "user", "bob"))
+	s.Require().Error(err)
+	_, err = rateLimit(context.Background(), req("ratelimit", "phone", "1"))
+	s.Require().Error(err)
+	_, err = service(context.Background(), req("service", "restart"))
+	s.Require().Error(err)
+	resp, err = service(context.Background(), req("service", "status"))
+	s.Require().NoError(err)
+	s.Require().Equal("ready", resp.Fields[2].Name)
+}
+
+func (s *ChatOpsTestSuite) slack(command, text string, sign bool) *httptest.ResponseRecorder {
+	body := url.Values{
+		"command":      {command},
+		"text":         {text},
+		"user_id":      {"admin"},
+		"channel_id":   {"C1"},
+		"response_url": {"http://127.0.0.1:1/unreachable"},
+	}.Encode()
+	req := httptest.NewRequest(http.MethodPost, "/slack", strings.NewReader(body))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	if sign {
+		req.Header.Set("X-Slack-Signature",
+			slackSignature("slack-secret", timestamp, []byte(body)))
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *ChatOpsTestSuite) TestSlack() {
+	s.Require().Equal(http.StatusUnauthorized,
+		s.slack("/jail", "1.2.3.4", false).Code)
+
+	w := s.slack("/jail", "1.2.3.4", true)
+	s.Require().Equal(http.StatusOK, w.Code)
+	msg := map[string]interface{}{}
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &msg))
+	s.Require().Equal("ephemeral", msg["response_type"])
+	s.Require().Equal("IP 1.2.3.4\njailed: ", msg["text"])
+	blocks := msg["blocks"].([]interface{})
+	s.Require().Len(blocks, 2)
+	s.Require().Equal("*jailed*\n",
+		blocks[1].(map[string]interface{})["fields"].([]interface{})[0].(map[string]interface{})["text"])
+
+	w = s.slack("/ops", "echo a b", true)
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &msg))
+	s.Require().Equal("in_channel", msg["response_type"])
+	s.Require().Equal("a|b", msg["text"])
+}
+
+func (s *ChatOpsTestSuite) TestSlackDelayed() {
+	defer func(d time.Duration) { slackAckTimeout = d }(slackAckTimeout)
+	slackAckTimeout = 10 * time.Millisecond
+
+	posted := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			posted <- string(body)
+		}))
+	defer server.Close()
+
+	body := url.Values{
+		"command":      {"/slow"},
+		"user_id":      {"admin"},
+		"response_url": {server.URL},
+	}.Encode()
+	req := httptest.NewRequest(http.MethodPost, "/slack", strings.NewReader(body))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature",
+		slackSignature("slack-secret", timestamp, []byte(body)))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Require().Contains(w.Body.String(), "Working on it...")
+
+	close(s.released)
+	select {
+	case msg := <-posted:
+		s.Require().Contains(msg, "finally")
+	case <-time.After(time.Second):
+		s.Fail("delayed response isn't posted")
+	}
+}
+
+func (s *ChatOpsTestSuite) TestLINE() {
+	body := []byte(`{"events": [{
+		"type": "message",
+		"replyToken": "token1",
+		"source": {"type": "group", "groupId": "G1", "userId": "admin"},
+		"timestamp": 1528000000000,
+		"message": {"type": "text", "id": "1", "text": "/jail 1.2.3.4"}
+	}, {
+		"type": "message",
+		"replyToken": "token2",
+		"source": {"type": "user", "userId": "admin"},
+		"timestamp": 1528000000000,
+		"message": {"type": "text", "id": "2", "text": "hello"}
+	}]}`)
+	send := func(signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/line",
+			strings.NewReader(string(body)))
+		req.Header.Set("X-Line-Signature", signature)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	s.Require().Equal(http.StatusUnauthorized, send("invalid"))
+	s.Require().Empty(s.replies)
+
+	mac := hmac.New(sha256.New, []byte("line-secret"))
+	mac.Write(body)
+	s.Require().Equal(http.StatusOK,
+		send(base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+	s.Require().Equal(map[string]string{
+		"token1": "IP 1.2.3.4\njailed: ",
+	}, s.replies)
+}
+
+func (s *ChatOpsTestSuite) TestLineText() {
+	text := lineText(&Response{Text: strings.Repeat("a", 6000)})
+	s.Require().Len([]rune(text), lineMaxText)
+}
+
+func TestChatOps(t *testing.T) {
+	suite.Run(t, new(ChatOpsTestSuite))
+}
@@ -0,0 +1,139 @@
+package chatops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/config/misc"
+	"github.com/jiarung/mochi/common/global"
+	"github.com/jiarung/mochi/common/limiters"
+	"github.com/jiarung/mochi/types"
+)
+
+// RegisterOpsCommands registers commands of ops staff to b:
+//
+//	/jail <ip> [--put|--release]
+//	/ratelimit <user|ip> <id> [--clear]
+//	/service status
+func RegisterOpsCommands(b *Bot) error {
+	commands := []*Command{{
+		Name: "jail",
+		Description: "Show whether an IP is jailed by websocket APIs, " +
+			"--put to jail it or --release to release it.",
+		Args:    []string{"ip"},
+		Scopes:  []types.Scope{types.ScopeAuditCommitteeSuperAdmin},
+		Handler: jail,
+	}, {
+		Name: "ratelimit",
+		Description: "Show the rate limit count of a user or an IP, " +
+			"--clear to reset it.",
+		Args:    []string{"kind", "id"},
+		Scopes:  []types.Scope{types.ScopeAuditCommitteeSuperAdmin},
+		Handler: rateLimit,
+	}, {
+		Name:        "service",
+		Description: "Show the status of the service.",
+		Args:        []string{"action"},
+		Scopes: []types.Scope{
+			types.ScopeAuditCommitteeSuperAdmin,
+			types.ScopeAdminSystemVersionAdministration,
+		},
+		Handler: service,
+	}}
+	for _, cmd := range commands {
+		if err := b.Register(cmd); err != nil {
+			return fmt.Errorf("register /%s: %v", cmd.Name, err)
+		}
+	}
+	return nil
+}
+
+// boolFlag returns whether the flag of name is set by --name.
+func boolFlag(req *Request, name string) bool {
+	v, _ := strconv.ParseBool(req.Flags[name])
+	return v
+}
+
+func jail(ctx context.Context, req *Request) (*Response, error) {
+	ip := req.Arg("ip")
+	if net.ParseIP(ip) == nil {
+		return nil, fmt.Errorf("invalid IP %q", ip)
+	}
+	switch {
+	case boolFlag(req, "put") && boolFlag(req, "release"):
+		return nil, errors.New("--put and --release are exclusive")
+	case boolFlag(req, "put"):
+		if !limiters.PutWebsocketIPBlackList(ip) {
+			return nil, errors.New("failed to jail IP")
+		}
+	case boolFlag(req, "release"):
+		if err := limiters.ClearWebsocketIPBlackList(ip); err != nil {
+			return nil, err
+		}
+	}
+	return &Response{
+		Text: "IP " + ip,
+		Fields: []Field{{
+			Name:  "jailed",
+			Value: strconv.FormatBool(limiters.ReachWesocketIPBlackList(ip)),
+		}},
+	}, nil
+}
+
+func rateLimit(ctx context.Context, req *Request) (*Response, error) {
+	var key string
+	id := req.Arg("id")
+	switch kind := req.Arg("kind"); kind {
+	case "user":
+		if _, err := uuid.FromString(id); err != nil {
+			return nil, fmt.Errorf("invalid user ID %q", id)
+		}
+		key = limiters.AuthLimiterKey(id)
+	case "ip":
+		if net.ParseIP(id) == nil {
+			return nil, fmt.Errorf("invalid IP %q", id)
+		}
+		key = limiters.WebsocketAPIIPKey(id)
+	default:
+		return nil, fmt.Errorf("unknown kind %q, expect user or ip", kind)
+	}
+	if boolFlag(req, "clear") {
+		if err := limiters.ClearCount(key); err != nil {
+			return nil, err
+		}
+	}
+	count, err := limiters.GetCount(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Text:   fmt.Sprintf("Rate limit of %s %s", req.Arg("kind"), id),
+		Fields: []Field{{Name: "count", Value: strconv.FormatInt(count, 10)}},
+	}, nil
+}
+
+func service(ctx context.Context, req *Request) (*Response, error) {
+	if action := req.Arg("action"); action != "status" {
+		return nil, fmt.Errorf("unknown action %q, expect status", action)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Response{
+		Text: "Service " + host,
+		Fields: []Field{
+			{Name: "environment", Value: misc.ServerEnvironment()},
+			{Name: "commit", Value: global.GitCommitHash},
+			{Name: "ready", Value: strconv.FormatBool(global.IsReady)},
+			{Name: "shutting down",
+				Value: strconv.FormatBool(global.IsShuttingDown)},
+		},
+	}, nil
+}
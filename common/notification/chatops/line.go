@@ -0,0 +1,94 @@
+package chatops
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/line/line-bot-sdk-go/linebot"
+
+	"github.com/jiarung/mochi/common/utils"
+)
+
+// lineMaxText is the max length of LINE text messages.
+const lineMaxText = 5000
+
+// LineReplier replies text to the reply token of an event.
+type LineReplier func(replyToken, text string) error
+
+// ReplyLineMsg replies by the LINE bot of LINEBOT_CONFIG.
+func ReplyLineMsg(replyToken, text string) error {
+	_, err := utils.GetLinebot().ReplyMessage(
+		replyToken, linebot.NewTextMessage(text)).Do()
+	return err
+}
+
+func validLineSignature(channelSecret, signature string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(channelSecret))
+	mac.Write(body)
+	return hmac.Equal([]byte(base64.StdEncoding.EncodeToString(mac.Sum(nil))),
+		[]byte(signature))
+}
+
+// lineText renders resp in plain text within the limit of LINE.
+func lineText(resp *Response) string {
+	text := []rune(resp.String())
+	if len(text) > lineMaxText {
+		text = append(text[:lineMaxText-1], '…')
+	}
+	return string(text)
+}
+
+// LineHandler returns the handler of LINE webhook events signed by
+// channelSecret. Text messages starting with "/" are run as commands and
+// replied by reply, which is ReplyLineMsg if it's nil.
+func LineHandler(bot *Bot, channelSecret string, reply LineReplier) gin.HandlerFunc {
+	if reply == nil {
+		reply = ReplyLineMsg
+	}
+	return func(ctx *gin.Context) {
+		body, err := ioutil.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+		if !validLineSignature(channelSecret,
+			ctx.GetHeader("X-Line-Signature"), body) {
+			ctx.Status(http.StatusUnauthorized)
+			return
+		}
+		events, err := utils.ParseLinebot(body)
+		if err != nil {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+
+		for _, event := range events {
+			if event.Type != linebot.EventTypeMessage || event.Source == nil {
+				continue
+			}
+			msg, ok := event.Message.(*linebot.TextMessage)
+			if !ok || !strings.HasPrefix(msg.Text, "/") {
+				continue
+			}
+			channel := event.Source.GroupID
+			if len(channel) == 0 {
+				channel = event.Source.RoomID
+			}
+			if len(channel) == 0 {
+				channel = event.Source.UserID
+			}
+			resp := bot.Handle(context.Background(), PlatformLINE,
+				event.Source.UserID, channel, msg.Text)
+			if err := reply(event.ReplyToken, lineText(resp)); err != nil {
+				bot.logger.Error("reply LINE message err: %v", err)
+			}
+		}
+		ctx.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,140 @@
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slackAckTimeout is the time to wait for a command before acknowledging the
+// request, since slack requires responses in 3 seconds. Later results are
+// posted to the response URL.
+var slackAckTimeout = 2500 * time.Millisecond
+
+// slackMaxFields is the max number of fields of a section block.
+const slackMaxFields = 10
+
+// slackSignature returns the signature of a request body sent at timestamp.
+// Reference: https://api.slack.com/authentication/verifying-requests-from-slack
+func slackSignature(signingSecret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validSlackRequest(signingSecret string, header http.Header,
+	body []byte, now time.Time) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || math.Abs(float64(now.Unix()-ts)) > 5*60 {
+		return false
+	}
+	return hmac.Equal([]byte(slackSignature(signingSecret, timestamp, body)),
+		[]byte(header.Get("X-Slack-Signature")))
+}
+
+// slackMessage renders resp in slack blocks.
+func slackMessage(resp *Response) map[string]interface{} {
+	responseType := "ephemeral"
+	if resp.Public {
+		responseType = "in_channel"
+	}
+	blocks := []map[string]interface{}{{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": resp.Text},
+	}}
+	for i := 0; i < len(resp.Fields); i += slackMaxFields {
+		var fields []map[string]string
+		for _, f := range resp.Fields[i:] {
+			if len(fields) == slackMaxFields {
+				break
+			}
+			fields = append(fields, map[string]string{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("*%s*\n%s", f.Name, f.Value),
+			})
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type":   "section",
+			"fields": fields,
+		})
+	}
+	return map[string]interface{}{
+		"response_type": responseType,
+		// text is the fallback of notifications.
+		"text":   resp.String(),
+		"blocks": blocks,
+	}
+}
+
+// SlackHandler returns the handler of slack slash commands signed by
+// signingSecret. The slash command can be a registered command, e.g.
+// "/jail 1.2.3.4", or an umbrella command followed by the command, e.g.
+// "/ops jail 1.2.3.4".
+func SlackHandler(bot *Bot, signingSecret string) gin.HandlerFunc {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(ctx *gin.Context) {
+		body, err := ioutil.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+		if !validSlackRequest(signingSecret, ctx.Request.Header, body,
+			time.Now()) {
+			ctx.Status(http.StatusUnauthorized)
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+
+		text := form.Get("text")
+		command := strings.TrimPrefix(form.Get("command"), "/")
+		if bot.Command(command) != nil {
+			text = command + " " + text
+		}
+		result := make(chan *Response, 1)
+		go func() {
+			result <- bot.Handle(context.Background(), PlatformSlack,
+				form.Get("user_id"), form.Get("channel_id"), text)
+		}()
+
+		timer := time.NewTimer(slackAckTimeout)
+		defer timer.Stop()
+		select {
+		case resp := <-result:
+			ctx.JSON(http.StatusOK, slackMessage(resp))
+			return
+		case <-timer.C:
+		}
+
+		ctx.JSON(http.StatusOK, slackMessage(&Response{Text: "Working on it..."}))
+		responseURL := form.Get("response_url")
+		go func() {
+			data, _ := json.Marshal(slackMessage(<-result))
+			resp, err := client.Post(responseURL, "application/json",
+				bytes.NewReader(data))
+			if err != nil {
+				bot.logger.Error("post slack response err: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+}
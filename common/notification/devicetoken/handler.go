@@ -0,0 +1,93 @@
+// Package devicetoken provides endpoints of apps registering device tokens
+// of APNs, which are targeted by notification tags of their users.
+package devicetoken
+
+import (
+	"encoding/hex"
+
+	apicontext "github.com/jiarung/mochi/common/api/context"
+	apierrors "github.com/jiarung/mochi/common/api/errors"
+	"github.com/jiarung/mochi/common/notification"
+)
+
+// maxTokenLength is the max length of device tokens in hex. Tokens are 32
+// bytes for now, but apple may make them longer.
+const maxTokenLength = 200
+
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+// validToken returns whether token is a device token in hex.
+func validToken(token string) bool {
+	if len(token) == 0 || len(token) > maxTokenLength {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// Service registers device tokens of users.
+type Service struct {
+	tag      *notification.AppTag
+	registry notification.DeviceTokenRegistry
+}
+
+// NewService returns a service registering device tokens to registry by
+// notification tags of tag, which should be the same as AppStruct.Tag.
+func NewService(tag *notification.AppTag,
+	registry notification.DeviceTokenRegistry) *Service {
+	return &Service{tag: tag, registry: registry}
+}
+
+// token binds the request, and returns the notification tag of the user and
+// the device token.
+func (s *Service) token(appCtx *apicontext.AppContext) (
+	tag, token string, ok bool) {
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	userID, _ := appCtx.GetUserID()
+	req := tokenRequest{}
+	if !appCtx.MustBindJSON(&req) {
+		return
+	}
+	if !validToken(req.Token) {
+		appCtx.SetError(apierrors.ParameterError)
+		return
+	}
+	tag = s.tag.GetNotificationTag(userID.String(), appCtx.ServiceName)
+	return tag, req.Token, true
+}
+
+// RegisterHandler registers the device token of the user, which should be
+// called whenever the app launches.
+// /v1/devices/apns [POST]
+func (s *Service) RegisterHandler(appCtx *apicontext.AppContext) {
+	tag, token, ok := s.token(appCtx)
+	if !ok {
+		return
+	}
+	if err := s.registry.AddDeviceToken(tag, token); err != nil {
+		appCtx.Logger().Error("add device token err: %v", err)
+		appCtx.SetError(apierrors.UnexpectedError)
+		return
+	}
+	appCtx.SetJSON(nil)
+}
+
+// UnregisterHandler unregisters the device token of the user, e.g. on
+// logout.
+// /v1/devices/apns [DELETE]
+func (s *Service) UnregisterHandler(appCtx *apicontext.AppContext) {
+	tag, token, ok := s.token(appCtx)
+	if !ok {
+		return
+	}
+	if err := s.registry.RemoveDeviceToken(tag, token); err != nil {
+		appCtx.Logger().Error("remove device token err: %v", err)
+		appCtx.SetError(apierrors.UnexpectedError)
+		return
+	}
+	appCtx.SetJSON(nil)
+}
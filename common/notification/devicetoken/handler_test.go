@@ -0,0 +1,23 @@
+package devicetoken
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DeviceTokenTestSuite struct {
+	suite.Suite
+}
+
+func (s *DeviceTokenTestSuite) TestValidToken() {
+	s.Require().True(validToken(strings.Repeat("ab", 32)))
+	s.Require().False(validToken(""))
+	s.Require().False(validToken("not hex"))
+	s.Require().False(validToken(strings.Repeat("a", maxTokenLength+2)))
+}
+
+func TestDeviceToken(t *testing.T) {
+	suite.Run(t, new(DeviceTokenTestSuite))
+}
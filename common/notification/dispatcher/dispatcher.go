@@ -0,0 +1,628 @@
+// Package dispatcher delivers notifications through durable per-channel
+// queues, so a pod restart or a provider outage doesn't lose them.
+//
+// Jobs are persisted in a local disk queue before Enqueue returns, and
+// delivered by per-channel workers. Failed jobs are retried with exponential
+// backoff through a retry queue, and moved to the dead letter file of the
+// channel once attempts run out or they're older than MaxAge.
+package dispatcher
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jiarung/mochi/common/fileio"
+	"github.com/jiarung/mochi/common/kubernetes"
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/common/notification/email"
+)
+
+// Errors of the dispatcher.
+var (
+	ErrDuplicateJob    = errors.New("duplicate notification job")
+	ErrUnknownChannel  = errors.New("unknown notification channel")
+	ErrDispatcherState = errors.New("invalid dispatcher state")
+	ErrQueueDir        = errors.New("queue dir is not set")
+)
+
+var jobsTotal = metric.NewCounter("notification_dispatcher", "jobs_total",
+	"Total number of notification jobs by result.", "channel", "result")
+
+// Option defines the options of Dispatcher.
+type Option struct {
+	// Dir is the directory of queue files. It must be set, and should be a
+	// persistent volume so queued jobs survive restarts.
+	Dir string
+	// Workers is the number of workers per channel.
+	Workers int
+	// MaxAttempts is the number of attempts before a job is dead-lettered.
+	MaxAttempts int
+	// MaxAge is the age after which a job is dead-lettered instead of
+	// delivered, so stale notifications aren't sent. Zero means no limit.
+	MaxAge time.Duration
+	// InitialBackoff is the delay before the first retry, doubled on each
+	// retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// PollInterval is the interval to poll empty queues.
+	PollInterval time.Duration
+	// SendTimeout is the timeout of a single delivery.
+	SendTimeout time.Duration
+	// IdempotencyTTL is the time idempotency keys are kept.
+	IdempotencyTTL time.Duration
+}
+
+// DefaultOption returns the default option. Dir is read from
+// NOTIFICATION_QUEUE_DIR, and Register fails with ErrQueueDir if it's unset.
+func DefaultOption() *Option {
+	return &Option{
+		Dir:            os.Getenv("NOTIFICATION_QUEUE_DIR"),
+		Workers:        4,
+		MaxAttempts:    8,
+		MaxAge:         24 * time.Hour,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     30 * time.Minute,
+		PollInterval:   100 * time.Millisecond,
+		SendTimeout:    30 * time.Second,
+		IdempotencyTTL: 24 * time.Hour,
+	}
+}
+
+// channelQueues holds the queues and sender of a channel.
+type channelQueues struct {
+	sender Sender
+	main   Queue
+	retry  Queue
+}
+
+// delivery is a job popped from a queue.
+type delivery struct {
+	job    *Job
+	queue  Queue
+	offset int64
+}
+
+// Dispatcher delivers jobs through per-channel queues and workers.
+type Dispatcher struct {
+	opt    Option
+	idem   IdempotencyStore
+	logger logging.Logger
+
+	mutex    sync.Mutex
+	channels map[Channel]*channelQueues
+	started  bool
+	closed   bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a dispatcher. Keys are kept in memory if idem is nil.
+func New(opt *Option, idem IdempotencyStore) *Dispatcher {
+	if opt == nil {
+		opt = DefaultOption()
+	}
+	if idem == nil {
+		idem = NewMemoryIdempotencyStore()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		opt:      *opt,
+		idem:     idem,
+		logger:   logging.NewLoggerTag("notification:dispatcher"),
+		channels: make(map[Channel]*channelQueues),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Register opens the queues of channel and sets its sender. It must be
+// called before Start.
+func (d *Dispatcher) Register(channel Channel, sender Sender) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.started || d.closed {
+		return ErrDispatcherState
+	}
+	if len(d.opt.Dir) == 0 {
+		return ErrQueueDir
+	}
+	if _, ok := d.channels[channel]; ok {
+		return fmt.Errorf("channel %s is already registered", channel)
+	}
+
+	main, err := NewDiskQueue(d.opt.Dir, string(channel))
+	if err != nil {
+		return err
+	}
+	retry, err := NewDiskQueue(d.opt.Dir, string(channel)+".retry")
+	if err != nil {
+		main.Close()
+		return err
+	}
+	d.channels[channel] = &channelQueues{
+		sender: sender,
+		main:   main,
+		retry:  retry,
+	}
+	return nil
+}
+
+// Start starts the workers of registered channels.
+func (d *Dispatcher) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.started || d.closed {
+		return ErrDispatcherState
+	}
+	d.started = true
+
+	for channel, queues := range d.channels {
+		deliveries := make(chan *delivery)
+		d.wg.Add(2 + d.opt.Workers)
+		go d.poll(channel, queues.main, deliveries)
+		go d.pollRetry(channel, queues.retry, deliveries)
+		for i := 0; i < d.opt.Workers; i++ {
+			go d.work(channel, queues, deliveries)
+		}
+	}
+	return nil
+}
+
+// Close stops workers and closes queues. Jobs in flight are redelivered
+// after restart.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return ErrDispatcherState
+	}
+	d.closed = true
+	d.mutex.Unlock()
+
+	d.cancel()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, queues := range d.channels {
+		queues.main.Close()
+		queues.retry.Close()
+	}
+	return nil
+}
+
+// Enqueue persists job to the queue of its channel. It returns
+// ErrDuplicateJob if a job with the same idempotency key is enqueued within
+// IdempotencyTTL. The key is released if the job isn't persisted, so it can
+// be enqueued again.
+func (d *Dispatcher) Enqueue(job *Job) error {
+	d.mutex.Lock()
+	queues, ok := d.channels[job.Channel]
+	closed := d.closed
+	d.mutex.Unlock()
+	if closed {
+		return ErrDispatcherState
+	}
+	if !ok {
+		return ErrUnknownChannel
+	}
+
+	enqueueKey := "enqueue:" + string(job.Channel) + ":" + job.IdempotencyKey
+	if len(job.IdempotencyKey) > 0 {
+		acquired, err := d.idem.Acquire(enqueueKey, d.opt.IdempotencyTTL)
+		if err != nil {
+			return err
+		}
+		if !acquired {
+			jobsTotal.WithLabelValues(string(job.Channel), "duplicate").Inc()
+			return ErrDuplicateJob
+		}
+	}
+	if err := queues.main.Push(job); err != nil {
+		if len(job.IdempotencyKey) > 0 {
+			d.idem.Release(enqueueKey)
+		}
+		return err
+	}
+	jobsTotal.WithLabelValues(string(job.Channel), "enqueued").Inc()
+	return nil
+}
+
+// EnqueueSMS enqueues a SMS.
+func (d *Dispatcher) EnqueueSMS(
+	idempotencyKey, country, phone, body string) error {
+	job, err := NewJob(ChannelSMS, idempotencyKey, &SMSRequest{
+		Country: country,
+		Phone:   phone,
+		Body:    body,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Enqueue(job)
+}
+
+// EnqueueSMSRef enqueues a SMS whose body is rendered from ref by the
+// SMSBodyFunc registered for kind at delivery. See RegisterSMSBody.
+func (d *Dispatcher) EnqueueSMSRef(
+	idempotencyKey, country, phone, kind, ref string) error {
+	job, err := NewJob(ChannelSMS, idempotencyKey, &SMSRequest{
+		Country:  country,
+		Phone:    phone,
+		BodyKind: kind,
+		BodyRef:  ref,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Enqueue(job)
+}
+
+// EnqueueEmail enqueues an email.
+func (d *Dispatcher) EnqueueEmail(
+	idempotencyKey string, req email.Request, requestTag string) error {
+	payload, err := NewEmailRequest(req, requestTag)
+	if err != nil {
+		return err
+	}
+	job, err := NewJob(ChannelEmail, idempotencyKey, payload)
+	if err != nil {
+		return err
+	}
+	return d.Enqueue(job)
+}
+
+// EnqueuePush enqueues a push notification.
+func (d *Dispatcher) EnqueuePush(
+	idempotencyKey string, req *notification.AppRequest) error {
+	job, err := NewJob(ChannelPush, idempotencyKey, &PushRequest{Request: req})
+	if err != nil {
+		return err
+	}
+	return d.Enqueue(job)
+}
+
+// EnqueueAnalytics enqueues a FB or GA event.
+//
+// Deprecated: Track events by package analytics, which batches them to all
+// sinks.
+func (d *Dispatcher) EnqueueAnalytics(
+	channel Channel, idempotencyKey string, v *url.Values) error {
+	job, err := NewJob(channel, idempotencyKey, &AnalyticsRequest{Values: *v})
+	if err != nil {
+		return err
+	}
+	return d.Enqueue(job)
+}
+
+// sleep waits for duration and returns false if the dispatcher is closed.
+func (d *Dispatcher) sleep(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-d.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// popFailed handles the pop error of queue. A corrupt job is dead-lettered
+// and committed, so it isn't popped again after restart. It returns false if
+// the dispatcher is closed while backing off before the next pop.
+func (d *Dispatcher) popFailed(channel Channel, queue Queue, err error,
+	failures int) bool {
+	d.logger.Error("pop %s job err: %v", channel, err)
+	var corrupt *CorruptJobError
+	if errors.As(err, &corrupt) {
+		d.deadLetterCorrupt(channel, corrupt)
+		if err := queue.Commit(corrupt.Offset); err != nil {
+			d.logger.Error("commit %s corrupt job at %d err: %v",
+				channel, corrupt.Offset, err)
+		}
+	}
+	return d.sleep(expBackoff(d.opt.PollInterval, d.opt.MaxBackoff, failures))
+}
+
+// poll pops jobs from queue and hands them to workers.
+func (d *Dispatcher) poll(channel Channel, queue Queue,
+	deliveries chan<- *delivery) {
+	defer d.wg.Done()
+
+	failures := 0
+	for {
+		job, offset, err := queue.Pop()
+		if err != nil {
+			failures++
+			if !d.popFailed(channel, queue, err, failures) {
+				return
+			}
+			continue
+		}
+		failures = 0
+		if job == nil {
+			if !d.sleep(d.opt.PollInterval) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case deliveries <- &delivery{job: job, queue: queue, offset: offset}:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliveryHeap is a min-heap of deliveries by NextAttemptAt of jobs.
+type deliveryHeap []*delivery
+
+func (h deliveryHeap) Len() int { return len(h) }
+
+func (h deliveryHeap) Less(i, j int) bool {
+	return h[i].job.NextAttemptAt.Before(h[j].job.NextAttemptAt)
+}
+
+func (h deliveryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *deliveryHeap) Push(x interface{}) { *h = append(*h, x.(*delivery)) }
+
+func (h *deliveryHeap) Pop() interface{} {
+	old := *h
+	dl := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return dl
+}
+
+// pollRetry pops jobs from the retry queue into a heap by due time, and
+// hands them to workers once they're due. Jobs of different backoff are
+// pushed out of order, so they're held in memory instead of blocking jobs
+// behind them. Offsets are committed out of order by the queue.
+func (d *Dispatcher) pollRetry(channel Channel, queue Queue,
+	deliveries chan<- *delivery) {
+	defer d.wg.Done()
+
+	pending := &deliveryHeap{}
+	failures := 0
+	for {
+		for {
+			job, offset, err := queue.Pop()
+			if err != nil {
+				failures++
+				if !d.popFailed(channel, queue, err, failures) {
+					return
+				}
+				continue
+			}
+			failures = 0
+			if job == nil {
+				break
+			}
+			heap.Push(pending, &delivery{job: job, queue: queue, offset: offset})
+		}
+
+		wait := d.opt.PollInterval
+		if pending.Len() > 0 {
+			next := (*pending)[0]
+			due := time.Until(next.job.NextAttemptAt)
+			if due <= 0 {
+				select {
+				case deliveries <- next:
+					heap.Pop(pending)
+				case <-d.ctx.Done():
+					return
+				}
+				continue
+			}
+			if due < wait {
+				wait = due
+			}
+		}
+		if !d.sleep(wait) {
+			return
+		}
+	}
+}
+
+// work delivers jobs of a channel.
+func (d *Dispatcher) work(channel Channel, queues *channelQueues,
+	deliveries <-chan *delivery) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case dl := <-deliveries:
+			if !d.deliver(channel, queues, dl.job) {
+				// Left uncommitted to be redelivered after restart.
+				continue
+			}
+			if err := dl.queue.Commit(dl.offset); err != nil {
+				d.logger.Error("commit %s job<%s> err: %v", channel, dl.job.ID, err)
+			}
+		}
+	}
+}
+
+// expBackoff returns initial doubled on each attempt after the first, up to
+// max.
+func expBackoff(initial, max time.Duration, attempts int) time.Duration {
+	backoff := initial
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// backoff returns the delay before the next attempt.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	return expBackoff(d.opt.InitialBackoff, d.opt.MaxBackoff, attempts)
+}
+
+// deliver sends job, and schedules a retry or dead-letters it on failure. It
+// returns false if the delivery is interrupted by Close.
+func (d *Dispatcher) deliver(
+	channel Channel, queues *channelQueues, job *Job) bool {
+	if d.expired(job, time.Now()) {
+		jobsTotal.WithLabelValues(string(channel), "expired").Inc()
+		d.deadLetter(channel, job)
+		return true
+	}
+
+	sentKey := "sent:" + string(channel) + ":" + job.IdempotencyKey
+	if len(job.IdempotencyKey) > 0 {
+		acquired, err := d.idem.Acquire(sentKey, d.opt.IdempotencyTTL)
+		if err != nil {
+			d.logger.Warn("acquire idempotency key of job<%s> err: %v", job.ID, err)
+		} else if !acquired {
+			jobsTotal.WithLabelValues(string(channel), "duplicate").Inc()
+			return true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.opt.SendTimeout)
+	err := queues.sender.Send(ctx, job)
+	cancel()
+	if err == nil {
+		jobsTotal.WithLabelValues(string(channel), "sent").Inc()
+		return true
+	}
+
+	if len(job.IdempotencyKey) > 0 {
+		d.idem.Release(sentKey)
+	}
+	if d.ctx.Err() != nil {
+		return false
+	}
+	job.Attempts++
+	job.LastError = err.Error()
+	if IsPermanent(err) || job.Attempts >= d.opt.MaxAttempts {
+		d.deadLetter(channel, job)
+		return true
+	}
+
+	job.NextAttemptAt = time.Now().Add(d.backoff(job.Attempts))
+	if d.expired(job, job.NextAttemptAt) {
+		jobsTotal.WithLabelValues(string(channel), "expired").Inc()
+		d.deadLetter(channel, job)
+		return true
+	}
+	d.logger.Warn("send %s job<%s> attempt %d err: %v, retry at %v",
+		channel, job.ID, job.Attempts, err, job.NextAttemptAt)
+	if pushErr := queues.retry.Push(job); pushErr != nil {
+		d.logger.Error("push %s job<%s> to retry queue err: %v",
+			channel, job.ID, pushErr)
+		d.deadLetter(channel, job)
+		return true
+	}
+	jobsTotal.WithLabelValues(string(channel), "retried").Inc()
+	return true
+}
+
+// expired returns whether job is older than MaxAge at t.
+func (d *Dispatcher) expired(job *Job, t time.Time) bool {
+	return d.opt.MaxAge > 0 && t.Sub(job.CreatedAt) > d.opt.MaxAge
+}
+
+// DeadLetterPath returns the path of the dead letter file of channel, which
+// has a JSON encoded job per line.
+func (d *Dispatcher) DeadLetterPath(channel Channel) string {
+	return filepath.Join(d.opt.Dir, string(channel)+".dead")
+}
+
+func (d *Dispatcher) deadLetter(channel Channel, job *Job) {
+	jobsTotal.WithLabelValues(string(channel), "dead").Inc()
+	d.logger.Error("%s job<%s> is dead after %d attempts, last err: %s",
+		channel, job.ID, job.Attempts, job.LastError)
+	if err := fileio.Append(d.DeadLetterPath(channel), job, true); err != nil {
+		d.logger.Critical("write dead letter of job<%s> err: %v", job.ID, err)
+	}
+}
+
+// deadLetterCorrupt writes the record of corrupt to the dead letter file of
+// channel, as the payload of a job with the decoding error.
+func (d *Dispatcher) deadLetterCorrupt(channel Channel, corrupt *CorruptJobError) {
+	payload, err := json.Marshal(string(corrupt.Data))
+	if err != nil {
+		d.logger.Critical("marshal %s corrupt job at %d err: %v",
+			channel, corrupt.Offset, err)
+		return
+	}
+	d.deadLetter(channel, &Job{
+		Channel:   channel,
+		Payload:   payload,
+		LastError: corrupt.Error(),
+		CreatedAt: time.Now(),
+	})
+}
+
+var (
+	defaultMutex      sync.Mutex
+	defaultDispatcher *Dispatcher
+)
+
+// Default returns the dispatcher set by SetDefault, or nil if it's not set.
+func Default() *Dispatcher {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	return defaultDispatcher
+}
+
+// SetDefault sets the dispatcher used by callers in shared packages.
+func SetDefault(d *Dispatcher) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultDispatcher = d
+}
+
+// Init creates the default dispatcher of channels with senders by
+// DefaultOption, starts it and sets it by SetDefault. It's closed and unset
+// on graceful shutdown after ctx is done. It should be called at startup of
+// services which send notifications.
+func Init(ctx context.Context, logger logging.Logger,
+	idem IdempotencyStore, senders map[Channel]Sender) (*Dispatcher, error) {
+	d := New(DefaultOption(), idem)
+	for channel, sender := range senders {
+		if err := d.Register(channel, sender); err != nil {
+			d.Close(ctx)
+			return nil, err
+		}
+	}
+	if err := d.Start(); err != nil {
+		d.Close(ctx)
+		return nil, err
+	}
+	SetDefault(d)
+	kubernetes.RegisterShutdownFunc(ctx, logger,
+		func(ctx context.Context) error {
+			SetDefault(nil)
+			return d.Close(ctx)
+		})
+	return d, nil
+}
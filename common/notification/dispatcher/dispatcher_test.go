@@ -0,0 +1,379 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/utils"
+)
+
+type recordSender struct {
+	mutex sync.Mutex
+	fails int
+	err   error
+	sent  []string
+	calls int
+}
+
+func (r *recordSender) Send(ctx context.Context, job *Job) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls++
+	if r.calls <= r.fails {
+		return r.err
+	}
+	req := SMSRequest{}
+	if err := unmarshalPayload(job, &req); err != nil {
+		return err
+	}
+	r.sent = append(r.sent, req.Phone)
+	return nil
+}
+
+// failQueue is a Queue failing to push.
+type failQueue struct {
+	Queue
+}
+
+func (q *failQueue) Push(job *Job) error {
+	return errors.New("disk full")
+}
+
+func (r *recordSender) result() ([]string, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.sent...), r.calls
+}
+
+type DispatcherTestSuite struct {
+	suite.Suite
+
+	opt *Option
+}
+
+func (s *DispatcherTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "dispatcher")
+	s.Require().NoError(err)
+	s.opt = &Option{
+		Dir:            dir,
+		Workers:        2,
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     40 * time.Millisecond,
+		PollInterval:   5 * time.Millisecond,
+		SendTimeout:    time.Second,
+		IdempotencyTTL: time.Minute,
+	}
+}
+
+func (s *DispatcherTestSuite) TearDownTest() {
+	os.RemoveAll(s.opt.Dir)
+}
+
+func (s *DispatcherTestSuite) start(
+	sender Sender, idem IdempotencyStore) *Dispatcher {
+	d := New(s.opt, idem)
+	s.Require().NoError(d.Register(ChannelSMS, sender))
+	s.Require().NoError(d.Start())
+	return d
+}
+
+func (s *DispatcherTestSuite) close(d *Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Require().NoError(d.Close(ctx))
+}
+
+func (s *DispatcherTestSuite) waitSent(sender *recordSender, n int) []string {
+	var sent []string
+	s.Require().Eventually(func() bool {
+		sent, _ = sender.result()
+		return len(sent) >= n
+	}, 2*time.Second, 5*time.Millisecond)
+	return sent
+}
+
+func (s *DispatcherTestSuite) TestSend() {
+	sender := &recordSender{}
+	d := s.start(sender, nil)
+	defer s.close(d)
+
+	s.Require().NoError(d.EnqueueSMS("", "TW", "0911", "hello"))
+	s.Require().NoError(d.EnqueueSMS("", "TW", "0912", "hello"))
+	s.Require().ElementsMatch([]string{"0911", "0912"}, s.waitSent(sender, 2))
+}
+
+func (s *DispatcherTestSuite) TestUnknownChannel() {
+	d := s.start(&recordSender{}, nil)
+	defer s.close(d)
+
+	job, err := NewJob(ChannelPush, "", &PushRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(ErrUnknownChannel, d.Enqueue(job))
+}
+
+func (s *DispatcherTestSuite) TestIdempotentEnqueue() {
+	sender := &recordSender{}
+	d := s.start(sender, nil)
+	defer s.close(d)
+
+	s.Require().NoError(d.EnqueueSMS("otp:1", "TW", "0911", "hello"))
+	s.Require().Equal(ErrDuplicateJob, d.EnqueueSMS("otp:1", "TW", "0911", "hello"))
+	s.waitSent(sender, 1)
+
+	time.Sleep(50 * time.Millisecond)
+	_, calls := sender.result()
+	s.Require().Equal(1, calls)
+}
+
+func (s *DispatcherTestSuite) TestEnqueuePushError() {
+	sender := &recordSender{}
+	d := s.start(sender, nil)
+	defer s.close(d)
+
+	main := d.channels[ChannelSMS].main
+	d.channels[ChannelSMS].main = &failQueue{Queue: main}
+	s.Require().EqualError(d.EnqueueSMS("otp:1", "TW", "0911", "hello"), "disk full")
+
+	// The key is released so the job can be enqueued again.
+	d.channels[ChannelSMS].main = main
+	s.Require().NoError(d.EnqueueSMS("otp:1", "TW", "0911", "hello"))
+	s.Require().Equal([]string{"0911"}, s.waitSent(sender, 1))
+}
+
+func (s *DispatcherTestSuite) TestEmptyDir() {
+	s.opt.Dir = ""
+	d := New(s.opt, nil)
+	s.Require().Equal(ErrQueueDir, d.Register(ChannelSMS, &recordSender{}))
+}
+
+func (s *DispatcherTestSuite) TestCorruptJob() {
+	channel := utils.NewPersistChannel(s.opt.Dir, string(ChannelSMS), 0)
+	channel.Write([]byte("{corrupt"))
+	channel.Flush()
+	channel.Close()
+
+	sender := &recordSender{}
+	d := s.start(sender, nil)
+	s.Require().NoError(d.EnqueueSMS("", "TW", "0911", "hello"))
+	s.Require().Equal([]string{"0911"}, s.waitSent(sender, 1))
+	s.close(d)
+
+	data, err := ioutil.ReadFile(d.DeadLetterPath(ChannelSMS))
+	s.Require().NoError(err)
+	s.Require().Contains(string(data), `"payload":"{corrupt"`)
+	s.Require().Contains(string(data), "corrupt job at 0")
+
+	// The corrupt job is committed and isn't popped again.
+	q, err := NewDiskQueue(s.opt.Dir, string(ChannelSMS))
+	s.Require().NoError(err)
+	defer q.Close()
+	job, _, err := q.Pop()
+	s.Require().NoError(err)
+	s.Require().Nil(job)
+}
+
+func (s *DispatcherTestSuite) TestRetry() {
+	sender := &recordSender{fails: 2, err: errors.New("provider down")}
+	d := s.start(sender, nil)
+	defer s.close(d)
+
+	s.Require().NoError(d.EnqueueSMS("otp:1", "TW", "0911", "hello"))
+	s.Require().Equal([]string{"0911"}, s.waitSent(sender, 1))
+	_, calls := sender.result()
+	s.Require().Equal(3, calls)
+}
+
+func (s *DispatcherTestSuite) TestDeadLetter() {
+	sender := &recordSender{fails: 100, err: errors.New("provider down")}
+	d := s.start(sender, nil)
+	defer s.close(d)
+
+	s.Require().NoError(d.EnqueueSMS("", "TW", "0911", "hello"))
+	s.Require().Eventually(func() bool {
+		data, err := ioutil.ReadFile(d.DeadLetterPath(ChannelSMS))
+		return err == nil && strings.Contains(string(data), "provider down")
+	}, 2*time.Second, 5*time.Millisecond)
+	_, calls := sender.result()
+	s.Require().Equal(s.opt.MaxAttempts, calls)
+}
+
+func (s *DispatcherTestSuite) TestPermanentError() {
+	sender := &recordSender{fails: 100, err: Permanent(errors.New("invalid phone"))}
+	d := s.start(sender, nil)
+	defer s.close(d)
+
+	s.Require().NoError(d.EnqueueSMS("", "TW", "0911", "hello"))
+	s.Require().Eventually(func() bool {
+		_, err := os.Stat(d.DeadLetterPath(ChannelSMS))
+		return err == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	_, calls := sender.result()
+	s.Require().Equal(1, calls)
+}
+
+func (s *DispatcherTestSuite) TestRecoverAfterRestart() {
+	inflight := make(chan struct{}, 2)
+	d := s.start(SenderFunc(func(ctx context.Context, job *Job) error {
+		inflight <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}), nil)
+	s.Require().NoError(d.EnqueueSMS("otp:1", "TW", "0911", "hello"))
+	s.Require().NoError(d.EnqueueSMS("otp:2", "TW", "0912", "hello"))
+	// Shut down while both jobs are in flight.
+	<-inflight
+	<-inflight
+	s.close(d)
+
+	sender := &recordSender{}
+	d = s.start(sender, nil)
+	defer s.close(d)
+	sent := s.waitSent(sender, 2)
+	s.Require().Contains(sent, "0911")
+	s.Require().Contains(sent, "0912")
+}
+
+func (s *DispatcherTestSuite) TestQueueCommitOutOfOrder() {
+	q, err := NewDiskQueue(s.opt.Dir, "queue")
+	s.Require().NoError(err)
+	for _, key := range []string{"a", "b", "c"} {
+		job, err := NewJob(ChannelSMS, key, &SMSRequest{})
+		s.Require().NoError(err)
+		s.Require().NoError(q.Push(job))
+	}
+	_, first, err := q.Pop()
+	s.Require().NoError(err)
+	_, second, err := q.Pop()
+	s.Require().NoError(err)
+	s.Require().NoError(q.Commit(second))
+	s.Require().NoError(q.Close())
+
+	// The first job isn't committed so both are redelivered.
+	q, err = NewDiskQueue(s.opt.Dir, "queue")
+	s.Require().NoError(err)
+	job, offset, err := q.Pop()
+	s.Require().NoError(err)
+	s.Require().Equal(first, offset)
+	s.Require().Equal("a", job.IdempotencyKey)
+	s.Require().NoError(q.Commit(offset))
+	job, _, err = q.Pop()
+	s.Require().NoError(err)
+	s.Require().Equal("b", job.IdempotencyKey)
+	s.Require().NoError(q.Close())
+}
+
+func (s *DispatcherTestSuite) TestRetryDueOrder() {
+	d := New(s.opt, nil)
+	q, err := NewDiskQueue(s.opt.Dir, "retry")
+	s.Require().NoError(err)
+	defer q.Close()
+
+	later, err := NewJob(ChannelSMS, "later", &SMSRequest{})
+	s.Require().NoError(err)
+	later.NextAttemptAt = time.Now().Add(time.Hour)
+	s.Require().NoError(q.Push(later))
+	due, err := NewJob(ChannelSMS, "due", &SMSRequest{})
+	s.Require().NoError(err)
+	s.Require().NoError(q.Push(due))
+
+	deliveries := make(chan *delivery)
+	d.wg.Add(1)
+	go d.pollRetry(ChannelSMS, q, deliveries)
+	defer func() {
+		d.cancel()
+		d.wg.Wait()
+	}()
+
+	// The job due later doesn't block the job behind it.
+	select {
+	case dl := <-deliveries:
+		s.Require().Equal("due", dl.job.IdempotencyKey)
+	case <-time.After(time.Second):
+		s.Fail("due job isn't delivered")
+	}
+}
+
+func (s *DispatcherTestSuite) TestMaxAge() {
+	s.opt.MaxAge = time.Minute
+	sender := &recordSender{}
+	d := s.start(sender, nil)
+	defer s.close(d)
+
+	job, err := NewJob(ChannelSMS, "", &SMSRequest{Phone: "0911"})
+	s.Require().NoError(err)
+	job.CreatedAt = time.Now().Add(-time.Hour)
+	s.Require().NoError(d.Enqueue(job))
+	s.Require().Eventually(func() bool {
+		_, err := os.Stat(d.DeadLetterPath(ChannelSMS))
+		return err == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	_, calls := sender.result()
+	s.Require().Equal(0, calls)
+}
+
+func (s *DispatcherTestSuite) TestSMSBodyRef() {
+	RegisterSMSBody("test-otp", func(ctx context.Context, ref string) (string, error) {
+		if ref != "1" {
+			return "", Permanent(errors.New("otp expired"))
+		}
+		return "123456 is your code", nil
+	})
+	var bodies []string
+	var mutex sync.Mutex
+	d := s.start(SenderFunc(func(ctx context.Context, job *Job) error {
+		req := SMSRequest{}
+		if err := unmarshalPayload(job, &req); err != nil {
+			return err
+		}
+		body, err := smsBody(ctx, &req)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		bodies = append(bodies, body)
+		return nil
+	}), nil)
+	defer s.close(d)
+
+	s.Require().NoError(d.EnqueueSMSRef("", "TW", "0911", "test-otp", "1"))
+	s.Require().NoError(d.EnqueueSMSRef("", "TW", "0912", "test-otp", "2"))
+	s.Require().Eventually(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(bodies) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	mutex.Lock()
+	s.Require().Equal([]string{"123456 is your code"}, bodies)
+	mutex.Unlock()
+
+	var data []byte
+	s.Require().Eventually(func() bool {
+		var err error
+		data, err = ioutil.ReadFile(d.DeadLetterPath(ChannelSMS))
+		return err == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	s.Require().Contains(string(data), "otp expired")
+	s.Require().NotContains(string(data), "123456")
+}
+
+func (s *DispatcherTestSuite) TestBackoff() {
+	d := New(s.opt, nil)
+	s.Require().Equal(10*time.Millisecond, d.backoff(1))
+	s.Require().Equal(20*time.Millisecond, d.backoff(2))
+	s.Require().Equal(40*time.Millisecond, d.backoff(3))
+	s.Require().Equal(40*time.Millisecond, d.backoff(10))
+}
+
+func TestDispatcher(t *testing.T) {
+	suite.Run(t, new(DispatcherTestSuite))
+}
@@ -0,0 +1,87 @@
+package dispatcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jiarung/mochi/cache"
+)
+
+// IdempotencyStore keeps idempotency keys for a period.
+type IdempotencyStore interface {
+	// Acquire returns false if key is acquired and not released within ttl.
+	Acquire(key string, ttl time.Duration) (bool, error)
+	// Release releases key.
+	Release(key string) error
+}
+
+// memoryIdempotencyStore keeps keys in memory.
+type memoryIdempotencyStore struct {
+	mutex sync.Mutex
+	keys  map[string]time.Time
+}
+
+// NewMemoryIdempotencyStore returns an idempotency store in memory. Keys are
+// lost when the process restarts.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{keys: make(map[string]time.Time)}
+}
+
+func (s *memoryIdempotencyStore) Acquire(
+	key string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if expiredAt, ok := s.keys[key]; ok && now.Before(expiredAt) {
+		return false, nil
+	}
+	// Drop expired keys lazily.
+	if len(s.keys) > 10000 {
+		for k, expiredAt := range s.keys {
+			if !now.Before(expiredAt) {
+				delete(s.keys, k)
+			}
+		}
+	}
+	s.keys[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+// redisIdempotencyStore keeps keys in redis.
+type redisIdempotencyStore struct {
+	redis *cache.Redis
+}
+
+// NewRedisIdempotencyStore returns an idempotency store backed by redis,
+// which is shared by all pods.
+func NewRedisIdempotencyStore(redis *cache.Redis) IdempotencyStore {
+	return &redisIdempotencyStore{redis: redis}
+}
+
+func redisIdempotencyKey(key string) string {
+	return "notification:dispatcher:idempotency:" + key
+}
+
+func (s *redisIdempotencyStore) Acquire(
+	key string, ttl time.Duration) (bool, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	v, err := rCli.Do("SET", redisIdempotencyKey(key), 1,
+		"EX", int(ttl.Seconds()), "NX")
+	if err != nil {
+		return false, err
+	}
+	return v == "OK", nil
+}
+
+func (s *redisIdempotencyStore) Release(key string) error {
+	return s.redis.Delete(redisIdempotencyKey(key))
+}
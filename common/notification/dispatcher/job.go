@@ -0,0 +1,101 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/common/notification/email"
+)
+
+// Channel defines the delivery channel of a job.
+type Channel string
+
+// Supported channels.
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+	ChannelPush  Channel = "push"
+	ChannelFB    Channel = "fb"
+	ChannelGA    Channel = "ga"
+)
+
+// Job is a queued notification.
+type Job struct {
+	ID      uuid.UUID       `json:"id"`
+	Channel Channel         `json:"channel"`
+	Payload json.RawMessage `json:"payload"`
+	// IdempotencyKey dedups enqueues and deliveries of the same
+	// notification. Jobs without key are never deduped.
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// NewJob creates a job with payload marshaled into JSON.
+func NewJob(channel Channel, idempotencyKey string,
+	payload interface{}) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s payload: %v", channel, err)
+	}
+	now := time.Now()
+	return &Job{
+		ID:             uuid.NewV4(),
+		Channel:        channel,
+		Payload:        data,
+		IdempotencyKey: idempotencyKey,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}, nil
+}
+
+// SMSRequest is the payload of a SMS job. Bodies with secrets, e.g.
+// one-time passwords, are referred by BodyKind and BodyRef, and rendered by
+// the SMSBodyFunc of BodyKind at delivery, so they aren't persisted in
+// queues and dead letters.
+type SMSRequest struct {
+	Country  string `json:"country"`
+	Phone    string `json:"phone"`
+	Body     string `json:"body,omitempty"`
+	BodyKind string `json:"body_kind,omitempty"`
+	BodyRef  string `json:"body_ref,omitempty"`
+}
+
+// EmailRequest is the payload of an email job. The request is kept with its
+// type name so it can be restored by email.GetEmailRequest.
+type EmailRequest struct {
+	Type       string          `json:"type"`
+	Request    json.RawMessage `json:"request"`
+	RequestTag string          `json:"request_tag"`
+}
+
+// NewEmailRequest wraps req into an email job payload.
+func NewEmailRequest(req email.Request, requestTag string) (*EmailRequest, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailRequest{
+		Type:       reflect.Indirect(reflect.ValueOf(req)).Type().Name(),
+		Request:    data,
+		RequestTag: requestTag,
+	}, nil
+}
+
+// PushRequest is the payload of a push job.
+type PushRequest struct {
+	Request *notification.AppRequest `json:"request"`
+}
+
+// AnalyticsRequest is the payload of FB and GA jobs.
+type AnalyticsRequest struct {
+	Values url.Values `json:"values"`
+}
@@ -0,0 +1,144 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jiarung/mochi/common/utils"
+)
+
+// Queue defines a durable FIFO queue of jobs.
+type Queue interface {
+	// Push appends job to the queue.
+	Push(job *Job) error
+	// Pop returns the next job and its offset, or nil job if the queue is
+	// empty. The job is redelivered after restart until it's committed. It
+	// returns a *CorruptJobError if the record can't be decoded.
+	Pop() (*Job, int64, error)
+	// Commit marks the job at offset done.
+	Commit(offset int64) error
+	// Close closes the queue.
+	Close() error
+}
+
+// CorruptJobError is returned by Queue.Pop for a record which can't be
+// decoded into a job. The record is in flight until its offset is committed.
+type CorruptJobError struct {
+	Offset int64
+	Data   []byte
+	Err    error
+}
+
+func (e *CorruptJobError) Error() string {
+	return fmt.Sprintf("corrupt job at %d: %v", e.Offset, e.Err)
+}
+
+func (e *CorruptJobError) Unwrap() error {
+	return e.Err
+}
+
+// diskQueue is a Queue on utils.PersistChannel. Jobs could be committed out
+// of order, and the committed offset only advances over contiguous done
+// jobs, so pending jobs are redelivered after restart.
+type diskQueue struct {
+	mutex      sync.Mutex
+	channel    *utils.PersistChannel
+	offsetPath string
+	committed  int64
+	inflight   map[int64]bool
+}
+
+// NewDiskQueue opens the queue of name in dir.
+func NewDiskQueue(dir, name string) (Queue, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	q := &diskQueue{
+		offsetPath: filepath.Join(dir, name+".offset"),
+		inflight:   make(map[int64]bool),
+	}
+
+	data, err := ioutil.ReadFile(q.offsetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		q.committed, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset file %s: %v", q.offsetPath, err)
+		}
+	}
+
+	q.channel = utils.NewPersistChannel(dir, name, q.committed)
+	return q, nil
+}
+
+func (q *diskQueue) Push(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := q.channel.Write(data); err != nil {
+		return err
+	}
+	// Flush so the job is on disk before Push returns.
+	q.channel.Flush()
+	return nil
+}
+
+func (q *diskQueue) Pop() (*Job, int64, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	data, offset := q.channel.Read()
+	if len(data) == 0 {
+		return nil, offset, nil
+	}
+	q.inflight[offset] = false
+
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, offset, &CorruptJobError{Offset: offset, Data: data, Err: err}
+	}
+	return job, offset, nil
+}
+
+func (q *diskQueue) Commit(offset int64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, ok := q.inflight[offset]; !ok {
+		return fmt.Errorf("offset %d is not in flight", offset)
+	}
+	q.inflight[offset] = true
+
+	committed := q.committed
+	for q.inflight[committed] {
+		delete(q.inflight, committed)
+		committed++
+	}
+	if committed == q.committed {
+		return nil
+	}
+	q.committed = committed
+
+	// Write then rename so a crash never leaves a torn offset file.
+	tmp := q.offsetPath + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(committed, 10)), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.offsetPath)
+}
+
+func (q *diskQueue) Close() error {
+	q.channel.Flush()
+	q.channel.Close()
+	return nil
+}
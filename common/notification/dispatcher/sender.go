@@ -0,0 +1,157 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/common/notification/email"
+)
+
+// Sender delivers jobs of a channel.
+type Sender interface {
+	Send(ctx context.Context, job *Job) error
+}
+
+// SenderFunc adapts a function to Sender.
+type SenderFunc func(ctx context.Context, job *Job) error
+
+// Send calls f.
+func (f SenderFunc) Send(ctx context.Context, job *Job) error {
+	return f(ctx, job)
+}
+
+// permanentError is an error that shouldn't be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps err so the job is dead-lettered without retries.
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// IsPermanent returns whether err is wrapped by Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+func unmarshalPayload(job *Job, v interface{}) error {
+	if err := json.Unmarshal(job.Payload, v); err != nil {
+		return Permanent(fmt.Errorf("unmarshal %s payload: %v", job.Channel, err))
+	}
+	return nil
+}
+
+// SMSBodyFunc renders the body of a SMS from its reference. It should
+// return an error wrapped by Permanent if the body would never be rendered,
+// e.g. the referred record is expired.
+type SMSBodyFunc func(ctx context.Context, ref string) (string, error)
+
+var (
+	smsBodyMutex sync.RWMutex
+	smsBodies    = map[string]SMSBodyFunc{}
+)
+
+// RegisterSMSBody registers fn to render bodies of SMS jobs of kind. It
+// should be called in init of the package that enqueues them.
+func RegisterSMSBody(kind string, fn SMSBodyFunc) {
+	smsBodyMutex.Lock()
+	defer smsBodyMutex.Unlock()
+	smsBodies[kind] = fn
+}
+
+// smsBody returns the body of req, which is rendered if it's referred.
+func smsBody(ctx context.Context, req *SMSRequest) (string, error) {
+	if len(req.BodyKind) == 0 {
+		return req.Body, nil
+	}
+	smsBodyMutex.RLock()
+	fn, ok := smsBodies[req.BodyKind]
+	smsBodyMutex.RUnlock()
+	if !ok {
+		return "", Permanent(fmt.Errorf("unknown sms body kind %s", req.BodyKind))
+	}
+	return fn(ctx, req.BodyRef)
+}
+
+// SMSSender returns the sender of SMS jobs.
+func SMSSender(sms *notification.SMSStruct) Sender {
+	return SenderFunc(func(ctx context.Context, job *Job) error {
+		req := SMSRequest{}
+		if err := unmarshalPayload(job, &req); err != nil {
+			return err
+		}
+		body, err := smsBody(ctx, &req)
+		if err != nil {
+			return err
+		}
+		_, err = sms.Send(ctx, req.Country, req.Phone, body)
+		return err
+	})
+}
+
+// EmailSender returns the sender of email jobs.
+func EmailSender(service *email.Service) Sender {
+	return SenderFunc(func(ctx context.Context, job *Job) error {
+		req := EmailRequest{}
+		if err := unmarshalPayload(job, &req); err != nil {
+			return err
+		}
+		emailReq, err := email.GetEmailRequest(req.Type, req.Request)
+		if err != nil {
+			return Permanent(err)
+		}
+		return service.SendContext(ctx, emailReq, req.RequestTag)
+	})
+}
+
+// PushSender returns the sender of push jobs.
+func PushSender(app *notification.AppStruct) Sender {
+	return SenderFunc(func(ctx context.Context, job *Job) error {
+		req := PushRequest{}
+		if err := unmarshalPayload(job, &req); err != nil {
+			return err
+		}
+		if req.Request == nil {
+			return Permanent(errors.New("empty push request"))
+		}
+		return app.Send(ctx, req.Request)
+	})
+}
+
+// FBSender returns the sender of facebook offline conversion jobs.
+//
+// Deprecated: Track events by package analytics, which batches them to all
+// sinks.
+func FBSender(fb *notification.FBStruct) Sender {
+	return SenderFunc(func(ctx context.Context, job *Job) error {
+		req := AnalyticsRequest{}
+		if err := unmarshalPayload(job, &req); err != nil {
+			return err
+		}
+		return fb.Send(ctx, &req.Values)
+	})
+}
+
+// GASender returns the sender of google analytics jobs.
+//
+// Deprecated: Track events by package analytics, which batches them to all
+// sinks.
+func GASender(ga *notification.GAStruct) Sender {
+	return SenderFunc(func(ctx context.Context, job *Job) error {
+		req := AnalyticsRequest{}
+		if err := unmarshalPayload(job, &req); err != nil {
+			return err
+		}
+		return ga.Send(ctx, &req.Values)
+	})
+}
@@ -2,7 +2,7 @@ package email
 
 import (
 	"fmt"
-	"html"
+	"html/template"
 	"strings"
 	"time"
 
@@ -67,7 +67,7 @@ func (e *BaseEmailRequest) genParameter(
 	lang := getLocale(id, []string{e.Locale})
 	subject := fixtures.EmailTemplates[id].TranslationMap[lang]["subject"]
 	paramMap["*|subject|*"] = subject
-	template, substitutions := genTemplate(id, lang, subject, paramMap)
+	tmpl, substitutions := genTemplate(id, lang, subject, paramMap)
 	substitutions["year"] = fmt.Sprintf("%d", time.Now().Year())
 
 	return &Parameter{
@@ -76,8 +76,9 @@ func (e *BaseEmailRequest) genParameter(
 		FromEmail:     e.FromEmail,
 		FromName:      e.FromName,
 		Subject:       subject,
-		Template:      template,
+		Template:      tmpl,
 		Substitutions: substitutions,
+		content:       template.HTML(substitutions["template"]),
 	}
 }
 
@@ -125,7 +126,7 @@ func (e *DeviceVerifyRequest) Parameter() *Parameter {
 	paramMap := map[string]string{
 		"*|name|*":     e.ToName,
 		"*|ip|*":       e.IP,
-		"*|platform|*": html.EscapeString(platformFullName),
+		"*|platform|*": platformFullName,
 		"*|link|*":     e.Link,
 	}
 	return e.genParameter(id, paramMap)
@@ -245,7 +246,7 @@ func (e *KYCVerifiedNotifyRequest) Parameter() *Parameter {
 	lang := getLocale(id, []string{e.Locale})
 	subject := fmt.Sprintf(
 		fixtures.EmailTemplates[id].TranslationMap[lang]["subject"], e.Level)
-	template, substitutions := genTemplate(id, lang, subject, paramMap)
+	tmpl, substitutions := genTemplate(id, lang, subject, paramMap)
 
 	return &Parameter{
 		To:            e.ToEmail,
@@ -253,8 +254,9 @@ func (e *KYCVerifiedNotifyRequest) Parameter() *Parameter {
 		FromEmail:     e.FromEmail,
 		FromName:      e.FromName,
 		Subject:       subject,
-		Template:      template,
+		Template:      tmpl,
 		Substitutions: substitutions,
+		content:       template.HTML(substitutions["template"]),
 	}
 }
 
@@ -264,14 +266,14 @@ func (e *KYCUpgradeFailedRequest) Parameter() *Parameter {
 	paramMap := map[string]string{
 		"*|name|*":   e.ToName,
 		"*|level|*":  fmt.Sprintf("%d", e.Level),
-		"*|reason|*": html.EscapeString(e.Reason),
+		"*|reason|*": e.Reason,
 		"*|time|*":   e.Time.UTC().Format(timeFormat),
 	}
 
 	lang := getLocale(id, []string{e.Locale})
 	subject := fmt.Sprintf(
 		fixtures.EmailTemplates[id].TranslationMap[lang]["subject"], e.Level)
-	template, substitutions := genTemplate(id, lang, subject, paramMap)
+	tmpl, substitutions := genTemplate(id, lang, subject, paramMap)
 
 	return &Parameter{
 		To:            e.ToEmail,
@@ -279,8 +281,9 @@ func (e *KYCUpgradeFailedRequest) Parameter() *Parameter {
 		FromEmail:     e.FromEmail,
 		FromName:      e.FromName,
 		Subject:       subject,
-		Template:      template,
+		Template:      tmpl,
 		Substitutions: substitutions,
+		content:       template.HTML(substitutions["template"]),
 	}
 }
 
@@ -336,7 +339,7 @@ func (e *ChangeEmailFailedRequest) Parameter() *Parameter {
 	id := "REJECT_CHANGE_EMAIL"
 	paramMap := map[string]string{
 		"*|name|*":   e.ToName,
-		"*|reason|*": html.EscapeString(e.Reason),
+		"*|reason|*": e.Reason,
 		"*|time|*":   e.Time.UTC().Format(timeFormat),
 	}
 	return e.genParameter(id, paramMap)
@@ -368,7 +371,7 @@ func (e *DeleteAccountFailedRequest) Parameter() *Parameter {
 	id := "REJECT_DELETE_ACCOUNT"
 	paramMap := map[string]string{
 		"*|name|*":   e.ToName,
-		"*|reason|*": html.EscapeString(e.Reason),
+		"*|reason|*": e.Reason,
 		"*|time|*":   e.Time.UTC().Format(timeFormat),
 	}
 	return e.genParameter(id, paramMap)
@@ -393,7 +396,7 @@ func (e *SlotMachineTokenRequest) Parameter() *Parameter {
 	paramMap := map[string]string{
 		"*|name|*":   e.ToName,
 		"*|count|*":  fmt.Sprintf("%d", e.Count),
-		"*|reason|*": html.EscapeString(reason),
+		"*|reason|*": reason,
 	}
 	return e.genParameter(id, paramMap)
 }
@@ -451,7 +454,7 @@ func (e *DisableTwoFARejectRequest) Parameter() *Parameter {
 	id := "DISABLE_TWO_FA_REQUEST_REJECT"
 	paramMap := map[string]string{
 		"*|name|*":   e.ToName,
-		"*|reason|*": html.EscapeString(e.Reason),
+		"*|reason|*": e.Reason,
 	}
 	return e.genParameter(id, paramMap)
 }
@@ -536,7 +539,7 @@ func (e *RevokeKYCNotifyRequest) Parameter() *Parameter {
 	id := "KYC_REVOCATION"
 	paramMap := map[string]string{
 		"*|name|*":           e.ToName,
-		"*|reason|*":         html.EscapeString(e.Reason),
+		"*|reason|*":         e.Reason,
 		"*|original_level|*": fmt.Sprintf("%d", e.OriginLevel),
 		"*|target_level|*":   fmt.Sprintf("%d", e.TargetLevel),
 	}
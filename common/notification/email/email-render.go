@@ -0,0 +1,196 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultLayouts are the layouts of templates in Parameter.Template. Only the
+// content rendered from translation templates of fixtures is trusted HTML,
+// whose values are escaped by genTemplate. Other content in substitution
+// "template", e.g. of GenericRequest, is escaped.
+const defaultLayouts = `
+{{define "GENERIC"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Subject}}</title>
+</head>
+<body>
+{{.Content}}
+<p>&copy; {{.Year}}</p>
+</body>
+</html>{{end}}
+{{define "GENERIC_EVENT"}}{{template "GENERIC" .}}{{end}}
+`
+
+// DefaultLayouts returns the built-in layouts. Layouts could be overridden
+// by parsing templates with the same names into it.
+func DefaultLayouts() *template.Template {
+	return template.Must(template.New("layouts").Parse(defaultLayouts))
+}
+
+// Message is a rendered email.
+type Message struct {
+	From     string
+	FromName string
+	To       string
+	ToName   string
+	Subject  string
+	HTML     string
+	Text     string
+}
+
+// Renderer renders requests into messages with html/template.
+type Renderer struct {
+	layouts *template.Template
+}
+
+// NewRenderer returns a renderer of layouts. DefaultLayouts() is used if
+// layouts is nil.
+func NewRenderer(layouts *template.Template) *Renderer {
+	if layouts == nil {
+		layouts = DefaultLayouts()
+	}
+	return &Renderer{layouts: layouts}
+}
+
+// layoutData is the data of layouts.
+type layoutData struct {
+	Subject       string
+	Name          string
+	Content       template.HTML
+	Year          string
+	Substitutions map[string]string
+}
+
+// Render renders req.
+func (r *Renderer) Render(req Request) (*Message, error) {
+	param := req.Parameter()
+	if param == nil {
+		return nil, fmt.Errorf("email request %T has no parameter", req)
+	}
+	layout := r.layouts.Lookup(param.Template)
+	if layout == nil {
+		return nil, fmt.Errorf("email layout %q not found", param.Template)
+	}
+
+	year := param.Substitutions["year"]
+	if len(year) == 0 {
+		year = fmt.Sprintf("%d", time.Now().Year())
+	}
+	content := param.content
+	if len(content) == 0 {
+		content = template.HTML(
+			template.HTMLEscapeString(param.Substitutions["template"]))
+	}
+	buf := &bytes.Buffer{}
+	err := layout.Execute(buf, &layoutData{
+		Subject:       param.Subject,
+		Name:          param.Name,
+		Content:       content,
+		Year:          year,
+		Substitutions: param.Substitutions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:     param.FromEmail,
+		FromName: param.FromName,
+		To:       param.To,
+		ToName:   param.Name,
+		Subject:  param.Subject,
+		HTML:     buf.String(),
+		Text:     htmlToText(buf.String()),
+	}, nil
+}
+
+var (
+	htmlHiddenRegexp  = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)>`)
+	htmlLinkRegexp    = regexp.MustCompile(`(?is)<a\b[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlNewlineRegexp = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h[1-6])>`)
+	htmlTagRegexp     = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinesRegexp  = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText converts s into plain text for the text part of emails. Links
+// are kept after their text.
+func htmlToText(s string) string {
+	s = htmlHiddenRegexp.ReplaceAllString(s, "")
+	s = htmlLinkRegexp.ReplaceAllString(s, "$2 ($1)")
+	s = htmlNewlineRegexp.ReplaceAllString(s, "\n")
+	s = htmlTagRegexp.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	s = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankLinesRegexp.ReplaceAllString(s, "\n\n"))
+}
+
+// Bytes encodes m as a multipart/alternative MIME message.
+func (m *Message) Bytes() ([]byte, error) {
+	if len(m.From) == 0 || len(m.To) == 0 {
+		return nil, errors.New("email message without sender or recipient")
+	}
+
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	header := []struct{ key, value string }{
+		{"From", (&mail.Address{Name: m.FromName, Address: m.From}).String()},
+		{"To", (&mail.Address{Name: m.ToName, Address: m.To}).String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, h := range header {
+		fmt.Fprintf(buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, p := range parts {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeQuotedPrintable(part, p.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -10,6 +10,7 @@ package email
 
 import (
 	"fmt"
+	"html/template"
 	"time"
 )
 
@@ -22,6 +23,10 @@ type Parameter struct {
 	Subject       string
 	Template      string            // template of email in service
 	Substitutions map[string]string // string substitutions in mail content
+
+	// content is the content rendered from the translation template of
+	// fixtures with escaped values, which is the only trusted HTML.
+	content template.HTML
 }
 
 // LocaleMap transfer from frontend format to standard
@@ -2,6 +2,7 @@ package email
 
 import (
 	"fmt"
+	"html"
 	"strings"
 
 	"github.com/jiarung/mochi/database/fixtures"
@@ -14,13 +15,29 @@ var templateList = map[string]struct{}{
 
 func getLocale(id string, locale []string) string {
 	if len(locale) > 0 {
-		if _, exist := fixtures.EmailTemplates[id]; !exist {
+		tmpl, exist := fixtures.EmailTemplates[id]
+		if !exist {
 			return "en"
 		}
-		if _, exist := fixtures.
-			EmailTemplates[id].
-			TranslationMap[locale[0]]; exist {
-			return locale[0]
+		return resolveLocale(locale[0], func(l string) bool {
+			_, exist := tmpl.TranslationMap[l]
+			return exist
+		})
+	}
+	return "en"
+}
+
+// resolveLocale returns the first locale with translation in locale, locale
+// mapped by LocaleMap, its language and the language mapped by LocaleMap. It
+// falls back to "en" if none of them is translated.
+func resolveLocale(locale string, translated func(string) bool) string {
+	candidates := []string{locale, LocaleMap[locale]}
+	if i := strings.IndexAny(locale, "_-"); i > 0 {
+		candidates = append(candidates, locale[:i], LocaleMap[locale[:i]])
+	}
+	for _, candidate := range candidates {
+		if len(candidate) > 0 && translated(candidate) {
+			return candidate
 		}
 	}
 	return "en"
@@ -33,6 +50,16 @@ func executeTemplate(template string, paramMap map[string]string) string {
 	return template
 }
 
+// escapeParams returns paramMap with values escaped, since values are from
+// callers and users while templates are trusted HTML of fixtures.
+func escapeParams(paramMap map[string]string) map[string]string {
+	escaped := make(map[string]string, len(paramMap))
+	for k, v := range paramMap {
+		escaped[k] = html.EscapeString(v)
+	}
+	return escaped
+}
+
 func genTemplate(id, lang, subject string, paramMap map[string]string) (
 	template string, substitutions map[string]string) {
 	template = fixtures.EmailTemplates[id].SendgridTemplate
@@ -44,7 +71,7 @@ func genTemplate(id, lang, subject string, paramMap map[string]string) (
 
 	htmltemplate := fixtures.EmailTemplates[id].
 		TranslationMap[lang]["template"]
-	htmltemplate = executeTemplate(htmltemplate, paramMap)
+	htmltemplate = executeTemplate(htmltemplate, escapeParams(paramMap))
 	substitutions = map[string]string{
 		"subject":  subject,
 		"template": htmltemplate,
@@ -0,0 +1,269 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonBuilder "github.com/jiarung/mochi/common/encode/json"
+	"github.com/jiarung/mochi/jsonrpc"
+)
+
+// Transport delivers email requests.
+type Transport interface {
+	Name() string
+	Send(ctx context.Context, req Request, requestTag string) error
+}
+
+// TransportFromEnv returns the transport configured by EMAIL_TRANSPORT,
+// which is one of "postman", "smtp" and "file". It returns nil for
+// "postman" or if it's not set.
+//
+// The smtp transport reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+// SMTP_PASSWORD, SMTP_FROM and SMTP_DISABLE_STARTTLS. The file transport
+// writes to EMAIL_FILE_DIR.
+func TransportFromEnv() (Transport, error) {
+	switch name := os.Getenv("EMAIL_TRANSPORT"); name {
+	case "", "postman":
+		return nil, nil
+	case "smtp":
+		cfg := SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     587,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+		if port := os.Getenv("SMTP_PORT"); len(port) > 0 {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q", port)
+			}
+			cfg.Port = p
+		}
+		if v := os.Getenv("SMTP_DISABLE_STARTTLS"); len(v) > 0 {
+			disabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_DISABLE_STARTTLS %q", v)
+			}
+			cfg.DisableStartTLS = disabled
+		}
+		return NewSMTPTransport(cfg, nil)
+	case "file":
+		return NewFileTransport(os.Getenv("EMAIL_FILE_DIR"), nil), nil
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_TRANSPORT %q", name)
+	}
+}
+
+// RPCTransport sends requests to the postman service over JSON-RPC, which
+// renders them with SendGrid templates.
+type RPCTransport struct {
+	Endpoint string
+}
+
+// Name returns the transport name.
+func (t *RPCTransport) Name() string {
+	return "postman"
+}
+
+// Send sends req to postman.
+func (t *RPCTransport) Send(
+	ctx context.Context, req Request, requestTag string) error {
+	rpcClient := jsonrpc.NewClient(t.Endpoint, "", "")
+	rpcClient.SetVersion(2)
+
+	requestType := reflect.Indirect(reflect.ValueOf(req)).Type().Name()
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	obj := jsonBuilder.Object(
+		jsonBuilder.Attr("type", requestType),
+		jsonBuilder.Attr("payload", payload),
+		jsonBuilder.Attr("request_tag", requestTag),
+	)
+	params := jsonrpc.NewParams()
+	params.UseObj(obj)
+	_, err = rpcClient.Post("sendgrid_handler", params, nil)
+	return err
+}
+
+// SMTPConfig defines the config of SMTPTransport.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender of requests without FromEmail.
+	From string
+	// DisableStartTLS allows sending without STARTTLS, e.g. to a local relay.
+	// Otherwise servers without STARTTLS are rejected.
+	DisableStartTLS bool
+	TLSConfig       *tls.Config
+	// Timeout is the timeout of a delivery if ctx has no deadline.
+	Timeout time.Duration
+}
+
+// SMTPTransport sends rendered requests to a SMTP server.
+type SMTPTransport struct {
+	cfg      SMTPConfig
+	renderer *Renderer
+}
+
+// NewSMTPTransport returns a SMTP transport. NewRenderer(nil) is used if
+// renderer is nil.
+func NewSMTPTransport(cfg SMTPConfig, renderer *Renderer) (*SMTPTransport, error) {
+	if len(cfg.Host) == 0 {
+		return nil, errors.New("empty SMTP host")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if renderer == nil {
+		renderer = NewRenderer(nil)
+	}
+	return &SMTPTransport{cfg: cfg, renderer: renderer}, nil
+}
+
+// Name returns the transport name.
+func (t *SMTPTransport) Name() string {
+	return "smtp"
+}
+
+// Send renders req and sends it.
+func (t *SMTPTransport) Send(
+	ctx context.Context, req Request, requestTag string) error {
+	msg, err := t.renderer.Render(req)
+	if err != nil {
+		return err
+	}
+	if len(msg.From) == 0 {
+		msg.From = t.cfg.From
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.cfg.Timeout)
+		defer cancel()
+	}
+	addr := net.JoinHostPort(t.cfg.Host, strconv.Itoa(t.cfg.Port))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, t.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		tlsConfig := t.cfg.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: t.cfg.Host}
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	} else if !t.cfg.DisableStartTLS {
+		return fmt.Errorf("SMTP server %s doesn't support STARTTLS", addr)
+	}
+
+	if len(t.cfg.Username) > 0 {
+		auth := smtp.PlainAuth("", t.cfg.Username, t.cfg.Password, t.cfg.Host)
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(msg.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileTransport writes rendered requests as .eml files for development.
+type FileTransport struct {
+	dir      string
+	renderer *Renderer
+}
+
+// NewFileTransport returns a file transport writing to dir, or a temp dir if
+// dir is empty. NewRenderer(nil) is used if renderer is nil.
+func NewFileTransport(dir string, renderer *Renderer) *FileTransport {
+	if len(dir) == 0 {
+		dir = filepath.Join(os.TempDir(), "emails")
+	}
+	if renderer == nil {
+		renderer = NewRenderer(nil)
+	}
+	return &FileTransport{dir: dir, renderer: renderer}
+}
+
+// Name returns the transport name.
+func (t *FileTransport) Name() string {
+	return "file"
+}
+
+// Dir returns the directory of files.
+func (t *FileTransport) Dir() string {
+	return t.dir
+}
+
+// Send renders req and writes it to <dir>/<time>-<type>-<requestTag>.eml.
+func (t *FileTransport) Send(
+	ctx context.Context, req Request, requestTag string) error {
+	msg, err := t.renderer.Render(req)
+	if err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(t.dir, os.ModePerm); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s-%s.eml", time.Now().UnixNano(),
+		reflect.Indirect(reflect.ValueOf(req)).Type().Name(),
+		strings.Replace(requestTag, string(os.PathSeparator), "_", -1))
+	return ioutil.WriteFile(filepath.Join(t.dir, name), data, 0644)
+}
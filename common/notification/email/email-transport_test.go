@@ -0,0 +1,245 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func newGenericRequest() *GenericRequest {
+	return &GenericRequest{
+		BaseEmailRequest: BaseEmailRequest{
+			FromEmail: "noreply@example.com",
+			FromName:  "Mochi",
+			ToName:    "Alice",
+			ToEmail:   "alice@example.com",
+		},
+		Subject:  "Verify <email>",
+		Template: "GENERIC",
+		Substitutions: map[string]string{
+			"template": `<p>Hi Alice,</p><p><a href="https://example.com/v">Verify</a></p>`,
+			"year":     "2018",
+		},
+	}
+}
+
+type EmailTransportTestSuite struct {
+	suite.Suite
+}
+
+func (s *EmailTransportTestSuite) TestResolveLocale() {
+	translated := func(l string) bool {
+		return l == "en" || l == "zh_Hant_TW" || l == "pt_PT" || l == "de"
+	}
+	s.Equal("zh_Hant_TW", resolveLocale("zh_Hant_TW", translated))
+	s.Equal("zh_Hant_TW", resolveLocale("zh-Hant", translated))
+	s.Equal("pt_PT", resolveLocale("pt", translated))
+	s.Equal("pt_PT", resolveLocale("pt_BR", translated))
+	s.Equal("de", resolveLocale("de_AT", translated))
+	s.Equal("en", resolveLocale("ko", translated))
+	s.Equal("en", resolveLocale("", translated))
+}
+
+func (s *EmailTransportTestSuite) TestHTMLToText() {
+	text := htmlToText(`<html><head><title>x</title><style>p{}</style></head>
+<body><p>Hi   &amp; welcome,</p><p><a href="https://a/b">Confirm</a><br>Bye</p></body></html>`)
+	s.Equal("Hi & welcome,\nConfirm (https://a/b)\nBye", text)
+}
+
+func (s *EmailTransportTestSuite) TestRender() {
+	msg, err := NewRenderer(nil).Render(newGenericRequest())
+	s.Require().NoError(err)
+	s.Equal("alice@example.com", msg.To)
+	s.Contains(msg.HTML, "<title>Verify &lt;email&gt;</title>")
+	s.Contains(msg.HTML, "&copy; 2018")
+
+	req := newGenericRequest()
+	req.Template = "UNKNOWN"
+	_, err = NewRenderer(nil).Render(req)
+	s.Error(err)
+}
+
+func (s *EmailTransportTestSuite) TestRenderEscape() {
+	// Content of callers isn't trusted.
+	msg, err := NewRenderer(nil).Render(newGenericRequest())
+	s.Require().NoError(err)
+	s.NotContains(msg.HTML, `<a href=`)
+	s.Contains(msg.HTML, `&lt;a href=&#34;https://example.com/v&#34;&gt;`)
+
+	// Values in translation templates are escaped.
+	req := &AuthSuccessRequest{
+		BaseEmailRequest: BaseEmailRequest{
+			FromEmail: "noreply@example.com",
+			ToName:    "<script>alert(1)</script>",
+			ToEmail:   "alice@example.com",
+		},
+		IP: "<b>127.0.0.1</b>",
+	}
+	msg, err = NewRenderer(nil).Render(req)
+	s.Require().NoError(err)
+	s.NotContains(msg.HTML, "<script>")
+	s.NotContains(msg.HTML, "<b>127.0.0.1</b>")
+}
+
+func (s *EmailTransportTestSuite) TestMessageBytes() {
+	msg, err := NewRenderer(nil).Render(newGenericRequest())
+	s.Require().NoError(err)
+	data, err := msg.Bytes()
+	s.Require().NoError(err)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	s.Require().NoError(err)
+	s.Equal(`"Alice" <alice@example.com>`, parsed.Header.Get("To"))
+	subject, err := (&mime.WordDecoder{}).DecodeHeader(parsed.Header.Get("Subject"))
+	s.Require().NoError(err)
+	s.Equal("Verify <email>", subject)
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	s.Require().NoError(err)
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		body, err := ioutil.ReadAll(quotedprintable.NewReader(part))
+		s.Require().NoError(err)
+		types = append(types, part.Header.Get("Content-Type"))
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			// Line breaks are encoded as CRLF.
+			s.Equal(msg.HTML, strings.Replace(string(body), "\r\n", "\n", -1))
+		}
+	}
+	s.Equal([]string{
+		"text/plain; charset=utf-8",
+		"text/html; charset=utf-8",
+	}, types)
+
+	_, err = (&Message{To: "a@b"}).Bytes()
+	s.Error(err)
+}
+
+func (s *EmailTransportTestSuite) TestFileTransport() {
+	dir, err := ioutil.TempDir("", "email")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	transport := NewFileTransport(dir, nil)
+	s.Require().NoError(transport.Send(
+		context.Background(), newGenericRequest(), "tag/1"))
+	files, err := ioutil.ReadDir(dir)
+	s.Require().NoError(err)
+	s.Require().Len(files, 1)
+	s.True(strings.HasSuffix(files[0].Name(), "-GenericRequest-tag_1.eml"))
+}
+
+// fakeSMTPServer accepts a SMTP session without STARTTLS and records the
+// envelope and data.
+func (s *EmailTransportTestSuite) fakeSMTPServer() (
+	addr string, result chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	result = make(chan []string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		reader := bufio.NewReader(conn)
+		write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		write("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					write("250 OK")
+					continue
+				}
+				lines = append(lines, line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				write("250 localhost")
+			case "MAIL", "RCPT":
+				lines = append(lines, line)
+				write("250 OK")
+			case "DATA":
+				inData = true
+				write("354 Go ahead")
+			case "QUIT":
+				write("221 Bye")
+				result <- lines
+				return
+			default:
+				write("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), result
+}
+
+func (s *EmailTransportTestSuite) TestSMTPTransport() {
+	addr, result := s.fakeSMTPServer()
+	host, port, err := net.SplitHostPort(addr)
+	s.Require().NoError(err)
+	portNum, err := strconv.Atoi(port)
+	s.Require().NoError(err)
+
+	// Servers without STARTTLS are rejected by default.
+	transport, err := NewSMTPTransport(SMTPConfig{
+		Host: host,
+		Port: portNum,
+	}, nil)
+	s.Require().NoError(err)
+	s.Error(transport.Send(context.Background(), newGenericRequest(), ""))
+
+	addr, result = s.fakeSMTPServer()
+	_, port, err = net.SplitHostPort(addr)
+	s.Require().NoError(err)
+	portNum, err = strconv.Atoi(port)
+	s.Require().NoError(err)
+	transport, err = NewSMTPTransport(SMTPConfig{
+		Host:            host,
+		Port:            portNum,
+		DisableStartTLS: true,
+		Timeout:         5 * time.Second,
+	}, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(transport.Send(
+		context.Background(), newGenericRequest(), ""))
+
+	lines := <-result
+	s.Require().True(len(lines) > 2)
+	s.Equal("MAIL FROM:<noreply@example.com>", lines[0])
+	s.Equal("RCPT TO:<alice@example.com>", lines[1])
+	s.Contains(strings.Join(lines[2:], "\n"), "To: \"Alice\" <alice@example.com>")
+}
+
+func TestEmailTransport(t *testing.T) {
+	suite.Run(t, new(EmailTransportTestSuite))
+}
@@ -1,6 +1,7 @@
 package email
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,8 +13,6 @@ import (
 	"github.com/jiarung/mochi/cache"
 	"github.com/jiarung/mochi/cache/keys"
 	"github.com/jiarung/mochi/common/config/misc"
-	jsonBuilder "github.com/jiarung/mochi/common/encode/json"
-	"github.com/jiarung/mochi/jsonrpc"
 	models "github.com/jiarung/mochi/models/exchange"
 	"github.com/jiarung/mochi/types"
 )
@@ -22,18 +21,28 @@ import (
 type Config struct {
 	CallbackDomain  string
 	EmailSenderAddr string
+	// Transport delivers requests. Requests are sent to postman at
+	// EmailSenderAddr if it's nil.
+	Transport Transport
 }
 
 // Service provides namespace for email functions.
 type Service struct {
 	Config
 	serviceName string
+	// err is the error of the config, which is returned by sends.
+	err error
 }
 
-func genConfig() Config {
+func genConfig() (Config, error) {
+	transport, err := TransportFromEnv()
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to get email transport. err: %v", err)
+	}
 	return Config{
 		EmailSenderAddr: misc.PostmanServerEndpoint(),
-	}
+		Transport:       transport,
+	}, nil
 }
 
 // New return new service.
@@ -47,11 +56,16 @@ var (
 	once  sync.Once
 )
 
-// Default returns service as singleton.
+// Default returns service as singleton. Its sends fail if the transport
+// configured by env is invalid, see TransportFromEnv.
 func Default() *Service {
 	once.Do(func() {
-		email = New(genConfig())
-		email.serviceName = "postman"
+		cfg, err := genConfig()
+		email = New(cfg)
+		email.err = err
+		if err == nil {
+			email.serviceName = email.transport().Name()
+		}
 	})
 
 	return email
@@ -62,27 +76,25 @@ func (e *Service) ServiceName() string {
 	return e.serviceName
 }
 
-// Send calls internal send api.
-func (e *Service) Send(req Request, requestTag string) error {
+func (e *Service) transport() Transport {
+	if e.Transport != nil {
+		return e.Transport
+	}
+	return &RPCTransport{Endpoint: e.EmailSenderAddr}
+}
 
-	rpcClient := jsonrpc.NewClient(e.EmailSenderAddr, "", "")
-	rpcClient.SetVersion(2)
+// Send sends req through the transport.
+func (e *Service) Send(req Request, requestTag string) error {
+	return e.SendContext(context.Background(), req, requestTag)
+}
 
-	requestType := reflect.Indirect(reflect.ValueOf(req)).Type().Name()
-	payload, err := json.Marshal(req)
-	if err != nil {
-		return err
+// SendContext sends req through the transport with ctx.
+func (e *Service) SendContext(
+	ctx context.Context, req Request, requestTag string) error {
+	if e.err != nil {
+		return e.err
 	}
-
-	obj := jsonBuilder.Object(
-		jsonBuilder.Attr("type", requestType),
-		jsonBuilder.Attr("payload", payload),
-		jsonBuilder.Attr("request_tag", requestTag),
-	)
-	params := jsonrpc.NewParams()
-	params.UseObj(obj)
-	_, err = rpcClient.Post("sendgrid_handler", params, nil)
-	return err
+	return e.transport().Send(ctx, req, requestTag)
 }
 
 // GetEmailRequest get the email's request in interface{} which for unit test.
@@ -2,6 +2,7 @@ package email
 
 import (
 	"fmt"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,6 +19,15 @@ func TestCheckVar(t *testing.T) {
 	assert.NotRegexp(tmplVar, `Name`)
 }
 
+func TestDefaultInvalidTransport(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("EMAIL_TRANSPORT", "pigeon")
+	defer os.Unsetenv("EMAIL_TRANSPORT")
+
+	err := Default().Send(&DepositReminderRequest{}, "test")
+	assert.NotNil(err)
+}
+
 func TestExecuteTemplate(t *testing.T) {
 	assert := assert.New(t)
 	result := executeTemplate("<*|Hello|*>", map[string]string{
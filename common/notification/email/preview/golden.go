@@ -0,0 +1,75 @@
+package preview
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// goldenExts are extensions of golden files of a preview.
+var goldenExts = []string{".html", ".txt", ".err"}
+
+// goldenFiles returns the files of p, which are <type>/<locale>.html and
+// <type>/<locale>.txt, or <type>/<locale>.err if it failed to render.
+func goldenFiles(p *Preview) map[string][]byte {
+	base := filepath.Join(p.Type, p.Locale)
+	if p.Err != nil {
+		return map[string][]byte{base + ".err": []byte(p.Err.Error() + "\n")}
+	}
+	return map[string][]byte{
+		base + ".html": []byte(p.Message.HTML),
+		base + ".txt":  []byte(p.Message.Text + "\n"),
+	}
+}
+
+// WriteGolden writes previews as golden files in dir. Only files of
+// previews are overwritten, and other files in dir are kept.
+func WriteGolden(dir string, previews []*Preview) error {
+	for _, p := range previews {
+		files := goldenFiles(p)
+		// Remove files of the preview which aren't rendered anymore, e.g.
+		// .err after it's fixed.
+		for _, ext := range goldenExts {
+			name := filepath.Join(p.Type, p.Locale) + ext
+			if _, ok := files[name]; ok {
+				continue
+			}
+			err := os.Remove(filepath.Join(dir, name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		for name, data := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CompareGolden returns golden files in dir which are different from
+// previews or missing.
+func CompareGolden(dir string, previews []*Preview) ([]string, error) {
+	var diffs []string
+	for _, p := range previews {
+		for name, data := range goldenFiles(p) {
+			golden, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if os.IsNotExist(err) {
+				diffs = append(diffs, name)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(golden, data) {
+				diffs = append(diffs, name)
+			}
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,77 @@
+package preview
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jiarung/mochi/common/notification/email"
+	"github.com/jiarung/mochi/common/utils"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Email previews</title></head>
+<body>
+<h1>Email previews</h1>
+<table>
+{{range $type := .Types}}<tr><td>{{$type}}</td><td>
+{{range $locale := $.Locales}}<a href="{{$type}}/{{$locale}}">{{$locale}}</a>
+<a href="{{$type}}/{{$locale}}?format=text">(text)</a>
+{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>`))
+
+// RegisterHandler registers preview routes to r, except in production.
+//
+//	GET /                index of request types and locales.
+//	GET /:type/:locale   the rendered HTML, or text with ?format=text.
+func RegisterHandler(r gin.IRouter, renderer *email.Renderer) {
+	if utils.IsProduction() {
+		return
+	}
+	if renderer == nil {
+		renderer = email.NewRenderer(nil)
+	}
+
+	r.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+		ctx.Header("Content-Type", "text/html; charset=utf-8")
+		err := indexTemplate.Execute(ctx.Writer, map[string][]string{
+			"Types":   Types(),
+			"Locales": Locales(),
+		})
+		if err != nil {
+			ctx.Error(err)
+		}
+	})
+
+	r.GET("/:type/:locale", func(ctx *gin.Context) {
+		typeName := ctx.Param("type")
+		if !isType(typeName) {
+			ctx.String(http.StatusNotFound, "unknown email request %s", typeName)
+			return
+		}
+		msg, err := Render(renderer, typeName, ctx.Param("locale"))
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ctx.Query("format") == "text" {
+			ctx.String(http.StatusOK, "Subject: %s\n\n%s", msg.Subject, msg.Text)
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(msg.HTML))
+	})
+}
+
+func isType(typeName string) bool {
+	for _, name := range Types() {
+		if name == typeName {
+			return true
+		}
+	}
+	return false
+}
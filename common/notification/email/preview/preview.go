@@ -0,0 +1,181 @@
+// Package preview renders every email request with fixture data in every
+// locale, to review templates and to catch template regressions with golden
+// files.
+package preview
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jiarung/mochi/common/notification/email"
+	"github.com/jiarung/mochi/types"
+)
+
+// Domain is the domain set to requests by SetDomain.
+const Domain = "preview.example.com"
+
+// FixedTime is the time of time fields, which keeps previews stable.
+var FixedTime = time.Date(2018, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// fixtureOverrides fixes fields which must have specific values.
+var fixtureOverrides = map[string]func(email.Request){
+	"GenericRequest": func(req email.Request) {
+		r := req.(*email.GenericRequest)
+		r.Template = "GENERIC"
+		r.Substitutions = map[string]string{
+			"template": "Hi ToName, this is a generic email.",
+		}
+	},
+	"SlotMachineTokenRequest": func(req email.Request) {
+		r := req.(*email.SlotMachineTokenRequest)
+		r.RewardType = string(types.DailyTradingReward)
+	},
+}
+
+// Preview is a request rendered in a locale.
+type Preview struct {
+	Type    string
+	Locale  string
+	Message *email.Message
+	Err     error
+}
+
+// Locales returns locales of templates, which are values of
+// email.LocaleMap.
+func Locales() []string {
+	set := map[string]bool{}
+	for _, locale := range email.LocaleMap {
+		set[locale] = true
+	}
+	locales := make([]string, 0, len(set))
+	for locale := range set {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Types returns type names of email.Requests.
+func Types() []string {
+	names := make([]string, 0, len(email.Requests))
+	for _, req := range email.Requests {
+		names = append(names, typeName(req))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func typeName(req email.Request) string {
+	return reflect.Indirect(reflect.ValueOf(req)).Type().Name()
+}
+
+// NewFixture returns a request of typeName filled with fixture data in
+// locale. Strings are filled with their field names, emails with
+// <field>@example.com, times with FixedTime and links by SetDomain(Domain).
+func NewFixture(typeName, locale string) (email.Request, error) {
+	var proto email.Request
+	for _, req := range email.Requests {
+		if typeName == reflect.Indirect(reflect.ValueOf(req)).Type().Name() {
+			proto = req
+			break
+		}
+	}
+	if proto == nil {
+		return nil, fmt.Errorf("unknown email request %s", typeName)
+	}
+
+	v := reflect.New(reflect.Indirect(reflect.ValueOf(proto)).Type())
+	fill(v.Elem())
+	req := v.Interface().(email.Request)
+	if override, ok := fixtureOverrides[typeName]; ok {
+		override(req)
+	}
+	if r, ok := req.(interface{ SetDomain(string) }); ok {
+		r.SetDomain(Domain)
+	}
+	field := v.Elem().FieldByName("Locale")
+	if !field.IsValid() || !field.CanSet() || field.Kind() != reflect.String {
+		return nil, fmt.Errorf("email request %s has no locale", typeName)
+	}
+	field.SetString(locale)
+	return req, nil
+}
+
+func fill(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, fieldType := v.Field(i), t.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			if strings.HasSuffix(fieldType.Name, "Email") {
+				field.SetString(strings.ToLower(fieldType.Name) + "@example.com")
+			} else {
+				field.SetString(fieldType.Name)
+			}
+		case reflect.Int, reflect.Int64:
+			field.SetInt(2)
+		case reflect.Slice:
+			if fieldType.Type.Elem().Kind() == reflect.String {
+				field.Set(reflect.ValueOf([]string{"BTC-USDT", "ETH-USDT"}))
+			}
+		case reflect.Struct:
+			if fieldType.Type == reflect.TypeOf(time.Time{}) {
+				field.Set(reflect.ValueOf(FixedTime))
+			} else {
+				fill(field)
+			}
+		}
+	}
+}
+
+// fixedYear fixes the year substitution of Request.
+type fixedYear struct {
+	email.Request
+}
+
+func (r fixedYear) Parameter() *email.Parameter {
+	param := r.Request.Parameter()
+	if param != nil && param.Substitutions != nil {
+		param.Substitutions["year"] = fmt.Sprintf("%d", FixedTime.Year())
+	}
+	return param
+}
+
+// Render renders the fixture of typeName in locale.
+func Render(renderer *email.Renderer, typeName, locale string) (
+	msg *email.Message, err error) {
+	req, err := NewFixture(typeName, locale)
+	if err != nil {
+		return nil, err
+	}
+	// Parameter() panics on templates not in fixtures.
+	defer func() {
+		if r := recover(); r != nil {
+			msg, err = nil, fmt.Errorf("render %s in %s: %v", typeName, locale, r)
+		}
+	}()
+	return renderer.Render(fixedYear{req})
+}
+
+// RenderAll renders every request in every locale.
+func RenderAll(renderer *email.Renderer) []*Preview {
+	var previews []*Preview
+	for _, name := range Types() {
+		for _, locale := range Locales() {
+			msg, err := Render(renderer, name, locale)
+			previews = append(previews, &Preview{
+				Type:    name,
+				Locale:  locale,
+				Message: msg,
+				Err:     err,
+			})
+		}
+	}
+	return previews
+}
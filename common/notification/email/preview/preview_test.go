@@ -0,0 +1,143 @@
+package preview
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/notification/email"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const goldenDir = "testdata/golden"
+
+type PreviewTestSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (s *PreviewTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "golden")
+	s.Require().NoError(err)
+	s.dir = dir
+}
+
+func (s *PreviewTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *PreviewTestSuite) TestLocales() {
+	locales := Locales()
+	s.Contains(locales, "en")
+	s.Contains(locales, "zh_Hant_TW")
+	s.IsIncreasing(locales)
+}
+
+func (s *PreviewTestSuite) TestNewFixture() {
+	req, err := NewFixture("VerifyEmailRequest", "de")
+	s.Require().NoError(err)
+	r := req.(*email.VerifyEmailRequest)
+	s.Equal("toemail@example.com", r.ToEmail)
+	s.Equal("ToName", r.ToName)
+	s.Equal("de", r.Locale)
+	s.Contains(r.ActiveLink, Domain)
+
+	_, err = NewFixture("UnknownRequest", "en")
+	s.Error(err)
+}
+
+func (s *PreviewTestSuite) TestGoldenRoundTrip() {
+	previews := []*Preview{{
+		Type:    "GenericRequest",
+		Locale:  "en",
+		Message: &email.Message{HTML: "<p>Hi</p>", Text: "Hi"},
+	}}
+	s.Require().NoError(WriteGolden(s.dir, previews))
+	diffs, err := CompareGolden(s.dir, previews)
+	s.Require().NoError(err)
+	s.Empty(diffs)
+
+	previews[0].Message.HTML = "<p>Hello</p>"
+	diffs, err = CompareGolden(s.dir, previews)
+	s.Require().NoError(err)
+	s.Equal([]string{filepath.Join("GenericRequest", "en.html")}, diffs)
+}
+
+func (s *PreviewTestSuite) TestWriteGoldenKeepsFiles() {
+	other := filepath.Join(s.dir, "README")
+	s.Require().NoError(ioutil.WriteFile(other, []byte("keep"), 0644))
+
+	previews := []*Preview{{
+		Type:   "GenericRequest",
+		Locale: "en",
+		Err:    os.ErrNotExist,
+	}}
+	s.Require().NoError(WriteGolden(s.dir, previews))
+	previews[0].Err = nil
+	previews[0].Message = &email.Message{HTML: "<p>Hi</p>", Text: "Hi"}
+	s.Require().NoError(WriteGolden(s.dir, previews))
+
+	// Files of other previews are kept, and stale files of the preview are
+	// removed.
+	_, err := os.Stat(other)
+	s.NoError(err)
+	_, err = os.Stat(filepath.Join(s.dir, "GenericRequest", "en.err"))
+	s.True(os.IsNotExist(err))
+	diffs, err := CompareGolden(s.dir, previews)
+	s.Require().NoError(err)
+	s.Empty(diffs)
+}
+
+// TestGolden compares every request in every locale with golden files. Run
+// with -update to regenerate them after changing templates or registering
+// types. Types without golden files fail.
+func (s *PreviewTestSuite) TestGolden() {
+	previews := RenderAll(email.NewRenderer(nil))
+	if *update {
+		s.Require().NoError(WriteGolden(goldenDir, previews))
+		return
+	}
+
+	byType := map[string][]*Preview{}
+	for _, p := range previews {
+		byType[p.Type] = append(byType[p.Type], p)
+	}
+	for _, name := range Types() {
+		s.Run(name, func() {
+			_, err := os.Stat(filepath.Join(goldenDir, name))
+			s.Require().False(os.IsNotExist(err),
+				"no golden files of %s, run with -update to generate them", name)
+			diffs, err := CompareGolden(goldenDir, byType[name])
+			s.Require().NoError(err)
+			s.Empty(diffs, "templates changed, run with -update if expected")
+		})
+	}
+}
+
+func (s *PreviewTestSuite) TestHandler() {
+	engine := gin.New()
+	RegisterHandler(engine.Group("/emails"), nil)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/emails/", nil))
+	s.Equal(http.StatusOK, w.Code)
+	s.Contains(w.Body.String(), `href="VerifyEmailRequest/en"`)
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(
+		http.MethodGet, "/emails/UnknownRequest/en", nil))
+	s.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestPreview(t *testing.T) {
+	suite.Run(t, new(PreviewTestSuite))
+}
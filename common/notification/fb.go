@@ -17,7 +17,8 @@ const (
 	tagName = "from_server"
 )
 
-// FBConfig defines config of FB.
+// FBConfig defines config of facebook offline conversions. Events are sent
+// by the facebook sink of package analytics.
 type FBConfig struct {
 	OfflineEventSetID string `config:"FbOfflineEventSetId"`
 
@@ -25,6 +26,9 @@ type FBConfig struct {
 }
 
 // FBStruct provides namespace for facebook functions.
+//
+// Deprecated: Track events by package analytics, which batches them to all
+// sinks.
 type FBStruct struct {
 	cfg      FBConfig
 	endpoint string
@@ -93,7 +97,7 @@ func (fb *FBStruct) GenDeposited(
 				// Because if we put it on higher level, fb will complain
 				// that we should add "currency" field, but fb doesn't accept "BTC"
 				// (It only accepts ISO 4217 currency code).
-				jsonBuilder.Attr("value", decimalToFloat(btcToSatoshi(deposit.BTCValue))),
+				jsonBuilder.Attr("value", decimalToFloat(BTCToSatoshi(deposit.BTCValue))),
 			)),
 		),
 	).Marshal()
@@ -112,7 +116,7 @@ func (fb *FBStruct) GenOrderCompleted(
 	v.Set("upload_tag", tagName)
 	v.Set("access_token", fb.cfg.AccessToken)
 
-	satoshi, err := orderSatoshi(order)
+	satoshi, err := OrderSatoshi(order)
 	if err != nil {
 		return nil, fmt.Errorf("get satoshi from order fail err %v", err)
 	}
@@ -14,7 +14,17 @@ const (
 	gaEndPoint = "https://www.google-analytics.com/collect"
 )
 
+// GAConfig defines config of google analytics. Events are sent by the google
+// analytics sink of package analytics.
+type GAConfig struct {
+	// thirdparty.GaTrackingId())
+	TrackingID string `config:"GaTrackingId"`
+}
+
 // GAStruct provides namespace for google ananlytics functions.
+//
+// Deprecated: Track events by package analytics, which batches them to all
+// sinks.
 type GAStruct struct {
 	// thirdparty.GaTrackingId())
 	TrackingID string `config:"GaTrackingId"`
@@ -49,7 +59,7 @@ func (ga *GAStruct) GenDeposited(deposit *models.Deposit) *url.Values {
 	v.Set("tid", ga.TrackingID)
 	v.Set("ec", "Balance")
 	v.Set("ea", "Deposited")
-	v.Set("ev", btcToSatoshi(deposit.BTCValue).StringFixed(0))
+	v.Set("ev", BTCToSatoshi(deposit.BTCValue).StringFixed(0))
 	return &v
 }
 
@@ -62,7 +72,7 @@ func (ga *GAStruct) GenOrderCompleted(order *models.Order) (*url.Values, error)
 	v.Set("tid", ga.TrackingID)
 	v.Set("ec", "Order")
 	v.Set("ea", "Completed")
-	satoshi, err := orderSatoshi(order)
+	satoshi, err := OrderSatoshi(order)
 	if err != nil {
 		return nil, fmt.Errorf("get satoshi from order fail err %v", err)
 	}
@@ -0,0 +1,236 @@
+package preference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/common/notification/email"
+)
+
+// ErrNoCenter is returned by Notify if the default center isn't set.
+var ErrNoCenter = errors.New("notification center isn't set")
+
+var notificationsTotal = metric.NewCounter("notification_preference",
+	"notifications_total", "Total number of notifications by result.",
+	"category", "channel", "result")
+
+// SMSMessage is the SMS content of a notification. Bodies with secrets, e.g.
+// one-time passwords, are referred by BodyKind and BodyRef instead of Body,
+// see dispatcher.Dispatcher.EnqueueSMSRef.
+type SMSMessage struct {
+	Country  string
+	Phone    string
+	Body     string
+	BodyKind string
+	BodyRef  string
+}
+
+// ChatMessage is the chat content of a notification, which is sent to LINE
+// ID To.
+type ChatMessage struct {
+	To   string
+	Text string
+}
+
+// Payload is the content of a notification per channel. Channels without
+// content are skipped.
+type Payload struct {
+	// ID dedups deliveries of the same notification if it's not empty.
+	ID    string
+	Email email.Request
+	SMS   *SMSMessage
+	Push  *notification.AppRequest
+	Chat  *ChatMessage
+}
+
+func (p *Payload) has(channel Channel) bool {
+	switch channel {
+	case ChannelEmail:
+		return p.Email != nil
+	case ChannelSMS:
+		return p.SMS != nil
+	case ChannelPush:
+		return p.Push != nil
+	case ChannelChat:
+		return p.Chat != nil
+	}
+	return false
+}
+
+// Sender delivers payloads to a channel.
+type Sender interface {
+	Send(ctx context.Context, userID uuid.UUID, payload *Payload) error
+}
+
+// SenderFunc adapts a function to Sender.
+type SenderFunc func(ctx context.Context, userID uuid.UUID, payload *Payload) error
+
+// Send calls f.
+func (f SenderFunc) Send(
+	ctx context.Context, userID uuid.UUID, payload *Payload) error {
+	return f(ctx, userID, payload)
+}
+
+// Center delivers notifications to channels enabled by preferences.
+type Center struct {
+	store   Store
+	senders map[Channel]Sender
+	logger  logging.Logger
+	now     func() time.Time
+}
+
+// NewCenter returns a center with preferences in store.
+func NewCenter(store Store) *Center {
+	return &Center{
+		store:   store,
+		senders: make(map[Channel]Sender),
+		logger:  logging.NewLoggerTag("notification:preference"),
+		now:     time.Now,
+	}
+}
+
+// Register sets the sender of channel. It must be called before Notify.
+func (c *Center) Register(channel Channel, sender Sender) {
+	c.senders[channel] = sender
+}
+
+// Store returns the preference store.
+func (c *Center) Store() Store {
+	return c.store
+}
+
+// Set enables or disables channel of category for userID.
+func (c *Center) Set(
+	userID uuid.UUID, category Category, channel Channel, enabled bool) error {
+	p, err := c.store.Get(userID)
+	if err != nil {
+		return err
+	}
+	if err = p.Set(category, channel, enabled); err != nil {
+		return err
+	}
+	return c.store.Save(userID, p)
+}
+
+// SetQuietHours sets quiet hours of userID, or disables it if q is nil.
+func (c *Center) SetQuietHours(userID uuid.UUID, q *QuietHours) error {
+	if q != nil {
+		if err := q.Validate(); err != nil {
+			return err
+		}
+	}
+	p, err := c.store.Get(userID)
+	if err != nil {
+		return err
+	}
+	p.QuietHours = q
+	return c.store.Save(userID, p)
+}
+
+// Channels returns channels of payload to deliver to userID. It fails closed
+// if preferences can't be read, except for mandatory categories.
+func (c *Center) Channels(
+	userID uuid.UUID, category Category, payload *Payload) ([]Channel, error) {
+	if !category.IsValid() {
+		return nil, ErrUnknownCategory
+	}
+	p, err := c.store.Get(userID)
+	if err != nil {
+		if !IsMandatory(category) {
+			return nil, err
+		}
+		// Mandatory notifications don't depend on preferences.
+		c.logger.Warn("get preferences of user<%s> err: %v", userID, err)
+		p = &Preferences{}
+	}
+
+	now := c.now()
+	var channels []Channel
+	for _, channel := range Channels {
+		if !payload.has(channel) {
+			continue
+		}
+		result := ""
+		switch {
+		case !p.Enabled(category, channel):
+			result = "disabled"
+		case p.Muted(category, channel, now):
+			result = "quiet"
+		case c.senders[channel] == nil:
+			result = "unregistered"
+		default:
+			channels = append(channels, channel)
+			continue
+		}
+		notificationsTotal.WithLabelValues(
+			string(category), string(channel), result).Inc()
+	}
+	return channels, nil
+}
+
+// Notify delivers payload of category to userID through channels enabled
+// by preferences. It returns the channels delivered to, and the first error
+// of channels failed to deliver.
+func (c *Center) Notify(ctx context.Context, userID uuid.UUID,
+	category Category, payload *Payload) ([]Channel, error) {
+	channels, err := c.Channels(userID, category, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var delivered []Channel
+	var firstErr error
+	for _, channel := range channels {
+		if err := c.senders[channel].Send(ctx, userID, payload); err != nil {
+			notificationsTotal.WithLabelValues(
+				string(category), string(channel), "failed").Inc()
+			c.logger.Error("notify user<%s> %s by %s err: %v",
+				userID, category, channel, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("notify by %s: %v", channel, err)
+			}
+			continue
+		}
+		notificationsTotal.WithLabelValues(
+			string(category), string(channel), "sent").Inc()
+		delivered = append(delivered, channel)
+	}
+	return delivered, firstErr
+}
+
+var (
+	defaultMutex  sync.Mutex
+	defaultCenter *Center
+)
+
+// Default returns the center set by SetDefault, or nil if it's not set.
+func Default() *Center {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	return defaultCenter
+}
+
+// SetDefault sets the center used by Notify.
+func SetDefault(c *Center) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultCenter = c
+}
+
+// Notify delivers payload of category to userID by the default center.
+func Notify(userID uuid.UUID, category Category, payload *Payload) error {
+	c := Default()
+	if c == nil {
+		return ErrNoCenter
+	}
+	_, err := c.Notify(context.Background(), userID, category, payload)
+	return err
+}
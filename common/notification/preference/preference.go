@@ -0,0 +1,191 @@
+// Package preference decides which channels a notification is delivered to
+// by the preferences of its receiver.
+//
+// Preferences are kept per user, notification category and channel.
+// Mandatory categories are delivered to every channel regardless of
+// preferences and quiet hours.
+package preference
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Category defines the category of notifications.
+type Category string
+
+// Categories of notifications.
+const (
+	CategorySecurity   Category = "security"
+	CategoryTrading    Category = "trading"
+	CategoryMarketing  Category = "marketing"
+	CategoryPriceAlert Category = "price_alert"
+	CategoryTwoFA      Category = "two_fa"
+	CategoryWithdrawal Category = "withdrawal"
+)
+
+// Channel defines the delivery channel of notifications.
+type Channel string
+
+// Channels of notifications.
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+	ChannelPush  Channel = "push"
+	ChannelChat  Channel = "chat"
+)
+
+// Channels are all channels in delivery order.
+var Channels = []Channel{ChannelEmail, ChannelSMS, ChannelPush, ChannelChat}
+
+// mandatoryCategories can't be disabled.
+var mandatoryCategories = map[Category]bool{
+	CategoryTwoFA:      true,
+	CategoryWithdrawal: true,
+}
+
+// DefaultChannels are channels enabled for users without preferences.
+var DefaultChannels = map[Category]map[Channel]bool{
+	CategorySecurity:   {ChannelEmail: true, ChannelPush: true},
+	CategoryTrading:    {ChannelEmail: true, ChannelPush: true},
+	CategoryMarketing:  {ChannelEmail: true},
+	CategoryPriceAlert: {ChannelPush: true},
+}
+
+// quietChannels are muted in quiet hours.
+var quietChannels = map[Channel]bool{
+	ChannelSMS:  true,
+	ChannelPush: true,
+	ChannelChat: true,
+}
+
+// Errors of preferences.
+var (
+	ErrMandatoryCategory = errors.New("mandatory category can't be disabled")
+	ErrUnknownCategory   = errors.New("unknown notification category")
+	ErrUnknownChannel    = errors.New("unknown notification channel")
+)
+
+// IsMandatory returns whether category can't be disabled.
+func IsMandatory(category Category) bool {
+	return mandatoryCategories[category]
+}
+
+// IsValid returns whether category is defined.
+func (c Category) IsValid() bool {
+	_, ok := DefaultChannels[c]
+	return ok || IsMandatory(c)
+}
+
+// IsValid returns whether channel is defined.
+func (c Channel) IsValid() bool {
+	for _, channel := range Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// QuietHours mutes SMS, push and chat notifications from Start to End, which
+// are "15:04" in TimeZone. It wraps midnight if End is before Start.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	TimeZone string `json:"time_zone"`
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate validates q.
+func (q *QuietHours) Validate() error {
+	if _, err := parseClock(q.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(q.End); err != nil {
+		return err
+	}
+	_, err := time.LoadLocation(q.TimeZone)
+	return err
+}
+
+// Contains returns whether t is in quiet hours.
+func (q *QuietHours) Contains(t time.Time) bool {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		return false
+	}
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return false
+	}
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return start <= minute && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// Preferences are notification preferences of a user.
+type Preferences struct {
+	// Channels overrides DefaultChannels per category.
+	Channels   map[Category]map[Channel]bool `json:"channels,omitempty"`
+	QuietHours *QuietHours                   `json:"quiet_hours,omitempty"`
+}
+
+// Set enables or disables channel of category.
+func (p *Preferences) Set(category Category, channel Channel, enabled bool) error {
+	if !category.IsValid() {
+		return ErrUnknownCategory
+	}
+	if !channel.IsValid() {
+		return ErrUnknownChannel
+	}
+	if IsMandatory(category) {
+		if !enabled {
+			return ErrMandatoryCategory
+		}
+		return nil
+	}
+
+	if p.Channels == nil {
+		p.Channels = make(map[Category]map[Channel]bool)
+	}
+	if p.Channels[category] == nil {
+		p.Channels[category] = make(map[Channel]bool)
+	}
+	p.Channels[category][channel] = enabled
+	return nil
+}
+
+// Enabled returns whether channel of category is enabled.
+func (p *Preferences) Enabled(category Category, channel Channel) bool {
+	if IsMandatory(category) {
+		return true
+	}
+	if enabled, ok := p.Channels[category][channel]; ok {
+		return enabled
+	}
+	return DefaultChannels[category][channel]
+}
+
+// Muted returns whether channel of category is muted by quiet hours at t.
+func (p *Preferences) Muted(category Category, channel Channel, t time.Time) bool {
+	if IsMandatory(category) || p.QuietHours == nil {
+		return false
+	}
+	return quietChannels[channel] && p.QuietHours.Contains(t)
+}
@@ -0,0 +1,188 @@
+package preference
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/notification"
+	"github.com/jiarung/mochi/common/notification/email"
+)
+
+type recordSender struct {
+	mutex sync.Mutex
+	err   error
+	sent  []uuid.UUID
+}
+
+func (r *recordSender) Send(
+	ctx context.Context, userID uuid.UUID, payload *Payload) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, userID)
+	return nil
+}
+
+type PreferenceTestSuite struct {
+	suite.Suite
+
+	center  *Center
+	senders map[Channel]*recordSender
+	userID  uuid.UUID
+	now     time.Time
+	payload *Payload
+}
+
+func (s *PreferenceTestSuite) SetupTest() {
+	s.center = NewCenter(NewMemoryStore())
+	s.senders = make(map[Channel]*recordSender)
+	for _, channel := range Channels {
+		s.senders[channel] = &recordSender{}
+		s.center.Register(channel, s.senders[channel])
+	}
+	s.userID = uuid.NewV4()
+	s.now = time.Date(2018, time.June, 1, 23, 30, 0, 0, time.UTC)
+	s.center.now = func() time.Time { return s.now }
+	s.payload = &Payload{
+		ID:   "payload",
+		SMS:  &SMSMessage{Country: "TW", Phone: "0911", Body: "hello"},
+		Push: &notification.AppRequest{ID: "push"},
+		Chat: &ChatMessage{To: "line", Text: "hello"},
+	}
+}
+
+func (s *PreferenceTestSuite) TestDefaultChannels() {
+	channels, err := s.center.Notify(
+		context.Background(), s.userID, CategoryPriceAlert, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelPush}, channels)
+	s.Require().Len(s.senders[ChannelPush].sent, 1)
+	s.Require().Empty(s.senders[ChannelSMS].sent)
+}
+
+func (s *PreferenceTestSuite) TestSet() {
+	s.Require().NoError(s.center.Set(s.userID, CategoryPriceAlert, ChannelPush, false))
+	s.Require().NoError(s.center.Set(s.userID, CategoryPriceAlert, ChannelChat, true))
+	channels, err := s.center.Channels(s.userID, CategoryPriceAlert, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelChat}, channels)
+
+	// Preferences of other users aren't changed.
+	channels, err = s.center.Channels(uuid.NewV4(), CategoryPriceAlert, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelPush}, channels)
+
+	s.Require().Equal(ErrUnknownCategory,
+		s.center.Set(s.userID, Category("unknown"), ChannelPush, true))
+	s.Require().Equal(ErrUnknownChannel,
+		s.center.Set(s.userID, CategoryTrading, Channel("fax"), true))
+}
+
+func (s *PreferenceTestSuite) TestMandatory() {
+	s.Require().Equal(ErrMandatoryCategory,
+		s.center.Set(s.userID, CategoryWithdrawal, ChannelSMS, false))
+	s.Require().NoError(s.center.Set(s.userID, CategoryWithdrawal, ChannelSMS, true))
+
+	// Mandatory categories are delivered to every channel with content.
+	channels, err := s.center.Channels(s.userID, CategoryTwoFA, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelSMS, ChannelPush, ChannelChat}, channels)
+}
+
+func (s *PreferenceTestSuite) TestQuietHours() {
+	s.Require().Error(s.center.SetQuietHours(s.userID, &QuietHours{
+		Start: "25:00", End: "07:00", TimeZone: "UTC",
+	}))
+	s.Require().NoError(s.center.SetQuietHours(s.userID, &QuietHours{
+		Start: "22:00", End: "07:00", TimeZone: "Asia/Taipei",
+	}))
+
+	// 23:30 UTC is 07:30 in Taipei.
+	channels, err := s.center.Channels(s.userID, CategoryTrading, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelPush}, channels)
+
+	// 22:30 UTC is 06:30 in Taipei.
+	s.now = s.now.Add(-time.Hour)
+	s.payload.Email = &email.GenericRequest{}
+	channels, err = s.center.Channels(s.userID, CategoryTrading, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelEmail}, channels)
+	channels, err = s.center.Channels(s.userID, CategoryWithdrawal, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal(Channels, channels)
+
+	s.Require().NoError(s.center.SetQuietHours(s.userID, nil))
+	channels, err = s.center.Channels(s.userID, CategoryTrading, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelEmail, ChannelPush}, channels)
+}
+
+func (s *PreferenceTestSuite) TestQuietHoursContains() {
+	q := &QuietHours{Start: "09:00", End: "17:00", TimeZone: "UTC"}
+	day := time.Date(2018, time.June, 1, 0, 0, 0, 0, time.UTC)
+	s.Require().False(q.Contains(day.Add(8 * time.Hour)))
+	s.Require().True(q.Contains(day.Add(9 * time.Hour)))
+	s.Require().False(q.Contains(day.Add(17 * time.Hour)))
+
+	q = &QuietHours{Start: "22:00", End: "07:00", TimeZone: "UTC"}
+	s.Require().True(q.Contains(day.Add(23 * time.Hour)))
+	s.Require().True(q.Contains(day.Add(3 * time.Hour)))
+	s.Require().False(q.Contains(day.Add(12 * time.Hour)))
+}
+
+func (s *PreferenceTestSuite) TestSendError() {
+	s.senders[ChannelSMS].err = errors.New("provider down")
+	channels, err := s.center.Notify(
+		context.Background(), s.userID, CategoryWithdrawal, s.payload)
+	s.Require().Error(err)
+	s.Require().Equal([]Channel{ChannelPush, ChannelChat}, channels)
+}
+
+// errStore fails to read preferences.
+type errStore struct {
+	Store
+}
+
+func (errStore) Get(userID uuid.UUID) (*Preferences, error) {
+	return nil, errors.New("redis down")
+}
+
+func (s *PreferenceTestSuite) TestStoreError() {
+	s.center.store = errStore{Store: s.center.store}
+
+	// Optional categories aren't delivered without preferences.
+	channels, err := s.center.Notify(
+		context.Background(), s.userID, CategoryTrading, s.payload)
+	s.Require().EqualError(err, "redis down")
+	s.Require().Empty(channels)
+	s.Require().Empty(s.senders[ChannelPush].sent)
+
+	// Mandatory categories are delivered regardless of preferences.
+	channels, err = s.center.Notify(
+		context.Background(), s.userID, CategoryTwoFA, s.payload)
+	s.Require().NoError(err)
+	s.Require().Equal([]Channel{ChannelSMS, ChannelPush, ChannelChat}, channels)
+}
+
+func (s *PreferenceTestSuite) TestDefaultCenter() {
+	SetDefault(nil)
+	s.Require().Equal(ErrNoCenter, Notify(s.userID, CategoryTrading, s.payload))
+
+	SetDefault(s.center)
+	defer SetDefault(nil)
+	s.Require().NoError(Notify(s.userID, CategoryTrading, s.payload))
+	s.Require().Len(s.senders[ChannelPush].sent, 1)
+}
+
+func TestPreference(t *testing.T) {
+	suite.Run(t, new(PreferenceTestSuite))
+}
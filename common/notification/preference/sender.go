@@ -0,0 +1,57 @@
+package preference
+
+import (
+	"context"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/notification/dispatcher"
+	"github.com/jiarung/mochi/common/utils"
+)
+
+// ignoreDuplicate ignores ErrDuplicateJob since the payload is enqueued.
+func ignoreDuplicate(err error) error {
+	if err == dispatcher.ErrDuplicateJob {
+		return nil
+	}
+	return err
+}
+
+// EmailSender returns the sender enqueuing emails to d.
+func EmailSender(d *dispatcher.Dispatcher) Sender {
+	return SenderFunc(func(
+		ctx context.Context, userID uuid.UUID, payload *Payload) error {
+		return ignoreDuplicate(
+			d.EnqueueEmail(payload.ID, payload.Email, payload.ID))
+	})
+}
+
+// SMSSender returns the sender enqueuing SMS to d.
+func SMSSender(d *dispatcher.Dispatcher) Sender {
+	return SenderFunc(func(
+		ctx context.Context, userID uuid.UUID, payload *Payload) error {
+		sms := payload.SMS
+		if len(sms.BodyKind) > 0 {
+			return ignoreDuplicate(d.EnqueueSMSRef(payload.ID,
+				sms.Country, sms.Phone, sms.BodyKind, sms.BodyRef))
+		}
+		return ignoreDuplicate(
+			d.EnqueueSMS(payload.ID, sms.Country, sms.Phone, sms.Body))
+	})
+}
+
+// PushSender returns the sender enqueuing push notifications to d.
+func PushSender(d *dispatcher.Dispatcher) Sender {
+	return SenderFunc(func(
+		ctx context.Context, userID uuid.UUID, payload *Payload) error {
+		return ignoreDuplicate(d.EnqueuePush(payload.ID, payload.Push))
+	})
+}
+
+// LineSender returns the sender of chat messages through LINE.
+func LineSender() Sender {
+	return SenderFunc(func(
+		ctx context.Context, userID uuid.UUID, payload *Payload) error {
+		return utils.PostLineMsg(payload.Chat.To, payload.Chat.Text)
+	})
+}
@@ -0,0 +1,97 @@
+package preference
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/cache"
+)
+
+// Store stores preferences of users.
+type Store interface {
+	// Get returns preferences of userID, or empty preferences if it's not
+	// set. It returns an error if preferences can't be read, so callers
+	// don't fall back to defaults the user may have opted out of.
+	Get(userID uuid.UUID) (*Preferences, error)
+	Save(userID uuid.UUID, p *Preferences) error
+}
+
+// memoryStore keeps preferences in memory.
+type memoryStore struct {
+	mutex sync.Mutex
+	prefs map[uuid.UUID][]byte
+}
+
+// NewMemoryStore returns a store which keeps preferences of users in memory.
+// Preferences are lost on restart and aren't shared between pods, so it's
+// only for tests and local development.
+func NewMemoryStore() Store {
+	return &memoryStore{prefs: make(map[uuid.UUID][]byte)}
+}
+
+func (s *memoryStore) Get(userID uuid.UUID) (*Preferences, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p := &Preferences{}
+	if data, ok := s.prefs[userID]; ok {
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (s *memoryStore) Save(userID uuid.UUID, p *Preferences) error {
+	// Keep a copy so callers can't modify stored preferences.
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prefs[userID] = data
+	return nil
+}
+
+// redisStore keeps preferences in redis.
+type redisStore struct {
+	redis *cache.Redis
+}
+
+// NewRedisStore returns a store backed by redis.
+func NewRedisStore(redis *cache.Redis) Store {
+	return &redisStore{redis: redis}
+}
+
+func redisKey(userID uuid.UUID) string {
+	return fmt.Sprintf("notification:preference:%s", userID)
+}
+
+func (s *redisStore) Get(userID uuid.UUID) (*Preferences, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	p := &Preferences{}
+	data, err := redis.Bytes(rCli.Do("GET", redisKey(userID)))
+	if err == redis.ErrNil {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *redisStore) Save(userID uuid.UUID, p *Preferences) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(redisKey(userID), string(data))
+}
@@ -1,9 +1,19 @@
 package notification
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -15,6 +25,14 @@ type NexmoConfig struct {
 	APIKey          string
 	APISecret       string
 	CountryCodeList string
+	// CallbackURL receives delivery receipts if it's set.
+	CallbackURL string
+	// SignatureSecret verifies signed delivery receipts. Receipts are
+	// rejected if it's empty.
+	SignatureSecret string
+	// SignatureMethod is the signature method of the account, which is one
+	// of md5hash, md5, sha1, sha256 and sha512. It's md5hash if empty.
+	SignatureMethod string
 }
 
 // Nexmo wraps nexmo service.
@@ -45,6 +63,9 @@ func (n *Nexmo) Request(to, from, msg string) *http.Request {
 	v.Set("from", from)
 	v.Set("to", to)
 	v.Set("text", msg)
+	if len(n.CallbackURL) > 0 {
+		v.Set("callback", n.CallbackURL)
+	}
 	rb := *strings.NewReader(v.Encode())
 
 	req, _ := http.NewRequest(http.MethodPost, nexmoAPIUrl, &rb)
@@ -54,6 +75,76 @@ func (n *Nexmo) Request(to, from, msg string) *http.Request {
 	return req
 }
 
+// nexmoSignatureMaxAge is the max clock difference of signed receipts, so
+// captured receipts can't be replayed later.
+const nexmoSignatureMaxAge = 5 * time.Minute
+
+var (
+	// nexmoSignatureHashes are the hashes of HMAC signature methods.
+	nexmoSignatureHashes = map[string]func() hash.Hash{
+		"md5":    md5.New,
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+	}
+
+	// nexmoSignatureReplacer replaces the separators in values.
+	nexmoSignatureReplacer = strings.NewReplacer("&", "_", "=", "_")
+)
+
+// signature returns the hex-encoded signature of params of a signed
+// request, or empty if the signature method isn't supported.
+// Reference: https://developer.nexmo.com/concepts/guides/signing-messages
+func (n *Nexmo) signature(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "sig" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	sb := strings.Builder{}
+	for _, k := range keys {
+		sb.WriteString("&")
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(nexmoSignatureReplacer.Replace(params.Get(k)))
+	}
+
+	if len(n.SignatureMethod) == 0 || n.SignatureMethod == "md5hash" {
+		sb.WriteString(n.SignatureSecret)
+		sum := md5.Sum([]byte(sb.String()))
+		return hex.EncodeToString(sum[:])
+	}
+	newHash, ok := nexmoSignatureHashes[n.SignatureMethod]
+	if !ok {
+		return ""
+	}
+	mac := hmac.New(newHash, []byte(n.SignatureSecret))
+	mac.Write([]byte(sb.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateSignature validates the sig parameter of a signed request, and
+// its timestamp is within nexmoSignatureMaxAge of now.
+func (n *Nexmo) ValidateSignature(params url.Values, now time.Time) bool {
+	if len(n.SignatureSecret) == 0 {
+		return false
+	}
+	ts, err := strconv.ParseInt(params.Get("timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > nexmoSignatureMaxAge ||
+		d < -nexmoSignatureMaxAge {
+		return false
+	}
+	sig := n.signature(params)
+	return len(sig) > 0 && hmac.Equal([]byte(sig),
+		[]byte(strings.ToLower(params.Get("sig"))))
+}
+
 // IsSupportCountry returns the country is supported.
 func (n *Nexmo) IsSupportCountry(toCountry string) bool {
 	return n.countryCodeMap.exist(toCountry)
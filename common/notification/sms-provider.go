@@ -0,0 +1,115 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// SMSProviderName defines the name of a SMS provider.
+type SMSProviderName string
+
+// Supported SMS providers.
+const (
+	SMSProviderTwilio SMSProviderName = "twilio"
+	SMSProviderNexmo  SMSProviderName = "nexmo"
+)
+
+// SMSProvider defines the interface of a SMS provider.
+type SMSProvider interface {
+	// Name returns the name of the provider.
+	Name() SMSProviderName
+	// Supports returns whether the provider can send to the phone number.
+	Supports(country, to string) bool
+	// Send sends msg to the phone number and returns the message ID of the
+	// provider.
+	Send(ctx context.Context, country, to, msg string) (string, error)
+}
+
+// normalizeCountry returns the country code with "+" prefix.
+func normalizeCountry(country string) string {
+	if !strings.HasPrefix(country, "+") {
+		return "+" + country
+	}
+	return country
+}
+
+func doSMSRequest(ctx context.Context, req *http.Request) ([]byte, int, error) {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, resp.StatusCode, err
+}
+
+// Name returns the name of the provider.
+func (t *Twilio) Name() SMSProviderName {
+	return SMSProviderTwilio
+}
+
+// Supports returns false for black listed phones.
+func (t *Twilio) Supports(country, to string) bool {
+	return !t.IsBlackListPhone(to)
+}
+
+// Send sends SMS with Twilio API.
+func (t *Twilio) Send(ctx context.Context, country, to, msg string) (
+	string, error) {
+	body, status, err := doSMSRequest(ctx, t.Request(to, country, msg))
+	if err != nil {
+		return "", fmt.Errorf("send sms error phoneNum<%s>: %v", to, err)
+	}
+	if status >= http.StatusBadRequest {
+		return "", fmt.Errorf("Get error response: %s", string(body))
+	}
+
+	// Reference: https://www.twilio.com/docs/sms/api/message-resource
+	res := struct {
+		SID string `json:"sid"`
+	}{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &res); err != nil {
+			return "", fmt.Errorf("parse response error: %+v", err)
+		}
+	}
+	return res.SID, nil
+}
+
+// Name returns the name of the provider.
+func (n *Nexmo) Name() SMSProviderName {
+	return SMSProviderNexmo
+}
+
+// Supports returns true since nexmo has no black list.
+func (n *Nexmo) Supports(country, to string) bool {
+	return true
+}
+
+// Send sends SMS with Nexmo API.
+func (n *Nexmo) Send(ctx context.Context, country, to, msg string) (
+	string, error) {
+	// FIXME(xnum): what's cobsms?
+	body, _, err := doSMSRequest(ctx, n.Request(to, "cobsms", msg))
+	if err != nil {
+		return "", fmt.Errorf("send sms error phoneNum<%s>: %v", to, err)
+	}
+
+	// Reference: https://developer.nexmo.com/api/sms
+	res := struct {
+		Messages []struct {
+			Status    string `json:"status"`
+			MessageID string `json:"message-id"`
+		} `json:"messages"`
+	}{}
+	json.Unmarshal(body, &res)
+	if len(res.Messages) <= 0 || res.Messages[0].Status != "0" {
+		return "", fmt.Errorf("send sms error res: %s", string(body))
+	}
+	return res.Messages[0].MessageID, nil
+}
@@ -0,0 +1,158 @@
+package notification
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Paths of delivery receipt callbacks under WEB_CALLBACK_PREFIX.
+const (
+	TwilioStatusCallbackPath = "/sms/twilio/status"
+	NexmoDeliveryReceiptPath = "/sms/nexmo/receipt"
+)
+
+var (
+	// Reference: https://www.twilio.com/docs/sms/api/message-resource#message-status-values
+	twilioStatusMap = map[string]SMSStatus{
+		"accepted":    SMSStatusSent,
+		"queued":      SMSStatusSent,
+		"sending":     SMSStatusSent,
+		"sent":        SMSStatusSent,
+		"delivered":   SMSStatusDelivered,
+		"undelivered": SMSStatusUndelivered,
+		"failed":      SMSStatusFailed,
+	}
+
+	// Reference: https://developer.nexmo.com/messaging/sms/guides/delivery-receipts
+	nexmoStatusMap = map[string]SMSStatus{
+		"accepted":  SMSStatusSent,
+		"buffered":  SMSStatusSent,
+		"delivered": SMSStatusDelivered,
+		"expired":   SMSStatusUndelivered,
+		"rejected":  SMSStatusUndelivered,
+		"unknown":   SMSStatusUndelivered,
+		"failed":    SMSStatusFailed,
+	}
+)
+
+// signature returns the signature of a callback posted to
+// StatusCallbackURL with form.
+// Reference: https://www.twilio.com/docs/usage/security#validating-requests
+func (t *Twilio) signature(form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sb := strings.Builder{}
+	sb.WriteString(t.StatusCallbackURL)
+	for _, k := range keys {
+		for _, v := range form[k] {
+			sb.WriteString(k)
+			sb.WriteString(v)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(t.AuthToken))
+	mac.Write([]byte(sb.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateSignature validates the X-Twilio-Signature header of a callback
+// request. The form of req must be parsed.
+func (t *Twilio) ValidateSignature(req *http.Request) bool {
+	return hmac.Equal([]byte(t.signature(req.PostForm)),
+		[]byte(req.Header.Get("X-Twilio-Signature")))
+}
+
+// receipt updates the status of a delivery receipt, and always responds OK
+// to known messages so providers don't retry the callback.
+func (r *SMSRouter) receipt(ctx *gin.Context, provider SMSProviderName,
+	messageID string, status SMSStatus) {
+	record, err := r.UpdateStatus(ctx.Request.Context(), provider, messageID, status)
+	if err == ErrSMSRecordNotFound {
+		r.logger.Warn("receipt of unknown %s message<%s>", provider, messageID)
+		ctx.Status(http.StatusOK)
+		return
+	}
+	if err != nil {
+		r.logger.Error("update %s message<%s> err: %v", provider, messageID, err)
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+	if status != SMSStatusSent && status != SMSStatusDelivered {
+		r.logger.Error("sms<%s> to %s%s is %s by %s", record.ID,
+			record.Country, record.Phone, status, provider)
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// TwilioStatusCallbackHandler returns the handler of Twilio status callbacks.
+// It should be routed at TwilioConfig.StatusCallbackURL.
+func (r *SMSRouter) TwilioStatusCallbackHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := ctx.Request.ParseForm(); err != nil {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+		// Callbacks can't be verified without twilio, so they're rejected.
+		if p, ok := r.byName[SMSProviderTwilio].(*Twilio); !ok ||
+			!p.ValidateSignature(ctx.Request) {
+			r.logger.Warn("invalid twilio signature")
+			ctx.Status(http.StatusForbidden)
+			return
+		}
+
+		status, ok := twilioStatusMap[ctx.Request.PostForm.Get("MessageStatus")]
+		messageID := ctx.Request.PostForm.Get("MessageSid")
+		if !ok || len(messageID) == 0 {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+		r.receipt(ctx, SMSProviderTwilio, messageID, status)
+	}
+}
+
+// NexmoDeliveryReceiptHandler returns the handler of Nexmo delivery
+// receipts. It should be routed at NexmoConfig.CallbackURL for both GET and
+// POST. Receipts must be signed by NexmoConfig.SignatureSecret.
+func (r *SMSRouter) NexmoDeliveryReceiptHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := ctx.Request.ParseForm(); err != nil {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+		// Receipts can't be verified without nexmo, so they're rejected.
+		if p, ok := r.byName[SMSProviderNexmo].(*Nexmo); !ok ||
+			!p.ValidateSignature(ctx.Request.Form, time.Now()) {
+			r.logger.Warn("invalid nexmo signature")
+			ctx.Status(http.StatusForbidden)
+			return
+		}
+
+		status, ok := nexmoStatusMap[ctx.Request.Form.Get("status")]
+		messageID := ctx.Request.Form.Get("messageId")
+		if !ok || len(messageID) == 0 {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+		r.receipt(ctx, SMSProviderNexmo, messageID, status)
+	}
+}
+
+// RegisterReceiptHandlers routes delivery receipt callbacks of providers at
+// their paths, which are the paths of callback URLs set by SMS().
+func (r *SMSRouter) RegisterReceiptHandlers(routes gin.IRoutes) {
+	routes.POST(TwilioStatusCallbackPath, r.TwilioStatusCallbackHandler())
+	routes.GET(NexmoDeliveryReceiptPath, r.NexmoDeliveryReceiptHandler())
+	routes.POST(NexmoDeliveryReceiptPath, r.NexmoDeliveryReceiptHandler())
+}
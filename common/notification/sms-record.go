@@ -0,0 +1,239 @@
+package notification
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/cache"
+)
+
+// SMSStatus defines the delivery status of a SMS.
+type SMSStatus string
+
+// SMS statuses.
+const (
+	SMSStatusSent        SMSStatus = "sent"
+	SMSStatusDelivered   SMSStatus = "delivered"
+	SMSStatusFailed      SMSStatus = "failed"
+	SMSStatusUndelivered SMSStatus = "undelivered"
+)
+
+// hashSMSBody returns the hex-encoded SHA-256 hash of body.
+func hashSMSBody(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsFinal returns whether the status won't change anymore.
+func (s SMSStatus) IsFinal() bool {
+	return s != SMSStatusSent
+}
+
+// ErrSMSRecordNotFound is returned when the record doesn't exist.
+var ErrSMSRecordNotFound = errors.New("sms record not found")
+
+// SMSAttempt is an attempt to send a SMS through a provider.
+type SMSAttempt struct {
+	Provider  SMSProviderName `json:"provider"`
+	MessageID string          `json:"message_id,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	SentAt    time.Time       `json:"sent_at"`
+}
+
+// SMSRecord tracks a SMS and its delivery status.
+type SMSRecord struct {
+	ID      uuid.UUID `json:"id"`
+	Country string    `json:"country"`
+	Phone   string    `json:"phone"`
+	// BodyHash is the SHA-256 hash of the body, since bodies may be one-time
+	// passwords and shouldn't be stored.
+	BodyHash string `json:"body_hash"`
+	// ResendBody is the body kept for resends on failed delivery receipts
+	// until ResendExpiry. It's cleared once the status is final or it
+	// expires, so bodies are only kept for the resend window.
+	ResendBody   string       `json:"resend_body,omitempty"`
+	ResendExpiry time.Time    `json:"resend_expiry"`
+	Status       SMSStatus    `json:"status"`
+	Attempts     []SMSAttempt `json:"attempts"`
+	Resends      int          `json:"resends"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// LastAttempt returns the last attempt, or nil if there's none.
+func (r *SMSRecord) LastAttempt() *SMSAttempt {
+	if len(r.Attempts) == 0 {
+		return nil
+	}
+	return &r.Attempts[len(r.Attempts)-1]
+}
+
+// Provider returns the provider that accepted the SMS.
+func (r *SMSRecord) Provider() SMSProviderName {
+	if a := r.LastAttempt(); a != nil && len(a.Error) == 0 {
+		return a.Provider
+	}
+	return ""
+}
+
+// MessageID returns the message ID of the provider that accepted the SMS.
+func (r *SMSRecord) MessageID() string {
+	if a := r.LastAttempt(); a != nil && len(a.Error) == 0 {
+		return a.MessageID
+	}
+	return ""
+}
+
+// SMSRecordStore stores SMS records.
+type SMSRecordStore interface {
+	// Save creates or updates r.
+	Save(r *SMSRecord) error
+	// Get returns the record by ID.
+	Get(id uuid.UUID) (*SMSRecord, error)
+	// GetByMessageID returns the record by the provider's message ID.
+	GetByMessageID(provider SMSProviderName, messageID string) (*SMSRecord, error)
+}
+
+func smsMessageKey(provider SMSProviderName, messageID string) string {
+	return fmt.Sprintf("%s:%s", provider, messageID)
+}
+
+// memorySMSRecordStore stores records in memory.
+type memorySMSRecordStore struct {
+	mutex     sync.Mutex
+	records   map[uuid.UUID]SMSRecord
+	messageID map[string]uuid.UUID
+}
+
+// NewMemorySMSRecordStore returns a store which keeps records in memory
+// without expiration, so it's only for tests and local development.
+func NewMemorySMSRecordStore() SMSRecordStore {
+	return &memorySMSRecordStore{
+		records:   make(map[uuid.UUID]SMSRecord),
+		messageID: make(map[string]uuid.UUID),
+	}
+}
+
+func (s *memorySMSRecordStore) Save(r *SMSRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	copied := *r
+	copied.Attempts = append([]SMSAttempt(nil), r.Attempts...)
+	s.records[r.ID] = copied
+	for _, a := range r.Attempts {
+		if len(a.MessageID) > 0 {
+			s.messageID[smsMessageKey(a.Provider, a.MessageID)] = r.ID
+		}
+	}
+	return nil
+}
+
+func (s *memorySMSRecordStore) Get(id uuid.UUID) (*SMSRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return nil, ErrSMSRecordNotFound
+	}
+	r.Attempts = append([]SMSAttempt(nil), r.Attempts...)
+	return &r, nil
+}
+
+func (s *memorySMSRecordStore) GetByMessageID(
+	provider SMSProviderName, messageID string) (*SMSRecord, error) {
+	s.mutex.Lock()
+	id, ok := s.messageID[smsMessageKey(provider, messageID)]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, ErrSMSRecordNotFound
+	}
+	return s.Get(id)
+}
+
+// redisSMSRecordStore stores records in redis with expiration.
+type redisSMSRecordStore struct {
+	redis *cache.Redis
+	ttl   int
+}
+
+// NewRedisSMSRecordStore returns a store which keeps records in redis for
+// ttl.
+func NewRedisSMSRecordStore(redis *cache.Redis, ttl time.Duration) SMSRecordStore {
+	return &redisSMSRecordStore{redis: redis, ttl: int(ttl.Seconds())}
+}
+
+func redisSMSRecordKey(id uuid.UUID) string {
+	return fmt.Sprintf("notification:sms:record:%s", id)
+}
+
+func redisSMSMessageKey(provider SMSProviderName, messageID string) string {
+	return fmt.Sprintf("notification:sms:message:%s",
+		smsMessageKey(provider, messageID))
+}
+
+func (s *redisSMSRecordStore) Save(r *SMSRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(redisSMSRecordKey(r.ID), string(data), s.ttl); err != nil {
+		return err
+	}
+	for _, a := range r.Attempts {
+		if len(a.MessageID) == 0 {
+			continue
+		}
+		err := s.redis.Set(redisSMSMessageKey(a.Provider, a.MessageID),
+			r.ID.String(), s.ttl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the value of key, or ErrSMSRecordNotFound if it doesn't exist.
+func (s *redisSMSRecordStore) get(key string) (string, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	data, err := redis.String(rCli.Do("GET", key))
+	if err == redis.ErrNil {
+		return "", ErrSMSRecordNotFound
+	}
+	return data, err
+}
+
+func (s *redisSMSRecordStore) Get(id uuid.UUID) (*SMSRecord, error) {
+	data, err := s.get(redisSMSRecordKey(id))
+	if err != nil {
+		return nil, err
+	}
+	r := &SMSRecord{}
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *redisSMSRecordStore) GetByMessageID(
+	provider SMSProviderName, messageID string) (*SMSRecord, error) {
+	idStr, err := s.get(redisSMSMessageKey(provider, messageID))
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.FromString(idStr)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
@@ -0,0 +1,359 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+)
+
+var (
+	smsSendsTotal = metric.NewCounter("sms", "sends_total",
+		"Total number of SMS send attempts.", "provider", "result")
+	smsReceiptsTotal = metric.NewCounter("sms", "delivery_receipts_total",
+		"Total number of SMS delivery receipts.", "provider", "status")
+)
+
+// ErrNoSMSProvider is returned when no provider can send the SMS.
+var ErrNoSMSProvider = errors.New("no available sms provider")
+
+// SMSRouterConfig defines the routing rules of SMSRouter.
+type SMSRouterConfig struct {
+	// CountryRules maps country codes, e.g. "+886", to providers in order of
+	// preference. Providers not listed are still used for failover.
+	CountryRules map[string][]SMSProviderName
+	// Weights are the weights to pick the first provider for countries
+	// without rules. Providers without weight are only used for failover.
+	Weights map[SMSProviderName]int
+	// HealthWindow is the window of send results to compute error rates.
+	HealthWindow time.Duration
+	// MinSamples is the minimum number of results in the window before a
+	// provider could be considered unhealthy.
+	MinSamples int
+	// MaxErrorRate is the error rate above which a provider is unhealthy.
+	// Unhealthy providers are tried after healthy ones.
+	MaxErrorRate float64
+	// MaxResends is the number of automatic resends on failed delivery
+	// receipts.
+	MaxResends int
+	// ResendWindow is the time bodies are kept with records for resends.
+	// Receipts after it aren't resent.
+	ResendWindow time.Duration
+}
+
+// DefaultSMSRouterConfig returns the default router config.
+func DefaultSMSRouterConfig() SMSRouterConfig {
+	return SMSRouterConfig{
+		CountryRules: map[string][]SMSProviderName{},
+		Weights:      map[SMSProviderName]int{},
+		HealthWindow: 5 * time.Minute,
+		MinSamples:   10,
+		MaxErrorRate: 0.5,
+		MaxResends:   1,
+		ResendWindow: 10 * time.Minute,
+	}
+}
+
+type smsHealthEvent struct {
+	at     time.Time
+	failed bool
+}
+
+// smsProviderHealth keeps send results of a provider in a time window.
+type smsProviderHealth struct {
+	mutex  sync.Mutex
+	window time.Duration
+	events []smsHealthEvent
+}
+
+// maxSMSHealthEvents bounds the memory of a provider's health.
+const maxSMSHealthEvents = 1000
+
+func (h *smsProviderHealth) trim(now time.Time) {
+	i := 0
+	for ; i < len(h.events) && now.Sub(h.events[i].at) > h.window; i++ {
+	}
+	if len(h.events)-i > maxSMSHealthEvents {
+		i = len(h.events) - maxSMSHealthEvents
+	}
+	h.events = h.events[i:]
+}
+
+func (h *smsProviderHealth) record(failed bool, now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events = append(h.events, smsHealthEvent{at: now, failed: failed})
+	h.trim(now)
+}
+
+func (h *smsProviderHealth) errorRate(now time.Time) (float64, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.trim(now)
+	if len(h.events) == 0 {
+		return 0, 0
+	}
+	failed := 0
+	for _, e := range h.events {
+		if e.failed {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(h.events)), len(h.events)
+}
+
+// SMSRouter sends SMS through providers chosen by country rules, weights
+// and live error rates, and fails over to other providers on errors.
+type SMSRouter struct {
+	cfg       SMSRouterConfig
+	store     SMSRecordStore
+	providers []SMSProvider
+	byName    map[SMSProviderName]SMSProvider
+	health    map[SMSProviderName]*smsProviderHealth
+	logger    logging.Logger
+
+	randMutex sync.Mutex
+	rand      *rand.Rand
+}
+
+// NewSMSRouter creates a router. The order of providers is the order of
+// failover when weights are equal.
+func NewSMSRouter(cfg SMSRouterConfig, store SMSRecordStore,
+	providers ...SMSProvider) *SMSRouter {
+	if store == nil {
+		store = NewMemorySMSRecordStore()
+	}
+	rules := make(map[string][]SMSProviderName, len(cfg.CountryRules))
+	for country, names := range cfg.CountryRules {
+		rules[normalizeCountry(country)] = names
+	}
+	cfg.CountryRules = rules
+
+	r := &SMSRouter{
+		cfg:       cfg,
+		store:     store,
+		providers: providers,
+		byName:    make(map[SMSProviderName]SMSProvider, len(providers)),
+		health:    make(map[SMSProviderName]*smsProviderHealth, len(providers)),
+		logger:    logging.NewLoggerTag("notification:sms:router"),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, p := range providers {
+		r.byName[p.Name()] = p
+		r.health[p.Name()] = &smsProviderHealth{window: cfg.HealthWindow}
+	}
+	return r
+}
+
+// Store returns the record store of the router.
+func (r *SMSRouter) Store() SMSRecordStore {
+	return r.store
+}
+
+// ErrorRate returns the error rate of the provider in the health window,
+// and the number of samples.
+func (r *SMSRouter) ErrorRate(name SMSProviderName) (float64, int) {
+	h, ok := r.health[name]
+	if !ok {
+		return 0, 0
+	}
+	return h.errorRate(time.Now())
+}
+
+func (r *SMSRouter) isHealthy(name SMSProviderName) bool {
+	rate, samples := r.ErrorRate(name)
+	return samples < r.cfg.MinSamples || rate <= r.cfg.MaxErrorRate
+}
+
+// candidates returns providers in the order they should be tried.
+func (r *SMSRouter) candidates(
+	country, to string, exclude SMSProviderName) []SMSProvider {
+	var ordered []SMSProvider
+	picked := make(map[SMSProviderName]bool)
+	add := func(p SMSProvider) {
+		if picked[p.Name()] || p.Name() == exclude || !p.Supports(country, to) {
+			return
+		}
+		picked[p.Name()] = true
+		ordered = append(ordered, p)
+	}
+
+	if names, ok := r.cfg.CountryRules[country]; ok {
+		for _, name := range names {
+			if p, ok := r.byName[name]; ok {
+				add(p)
+			}
+		}
+	} else {
+		for _, p := range r.weightedOrder() {
+			add(p)
+		}
+	}
+	for _, p := range r.providers {
+		add(p)
+	}
+
+	// Try healthy providers first, keep the order otherwise.
+	healthy := make([]SMSProvider, 0, len(ordered))
+	var unhealthy []SMSProvider
+	for _, p := range ordered {
+		if r.isHealthy(p.Name()) {
+			healthy = append(healthy, p)
+		} else {
+			unhealthy = append(unhealthy, p)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// weightedOrder returns the providers with positive weight in a weighted
+// random order.
+func (r *SMSRouter) weightedOrder() []SMSProvider {
+	var pool []SMSProvider
+	total := 0
+	for _, p := range r.providers {
+		if w := r.cfg.Weights[p.Name()]; w > 0 {
+			pool = append(pool, p)
+			total += w
+		}
+	}
+
+	r.randMutex.Lock()
+	defer r.randMutex.Unlock()
+
+	ordered := make([]SMSProvider, 0, len(pool))
+	for len(pool) > 0 {
+		n := r.rand.Intn(total)
+		for i, p := range pool {
+			w := r.cfg.Weights[p.Name()]
+			if n < w {
+				ordered = append(ordered, p)
+				pool = append(pool[:i], pool[i+1:]...)
+				total -= w
+				break
+			}
+			n -= w
+		}
+	}
+	return ordered
+}
+
+// Send sends msg and returns the record of it. The record is saved even if
+// all providers fail.
+func (r *SMSRouter) Send(
+	ctx context.Context, country, phone, msg string) (*SMSRecord, error) {
+	now := time.Now()
+	record := &SMSRecord{
+		ID:        uuid.NewV4(),
+		Country:   normalizeCountry(country),
+		Phone:     phone,
+		BodyHash:  hashSMSBody(msg),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err := r.send(ctx, record, msg, "")
+	if err == nil && r.cfg.MaxResends > 0 {
+		record.ResendBody = msg
+		record.ResendExpiry = now.Add(r.cfg.ResendWindow)
+	}
+	if saveErr := r.store.Save(record); saveErr != nil {
+		r.logger.Error("save sms record<%s> err: %v", record.ID, saveErr)
+	}
+	return record, err
+}
+
+// send tries candidates until one of them accepts the SMS.
+func (r *SMSRouter) send(ctx context.Context, record *SMSRecord, body string,
+	exclude SMSProviderName) error {
+	to := record.Country + record.Phone
+	candidates := r.candidates(record.Country, to, exclude)
+	if len(candidates) == 0 {
+		record.Status = SMSStatusFailed
+		return ErrNoSMSProvider
+	}
+
+	var lastErr error
+	for _, p := range candidates {
+		messageID, err := p.Send(ctx, record.Country, to, body)
+		now := time.Now()
+		r.health[p.Name()].record(err != nil, now)
+
+		attempt := SMSAttempt{
+			Provider:  p.Name(),
+			MessageID: messageID,
+			SentAt:    now,
+		}
+		record.UpdatedAt = now
+		if err != nil {
+			smsSendsTotal.WithLabelValues(string(p.Name()), "error").Inc()
+			r.logger.Warn("send sms<%s> by %s err: %v", record.ID, p.Name(), err)
+			attempt.Error = err.Error()
+			record.Attempts = append(record.Attempts, attempt)
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		smsSendsTotal.WithLabelValues(string(p.Name()), "success").Inc()
+		record.Attempts = append(record.Attempts, attempt)
+		record.Status = SMSStatusSent
+		return nil
+	}
+
+	record.Status = SMSStatusFailed
+	return fmt.Errorf("all sms providers failed, last err: %v", lastErr)
+}
+
+// UpdateStatus updates the record of a delivery receipt. A failed delivery
+// counts as a provider error and is resent through another provider if
+// resends are left. Receipts of records in a final status are ignored.
+func (r *SMSRouter) UpdateStatus(ctx context.Context, provider SMSProviderName,
+	messageID string, status SMSStatus) (*SMSRecord, error) {
+	smsReceiptsTotal.WithLabelValues(string(provider), string(status)).Inc()
+
+	record, err := r.store.GetByMessageID(provider, messageID)
+	if err != nil {
+		return nil, err
+	}
+	// Ignore receipts of previous attempts, duplicated receipts and receipts
+	// after the final status, e.g. a late failure after delivery.
+	if record.Provider() != provider || record.MessageID() != messageID ||
+		record.Status == status || record.Status.IsFinal() {
+		return record, nil
+	}
+
+	record.Status = status
+	record.UpdatedAt = time.Now()
+	if status == SMSStatusFailed || status == SMSStatusUndelivered {
+		if h, ok := r.health[provider]; ok {
+			h.record(true, record.UpdatedAt)
+		}
+		if record.Resends < r.cfg.MaxResends {
+			if len(record.ResendBody) == 0 ||
+				record.UpdatedAt.After(record.ResendExpiry) {
+				r.logger.Warn("body of sms<%s> expired, not resent", record.ID)
+			} else {
+				record.Resends++
+				if err := r.send(ctx, record, record.ResendBody, provider); err != nil {
+					r.logger.Error("resend sms<%s> err: %v", record.ID, err)
+				}
+			}
+		}
+	}
+	if record.Status.IsFinal() || record.Resends >= r.cfg.MaxResends ||
+		record.UpdatedAt.After(record.ResendExpiry) {
+		record.ResendBody = ""
+		record.ResendExpiry = time.Time{}
+	}
+
+	return record, r.store.Save(record)
+}
@@ -0,0 +1,343 @@
+package notification
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeSMSProvider struct {
+	mutex     sync.Mutex
+	name      SMSProviderName
+	blacklist map[string]bool
+	err       error
+	sent      []string
+}
+
+func (p *fakeSMSProvider) Name() SMSProviderName {
+	return p.name
+}
+
+func (p *fakeSMSProvider) Supports(country, to string) bool {
+	return !p.blacklist[to]
+}
+
+func (p *fakeSMSProvider) Send(
+	ctx context.Context, country, to, msg string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err != nil {
+		return "", p.err
+	}
+	p.sent = append(p.sent, to)
+	return fmt.Sprintf("%s-%d", p.name, len(p.sent)), nil
+}
+
+func (p *fakeSMSProvider) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.sent)
+}
+
+type SMSRouterTestSuite struct {
+	suite.Suite
+
+	twilio *fakeSMSProvider
+	nexmo  *fakeSMSProvider
+	cfg    SMSRouterConfig
+}
+
+func (s *SMSRouterTestSuite) SetupTest() {
+	s.twilio = &fakeSMSProvider{name: SMSProviderTwilio,
+		blacklist: map[string]bool{"+1999": true}}
+	s.nexmo = &fakeSMSProvider{name: SMSProviderNexmo}
+	s.cfg = DefaultSMSRouterConfig()
+	s.cfg.Weights[SMSProviderTwilio] = 1
+	s.cfg.CountryRules["886"] = []SMSProviderName{
+		SMSProviderNexmo, SMSProviderTwilio}
+	s.cfg.MinSamples = 2
+}
+
+func (s *SMSRouterTestSuite) newRouter() *SMSRouter {
+	return NewSMSRouter(s.cfg, nil, s.twilio, s.nexmo)
+}
+
+func (s *SMSRouterTestSuite) TestRoute() {
+	r := s.newRouter()
+
+	record, err := r.Send(context.Background(), "886", "123", "test")
+	s.Require().Nil(err)
+	s.Require().Equal(SMSProviderNexmo, record.Provider())
+	s.Require().Equal("+886", record.Country)
+
+	record, err = r.Send(context.Background(), "+1", "123", "test")
+	s.Require().Nil(err)
+	s.Require().Equal(SMSProviderTwilio, record.Provider())
+	s.Require().Equal(SMSStatusSent, record.Status)
+
+	// Black listed phone.
+	record, err = r.Send(context.Background(), "+1", "999", "test")
+	s.Require().Nil(err)
+	s.Require().Equal(SMSProviderNexmo, record.Provider())
+
+	stored, err := r.Store().GetByMessageID(
+		SMSProviderNexmo, record.MessageID())
+	s.Require().Nil(err)
+	s.Require().Equal(record.ID, stored.ID)
+}
+
+func (s *SMSRouterTestSuite) TestFailover() {
+	r := s.newRouter()
+	s.twilio.err = errors.New("unavailable")
+
+	record, err := r.Send(context.Background(), "+1", "123", "test")
+	s.Require().Nil(err)
+	s.Require().Len(record.Attempts, 2)
+	s.Require().Equal(SMSProviderTwilio, record.Attempts[0].Provider)
+	s.Require().NotEmpty(record.Attempts[0].Error)
+	s.Require().Equal(SMSProviderNexmo, record.Provider())
+
+	// Twilio is unhealthy after enough errors and is tried last.
+	_, err = r.Send(context.Background(), "+1", "123", "test")
+	s.Require().Nil(err)
+	rate, samples := r.ErrorRate(SMSProviderTwilio)
+	s.Require().Equal(1.0, rate)
+	s.Require().Equal(2, samples)
+
+	record, err = r.Send(context.Background(), "+1", "123", "test")
+	s.Require().Nil(err)
+	s.Require().Len(record.Attempts, 1)
+	s.Require().Equal(SMSProviderNexmo, record.Provider())
+
+	// All providers fail.
+	s.nexmo.err = errors.New("unavailable")
+	record, err = r.Send(context.Background(), "+1", "123", "test")
+	s.Require().NotNil(err)
+	s.Require().Equal(SMSStatusFailed, record.Status)
+	_, err = r.Store().Get(record.ID)
+	s.Require().Nil(err)
+}
+
+func (s *SMSRouterTestSuite) TestWeights() {
+	s.cfg.Weights[SMSProviderNexmo] = 1
+	r := s.newRouter()
+	for i := 0; i < 200; i++ {
+		_, err := r.Send(context.Background(), "+1", "123", "test")
+		s.Require().Nil(err)
+	}
+	s.Require().True(s.twilio.count() > 50)
+	s.Require().True(s.nexmo.count() > 50)
+}
+
+func (s *SMSRouterTestSuite) TestHealthWindow() {
+	h := &smsProviderHealth{window: time.Minute}
+	now := time.Now()
+	h.record(true, now.Add(-2*time.Minute))
+	h.record(false, now)
+	rate, samples := h.errorRate(now)
+	s.Require().Equal(0.0, rate)
+	s.Require().Equal(1, samples)
+}
+
+func (s *SMSRouterTestSuite) TestTwilioReceiptResend() {
+	gin.SetMode(gin.TestMode)
+	twilio := NewTwilio(TwilioConfig{
+		AccountSID:        "ABCD",
+		AuthToken:         "EDCRFV",
+		StatusCallbackURL: "https://example.com/sms/twilio",
+	})
+	r := NewSMSRouter(s.cfg, nil, s.twilio, s.nexmo)
+	r.byName[SMSProviderTwilio] = twilio
+
+	record, err := r.Send(context.Background(), "+1", "123", "test")
+	s.Require().Nil(err)
+	s.Require().Equal(SMSProviderTwilio, record.Provider())
+
+	engine := gin.New()
+	engine.POST("/sms/twilio", r.TwilioStatusCallbackHandler())
+	post := func(form url.Values, sign bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/sms/twilio",
+			strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if sign {
+			req.Header.Set("X-Twilio-Signature", twilio.signature(form))
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	form := url.Values{
+		"MessageSid":    {record.MessageID()},
+		"MessageStatus": {"undelivered"},
+	}
+	s.Require().Equal(http.StatusForbidden, post(form, false))
+	s.Require().Equal(http.StatusOK, post(form, true))
+
+	updated, err := r.Store().Get(record.ID)
+	s.Require().Nil(err)
+	s.Require().Equal(1, updated.Resends)
+	s.Require().Equal(SMSProviderNexmo, updated.Provider())
+	s.Require().Equal(SMSStatusSent, updated.Status)
+
+	// Receipt of the previous attempt is ignored.
+	s.Require().Equal(http.StatusOK, post(form, true))
+	updated, err = r.Store().Get(record.ID)
+	s.Require().Nil(err)
+	s.Require().Equal(1, updated.Resends)
+}
+
+func (s *SMSRouterTestSuite) TestReceiptWithoutProvider() {
+	gin.SetMode(gin.TestMode)
+	r := NewSMSRouter(s.cfg, nil, s.twilio, s.nexmo)
+	engine := gin.New()
+	r.RegisterReceiptHandlers(engine)
+
+	form := url.Values{
+		"MessageSid":    {"twilio-1"},
+		"MessageStatus": {"delivered"},
+	}
+	req := httptest.NewRequest(http.MethodPost, TwilioStatusCallbackPath,
+		strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	s.Require().Equal(http.StatusForbidden, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, NexmoDeliveryReceiptPath+
+		"?messageId=nexmo-1&status=delivered", nil)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	s.Require().Equal(http.StatusForbidden, w.Code)
+}
+
+func (s *SMSRouterTestSuite) TestBodyNotStored() {
+	s.cfg.ResendWindow = -time.Second
+	r := NewSMSRouter(s.cfg, nil, s.twilio, s.nexmo)
+	record, err := r.Send(context.Background(), "+1", "123", "code 123456")
+	s.Require().Nil(err)
+	s.Require().Equal(hashSMSBody("code 123456"), record.BodyHash)
+
+	// Bodies expired aren't resent.
+	updated, err := r.UpdateStatus(context.Background(), SMSProviderTwilio,
+		record.MessageID(), SMSStatusUndelivered)
+	s.Require().Nil(err)
+	s.Require().Equal(0, updated.Resends)
+	s.Require().Equal(SMSStatusUndelivered, updated.Status)
+	s.Require().Equal(0, s.nexmo.count())
+	s.Require().Empty(updated.ResendBody)
+}
+
+func (s *SMSRouterTestSuite) TestResendByAnotherRouter() {
+	store := NewMemorySMSRecordStore()
+	record, err := NewSMSRouter(s.cfg, store, s.twilio, s.nexmo).Send(
+		context.Background(), "+1", "123", "test")
+	s.Require().Nil(err)
+	s.Require().Equal("test", record.ResendBody)
+
+	// The body is kept with the record, so the receipt could be handled by
+	// another pod.
+	updated, err := NewSMSRouter(s.cfg, store, s.twilio, s.nexmo).UpdateStatus(
+		context.Background(), SMSProviderTwilio, record.MessageID(),
+		SMSStatusFailed)
+	s.Require().Nil(err)
+	s.Require().Equal(1, updated.Resends)
+	s.Require().Equal(SMSProviderNexmo, updated.Provider())
+	s.Require().Equal(1, s.nexmo.count())
+	// Resends are used up, so the body isn't kept anymore.
+	s.Require().Empty(updated.ResendBody)
+}
+
+func (s *SMSRouterTestSuite) TestFinalStatus() {
+	r := s.newRouter()
+	record, err := r.Send(context.Background(), "+1", "123", "test")
+	s.Require().Nil(err)
+
+	updated, err := r.UpdateStatus(context.Background(), SMSProviderTwilio,
+		record.MessageID(), SMSStatusDelivered)
+	s.Require().Nil(err)
+	s.Require().Equal(SMSStatusDelivered, updated.Status)
+	s.Require().Empty(updated.ResendBody)
+
+	// A late failure after delivery is ignored.
+	updated, err = r.UpdateStatus(context.Background(), SMSProviderTwilio,
+		record.MessageID(), SMSStatusFailed)
+	s.Require().Nil(err)
+	s.Require().Equal(SMSStatusDelivered, updated.Status)
+	s.Require().Equal(0, updated.Resends)
+	s.Require().Equal(0, s.nexmo.count())
+}
+
+func (s *SMSRouterTestSuite) TestNexmoSignedReceipt() {
+	gin.SetMode(gin.TestMode)
+	nexmo := NewNexmo(NexmoConfig{SignatureSecret: "secret"})
+	r := NewSMSRouter(s.cfg, nil, s.twilio, s.nexmo)
+	record, err := r.Send(context.Background(), "+886", "123", "test")
+	s.Require().Nil(err)
+	s.Require().Equal(SMSProviderNexmo, record.Provider())
+	r.byName[SMSProviderNexmo] = nexmo
+
+	engine := gin.New()
+	r.RegisterReceiptHandlers(engine)
+	get := func(params url.Values) int {
+		req := httptest.NewRequest(http.MethodGet,
+			NexmoDeliveryReceiptPath+"?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	params := url.Values{
+		"messageId": {record.MessageID()},
+		"status":    {"delivered"},
+		"timestamp": {fmt.Sprint(time.Now().Unix())},
+	}
+	params.Set("sig", nexmo.signature(params))
+	params.Set("status", "failed")
+	s.Require().Equal(http.StatusForbidden, get(params))
+
+	params.Set("status", "delivered")
+	params.Set("timestamp", fmt.Sprint(time.Now().Add(-time.Hour).Unix()))
+	params.Set("sig", nexmo.signature(params))
+	s.Require().Equal(http.StatusForbidden, get(params))
+
+	params.Set("timestamp", fmt.Sprint(time.Now().Unix()))
+	params.Set("sig", nexmo.signature(params))
+	s.Require().Equal(http.StatusOK, get(params))
+	updated, err := r.Store().Get(record.ID)
+	s.Require().Nil(err)
+	s.Require().Equal(SMSStatusDelivered, updated.Status)
+}
+
+func (s *SMSRouterTestSuite) TestNexmoSignature() {
+	params := url.Values{"a": {"1&2"}, "b": {"x=y"}, "sig": {"ignored"}}
+	n := NewNexmo(NexmoConfig{SignatureSecret: "secret"})
+	s.Require().Equal(fmt.Sprintf("%x", md5.Sum([]byte("&a=1_2&b=x_ysecret"))),
+		n.signature(params))
+
+	n.SignatureMethod = "sha256"
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("&a=1_2&b=x_y"))
+	s.Require().Equal(hex.EncodeToString(mac.Sum(nil)), n.signature(params))
+
+	n.SignatureMethod = "crc32"
+	s.Require().Empty(n.signature(params))
+}
+
+func TestSMSRouter(t *testing.T) {
+	suite.Run(t, new(SMSRouterTestSuite))
+}
@@ -36,6 +36,8 @@ type TwilioConfig struct {
 	AuthToken     string
 	CountryPhones string
 	FromPhoneUS1  string
+	// StatusCallbackURL receives delivery receipts if it's set.
+	StatusCallbackURL string
 }
 
 // Twilio wraps twilio service.
@@ -79,6 +81,9 @@ func (t *Twilio) Request(to, country, msg string) *http.Request {
 	v.Set("To", to)
 	v.Set("From", from)
 	v.Set("Body", msg)
+	if len(t.StatusCallbackURL) > 0 {
+		v.Set("StatusCallback", t.StatusCallbackURL)
+	}
 	rb := *strings.NewReader(v.Encode())
 
 	req, _ := http.NewRequest(http.MethodPost, t.url, &rb)
@@ -1,15 +1,12 @@
 package notification
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	mathRand "math/rand"
-	"net/http"
+	"context"
 	"strings"
 	"time"
 
+	"github.com/jiarung/mochi/cache"
+	"github.com/jiarung/mochi/common/config/misc"
 	"github.com/jiarung/mochi/common/config/secret"
 	"github.com/jiarung/mochi/common/config/thirdparty"
 )
@@ -21,6 +18,9 @@ func (m mapWithExist) exist(key string) (exist bool) {
 	return
 }
 
+// smsRecordTTL is the time SMS records are kept in redis.
+const smsRecordTTL = 24 * time.Hour
+
 // SMSConfig defines config of sms.
 type SMSConfig struct {
 	TwilioEnabled bool
@@ -28,6 +28,11 @@ type SMSConfig struct {
 
 	NexmoEnabled bool
 	NexmoConfig
+
+	// Router overrides the default routing rules if it's set.
+	Router *SMSRouterConfig
+	// RecordStore stores SMS records. Records are kept in memory if it's nil.
+	RecordStore SMSRecordStore
 }
 
 // SMSStruct wraps sms struct.
@@ -37,6 +42,8 @@ type SMSStruct struct {
 	twilio *Twilio
 
 	nexmo *Nexmo
+
+	router *SMSRouter
 }
 
 // SMS returns an inited SMSSturct.
@@ -45,102 +52,87 @@ func SMS() *SMSStruct {
 	return NewSMS(SMSConfig{
 		TwilioEnabled: true,
 		TwilioConfig: TwilioConfig{
-			AccountSID:    thirdparty.TwilioAccountSid(),
-			AuthToken:     secret.Get("TWILIO_AUTH_TOKEN"),
-			CountryPhones: thirdparty.TwilioCountryPhone(),
-			FromPhoneUS1:  thirdparty.TwilioFromPhoneUs1(),
+			AccountSID:        thirdparty.TwilioAccountSid(),
+			AuthToken:         secret.Get("TWILIO_AUTH_TOKEN"),
+			CountryPhones:     thirdparty.TwilioCountryPhone(),
+			FromPhoneUS1:      thirdparty.TwilioFromPhoneUs1(),
+			StatusCallbackURL: smsCallbackURL(TwilioStatusCallbackPath),
 		},
 		NexmoEnabled: true,
 		NexmoConfig: NexmoConfig{
 			APIKey:          thirdparty.NexmoApiKey(),
 			APISecret:       secret.Get("NEXMO_API_SECRET"),
 			CountryCodeList: thirdparty.NexmoCountryCodeList(),
+			CallbackURL:     smsCallbackURL(NexmoDeliveryReceiptPath),
+			SignatureSecret: secret.Get("NEXMO_SIGNATURE_SECRET"),
 		},
+		RecordStore: NewRedisSMSRecordStore(cache.GetRedis(), smsRecordTTL),
 	})
 }
 
+// smsCallbackURL returns the URL of path under WEB_CALLBACK_PREFIX, or empty
+// if the prefix isn't set, so providers don't post receipts.
+func smsCallbackURL(path string) string {
+	prefix := misc.WebCallbackPrefix()
+	if len(prefix) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(prefix, "/") + path
+}
+
 // NewSMS creates SMS.
 func NewSMS(cfg SMSConfig) *SMSStruct {
 	s := &SMSStruct{SMSConfig: cfg}
 	s.twilio = NewTwilio(cfg.TwilioConfig)
 	s.nexmo = NewNexmo(cfg.NexmoConfig)
-	return s
-}
 
-// SendTo sends SMS using Twilio API and return the response.
-func (s *SMSStruct) SendTo(toCountry, toPhoneNum, msg string) (err error) {
-	if !s.TwilioEnabled && !s.NexmoEnabled {
-		return
+	var providers []SMSProvider
+	if cfg.TwilioEnabled {
+		providers = append(providers, s.twilio)
 	}
-
-	if !strings.HasPrefix(toCountry, "+") {
-		toCountry = "+" + toCountry
+	if cfg.NexmoEnabled {
+		providers = append(providers, s.nexmo)
 	}
-	to := toCountry + toPhoneNum
-
-	if s.NexmoEnabled {
-		if s.nexmo.IsSupportCountry(toCountry) {
-			return s.sendSMSNexmo(to, msg)
-		}
-
-		if s.twilio.IsBlackListPhone(to) {
-			return s.sendSMSNexmo(to, msg)
-		}
 
-		// for analysing Nexmo's stability
-		mathRand.Seed(time.Now().UnixNano())
-		if mathRand.Intn(100) < 10 {
-			return s.sendSMSNexmo(to, msg)
+	routerCfg := DefaultSMSRouterConfig()
+	if cfg.Router != nil {
+		routerCfg = *cfg.Router
+	} else {
+		// Twilio is preferred, and nexmo is preferred for its countries and
+		// for phones black listed by twilio.
+		routerCfg.Weights[SMSProviderTwilio] = 1
+		if cfg.NexmoEnabled {
+			for country := range s.nexmo.countryCodeMap {
+				if len(country) == 0 {
+					continue
+				}
+				routerCfg.CountryRules[country] = []SMSProviderName{
+					SMSProviderNexmo, SMSProviderTwilio}
+			}
 		}
 	}
+	s.router = NewSMSRouter(routerCfg, cfg.RecordStore, providers...)
+	return s
+}
 
-	if !s.TwilioEnabled {
-		return errors.New("parameter is not suitable for sms service")
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(s.twilio.Request(to, toCountry, msg))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 400 {
-		var data map[string]interface{}
-		decoder := json.NewDecoder(resp.Body)
-		e := decoder.Decode(&data)
-		if e != nil {
-			return fmt.Errorf("parse response error: %+v", e)
-		}
-		return fmt.Errorf("Get error response: %+v", data)
-	}
+// Router returns the router of the SMS providers, which serves delivery
+// receipt webhooks.
+func (s *SMSStruct) Router() *SMSRouter {
+	return s.router
+}
 
-	_, err = ioutil.ReadAll(resp.Body)
+// SendTo sends SMS and return the error.
+func (s *SMSStruct) SendTo(toCountry, toPhoneNum, msg string) (err error) {
+	_, err = s.Send(context.Background(), toCountry, toPhoneNum, msg)
 	return
 }
 
-func (s *SMSStruct) sendSMSNexmo(to string, msg string) (err error) {
-	client := &http.Client{}
-	// FIXME(xnum): what's cobsms?
-	resp, err := client.Do(s.nexmo.Request(to, "cobsms", msg))
-	if err != nil {
-		return fmt.Errorf("send sms error phoneNum<%s>: %v", to, err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-
-	type smsResp struct {
-		Messages []struct {
-			Status string `json:"status"`
-		} `json:"messages"`
-	}
-	res := smsResp{}
-	json.Unmarshal(bodyBytes, &res)
-	if len(res.Messages) <= 0 || res.Messages[0].Status != "0" {
-		err = fmt.Errorf("send sms error res: %s", string(bodyBytes))
-		return
+// Send sends SMS through the router and returns the record of it. It
+// returns nil record if no provider is enabled.
+func (s *SMSStruct) Send(ctx context.Context,
+	toCountry, toPhoneNum, msg string) (*SMSRecord, error) {
+	if !s.TwilioEnabled && !s.NexmoEnabled {
+		return nil, nil
 	}
-
-	return
+	return s.router.Send(ctx, toCountry, toPhoneNum, msg)
 }
@@ -12,11 +12,14 @@ import (
 	models "github.com/jiarung/mochi/models/exchange"
 )
 
-func btcToSatoshi(btc decimal.Decimal) decimal.Decimal {
+// BTCToSatoshi converts BTC to satoshi.
+func BTCToSatoshi(btc decimal.Decimal) decimal.Decimal {
 	return btc.Mul(decimal.New(1, 8))
 }
 
-func orderSatoshi(order *models.Order) (decimal.Decimal, error) {
+// OrderSatoshi returns the value of order in satoshi by the exchange rate
+// of its base currency.
+func OrderSatoshi(order *models.Order) (decimal.Decimal, error) {
 	amount := order.Size
 	pair := strings.Split(order.TradingPairID, "-")
 	if len(pair) != 2 {
@@ -27,7 +30,7 @@ func orderSatoshi(order *models.Order) (decimal.Decimal, error) {
 		return decimal.Zero, err
 	}
 	amountBTC := amount.Mul(exchangeRate.PriceBTC)
-	return btcToSatoshi(amountBTC), nil
+	return BTCToSatoshi(amountBTC), nil
 }
 
 // decimalToFloat doesn't care whether float exactly represent decimal, we just need a approximate value
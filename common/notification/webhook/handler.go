@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"github.com/satori/go.uuid"
+
+	apicontext "github.com/jiarung/mochi/common/api/context"
+	apierrors "github.com/jiarung/mochi/common/api/errors"
+)
+
+type createEndpointRequest struct {
+	URL    string      `json:"url"`
+	Events []EventType `json:"events"`
+}
+
+// endpointResponse hides the secret, which is only returned on creation.
+type endpointResponse struct {
+	*Endpoint
+	Secret string `json:"secret,omitempty"`
+}
+
+func (s *Service) endpointID(appCtx *apicontext.AppContext) (
+	userID, id uuid.UUID, ok bool) {
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	userID, _ = appCtx.GetUserID()
+	id = uuid.FromStringOrNil(appCtx.Param("endpoint_id"))
+	if uuid.Equal(uuid.Nil, id) {
+		appCtx.SetError(apierrors.ParameterError)
+		return
+	}
+	ok = true
+	return
+}
+
+func (s *Service) setError(appCtx *apicontext.AppContext, err error) {
+	switch err {
+	case ErrEndpointNotFound:
+		appCtx.SetError(apierrors.WebhookNotFound)
+	case ErrTooManyEndpoints:
+		appCtx.SetError(apierrors.WebhookTooMany)
+	case ErrInvalidURL:
+		appCtx.SetError(apierrors.InvalidWebhookURL)
+	case ErrInvalidEventType:
+		appCtx.SetError(apierrors.ParameterError)
+	default:
+		appCtx.Logger().Error("webhook err: %v", err)
+		appCtx.SetError(apierrors.UnexpectedError)
+	}
+}
+
+// ListEndpointsHandler lists endpoints of the user.
+// /v1/webhooks [GET]
+func (s *Service) ListEndpointsHandler(appCtx *apicontext.AppContext) {
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	userID, _ := appCtx.GetUserID()
+	endpoints, err := s.store.Endpoints(userID)
+	if err != nil {
+		s.setError(appCtx, err)
+		return
+	}
+	resp := make([]endpointResponse, 0, len(endpoints))
+	for _, e := range endpoints {
+		resp = append(resp, endpointResponse{Endpoint: e})
+	}
+	appCtx.SetJSON(resp)
+}
+
+// CreateEndpointHandler registers an endpoint, and returns it with its
+// signing secret.
+// /v1/webhooks [POST]
+func (s *Service) CreateEndpointHandler(appCtx *apicontext.AppContext) {
+	if !appCtx.ValidateAuthenticated() {
+		return
+	}
+	userID, _ := appCtx.GetUserID()
+	req := createEndpointRequest{}
+	if !appCtx.MustBindJSON(&req) {
+		return
+	}
+	e, err := s.Create(userID, req.URL, req.Events)
+	if err != nil {
+		s.setError(appCtx, err)
+		return
+	}
+	appCtx.SetJSON(endpointResponse{Endpoint: e, Secret: e.Secret})
+}
+
+// DeleteEndpointHandler deletes an endpoint.
+// /v1/webhooks/:endpoint_id [DELETE]
+func (s *Service) DeleteEndpointHandler(appCtx *apicontext.AppContext) {
+	userID, id, ok := s.endpointID(appCtx)
+	if !ok {
+		return
+	}
+	if err := s.Delete(userID, id); err != nil {
+		s.setError(appCtx, err)
+		return
+	}
+	appCtx.SetJSON(nil)
+}
+
+// EnableEndpointHandler re-enables a disabled endpoint.
+// /v1/webhooks/:endpoint_id/enable [POST]
+func (s *Service) EnableEndpointHandler(appCtx *apicontext.AppContext) {
+	userID, id, ok := s.endpointID(appCtx)
+	if !ok {
+		return
+	}
+	e, err := s.Enable(userID, id)
+	if err != nil {
+		s.setError(appCtx, err)
+		return
+	}
+	appCtx.SetJSON(endpointResponse{Endpoint: e})
+}
+
+// ListDeliveriesHandler lists the latest deliveries of an endpoint.
+// /v1/webhooks/:endpoint_id/deliveries [GET]
+func (s *Service) ListDeliveriesHandler(appCtx *apicontext.AppContext) {
+	userID, id, ok := s.endpointID(appCtx)
+	if !ok {
+		return
+	}
+	if _, err := s.store.Endpoint(userID, id); err != nil {
+		s.setError(appCtx, err)
+		return
+	}
+	deliveries, err := s.store.Deliveries(id)
+	if err != nil {
+		s.setError(appCtx, err)
+		return
+	}
+	appCtx.SetJSON(deliveries)
+}
+
+// PingEndpointHandler sends a ping event to an endpoint, and returns the
+// delivery.
+// /v1/webhooks/:endpoint_id/ping [POST]
+func (s *Service) PingEndpointHandler(appCtx *apicontext.AppContext) {
+	userID, id, ok := s.endpointID(appCtx)
+	if !ok {
+		return
+	}
+	d, err := s.Ping(appCtx, userID, id)
+	if err != nil {
+		s.setError(appCtx, err)
+		return
+	}
+	appCtx.SetJSON(d)
+}
@@ -0,0 +1,392 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/common/logging"
+	"github.com/jiarung/mochi/common/metric"
+	"github.com/jiarung/mochi/common/notification/dispatcher"
+	"github.com/jiarung/mochi/common/utils"
+)
+
+// Channel is the dispatcher channel of webhook deliveries, which is
+// registered with Service.Sender.
+const Channel dispatcher.Channel = "webhook"
+
+var deliveriesTotal = metric.NewCounter("notification_webhook",
+	"deliveries_total", "Total number of webhook deliveries by result.",
+	"event", "result")
+
+// Option defines the options of Service.
+type Option struct {
+	// Timeout is the timeout of a delivery.
+	Timeout time.Duration
+	// MaxFailures is the number of consecutive failed deliveries to disable
+	// an endpoint.
+	MaxFailures int
+	// MaxEndpoints caps the endpoints of a user.
+	MaxEndpoints int
+	// MaxDeliveryLogs is the number of delivery logs kept per endpoint.
+	MaxDeliveryLogs int
+	// AllowInsecure allows http endpoints.
+	AllowInsecure bool
+	// AllowPrivate allows endpoints resolved to loopback and private
+	// addresses.
+	AllowPrivate bool
+}
+
+// DefaultOption returns the default option, which only allows public https
+// endpoints in production.
+func DefaultOption() *Option {
+	return &Option{
+		Timeout:         10 * time.Second,
+		MaxFailures:     20,
+		MaxEndpoints:    10,
+		MaxDeliveryLogs: 100,
+		AllowInsecure:   !utils.IsProduction(),
+		AllowPrivate:    !utils.IsProduction(),
+	}
+}
+
+// Service manages endpoints and delivers events to them.
+type Service struct {
+	opt    Option
+	store  Store
+	client *http.Client
+	logger logging.Logger
+	now    func() time.Time
+
+	// mutex serializes updates of endpoint failures.
+	mutex sync.Mutex
+}
+
+// NewService returns a service keeping endpoints in store.
+func NewService(store Store, opt *Option) *Service {
+	if opt == nil {
+		opt = DefaultOption()
+	}
+	dialer := &net.Dialer{Timeout: opt.Timeout}
+	if !opt.AllowPrivate {
+		// Check resolved addresses on dial, so endpoints can't reach
+		// internal services by DNS rebinding.
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return fmt.Errorf("%v: private address %s", ErrInvalidURL, host)
+			}
+			return nil
+		}
+	}
+	return &Service{
+		opt:   *opt,
+		store: store,
+		client: &http.Client{
+			Timeout: opt.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: opt.Timeout,
+			},
+			// Redirects aren't followed, since they could bypass URL checks.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logging.NewLoggerTag("notification:webhook"),
+		now:    time.Now,
+	}
+}
+
+func isPrivate(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, cidr := range privateCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var privateCIDRs = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10",
+		"fc00::/7",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// Store returns the store of s.
+func (s *Service) Store() Store {
+	return s.store
+}
+
+func (s *Service) validate(rawURL string, events []EventType) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Host) == 0 ||
+		(u.Scheme != "https" && (u.Scheme != "http" || !s.opt.AllowInsecure)) {
+		return ErrInvalidURL
+	}
+	if len(events) == 0 {
+		return ErrInvalidEventType
+	}
+	for _, event := range events {
+		valid := false
+		for _, t := range EventTypes {
+			valid = valid || event == t
+		}
+		if !valid {
+			return ErrInvalidEventType
+		}
+	}
+	return nil
+}
+
+// Create registers an endpoint of userID receiving events.
+func (s *Service) Create(userID uuid.UUID, rawURL string,
+	events []EventType) (*Endpoint, error) {
+	if err := s.validate(rawURL, events); err != nil {
+		return nil, err
+	}
+	endpoints, err := s.store.Endpoints(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) >= s.opt.MaxEndpoints {
+		return nil, ErrTooManyEndpoints
+	}
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Endpoint{
+		ID:        uuid.NewV4(),
+		UserID:    userID,
+		URL:       rawURL,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: s.now(),
+	}
+	return e, s.store.SaveEndpoint(e)
+}
+
+// Delete deletes an endpoint and its delivery logs.
+func (s *Service) Delete(userID, id uuid.UUID) error {
+	return s.store.DeleteEndpoint(userID, id)
+}
+
+// Enable re-enables a disabled endpoint.
+func (s *Service) Enable(userID, id uuid.UUID) (*Endpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e, err := s.store.Endpoint(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	e.Disabled = false
+	e.DisabledAt = nil
+	e.Failures = 0
+	return e, s.store.SaveEndpoint(e)
+}
+
+// jobPayload is the dispatcher payload of a delivery.
+type jobPayload struct {
+	UserID     uuid.UUID `json:"user_id"`
+	EndpointID uuid.UUID `json:"endpoint_id"`
+	Event      *Event    `json:"event"`
+}
+
+// Publish enqueues event to enabled endpoints of userID subscribing it.
+func (s *Service) Publish(d *dispatcher.Dispatcher, userID uuid.UUID,
+	event *Event) error {
+	endpoints, err := s.store.Endpoints(userID)
+	if err != nil {
+		return err
+	}
+	for _, e := range endpoints {
+		if e.Disabled || !e.Subscribed(event.Type) {
+			continue
+		}
+		job, err := dispatcher.NewJob(Channel,
+			fmt.Sprintf("webhook:%s:%s", e.ID, event.ID),
+			&jobPayload{UserID: userID, EndpointID: e.ID, Event: event})
+		if err != nil {
+			return err
+		}
+		if err := d.Enqueue(job); err != nil && err != dispatcher.ErrDuplicateJob {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sender returns the dispatcher sender delivering published events. Failed
+// deliveries are retried by the dispatcher with backoff until the endpoint
+// is disabled.
+func (s *Service) Sender() dispatcher.Sender {
+	return dispatcher.SenderFunc(func(ctx context.Context,
+		job *dispatcher.Job) error {
+		payload := &jobPayload{}
+		if err := json.Unmarshal(job.Payload, payload); err != nil {
+			return dispatcher.Permanent(err)
+		}
+		e, err := s.store.Endpoint(payload.UserID, payload.EndpointID)
+		if err == ErrEndpointNotFound {
+			return dispatcher.Permanent(err)
+		} else if err != nil {
+			return err
+		}
+		if e.Disabled {
+			return dispatcher.Permanent(ErrEndpointDisabled)
+		}
+
+		d := s.deliver(ctx, e, payload.Event, job.Attempts+1)
+		if d.Succeeded() {
+			return nil
+		}
+		err = fmt.Errorf("deliver event<%s> to endpoint<%s>: %s",
+			payload.Event.ID, e.ID, d.Error)
+		if d.StatusCode == http.StatusGone {
+			return dispatcher.Permanent(err)
+		}
+		return err
+	})
+}
+
+// Ping delivers a ping event to an endpoint, which doesn't count as a
+// failure of the endpoint.
+func (s *Service) Ping(ctx context.Context, userID, id uuid.UUID) (
+	*Delivery, error) {
+	e, err := s.store.Endpoint(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	event, err := NewEvent(EventPing, map[string]uuid.UUID{"endpoint_id": e.ID})
+	if err != nil {
+		return nil, err
+	}
+	return s.deliver(ctx, e, event, 0), nil
+}
+
+// deliver posts event to e, logs the delivery and updates failures of e.
+// Attempt 0 is a ping.
+func (s *Service) deliver(ctx context.Context, e *Endpoint, event *Event,
+	attempt int) *Delivery {
+	d := &Delivery{
+		ID:         uuid.NewV4(),
+		EndpointID: e.ID,
+		EventID:    event.ID,
+		EventType:  event.Type,
+		Attempt:    attempt,
+		CreatedAt:  s.now(),
+	}
+	s.post(ctx, e, event, d)
+	d.Duration = s.now().Sub(d.CreatedAt)
+
+	result := "sent"
+	if !d.Succeeded() {
+		result = "failed"
+		s.logger.Warn("deliver %s event<%s> to endpoint<%s> attempt %d err: %s",
+			event.Type, event.ID, e.ID, attempt, d.Error)
+	}
+	deliveriesTotal.WithLabelValues(string(event.Type), result).Inc()
+	if err := s.store.AppendDelivery(d, s.opt.MaxDeliveryLogs); err != nil {
+		s.logger.Error("log delivery of endpoint<%s> err: %v", e.ID, err)
+	}
+	if attempt > 0 {
+		s.updateFailures(e.UserID, e.ID, d)
+	}
+	return d
+}
+
+func (s *Service) post(ctx context.Context, e *Endpoint, event *Event,
+	d *Delivery) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Mochi-Webhook/1.0")
+	req.Header.Set(HeaderEvent, string(event.Type))
+	req.Header.Set(HeaderDelivery, event.ID.String())
+	req.Header.Set(HeaderSignature, Sign(e.Secret, s.now(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	head, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	io.Copy(ioutil.Discard, resp.Body)
+
+	d.StatusCode = resp.StatusCode
+	d.Response = string(head)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		d.Error = fmt.Sprintf("status %d", resp.StatusCode)
+	}
+}
+
+// updateFailures resets failures of an endpoint on success, or disables it
+// after MaxFailures consecutive failures or if it's gone.
+func (s *Service) updateFailures(userID, id uuid.UUID, d *Delivery) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e, err := s.store.Endpoint(userID, id)
+	if err != nil {
+		// Deleted during delivery.
+		return
+	}
+
+	if d.Succeeded() {
+		// Healthy endpoints are saved at most once a minute.
+		if e.Failures == 0 && e.LastSuccessAt != nil &&
+			e.LastSuccessAt.After(d.CreatedAt.Add(-time.Minute)) {
+			return
+		}
+		e.Failures = 0
+		e.LastSuccessAt = &d.CreatedAt
+	} else {
+		e.Failures++
+		if !e.Disabled && (e.Failures >= s.opt.MaxFailures ||
+			d.StatusCode == http.StatusGone) {
+			now := s.now()
+			e.Disabled = true
+			e.DisabledAt = &now
+			s.logger.Warn("endpoint<%s> of user<%s> disabled after %d failures",
+				e.ID, e.UserID, e.Failures)
+		}
+	}
+	if err := s.store.SaveEndpoint(e); err != nil {
+		s.logger.Error("save endpoint<%s> err: %v", e.ID, err)
+	}
+}
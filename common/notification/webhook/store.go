@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/satori/go.uuid"
+
+	"github.com/jiarung/mochi/cache"
+)
+
+// Store stores endpoints and their delivery logs.
+type Store interface {
+	// Endpoints returns the endpoints of userID by creation time.
+	Endpoints(userID uuid.UUID) ([]*Endpoint, error)
+	// Endpoint returns the endpoint of userID, or ErrEndpointNotFound.
+	Endpoint(userID, id uuid.UUID) (*Endpoint, error)
+	SaveEndpoint(e *Endpoint) error
+	DeleteEndpoint(userID, id uuid.UUID) error
+	// AppendDelivery logs d, and keeps the latest limit logs of the
+	// endpoint.
+	AppendDelivery(d *Delivery, limit int) error
+	// Deliveries returns the logs of endpoint id, latest first.
+	Deliveries(id uuid.UUID) ([]*Delivery, error)
+}
+
+func sortEndpoints(endpoints []*Endpoint) {
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+	})
+}
+
+// memoryStore keeps endpoints in memory.
+type memoryStore struct {
+	mutex      sync.Mutex
+	endpoints  map[uuid.UUID]map[uuid.UUID][]byte
+	deliveries map[uuid.UUID][][]byte
+}
+
+// NewMemoryStore returns a store which keeps endpoints and delivery logs in
+// memory. Endpoints are lost on restart, so it's only for tests and local
+// development.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		endpoints:  make(map[uuid.UUID]map[uuid.UUID][]byte),
+		deliveries: make(map[uuid.UUID][][]byte),
+	}
+}
+
+func (s *memoryStore) Endpoints(userID uuid.UUID) ([]*Endpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var endpoints []*Endpoint
+	for _, data := range s.endpoints[userID] {
+		e := &Endpoint{}
+		if err := json.Unmarshal(data, e); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	sortEndpoints(endpoints)
+	return endpoints, nil
+}
+
+func (s *memoryStore) Endpoint(userID, id uuid.UUID) (*Endpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.endpoints[userID][id]
+	if !ok {
+		return nil, ErrEndpointNotFound
+	}
+	e := &Endpoint{}
+	return e, json.Unmarshal(data, e)
+}
+
+func (s *memoryStore) SaveEndpoint(e *Endpoint) error {
+	// Keep a copy so callers can't modify stored endpoints.
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.endpoints[e.UserID] == nil {
+		s.endpoints[e.UserID] = make(map[uuid.UUID][]byte)
+	}
+	s.endpoints[e.UserID][e.ID] = data
+	return nil
+}
+
+func (s *memoryStore) DeleteEndpoint(userID, id uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.endpoints[userID][id]; !ok {
+		return ErrEndpointNotFound
+	}
+	delete(s.endpoints[userID], id)
+	delete(s.deliveries, id)
+	return nil
+}
+
+func (s *memoryStore) AppendDelivery(d *Delivery, limit int) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	logs := append([][]byte{data}, s.deliveries[d.EndpointID]...)
+	if len(logs) > limit {
+		logs = logs[:limit]
+	}
+	s.deliveries[d.EndpointID] = logs
+	return nil
+}
+
+func (s *memoryStore) Deliveries(id uuid.UUID) ([]*Delivery, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return unmarshalDeliveries(s.deliveries[id])
+}
+
+func unmarshalDeliveries(logs [][]byte) ([]*Delivery, error) {
+	deliveries := make([]*Delivery, 0, len(logs))
+	for _, data := range logs {
+		d := &Delivery{}
+		if err := json.Unmarshal(data, d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// redisStore keeps endpoints of a user in a hash, and delivery logs of an
+// endpoint in a list.
+type redisStore struct {
+	redis *cache.Redis
+}
+
+// NewRedisStore returns a store backed by redis.
+func NewRedisStore(redis *cache.Redis) Store {
+	return &redisStore{redis: redis}
+}
+
+func redisEndpointsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("notification:webhook:endpoints:%s", userID)
+}
+
+func redisDeliveriesKey(id uuid.UUID) string {
+	return fmt.Sprintf("notification:webhook:deliveries:%s", id)
+}
+
+func (s *redisStore) Endpoints(userID uuid.UUID) ([]*Endpoint, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	values, err := redis.StringMap(rCli.Do("HGETALL", redisEndpointsKey(userID)))
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []*Endpoint
+	for _, data := range values {
+		e := &Endpoint{}
+		if err := json.Unmarshal([]byte(data), e); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	sortEndpoints(endpoints)
+	return endpoints, nil
+}
+
+func (s *redisStore) Endpoint(userID, id uuid.UUID) (*Endpoint, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	data, err := redis.Bytes(rCli.Do("HGET", redisEndpointsKey(userID), id.String()))
+	if err == redis.ErrNil {
+		return nil, ErrEndpointNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	e := &Endpoint{}
+	return e, json.Unmarshal(data, e)
+}
+
+func (s *redisStore) SaveEndpoint(e *Endpoint) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	rCli, release := s.redis.GetConn()
+	defer release()
+	_, err = rCli.Do("HSET", redisEndpointsKey(e.UserID), e.ID.String(), data)
+	return err
+}
+
+func (s *redisStore) DeleteEndpoint(userID, id uuid.UUID) error {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	n, err := redis.Int(rCli.Do("HDEL", redisEndpointsKey(userID), id.String()))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEndpointNotFound
+	}
+	_, err = rCli.Do("DEL", redisDeliveriesKey(id))
+	return err
+}
+
+func (s *redisStore) AppendDelivery(d *Delivery, limit int) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	key := redisDeliveriesKey(d.EndpointID)
+	rCli, release := s.redis.GetConn()
+	defer release()
+	if _, err := rCli.Do("LPUSH", key, data); err != nil {
+		return err
+	}
+	_, err = rCli.Do("LTRIM", key, 0, limit-1)
+	return err
+}
+
+func (s *redisStore) Deliveries(id uuid.UUID) ([]*Delivery, error) {
+	rCli, release := s.redis.GetConn()
+	defer release()
+	logs, err := redis.ByteSlices(rCli.Do("LRANGE", redisDeliveriesKey(id), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalDeliveries(logs)
+}
@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+
+	models "github.com/jiarung/mochi/models/exchange"
+)
+
+// EventType defines the type of webhook events.
+type EventType string
+
+// Event types. Ping is sent to test endpoints and isn't subscribable.
+const (
+	EventOrderFilled         EventType = "order.filled"
+	EventDepositConfirmed    EventType = "deposit.confirmed"
+	EventWithdrawalConfirmed EventType = "withdrawal.confirmed"
+	EventPing                EventType = "ping"
+)
+
+// EventTypes are the subscribable event types.
+var EventTypes = []EventType{
+	EventOrderFilled,
+	EventDepositConfirmed,
+	EventWithdrawalConfirmed,
+}
+
+// Errors of webhooks.
+var (
+	ErrEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrEndpointDisabled = errors.New("webhook endpoint is disabled")
+	ErrTooManyEndpoints = errors.New("too many webhook endpoints")
+	ErrInvalidURL       = errors.New("invalid webhook url")
+	ErrInvalidEventType = errors.New("invalid webhook event type")
+)
+
+// Endpoint is an URL registered by a user to receive events.
+type Endpoint struct {
+	ID     uuid.UUID   `json:"id"`
+	UserID uuid.UUID   `json:"user_id"`
+	URL    string      `json:"url"`
+	Events []EventType `json:"events"`
+	// Secret signs payloads sent to the endpoint.
+	Secret string `json:"secret"`
+	// Failures is the number of consecutive failed deliveries. The endpoint
+	// is disabled when it reaches the limit.
+	Failures      int        `json:"failures"`
+	Disabled      bool       `json:"disabled"`
+	DisabledAt    *time.Time `json:"disabled_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Subscribed returns if the endpoint receives events of t.
+func (e *Endpoint) Subscribed(t EventType) bool {
+	if t == EventPing {
+		return true
+	}
+	for _, event := range e.Events {
+		if event == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the payload posted to endpoints.
+type Event struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      EventType       `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewEvent creates an event with data marshaled into JSON.
+func NewEvent(t EventType, data interface{}) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s event: %v", t, err)
+	}
+	return &Event{
+		ID:        uuid.NewV4(),
+		Type:      t,
+		CreatedAt: time.Now(),
+		Data:      raw,
+	}, nil
+}
+
+// OrderData is the data of EventOrderFilled.
+type OrderData struct {
+	OrderID       uuid.UUID `json:"order_id"`
+	TradingPairID string    `json:"trading_pair_id"`
+	Type          string    `json:"type"`
+}
+
+// NewOrderFilledEvent creates the event of a filled order, which is pushed
+// by notification.AppStruct.GenOrderFilled.
+func NewOrderFilledEvent(order models.Order) (*Event, error) {
+	return NewEvent(EventOrderFilled, &OrderData{
+		OrderID:       order.ID,
+		TradingPairID: order.TradingPairID,
+		Type:          fmt.Sprint(order.Type),
+	})
+}
+
+// TransferData is the data of EventDepositConfirmed and
+// EventWithdrawalConfirmed.
+type TransferData struct {
+	ID         uuid.UUID       `json:"id"`
+	CurrencyID string          `json:"currency_id"`
+	Amount     decimal.Decimal `json:"amount"`
+}
+
+// NewDepositConfirmedEvent creates the event of a confirmed deposit, which is
+// pushed by notification.AppStruct.GenDepositConfirmed.
+func NewDepositConfirmedEvent(deposit *models.Deposit) (*Event, error) {
+	return NewEvent(EventDepositConfirmed, &TransferData{
+		ID:         deposit.ID,
+		CurrencyID: fmt.Sprint(deposit.CurrencyID),
+		Amount:     deposit.Amount,
+	})
+}
+
+// NewWithdrawalConfirmedEvent creates the event of a confirmed withdrawal,
+// which is pushed by notification.AppStruct.GenWithdrawalConfirmed.
+func NewWithdrawalConfirmedEvent(withdrawal *models.Withdrawal) (
+	*Event, error) {
+	return NewEvent(EventWithdrawalConfirmed, &TransferData{
+		ID:         withdrawal.ID,
+		CurrencyID: fmt.Sprint(withdrawal.CurrencyID),
+		Amount:     withdrawal.Amount,
+	})
+}
+
+// Delivery is a log of a delivery attempt.
+type Delivery struct {
+	ID         uuid.UUID `json:"id"`
+	EndpointID uuid.UUID `json:"endpoint_id"`
+	EventID    uuid.UUID `json:"event_id"`
+	EventType  EventType `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	// Response is the head of the response body.
+	Response  string        `json:"response,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Succeeded returns if the endpoint accepted the event.
+func (d *Delivery) Succeeded() bool {
+	return len(d.Error) == 0
+}
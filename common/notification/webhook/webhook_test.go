@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/notification/dispatcher"
+)
+
+type WebhookTestSuite struct {
+	suite.Suite
+
+	service *Service
+	server  *httptest.Server
+	userID  uuid.UUID
+
+	mutex    sync.Mutex
+	status   int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (s *WebhookTestSuite) SetupTest() {
+	s.status = http.StatusOK
+	s.received = nil
+	s.bodies = nil
+	s.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			s.received = append(s.received, r)
+			s.bodies = append(s.bodies, body)
+			w.WriteHeader(s.status)
+			w.Write([]byte("received"))
+		}))
+	s.service = NewService(NewMemoryStore(), &Option{
+		Timeout:         time.Second,
+		MaxFailures:     3,
+		MaxEndpoints:    2,
+		MaxDeliveryLogs: 5,
+		AllowInsecure:   true,
+		AllowPrivate:    true,
+	})
+	s.userID = uuid.NewV4()
+}
+
+func (s *WebhookTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *WebhookTestSuite) create(events ...EventType) *Endpoint {
+	e, err := s.service.Create(s.userID, s.server.URL, events)
+	s.Require().NoError(err)
+	return e
+}
+
+func (s *WebhookTestSuite) send(e *Endpoint, event *Event, attempts int) error {
+	job, err := dispatcher.NewJob(Channel, "", &jobPayload{
+		UserID:     e.UserID,
+		EndpointID: e.ID,
+		Event:      event,
+	})
+	s.Require().NoError(err)
+	job.Attempts = attempts
+	return s.service.Sender().Send(context.Background(), job)
+}
+
+func (s *WebhookTestSuite) endpoint(e *Endpoint) *Endpoint {
+	e, err := s.service.Store().Endpoint(e.UserID, e.ID)
+	s.Require().NoError(err)
+	return e
+}
+
+func (s *WebhookTestSuite) TestSignature() {
+	now := time.Unix(1528000000, 0)
+	body := []byte(`{"type":"ping"}`)
+	header := Sign("secret", now, body)
+	s.Require().True(strings.HasPrefix(header, "t=1528000000,v1="))
+
+	s.Require().NoError(Verify("secret", header, body, now, time.Minute))
+	s.Require().NoError(
+		Verify("secret", header+",v1=rotated", body, now, time.Minute))
+	s.Require().Equal(ErrInvalidSignature,
+		Verify("other", header, body, now, time.Minute))
+	s.Require().Equal(ErrInvalidSignature,
+		Verify("secret", header, []byte(`{}`), now, time.Minute))
+	s.Require().Equal(ErrInvalidSignature,
+		Verify("secret", "v1=abc", body, now, time.Minute))
+	s.Require().Equal(ErrSignatureExpired, Verify("secret", header, body,
+		now.Add(2*time.Minute), time.Minute))
+}
+
+func (s *WebhookTestSuite) TestCreate() {
+	_, err := s.service.Create(s.userID, "ftp://example.com", EventTypes)
+	s.Require().Equal(ErrInvalidURL, err)
+	_, err = s.service.Create(s.userID, s.server.URL, nil)
+	s.Require().Equal(ErrInvalidEventType, err)
+	_, err = s.service.Create(s.userID, s.server.URL,
+		[]EventType{EventPing})
+	s.Require().Equal(ErrInvalidEventType, err)
+
+	e := s.create(EventOrderFilled)
+	s.Require().True(strings.HasPrefix(e.Secret, "whsec_"))
+	s.Require().True(e.Subscribed(EventOrderFilled))
+	s.Require().True(e.Subscribed(EventPing))
+	s.Require().False(e.Subscribed(EventDepositConfirmed))
+	s.create(EventDepositConfirmed)
+	_, err = s.service.Create(s.userID, s.server.URL, EventTypes)
+	s.Require().Equal(ErrTooManyEndpoints, err)
+
+	s.service.opt.AllowInsecure = false
+	_, err = s.service.Create(uuid.NewV4(), s.server.URL, EventTypes)
+	s.Require().Equal(ErrInvalidURL, err)
+}
+
+func (s *WebhookTestSuite) TestDeliver() {
+	e := s.create(EventOrderFilled)
+	event, err := NewEvent(EventOrderFilled, &OrderData{
+		OrderID:       uuid.NewV4(),
+		TradingPairID: "ETH-BTC",
+		Type:          "limit",
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.send(e, event, 0))
+
+	s.Require().Len(s.received, 1)
+	req, body := s.received[0], s.bodies[0]
+	s.Require().Equal("order.filled", req.Header.Get(HeaderEvent))
+	s.Require().Equal(event.ID.String(), req.Header.Get(HeaderDelivery))
+	s.Require().NoError(Verify(e.Secret, req.Header.Get(HeaderSignature),
+		body, time.Now(), time.Minute))
+	received := &Event{}
+	s.Require().NoError(json.Unmarshal(body, received))
+	s.Require().Equal(event.ID, received.ID)
+	s.Require().JSONEq(string(event.Data), string(received.Data))
+
+	deliveries, err := s.service.Store().Deliveries(e.ID)
+	s.Require().NoError(err)
+	s.Require().Len(deliveries, 1)
+	s.Require().True(deliveries[0].Succeeded())
+	s.Require().Equal(1, deliveries[0].Attempt)
+	s.Require().Equal(http.StatusOK, deliveries[0].StatusCode)
+	s.Require().Equal("received", deliveries[0].Response)
+	s.Require().NotNil(s.endpoint(e).LastSuccessAt)
+}
+
+func (s *WebhookTestSuite) TestAutoDisable() {
+	e := s.create(EventDepositConfirmed)
+	event, err := NewEvent(EventDepositConfirmed, &TransferData{})
+	s.Require().NoError(err)
+
+	s.status = http.StatusInternalServerError
+	for i := 0; i < 2; i++ {
+		err = s.send(e, event, i)
+		s.Require().Error(err)
+		s.Require().False(dispatcher.IsPermanent(err))
+	}
+	s.Require().Equal(2, s.endpoint(e).Failures)
+
+	// Pings don't count.
+	d, err := s.service.Ping(context.Background(), s.userID, e.ID)
+	s.Require().NoError(err)
+	s.Require().False(d.Succeeded())
+	s.Require().Equal("status 500", d.Error)
+	s.Require().Equal(2, s.endpoint(e).Failures)
+
+	s.Require().Error(s.send(e, event, 2))
+	s.Require().True(s.endpoint(e).Disabled)
+	err = s.send(e, event, 3)
+	s.Require().True(dispatcher.IsPermanent(err))
+	s.Require().Len(s.received, 4)
+
+	deliveries, err := s.service.Store().Deliveries(e.ID)
+	s.Require().NoError(err)
+	s.Require().Len(deliveries, 4)
+	s.Require().Equal(3, deliveries[0].Attempt)
+	s.Require().Equal(0, deliveries[1].Attempt)
+
+	e, err = s.service.Enable(s.userID, e.ID)
+	s.Require().NoError(err)
+	s.Require().False(e.Disabled)
+	s.Require().Zero(e.Failures)
+	s.status = http.StatusOK
+	s.Require().NoError(s.send(e, event, 0))
+}
+
+func (s *WebhookTestSuite) TestGone() {
+	e := s.create(EventWithdrawalConfirmed)
+	event, err := NewEvent(EventWithdrawalConfirmed, &TransferData{})
+	s.Require().NoError(err)
+
+	s.status = http.StatusGone
+	err = s.send(e, event, 0)
+	s.Require().True(dispatcher.IsPermanent(err))
+	s.Require().True(s.endpoint(e).Disabled)
+}
+
+func (s *WebhookTestSuite) TestPrivateAddress() {
+	s.service = NewService(s.service.store, &Option{
+		Timeout:         time.Second,
+		MaxFailures:     3,
+		MaxEndpoints:    2,
+		MaxDeliveryLogs: 5,
+		AllowInsecure:   true,
+	})
+	e := s.create(EventOrderFilled)
+	d, err := s.service.Ping(context.Background(), s.userID, e.ID)
+	s.Require().NoError(err)
+	s.Require().Contains(d.Error, "private address")
+	s.Require().Empty(s.received)
+}
+
+func (s *WebhookTestSuite) TestPublish() {
+	subscribed := s.create(EventOrderFilled)
+	s.create(EventDepositConfirmed)
+
+	dir, err := ioutil.TempDir("", "webhook")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	d := dispatcher.New(&dispatcher.Option{
+		Dir:            dir,
+		Workers:        1,
+		MaxAttempts:    2,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		PollInterval:   10 * time.Millisecond,
+		SendTimeout:    time.Second,
+		IdempotencyTTL: time.Minute,
+	}, nil)
+	s.Require().NoError(d.Register(Channel, s.service.Sender()))
+	s.Require().NoError(d.Start())
+	defer d.Close(context.Background())
+
+	event, err := NewEvent(EventOrderFilled, &OrderData{})
+	s.Require().NoError(err)
+	s.Require().NoError(s.service.Publish(d, s.userID, event))
+	s.Require().NoError(s.service.Publish(d, s.userID, event))
+
+	s.Require().Eventually(func() bool {
+		deliveries, err := s.service.Store().Deliveries(subscribed.ID)
+		return err == nil && len(deliveries) == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Require().Len(s.received, 1)
+}
+
+func TestWebhook(t *testing.T) {
+	suite.Run(t, new(WebhookTestSuite))
+}
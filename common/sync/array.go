@@ -1,26 +1,14 @@
 package sync
 
 import (
-	"sync/atomic"
+	"github.com/jiarung/mochi/common/collections"
 )
 
 // Array provides thread-safe array collection to any type with fixed length.
-type Array struct {
-	arr []atomic.Value
-}
+// New code should use collections.Array of its own type.
+type Array = collections.Array[interface{}]
 
 // NewArray returns new Array.
 func NewArray(length int) *Array {
-	return &Array{arr: make([]atomic.Value, length)}
-}
-
-// Store saves value. user should check k is not out of range.
-func (s *Array) Store(k int, v interface{}) {
-	s.arr[k].Store(v)
-}
-
-// Load returns value. nil if not store yet.
-// user should check k is not out of range.
-func (s *Array) Load(k int) interface{} {
-	return s.arr[k].Load()
+	return collections.NewArray[interface{}](length)
 }
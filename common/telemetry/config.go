@@ -0,0 +1,183 @@
+package telemetry
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jiarung/mochi/common/config/misc"
+	"github.com/jiarung/mochi/common/utils"
+)
+
+// ExporterType defines the backend that telemetry data is exported to.
+type ExporterType string
+
+// Supported exporter types.
+const (
+	// ExporterNone disables exporting, providers are no-op.
+	ExporterNone ExporterType = "none"
+	// ExporterOTLP exports to an OTLP/gRPC collector.
+	ExporterOTLP ExporterType = "otlp"
+	// ExporterDatadog exports traces to the dd-agent and metrics/logs to the
+	// OTLP receiver of the dd-agent.
+	ExporterDatadog ExporterType = "datadog"
+	// ExporterStackdriver exports to Google Cloud Trace/Monitoring/Logging.
+	ExporterStackdriver ExporterType = "stackdriver"
+	// ExporterStdout prints telemetry data to stdout for local development.
+	ExporterStdout ExporterType = "stdout"
+)
+
+// Environment variables read by ConfigFromEnv.
+const (
+	envExporter       = "TELEMETRY_EXPORTER"
+	envOTLPEndpoint   = "TELEMETRY_OTLP_ENDPOINT"
+	envOTLPInsecure   = "TELEMETRY_OTLP_INSECURE"
+	envSampleRatio    = "TELEMETRY_SAMPLE_RATIO"
+	envExportInterval = "TELEMETRY_EXPORT_INTERVAL"
+	envGCPProjectID   = "TELEMETRY_GCP_PROJECT_ID"
+	envServiceName    = "TELEMETRY_SERVICE_NAME"
+	envStatsdAddr     = "TELEMETRY_STATSD_ADDR"
+	envKubeletHost    = "KUBERNETES_KUBELET_HOST"
+)
+
+const (
+	defaultSampleRatio    = 1.0
+	defaultExportInterval = 60 * time.Second
+	defaultDatadogOTLP    = "4317"
+	defaultDatadogAgent   = "8126"
+	defaultDatadogStatsd  = "8125"
+)
+
+// ParseExporterType parses s into an ExporterType.
+func ParseExporterType(s string) (ExporterType, error) {
+	switch t := ExporterType(strings.ToLower(strings.TrimSpace(s))); t {
+	case ExporterNone, ExporterOTLP, ExporterDatadog,
+		ExporterStackdriver, ExporterStdout:
+		return t, nil
+	case "":
+		return ExporterNone, nil
+	default:
+		return ExporterNone, fmt.Errorf("unknown telemetry exporter: %s", s)
+	}
+}
+
+// Config defines how the telemetry providers are set up.
+type Config struct {
+	// ServiceName is reported as the `service.name` resource attribute.
+	ServiceName string
+	// ServiceVersion is reported as the `service.version` resource attribute.
+	ServiceVersion string
+	// Environment is reported as the `deployment.environment` attribute.
+	Environment string
+
+	// Exporter selects the backend.
+	Exporter ExporterType
+	// OTLPEndpoint is the host:port of the OTLP collector. Used by
+	// ExporterOTLP, and by ExporterDatadog for metrics and logs.
+	OTLPEndpoint string
+	// OTLPInsecure disables TLS to the OTLP collector.
+	OTLPInsecure bool
+	// GCPProjectID overrides the project detected from GCE metadata.
+	GCPProjectID string
+	// DatadogAgentAddr is the host:port of the dd-agent trace receiver, used
+	// by ExporterDatadog.
+	DatadogAgentAddr string
+	// StatsdAddr is the host:port of the statsd receiver, which is the
+	// dd-agent for ExporterDatadog. Statsd is disabled if it's empty.
+	StatsdAddr string
+
+	// SampleRatio is the fraction of root spans sampled, in [0, 1].
+	SampleRatio float64
+	// ExportInterval is the interval between metric exports.
+	ExportInterval time.Duration
+}
+
+// DefaultExporter returns the exporter used if TELEMETRY_EXPORTER is unset.
+// Services were traced by the dd-agent in every environment except local
+// development before the exporter was configurable, so it's ExporterDatadog
+// unless the server environment is local development or unset.
+func DefaultExporter() ExporterType {
+	switch misc.ServerEnvironment() {
+	case "", utils.EnvLocalDevelopmentTag:
+		return ExporterNone
+	default:
+		return ExporterDatadog
+	}
+}
+
+// ConfigFromEnv returns a Config populated from environment variables. The
+// service name is read from TELEMETRY_SERVICE_NAME if serviceName is empty,
+// and the exporter is DefaultExporter if TELEMETRY_EXPORTER is unset.
+func ConfigFromEnv(serviceName string) (*Config, error) {
+	var err error
+	exporter := DefaultExporter()
+	if s, ok := os.LookupEnv(envExporter); ok {
+		if exporter, err = ParseExporterType(s); err != nil {
+			return nil, err
+		}
+	}
+	if len(serviceName) == 0 {
+		serviceName = os.Getenv(envServiceName)
+	}
+
+	cfg := &Config{
+		ServiceName:    serviceName,
+		Exporter:       exporter,
+		OTLPEndpoint:   os.Getenv(envOTLPEndpoint),
+		GCPProjectID:   os.Getenv(envGCPProjectID),
+		StatsdAddr:     os.Getenv(envStatsdAddr),
+		SampleRatio:    defaultSampleRatio,
+		ExportInterval: defaultExportInterval,
+	}
+
+	if s := os.Getenv(envOTLPInsecure); len(s) > 0 {
+		if cfg.OTLPInsecure, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", envOTLPInsecure, err)
+		}
+	}
+	if s := os.Getenv(envSampleRatio); len(s) > 0 {
+		if cfg.SampleRatio, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", envSampleRatio, err)
+		}
+	}
+	if s := os.Getenv(envExportInterval); len(s) > 0 {
+		if cfg.ExportInterval, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", envExportInterval, err)
+		}
+	}
+	if cfg.Exporter == ExporterDatadog {
+		host := os.Getenv(envKubeletHost)
+		if len(cfg.OTLPEndpoint) == 0 {
+			cfg.OTLPEndpoint = fmt.Sprint(host, ":", defaultDatadogOTLP)
+			cfg.OTLPInsecure = true
+		}
+		cfg.DatadogAgentAddr = fmt.Sprint(host, ":", defaultDatadogAgent)
+		if len(cfg.StatsdAddr) == 0 {
+			cfg.StatsdAddr = fmt.Sprint(host, ":", defaultDatadogStatsd)
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the config.
+func (c *Config) Validate() error {
+	if len(c.ServiceName) == 0 {
+		return fmt.Errorf("empty service name")
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio %v out of range [0, 1]", c.SampleRatio)
+	}
+	if c.ExportInterval <= 0 {
+		return fmt.Errorf("export interval must be positive")
+	}
+	switch c.Exporter {
+	case ExporterOTLP, ExporterDatadog:
+		if len(c.OTLPEndpoint) == 0 {
+			return fmt.Errorf("exporter %s requires an OTLP endpoint", c.Exporter)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package telemetry
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/jiarung/mochi/common/config/misc"
+	"github.com/jiarung/mochi/common/utils"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func (s *ConfigTestSuite) TearDownTest() {
+	for _, env := range []string{envExporter, envOTLPEndpoint, envOTLPInsecure,
+		envSampleRatio, envExportInterval, envGCPProjectID, envServiceName,
+		envStatsdAddr, envKubeletHost} {
+		os.Unsetenv(env)
+	}
+	misc.SetServerEnvironment("")
+}
+
+func (s *ConfigTestSuite) TestParseExporterType() {
+	t, err := ParseExporterType(" OTLP ")
+	s.Require().Nil(err)
+	s.Require().Equal(ExporterOTLP, t)
+
+	t, err = ParseExporterType("")
+	s.Require().Nil(err)
+	s.Require().Equal(ExporterNone, t)
+
+	_, err = ParseExporterType("newrelic")
+	s.Require().NotNil(err)
+}
+
+func (s *ConfigTestSuite) TestConfigFromEnv() {
+	cfg, err := ConfigFromEnv("test")
+	s.Require().Nil(err)
+	s.Require().Equal(ExporterNone, cfg.Exporter)
+	s.Require().Equal(defaultExportInterval, cfg.ExportInterval)
+
+	os.Setenv(envExporter, "otlp")
+	_, err = ConfigFromEnv("test")
+	s.Require().NotNil(err)
+
+	os.Setenv(envOTLPEndpoint, "collector:4317")
+	os.Setenv(envOTLPInsecure, "true")
+	os.Setenv(envSampleRatio, "0.25")
+	os.Setenv(envExportInterval, "10s")
+	cfg, err = ConfigFromEnv("test")
+	s.Require().Nil(err)
+	s.Require().Equal("collector:4317", cfg.OTLPEndpoint)
+	s.Require().True(cfg.OTLPInsecure)
+	s.Require().Equal(0.25, cfg.SampleRatio)
+	s.Require().Equal(10*time.Second, cfg.ExportInterval)
+
+	os.Setenv(envSampleRatio, "2")
+	_, err = ConfigFromEnv("test")
+	s.Require().NotNil(err)
+}
+
+func (s *ConfigTestSuite) TestConfigFromEnvDatadog() {
+	_, err := ConfigFromEnv("")
+	s.Require().NotNil(err)
+
+	os.Setenv(envServiceName, "api")
+	os.Setenv(envExporter, "datadog")
+	os.Setenv(envKubeletHost, "10.0.0.1")
+	cfg, err := ConfigFromEnv("")
+	s.Require().Nil(err)
+	s.Require().Equal("api", cfg.ServiceName)
+	s.Require().Equal("10.0.0.1:4317", cfg.OTLPEndpoint)
+	s.Require().Equal("10.0.0.1:8126", cfg.DatadogAgentAddr)
+	s.Require().Equal("10.0.0.1:8125", cfg.StatsdAddr)
+}
+
+func (s *ConfigTestSuite) TestConfigFromEnvDefault() {
+	os.Setenv(envKubeletHost, "10.0.0.1")
+
+	misc.SetServerEnvironment(utils.EnvLocalDevelopmentTag)
+	cfg, err := ConfigFromEnv("test")
+	s.Require().Nil(err)
+	s.Require().Equal(ExporterNone, cfg.Exporter)
+	s.Require().Empty(cfg.StatsdAddr)
+
+	misc.SetServerEnvironment(utils.EnvProductionTag)
+	cfg, err = ConfigFromEnv("test")
+	s.Require().Nil(err)
+	s.Require().Equal(ExporterDatadog, cfg.Exporter)
+	s.Require().Equal("10.0.0.1:8125", cfg.StatsdAddr)
+
+	os.Setenv(envExporter, "none")
+	cfg, err = ConfigFromEnv("test")
+	s.Require().Nil(err)
+	s.Require().Equal(ExporterNone, cfg.Exporter)
+}
+
+func (s *ConfigTestSuite) TestInitNone() {
+	s.Require().Nil(Init(nil, &Config{
+		ServiceName:    "test",
+		Exporter:       ExporterNone,
+		ExportInterval: time.Second,
+	}))
+	s.Require().False(Enabled())
+	s.Require().Equal("test", CurrentConfig().ServiceName)
+}
+
+func TestConfig(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
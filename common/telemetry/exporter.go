@@ -0,0 +1,90 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	mexporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/metric"
+	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newSpanExporter returns the span exporter of cfg.Exporter. Datadog is not
+// handled here since dd-trace-go provides its own tracer provider.
+func newSpanExporter(
+	ctx context.Context, cfg *Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint),
+		}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ExporterStackdriver:
+		var opts []texporter.Option
+		if len(cfg.GCPProjectID) > 0 {
+			opts = append(opts, texporter.WithProjectID(cfg.GCPProjectID))
+		}
+		return texporter.New(opts...)
+	case ExporterStdout:
+		return stdouttrace.New(
+			stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	}
+	return nil, fmt.Errorf("no span exporter for %s", cfg.Exporter)
+}
+
+// newMetricExporter returns the metric exporter of cfg.Exporter.
+func newMetricExporter(
+	ctx context.Context, cfg *Config) (sdkmetric.Exporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLP, ExporterDatadog:
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(cfg.OTLPEndpoint),
+		}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case ExporterStackdriver:
+		var opts []mexporter.Option
+		if len(cfg.GCPProjectID) > 0 {
+			opts = append(opts, mexporter.WithProjectID(cfg.GCPProjectID))
+		}
+		return mexporter.New(opts...)
+	case ExporterStdout:
+		return stdoutmetric.New(stdoutmetric.WithWriter(os.Stdout))
+	}
+	return nil, fmt.Errorf("no metric exporter for %s", cfg.Exporter)
+}
+
+// newLogExporter returns the log exporter of cfg.Exporter. It returns nil
+// for Stackdriver since logging already has a native stackdriver output.
+func newLogExporter(
+	ctx context.Context, cfg *Config) (sdklog.Exporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLP, ExporterDatadog:
+		opts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(cfg.OTLPEndpoint),
+		}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		}
+		return otlploggrpc.New(ctx, opts...)
+	case ExporterStackdriver:
+		return nil, nil
+	case ExporterStdout:
+		return stdoutlog.New(stdoutlog.WithWriter(os.Stdout))
+	}
+	return nil, fmt.Errorf("no log exporter for %s", cfg.Exporter)
+}
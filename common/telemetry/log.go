@@ -0,0 +1,42 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	otellog "go.opentelemetry.io/otel/log"
+
+	"github.com/jiarung/mochi/common/logging"
+)
+
+var severityMap = map[logging.Level]otellog.Severity{
+	logging.Critical: otellog.SeverityFatal,
+	logging.Error:    otellog.SeverityError,
+	logging.Warn:     otellog.SeverityWarn,
+	logging.Notice:   otellog.SeverityInfo2,
+	logging.Info:     otellog.SeverityInfo,
+	logging.Debug:    otellog.SeverityDebug,
+}
+
+// LogOutput returns a logging.Output that forwards logs to the global
+// logger provider, so it can be appended to existing loggers.
+func LogOutput() logging.Output {
+	return &logOutput{}
+}
+
+type logOutput struct{}
+
+// Output outputs the logs.
+func (o *logOutput) Output(_ *logging.OutputOpt, level logging.Level,
+	labelMap logging.LabelMap, log string) {
+	var r otellog.Record
+	r.SetTimestamp(time.Now())
+	r.SetSeverity(severityMap[level])
+	r.SetSeverityText(strings.TrimSpace(level.String()))
+	r.SetBody(otellog.StringValue(log))
+	for k, v := range labelMap {
+		r.AddAttributes(otellog.String(k, v))
+	}
+	Logger().Emit(context.Background(), r)
+}
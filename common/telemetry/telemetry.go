@@ -0,0 +1,187 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	ddotel "gopkg.in/DataDog/dd-trace-go.v1/ddtrace/opentelemetry"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/jiarung/mochi/common/logging"
+)
+
+// InstrumentationName is the instrumentation scope name used by the shared
+// packages of this repo.
+const InstrumentationName = "github.com/jiarung/mochi"
+
+var (
+	mutex         sync.Mutex
+	enabled       bool
+	config        *Config
+	shutdownFuncs []func(context.Context) error
+)
+
+// Init sets up the global tracer, meter and logger providers with the
+// exporters of cfg. It should be called once at startup, and Shutdown should
+// be called before the process exits to flush buffered data.
+func Init(ctx context.Context, cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if enabled {
+		return errors.New("telemetry is already initialized")
+	}
+	config = cfg
+	if cfg.Exporter == ExporterNone {
+		return nil
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(cfg.ServiceVersion),
+			semconv.DeploymentEnvironment(cfg.Environment),
+		),
+	)
+	if err != nil {
+		return fmt.Errorf("merge resource: %v", err)
+	}
+
+	var funcs []func(context.Context) error
+	fail := func(err error) error {
+		for i := len(funcs) - 1; i >= 0; i-- {
+			funcs[i](ctx)
+		}
+		return err
+	}
+
+	// Tracer provider.
+	if cfg.Exporter == ExporterDatadog {
+		tp := ddotel.NewTracerProvider(
+			tracer.WithService(cfg.ServiceName),
+			tracer.WithServiceVersion(cfg.ServiceVersion),
+			tracer.WithEnv(cfg.Environment),
+			tracer.WithAgentAddr(cfg.DatadogAgentAddr),
+			tracer.WithSampler(tracer.NewRateSampler(cfg.SampleRatio)),
+		)
+		otel.SetTracerProvider(tp)
+		funcs = append(funcs, func(context.Context) error {
+			return tp.Shutdown()
+		})
+	} else {
+		spanExporter, err := newSpanExporter(ctx, cfg)
+		if err != nil {
+			return fail(fmt.Errorf("create span exporter: %v", err))
+		}
+		tp := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(spanExporter),
+			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sdktrace.ParentBased(
+				sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		)
+		otel.SetTracerProvider(tp)
+		funcs = append(funcs, tp.Shutdown)
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Meter provider.
+	metricExporter, err := newMetricExporter(ctx, cfg)
+	if err != nil {
+		return fail(fmt.Errorf("create metric exporter: %v", err))
+	}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(
+			metricExporter, sdkmetric.WithInterval(cfg.ExportInterval))),
+	)
+	otel.SetMeterProvider(mp)
+	funcs = append(funcs, mp.Shutdown)
+
+	// Logger provider.
+	logExporter, err := newLogExporter(ctx, cfg)
+	if err != nil {
+		return fail(fmt.Errorf("create log exporter: %v", err))
+	}
+	if logExporter != nil {
+		lp := sdklog.NewLoggerProvider(
+			sdklog.WithResource(res),
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
+		)
+		global.SetLoggerProvider(lp)
+		funcs = append(funcs, lp.Shutdown)
+	}
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logging.NewLoggerTag("telemetry").Warn("otel: %v", err)
+	}))
+
+	shutdownFuncs = funcs
+	enabled = true
+	return nil
+}
+
+// Shutdown flushes and stops all providers set up by Init.
+func Shutdown(ctx context.Context) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var firstErr error
+	for i := len(shutdownFuncs) - 1; i >= 0; i-- {
+		if err := shutdownFuncs[i](ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	shutdownFuncs = nil
+	enabled = false
+	config = nil
+	return firstErr
+}
+
+// CurrentConfig returns the config of Init, or nil if Init isn't called.
+func CurrentConfig() *Config {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return config
+}
+
+// Enabled returns whether Init has set up exporting providers.
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return enabled
+}
+
+// Tracer returns a tracer of the global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Meter returns a meter of the global meter provider.
+func Meter() metric.Meter {
+	return otel.Meter(InstrumentationName)
+}
+
+// Logger returns a logger of the global logger provider.
+func Logger() otellog.Logger {
+	return global.Logger(InstrumentationName)
+}
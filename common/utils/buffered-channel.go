@@ -1,96 +1,19 @@
 package utils
 
 import (
-	"context"
-	"errors"
+	"github.com/jiarung/mochi/common/collections"
 )
 
 var (
 	// ErrChannelFull defines error when channel is full.
-	ErrChannelFull = errors.New("channel full")
+	ErrChannelFull = collections.ErrChannelFull
 )
 
-// BufferedChannel defines buffered channel struct.
-type BufferedChannel struct {
-	*UnlimitedChannel
-	err chan error
-	cap uint64
-}
-
-// Err returns error channel.
-func (c *BufferedChannel) Err() <-chan error {
-	return c.err
-}
-
-// Cap returns capacity of buffered channel.
-func (c *BufferedChannel) Cap() uint64 {
-	return c.cap
-}
-
-func (c *BufferedChannel) sendErr(err error) {
-	select {
-	case c.err <- err:
-	default:
-	}
-}
+// BufferedChannel defines buffered channel struct of objects of any type.
+// New code should use collections.BufferedChan of its own type.
+type BufferedChannel = collections.BufferedChan[interface{}]
 
 // NewBufferedChannel returns an buffered channel object.
 func NewBufferedChannel(cap uint64) *BufferedChannel {
-	ctx, cancel := context.WithCancel(context.Background())
-	c := &BufferedChannel{
-		UnlimitedChannel: &UnlimitedChannel{
-			in:     make(chan interface{}),
-			out:    make(chan interface{}),
-			done:   make(chan struct{}),
-			ctx:    ctx,
-			cancel: cancel,
-			deque:  NewDeque(),
-		},
-		err: make(chan error, 1),
-		cap: cap,
-	}
-	go func() {
-		defer func() {
-			c.in = nil
-			c.out = nil
-			close(c.done)
-		}()
-		for {
-			// Priority Done().
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			out, ok := c.deque.Head()
-			if !ok {
-				select {
-				case <-ctx.Done():
-					return
-				case in := <-c.in:
-					if c.deque.Len() >= cap {
-						c.sendErr(ErrChannelFull)
-						continue
-					}
-					c.deque.PushBack(in)
-				}
-				continue
-			}
-
-			select {
-			case <-ctx.Done():
-				return
-			case in := <-c.in:
-				if c.deque.Len() >= cap {
-					c.sendErr(ErrChannelFull)
-					continue
-				}
-				c.deque.PushBack(in)
-			case c.out <- out:
-				c.deque.PopFront()
-			}
-		}
-	}()
-	return c
+	return collections.NewBufferedChan[interface{}](cap)
 }
@@ -1,6 +1,7 @@
 package utils
 
-// Deque defines deque struct.
+// Deque defines deque struct. New code should use collections.Deque of its
+// own type.
 type Deque struct {
 	start uint64
 	end   uint64
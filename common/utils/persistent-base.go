@@ -1,12 +1,23 @@
 package utils
 
 import (
+	"errors"
 	"os"
 	"time"
 )
 
 type writeCtrlType int
 
+var (
+	// ErrPersistChannelClosed is returned by blocking reads and writes of
+	// closed channels.
+	ErrPersistChannelClosed = errors.New("persist channel closed")
+	// ErrPersistChannelFull is returned by writes if the reader or a
+	// consumer lags more than MaxLagPages pages.
+	ErrPersistChannelFull = errors.New("persist channel full")
+)
+
+// Defaults of PersistChannelOptions.
 var (
 	persistChannelPageSize       int64 = 10000
 	persistChannelRetainSize     int64 = 5
@@ -17,12 +28,66 @@ var (
 	writeFlush writeCtrlType = 1
 )
 
+// PersistChannelSyncPolicy is the policy to sync written data to disk.
+type PersistChannelSyncPolicy int
+
+const (
+	// PersistChannelSyncInterval syncs flushed data at most once per
+	// SyncInterval, or every flush if SyncInterval is zero.
+	PersistChannelSyncInterval PersistChannelSyncPolicy = iota
+	// PersistChannelSyncEveryWrite flushes and syncs every write before
+	// Write returns.
+	PersistChannelSyncEveryWrite
+	// PersistChannelSyncNone leaves syncing to the OS, except Flush, Close
+	// and full pages.
+	PersistChannelSyncNone
+)
+
+// PersistChannelOptions are options of persist channels. Zero values are
+// replaced by defaults, except MaxLagPages.
+type PersistChannelOptions struct {
+	Sync         PersistChannelSyncPolicy
+	SyncInterval time.Duration
+	// PageSize is the number of records of a page. It shouldn't change
+	// between opens of a channel.
+	PageSize int64
+	// RetainSize is the number of pages retained before the reader.
+	RetainSize int64
+	// WriteBuffer is the number of buffered records, writes block until
+	// they're flushed if the buffer is full.
+	WriteBuffer int64
+	// BufferDuration is the max duration records are buffered.
+	BufferDuration time.Duration
+	// MaxLagPages is the max number of pages the writer could be ahead of
+	// the reader and consumers, which bounds the pages kept on disk. Writes
+	// fail with ErrPersistChannelFull if it's exceeded. Zero is unlimited.
+	MaxLagPages int64
+}
+
+// withDefaults returns o whose zero values are replaced by defaults.
+func (o PersistChannelOptions) withDefaults() PersistChannelOptions {
+	if o.PageSize <= 0 {
+		o.PageSize = persistChannelPageSize
+	}
+	if o.RetainSize <= 0 {
+		o.RetainSize = persistChannelRetainSize
+	}
+	if o.WriteBuffer <= 0 {
+		o.WriteBuffer = persistChannelWriteBuffer
+	}
+	if o.BufferDuration <= 0 {
+		o.BufferDuration = persistChannelBufferDuration
+	}
+	return o
+}
+
 // PersistChannelFileOperator is base of file operator in channel.
 type PersistChannelFileOperator struct {
-	getPath func(int64) string
-	offset  int64
-	page    int64
-	file    *os.File
+	getPath  func(int64) string
+	pageSize int64
+	offset   int64
+	page     int64
+	file     *os.File
 }
 
 // GetOffset returns current offset.
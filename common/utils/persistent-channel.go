@@ -1,106 +1,199 @@
 package utils
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // PersistChannel provides an infinite channel with disk.
 type PersistChannel struct {
 	dir    string
 	name   string
+	opts   PersistChannelOptions
 	writer *PersistChannelWriter
 	reader *PersistChannelReader
 	closed bool
+	// done is closed when the channel is closed.
+	done chan struct{}
+
+	// gcMutex guards consumers, firstPage, releasePage and readerPage.
+	gcMutex   sync.Mutex
+	consumers map[string]*PersistChannelConsumer
+	// firstPage is the first page not removed yet.
+	firstPage int64
+	// releasePage is the page before which the reader has released.
+	releasePage int64
+	// readerPage is the page opened by the reader.
+	readerPage int64
 }
 
-// NewPersistChannel create a new persist channel instance.
+// NewPersistChannel create a new persist channel instance. It panics if the
+// channel can't be opened.
 func NewPersistChannel(dir, name string, roffset int64) *PersistChannel {
+	channel, err := OpenPersistChannel(dir, name, roffset, PersistChannelOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return channel
+}
+
+// OpenPersistChannel opens the persist channel of name in dir, whose reader
+// reads from roffset, or the end of the channel if roffset is out of range.
+// Torn records at the tail of the channel, left by crashes, are truncated.
+// Records are length-prefixed, so data could be arbitrary binary. Pages of
+// newline-terminated records, written by older versions, are migrated.
+func OpenPersistChannel(dir, name string, roffset int64,
+	opts PersistChannelOptions) (*PersistChannel, error) {
+	opts = opts.withDefaults()
 	channel := &PersistChannel{
-		dir:  dir,
-		name: name,
+		dir:       dir,
+		name:      name,
+		opts:      opts,
+		done:      make(chan struct{}),
+		consumers: make(map[string]*PersistChannelConsumer),
 	}
-	channel.reader = NewPersistChannelReader(channel.getPath, roffset)
-	channel.writer = NewPersistChannelWriter(channel.getPath)
+	channel.reader = newPersistChannelReader(channel.getPath, roffset, opts)
+	channel.reader.release = channel.release
+	channel.writer = newPersistChannelWriter(channel.getPath, opts)
 
-	channel.setupOffset()
+	if err := channel.setupOffset(); err != nil {
+		return nil, err
+	}
 	channel.writer.Start()
 	channel.writer.OpenPage()
-	channel.reader.OpenPage()
-	return channel
+	if err := channel.reader.OpenPage(); err != nil {
+		channel.writer.Close()
+		return nil, err
+	}
+	return channel, nil
 }
 
 func (c *PersistChannel) getPath(page int64) string {
 	return fmt.Sprintf("%s/%s.%v", c.dir, c.name, page)
 }
 
-func (c *PersistChannel) integrityCheck() {
+func (c *PersistChannel) integrityCheck(page int64) error {
 	if c.writer.page == 0 {
-		return
+		return nil
 	}
-	for i := c.reader.page; i <= c.writer.page; i++ {
-		if _, err := os.Stat(c.getPath(i)); os.IsNotExist(err) {
-			c.Close()
-			panic(err)
+	for i := page; i <= c.writer.page; i++ {
+		if _, err := os.Stat(c.getPath(i)); err != nil {
+			return fmt.Errorf("persist channel %s page %d missing. err: %v",
+				c.name, i, err)
 		}
 	}
+	return nil
 }
 
-func (c *PersistChannel) setupOffset() {
+// pages returns the first and last page in dir.
+func (c *PersistChannel) pages() (first, last int64, err error) {
 	files, err := ioutil.ReadDir(c.dir)
 	if err != nil {
-		panic(err)
+		return 0, 0, err
 	}
 
-	nameLen := len(c.name)
+	first = -1
+	prefix := c.name + "."
 	for _, file := range files {
 		name := file.Name()
 		// find filname "{channel_name}.{page}"
-		if len(name) > nameLen && name[:nameLen] == c.name {
-			page, err := strconv.ParseInt(name[nameLen+1:], 10, 64)
-			if err == nil && page > c.writer.page {
-				c.writer.page = page
-			}
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		page, err := strconv.ParseInt(name[len(prefix):], 10, 64)
+		if err != nil {
+			continue
+		}
+		if page > last {
+			last = page
+		}
+		if first < 0 || page < first {
+			first = page
 		}
 	}
+	if first < 0 {
+		first = 0
+	}
+	return first, last, nil
+}
 
-	f, err := os.OpenFile(c.getPath(c.writer.page), os.O_RDONLY|os.O_CREATE, 0644)
+func (c *PersistChannel) setupOffset() error {
+	first, last, err := c.pages()
 	if err != nil {
-		panic(err)
-	}
-	reader := bufio.NewReader(f)
-	if reader == nil {
-		panic("nil buffer reader")
+		return err
 	}
+	c.firstPage = first
+	c.writer.page = last
 
-	c.writer.offset = c.writer.page * persistChannelPageSize
-	for {
-		_, err := reader.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			panic(err)
-		}
-		if err == io.EOF {
-			break
+	// Pages of version 1 are rewritten before they're read or appended.
+	for page := first; page <= last; page++ {
+		if err := migratePersistPage(c.getPath(page)); err != nil {
+			return fmt.Errorf("persist channel %s page %d migration failed. "+
+				"err: %v", c.name, page, err)
 		}
-		c.writer.offset++
 	}
 
-	f.Close()
+	path := c.getPath(c.writer.page)
+	count, size, err := scanPersistPage(path)
+	if err != nil {
+		return err
+	}
+	// Remove the torn tail, so new records are appended after valid ones.
+	if err := os.Truncate(path, size); err != nil {
+		return err
+	}
+	if count >= c.opts.PageSize {
+		// The writer crashed before it opened the next page.
+		c.writer.page++
+		count = 0
+	}
+	c.writer.offset = c.writer.page*c.opts.PageSize + count
+
 	if c.reader.offset > c.writer.offset || c.reader.offset < 0 {
 		c.reader.offset = c.writer.offset
-		c.reader.page = c.reader.offset / persistChannelPageSize
+		c.reader.page = c.reader.offset / c.opts.PageSize
+	}
+	if err := c.loadConsumers(); err != nil {
+		return err
+	}
+	return c.integrityCheck(c.reader.page)
+}
+
+// release removes pages which the reader and all consumers have passed.
+// Pages within RetainSize pages before the reader are kept,
+// since callers may seek back to their committed offsets.
+func (c *PersistChannel) release(page int64) {
+	c.gcMutex.Lock()
+	defer c.gcMutex.Unlock()
+	c.readerPage = page
+	c.releasePage = page - c.opts.RetainSize + 1
+	c.gc()
+}
+
+// gc removes pages released by the reader and passed by all consumers. It
+// should be called with gcMutex locked.
+func (c *PersistChannel) gc() {
+	page := c.releasePage
+	for _, consumer := range c.consumers {
+		if p := consumer.committed / c.opts.PageSize; p < page {
+			page = p
+		}
+	}
+	for ; c.firstPage < page; c.firstPage++ {
+		os.Remove(c.getPath(c.firstPage))
 	}
-	c.integrityCheck()
 }
 
 // CreateShadowReader create a shadow reader with this channel.
-// Shadow reader wouldn't retain pages.
+// Shadow reader wouldn't retain pages. Errors of opening its page are
+// returned by its Err.
 func (c *PersistChannel) CreateShadowReader() *PersistChannelReader {
-	reader := NewPersistChannelReader(c.getPath, c.writer.offset)
+	reader := newPersistChannelReader(c.getPath, c.writer.offset, c.opts)
 	reader.shadow = true
 	reader.OpenPage()
 	return reader
@@ -114,19 +207,33 @@ func (c *PersistChannel) IsClosed() bool {
 // Close close the persist channel.
 func (c *PersistChannel) Close() {
 	c.closed = true
+	close(c.done)
 	c.writer.Close()
 	c.reader.Close()
+	c.gcMutex.Lock()
+	defer c.gcMutex.Unlock()
+	for _, consumer := range c.consumers {
+		if consumer.reader != nil {
+			consumer.reader.Close()
+		}
+	}
 }
 
-// SeekReader seeks reader to offset.
-func (c *PersistChannel) SeekReader(offset int64) {
+// SeekReader seeks reader to offset, or the end of the channel if offset is
+// out of range. It returns errors if pages from offset are missing or records
+// before offset are corrupt, and reads return no data until it's sought again.
+func (c *PersistChannel) SeekReader(offset int64) error {
 	c.reader.Close()
 	if offset > c.writer.offset || offset < 0 {
 		offset = c.writer.offset
 	}
-	c.reader = NewPersistChannelReader(c.getPath, offset)
-	c.integrityCheck()
-	c.reader.OpenPage()
+	c.reader = newPersistChannelReader(c.getPath, offset, c.opts)
+	c.reader.release = c.release
+	if err := c.integrityCheck(c.reader.page); err != nil {
+		c.reader.err = err
+		return err
+	}
+	return c.reader.OpenPage()
 }
 
 // Flush flushs data in writer.
@@ -142,10 +249,88 @@ func (c *PersistChannel) Read() ([]byte, int64) {
 	return c.reader.Read()
 }
 
-// Write writes data to writer.
-func (c *PersistChannel) Write(data []byte) {
+// Write writes data to writer. See WriteBatch for errors.
+func (c *PersistChannel) Write(data []byte) error {
+	return c.WriteBatch([][]byte{data})
+}
+
+// ReadBatch reads at most max records from reader, and returns them with the
+// offset of the first one.
+func (c *PersistChannel) ReadBatch(max int) ([][]byte, int64) {
+	if c.closed {
+		return nil, c.reader.offset
+	}
+	return c.reader.ReadBatch(max)
+}
+
+// Err returns the error of reader, e.g. ErrPersistRecordCorrupt. Reads
+// return no data after errors.
+func (c *PersistChannel) Err() error {
+	return c.reader.Err()
+}
+
+// ReadContext reads data from reader, waiting for data to be written until
+// ctx is done or the channel is closed. Errors of reader are returned.
+func (c *PersistChannel) ReadContext(ctx context.Context) ([]byte, int64,
+	error) {
+	return c.wait(ctx, c.reader.Read, c.Err)
+}
+
+// wait calls read until it returns data, ctx is done, the channel is closed
+// or errors are returned by readErr.
+func (c *PersistChannel) wait(ctx context.Context, read func() ([]byte, int64),
+	readErr func() error) ([]byte, int64, error) {
+	for {
+		// Get written before read, so writes after read aren't missed.
+		written := c.writer.Written()
+		select {
+		case <-c.done:
+			return nil, 0, ErrPersistChannelClosed
+		default:
+		}
+		data, offset := read()
+		if data != nil {
+			return data, offset, nil
+		}
+		if err := readErr(); err != nil {
+			return nil, offset, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, offset, ctx.Err()
+		case <-c.done:
+			return nil, offset, ErrPersistChannelClosed
+		case <-written:
+		}
+	}
+}
+
+// WriteBatch writes records of batch to writer contiguously. It returns
+// ErrPersistChannelFull without writing if the batch would be written more
+// than MaxLagPages pages after the page of the reader or a consumer.
+func (c *PersistChannel) WriteBatch(batch [][]byte) error {
 	if c.closed {
-		return
+		return ErrPersistChannelClosed
+	}
+	if c.opts.MaxLagPages > 0 {
+		last := c.writer.pageOf(int64(len(batch)) - 1)
+		if last-c.lagPage() > c.opts.MaxLagPages {
+			return ErrPersistChannelFull
+		}
+	}
+	c.writer.WriteBatch(batch)
+	return nil
+}
+
+// lagPage returns the page of the reader or the consumer which lags most.
+func (c *PersistChannel) lagPage() int64 {
+	c.gcMutex.Lock()
+	defer c.gcMutex.Unlock()
+	page := c.readerPage
+	for _, consumer := range c.consumers {
+		if p := consumer.committed / c.opts.PageSize; p < page {
+			page = p
+		}
 	}
-	c.writer.Write(data)
+	return page
 }
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -24,35 +27,23 @@ func (c *ChannelTestSuite) SetupSuite() {
 
 func (c *ChannelTestSuite) TearDownSuite() {
 	removeTestFiles()
+	c.removeConsumerFiles()
+}
+
+func (c *ChannelTestSuite) removeConsumerFiles() {
+	files, _ := filepath.Glob(fmt.Sprintf("%s/%s.consumer.*",
+		testChannelDir, testChannelName))
+	for _, file := range files {
+		os.Remove(file)
+	}
 }
 
 func (c *ChannelTestSuite) SetupTest() {
 	removeTestFiles()
+	c.removeConsumerFiles()
 	setupTestData()
-
-	var (
-		f   *os.File
-		err error
-	)
-	for idx, v := range testData {
-		// only write half testData to file
-		if int64(idx) == testDataSize/2 {
-			break
-		}
-		if int64(idx)%persistChannelPageSize == 0 {
-			if f != nil {
-				f.Close()
-			}
-			f, err = os.OpenFile(getTestFilePath(int64(idx)/persistChannelPageSize),
-				os.O_SYNC|os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-			require.Nil(c.T(), err)
-		}
-		buffer := []byte(v)
-		buffer = append(buffer, '\n')
-		_, err = f.Write(buffer)
-		require.Nil(c.T(), err)
-	}
-	f.Close()
+	// only write half testData to file
+	writeTestPages(c.T(), testData[:testDataSize/2])
 }
 
 func (c *ChannelTestSuite) TestSetupOffset() {
@@ -154,14 +145,301 @@ func (c *ChannelTestSuite) TestSeekReader() {
 	require.Equal(c.T(), "123", string(bytes))
 	require.Equal(c.T(), testDataSize/2, offset)
 
-	channel.SeekReader(offset)
+	require.Nil(c.T(), channel.SeekReader(offset))
 	bytes, offset = channel.Read()
 	require.Equal(c.T(), "123", string(bytes))
 	require.Equal(c.T(), testDataSize/2, offset)
 
+	// Seeking to removed pages returns errors.
+	os.Remove(getTestFilePath(0))
+	require.NotNil(c.T(), channel.SeekReader(0))
+	bytes, _ = channel.Read()
+	require.Nil(c.T(), bytes)
+	require.NotNil(c.T(), channel.Err())
+
+	channel.Close()
+}
+
+func (c *ChannelTestSuite) TestTornTail() {
+	page := testDataSize / 2 / persistChannelPageSize
+	stat, err := os.Stat(getTestFilePath(page))
+	require.Nil(c.T(), err)
+
+	// Append a record whose data is half written.
+	f, err := os.OpenFile(getTestFilePath(page), os.O_APPEND|os.O_WRONLY, 0644)
+	require.Nil(c.T(), err)
+	record := encodePersistRecord(nil, []byte("torn"))
+	_, err = f.Write(record[:len(record)-2])
+	require.Nil(c.T(), err)
+	f.Close()
+
+	channel, err := OpenPersistChannel(testChannelDir, testChannelName, -1,
+		PersistChannelOptions{})
+	require.Nil(c.T(), err)
+	require.Equal(c.T(), testDataSize/2, channel.writer.offset)
+	truncated, err := os.Stat(getTestFilePath(page))
+	require.Nil(c.T(), err)
+	require.Equal(c.T(), stat.Size(), truncated.Size())
+
+	channel.Write([]byte("123"))
+	channel.Flush()
+	bytes, offset := channel.Read()
+	require.Equal(c.T(), "123", string(bytes))
+	require.Equal(c.T(), testDataSize/2, offset)
 	channel.Close()
 }
 
+func (c *ChannelTestSuite) TestCorruptRecord() {
+	f, err := os.OpenFile(getTestFilePath(0), os.O_WRONLY, 0644)
+	require.Nil(c.T(), err)
+	// Flip the first byte of data of the first record.
+	_, err = f.WriteAt([]byte{0xff},
+		persistPageHeaderSize+persistRecordHeaderSize)
+	require.Nil(c.T(), err)
+	f.Close()
+
+	channel := NewPersistChannel(testChannelDir, testChannelName, -1)
+	defer channel.Close()
+	// Seeking past corrupt records returns errors.
+	require.Equal(c.T(), ErrPersistRecordCorrupt, channel.SeekReader(1))
+	require.Nil(c.T(), channel.SeekReader(0))
+	bytes, offset := channel.Read()
+	require.Nil(c.T(), bytes)
+	require.Equal(c.T(), int64(0), offset)
+	require.Equal(c.T(), ErrPersistRecordCorrupt, channel.Err())
+
+	_, _, err = channel.ReadContext(context.Background())
+	require.Equal(c.T(), ErrPersistRecordCorrupt, err)
+}
+
+func (c *ChannelTestSuite) TestLegacyPages() {
+	// Pages of version 1 are newline-terminated, and the last line of the
+	// last page is torn.
+	for page := int64(0); page < 2; page++ {
+		var lines []byte
+		for _, v := range testData[page*persistChannelPageSize : (page+1)*
+			persistChannelPageSize] {
+			lines = append(lines, v+"\n"...)
+		}
+		if page == 1 {
+			lines = lines[:len(lines)-1]
+		}
+		require.Nil(c.T(), ioutil.WriteFile(getTestFilePath(page), lines, 0644))
+	}
+	for page := int64(2); page <= testDataSize/persistChannelPageSize; page++ {
+		os.Remove(getTestFilePath(page))
+	}
+
+	channel, err := OpenPersistChannel(testChannelDir, testChannelName, 0,
+		PersistChannelOptions{})
+	require.Nil(c.T(), err)
+	defer channel.Close()
+	assertPage(c.T(), 0, testData[:persistChannelPageSize])
+	assertPage(c.T(), 1,
+		testData[persistChannelPageSize:2*persistChannelPageSize-1])
+	require.Equal(c.T(), 2*persistChannelPageSize-1, channel.writer.offset)
+
+	channel.Write([]byte("123"))
+	channel.Flush()
+	for i := int64(0); i < 2*persistChannelPageSize-1; i++ {
+		bytes, offset := channel.Read()
+		require.Equal(c.T(), i, offset)
+		require.Equal(c.T(), testData[i], string(bytes))
+	}
+	bytes, _ := channel.Read()
+	require.Equal(c.T(), "123", string(bytes))
+	require.Nil(c.T(), channel.Err())
+}
+
+func (c *ChannelTestSuite) TestConsumer() {
+	channel, err := OpenPersistChannel(testChannelDir, testChannelName, -1,
+		PersistChannelOptions{Sync: PersistChannelSyncEveryWrite})
+	require.Nil(c.T(), err)
+
+	_, err = channel.Consumer("../c")
+	require.Equal(c.T(), ErrPersistConsumerName, err)
+
+	consumer, err := channel.Consumer("c")
+	require.Nil(c.T(), err)
+	require.Equal(c.T(), int64(0), consumer.Offset())
+	for i := int64(0); i < 3; i++ {
+		bytes, offset := consumer.Read()
+		require.Equal(c.T(), i, offset)
+		require.Equal(c.T(), testData[i], string(bytes))
+	}
+	require.Nil(c.T(), consumer.Commit(2))
+	channel.Close()
+
+	// Uncommitted data are read again after the channel is reopened.
+	channel, err = OpenPersistChannel(testChannelDir, testChannelName, -1,
+		PersistChannelOptions{})
+	require.Nil(c.T(), err)
+	consumer, err = channel.Consumer("c")
+	require.Nil(c.T(), err)
+	require.Equal(c.T(), int64(2), consumer.Offset())
+	bytes, offset := consumer.Read()
+	require.Equal(c.T(), int64(2), offset)
+	require.Equal(c.T(), testData[2], string(bytes))
+
+	consumer.Close()
+	bytes, _ = consumer.Read()
+	require.Nil(c.T(), bytes)
+	channel.Close()
+}
+
+func (c *ChannelTestSuite) TestConsumerGC() {
+	channel, err := OpenPersistChannel(testChannelDir, testChannelName, 0,
+		PersistChannelOptions{RetainSize: 1})
+	require.Nil(c.T(), err)
+	defer channel.Close()
+	consumer, err := channel.Consumer("c")
+	require.Nil(c.T(), err)
+
+	// Pages aren't removed before the consumer passes them.
+	channel.SeekReader(-1)
+	_, err = os.Stat(getTestFilePath(0))
+	require.Nil(c.T(), err)
+
+	require.Nil(c.T(), consumer.Commit(2*persistChannelPageSize+5))
+	for page := int64(0); page < 2; page++ {
+		_, err = os.Stat(getTestFilePath(page))
+		require.True(c.T(), os.IsNotExist(err))
+	}
+	_, err = os.Stat(getTestFilePath(2))
+	require.Nil(c.T(), err)
+
+	require.Nil(c.T(), channel.RemoveConsumer("c"))
+	_, err = os.Stat(getTestFilePath(2))
+	require.True(c.T(), os.IsNotExist(err))
+	_, err = os.Stat(fmt.Sprintf("%s/%s.consumer.c",
+		testChannelDir, testChannelName))
+	require.True(c.T(), os.IsNotExist(err))
+}
+
+func (c *ChannelTestSuite) openTempChannel(opts PersistChannelOptions) (
+	*PersistChannel, func()) {
+	dir, err := ioutil.TempDir("", "persist")
+	require.Nil(c.T(), err)
+	channel, err := OpenPersistChannel(dir, testChannelName, 0, opts)
+	require.Nil(c.T(), err)
+	return channel, func() {
+		if !channel.IsClosed() {
+			channel.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func (c *ChannelTestSuite) TestBinary() {
+	channel, cleanup := c.openTempChannel(PersistChannelOptions{})
+	defer cleanup()
+
+	data := [][]byte{{'\n', 0, 0xff, '\n'}, {}, []byte("a\nb")}
+	for _, d := range data {
+		channel.Write(d)
+	}
+	channel.Flush()
+	for i, d := range data {
+		bytes, offset := channel.Read()
+		require.Equal(c.T(), int64(i), offset)
+		require.Equal(c.T(), d, bytes)
+	}
+}
+
+func (c *ChannelTestSuite) TestBatch() {
+	channel, cleanup := c.openTempChannel(PersistChannelOptions{
+		PageSize:    4,
+		WriteBuffer: 3,
+	})
+	defer cleanup()
+
+	var batch [][]byte
+	for _, v := range testData[:10] {
+		batch = append(batch, []byte(v))
+	}
+	channel.WriteBatch(batch)
+	channel.Flush()
+	require.Equal(c.T(), int64(10), channel.writer.offset)
+	require.Equal(c.T(), int64(2), channel.writer.page)
+
+	read, offset := channel.ReadBatch(6)
+	require.Equal(c.T(), int64(0), offset)
+	require.Equal(c.T(), batch[:6], read)
+	read, offset = channel.ReadBatch(6)
+	require.Equal(c.T(), int64(6), offset)
+	require.Equal(c.T(), batch[6:], read)
+	read, offset = channel.ReadBatch(6)
+	require.Equal(c.T(), int64(10), offset)
+	require.Empty(c.T(), read)
+}
+
+func (c *ChannelTestSuite) TestMaxLagPages() {
+	channel, cleanup := c.openTempChannel(PersistChannelOptions{
+		PageSize:    2,
+		MaxLagPages: 1,
+	})
+	defer cleanup()
+
+	var batch [][]byte
+	for _, v := range testData[:4] {
+		batch = append(batch, []byte(v))
+	}
+	require.Nil(c.T(), channel.WriteBatch(batch))
+	require.Equal(c.T(), ErrPersistChannelFull, channel.Write([]byte("4")))
+	channel.Flush()
+
+	// Writes are allowed after the reader passes pages.
+	read, _ := channel.ReadBatch(2)
+	require.Len(c.T(), read, 2)
+	require.Nil(c.T(), channel.Write([]byte("4")))
+
+	// Consumers lag from their committed offsets.
+	consumer, err := channel.Consumer("c")
+	require.Nil(c.T(), err)
+	require.Equal(c.T(), ErrPersistChannelFull, channel.Write([]byte("5")))
+	require.Nil(c.T(), consumer.Commit(4))
+	require.Nil(c.T(), channel.Write([]byte("5")))
+
+	channel.Close()
+	require.Equal(c.T(), ErrPersistChannelClosed, channel.Write([]byte("6")))
+}
+
+func (c *ChannelTestSuite) TestReadContext() {
+	channel, cleanup := c.openTempChannel(PersistChannelOptions{
+		BufferDuration: time.Millisecond * 5,
+	})
+	defer cleanup()
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		channel.Write([]byte("123"))
+	}()
+	bytes, offset, err := channel.ReadContext(context.Background())
+	require.Nil(c.T(), err)
+	require.Equal(c.T(), "123", string(bytes))
+	require.Equal(c.T(), int64(0), offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Millisecond*10)
+	defer cancel()
+	_, offset, err = channel.ReadContext(ctx)
+	require.Equal(c.T(), context.DeadlineExceeded, err)
+	require.Equal(c.T(), int64(1), offset)
+
+	consumer, err := channel.Consumer("c")
+	require.Nil(c.T(), err)
+	bytes, _, err = consumer.ReadContext(context.Background())
+	require.Nil(c.T(), err)
+	require.Equal(c.T(), "123", string(bytes))
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		channel.Close()
+	}()
+	_, _, err = consumer.ReadContext(context.Background())
+	require.Equal(c.T(), ErrPersistChannelClosed, err)
+}
+
 func TestChannel(t *testing.T) {
 	suite.Run(t, new(ChannelTestSuite))
 }
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// persistConsumerPattern matches names of consumers, which are parts of
+// filenames.
+var persistConsumerPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ErrPersistConsumerName is returned if the name of a consumer is invalid.
+var ErrPersistConsumerName = errors.New("invalid persist channel consumer name")
+
+// PersistChannelConsumer reads a persist channel from its committed offset,
+// which is persisted in the channel directory. Pages aren't removed until
+// all consumers have committed offsets after them.
+type PersistChannelConsumer struct {
+	channel *PersistChannel
+	name    string
+	reader  *PersistChannelReader
+	// committed is guarded by gcMutex of channel.
+	committed int64
+}
+
+func (c *PersistChannel) getConsumerPath(name string) string {
+	return fmt.Sprintf("%s/%s.consumer.%s", c.dir, c.name, name)
+}
+
+// loadConsumers loads committed offsets of consumers in dir, so their pages
+// are retained before they're opened again.
+func (c *PersistChannel) loadConsumers() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	prefix := c.name + ".consumer."
+	for _, file := range files {
+		// find filename "{channel_name}.consumer.{consumer_name}"
+		name := strings.TrimPrefix(file.Name(), prefix)
+		if name == file.Name() || !persistConsumerPattern.MatchString(name) {
+			continue
+		}
+		data, err := ioutil.ReadFile(c.getConsumerPath(name))
+		if err != nil {
+			return err
+		}
+		offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("persist channel %s consumer %s corrupt. err: %v",
+				c.name, name, err)
+		}
+		// Offsets of truncated torn records are read again.
+		if offset > c.writer.offset {
+			offset = c.writer.offset
+		}
+		c.consumers[name] = &PersistChannelConsumer{
+			channel:   c,
+			name:      name,
+			committed: offset,
+		}
+	}
+	return nil
+}
+
+// Consumer opens the consumer of name, which reads from its committed
+// offset. New consumers read from the first page of the channel.
+func (c *PersistChannel) Consumer(name string) (*PersistChannelConsumer, error) {
+	if !persistConsumerPattern.MatchString(name) {
+		return nil, ErrPersistConsumerName
+	}
+	c.gcMutex.Lock()
+	defer c.gcMutex.Unlock()
+
+	consumer, ok := c.consumers[name]
+	if !ok {
+		consumer = &PersistChannelConsumer{
+			channel:   c,
+			name:      name,
+			committed: c.firstPage * c.opts.PageSize,
+		}
+		if err := consumer.save(consumer.committed); err != nil {
+			return nil, err
+		}
+		c.consumers[name] = consumer
+	}
+	if consumer.reader != nil {
+		return consumer, nil
+	}
+	if err := c.integrityCheck(consumer.committed /
+		c.opts.PageSize); err != nil {
+		return nil, err
+	}
+	consumer.reader = newPersistChannelReader(c.getPath, consumer.committed,
+		c.opts)
+	consumer.reader.shadow = true
+	if err := consumer.reader.OpenPage(); err != nil {
+		consumer.reader.Close()
+		consumer.reader = nil
+		return nil, err
+	}
+	return consumer, nil
+}
+
+// RemoveConsumer removes the consumer of name and its committed offset, so
+// its pages could be removed.
+func (c *PersistChannel) RemoveConsumer(name string) error {
+	c.gcMutex.Lock()
+	defer c.gcMutex.Unlock()
+
+	consumer, ok := c.consumers[name]
+	if !ok {
+		return nil
+	}
+	if consumer.reader != nil {
+		consumer.reader.Close()
+	}
+	delete(c.consumers, name)
+	if err := os.Remove(c.getConsumerPath(name)); err != nil &&
+		!os.IsNotExist(err) {
+		return err
+	}
+	c.gc()
+	return nil
+}
+
+// Name returns the name of the consumer.
+func (c *PersistChannelConsumer) Name() string {
+	return c.name
+}
+
+// getReader returns the reader, or nil if the consumer or channel is closed.
+func (c *PersistChannelConsumer) getReader() *PersistChannelReader {
+	select {
+	case <-c.channel.done:
+		return nil
+	default:
+	}
+	c.channel.gcMutex.Lock()
+	defer c.channel.gcMutex.Unlock()
+	return c.reader
+}
+
+// Read reads data from the consumer. Read data are read again after the
+// channel is reopened unless they're committed.
+func (c *PersistChannelConsumer) Read() ([]byte, int64) {
+	reader := c.getReader()
+	if reader == nil {
+		return nil, c.Offset()
+	}
+	return reader.Read()
+}
+
+// ReadBatch reads at most max records from the consumer, and returns them
+// with the offset of the first one.
+func (c *PersistChannelConsumer) ReadBatch(max int) ([][]byte, int64) {
+	reader := c.getReader()
+	if reader == nil {
+		return nil, c.Offset()
+	}
+	return reader.ReadBatch(max)
+}
+
+// ReadContext reads data from the consumer, waiting for data to be written
+// until ctx is done or the channel is closed. Errors of reads are returned.
+func (c *PersistChannelConsumer) ReadContext(ctx context.Context) ([]byte,
+	int64, error) {
+	return c.channel.wait(ctx, c.Read, c.Err)
+}
+
+// Err returns the error of reads, e.g. ErrPersistRecordCorrupt. Reads
+// return no data after errors.
+func (c *PersistChannelConsumer) Err() error {
+	reader := c.getReader()
+	if reader == nil {
+		return nil
+	}
+	return reader.Err()
+}
+
+// Offset returns the committed offset.
+func (c *PersistChannelConsumer) Offset() int64 {
+	c.channel.gcMutex.Lock()
+	defer c.channel.gcMutex.Unlock()
+	return c.committed
+}
+
+// Commit persists offset, which is the offset the consumer reads from after
+// the channel is reopened, and removes pages passed by all consumers.
+func (c *PersistChannelConsumer) Commit(offset int64) error {
+	if offset < 0 {
+		return fmt.Errorf("invalid offset %d", offset)
+	}
+	c.channel.gcMutex.Lock()
+	defer c.channel.gcMutex.Unlock()
+	if err := c.save(offset); err != nil {
+		return err
+	}
+	c.committed = offset
+	c.channel.gc()
+	return nil
+}
+
+// save writes offset to the consumer file atomically.
+func (c *PersistChannelConsumer) save(offset int64) error {
+	path := c.channel.getConsumerPath(c.name)
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatInt(offset, 10))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Close closes the reader of the consumer. Its committed offset is kept
+// until the consumer is removed.
+func (c *PersistChannelConsumer) Close() {
+	c.channel.gcMutex.Lock()
+	defer c.channel.gcMutex.Unlock()
+	if c.reader != nil {
+		c.reader.Close()
+		c.reader = nil
+	}
+}
@@ -1,7 +1,7 @@
 package utils
 
 import (
-	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"sync"
@@ -15,43 +15,83 @@ type PersistChannelReader struct {
 	buffer []byte
 	shadow bool
 	lock   sync.Mutex
+	// header is whether the page header of the opened page is read.
+	header bool
+	// err is the error of the last read, reads stop after errors.
+	err error
+	// retainSize is the number of pages retain removes.
+	retainSize int64
+	// release is called with the page opened by non-shadow readers, which
+	// removes old pages.
+	release func(page int64)
 }
 
 // NewPersistChannelReader create a new persist channel reader.
 func NewPersistChannelReader(
 	getPath func(int64) string,
 	offset int64) *PersistChannelReader {
-	return &PersistChannelReader{
+	return newPersistChannelReader(getPath, offset,
+		PersistChannelOptions{}.withDefaults())
+}
+
+func newPersistChannelReader(getPath func(int64) string, offset int64,
+	opts PersistChannelOptions) *PersistChannelReader {
+	r := &PersistChannelReader{
 		PersistChannelFileOperator: PersistChannelFileOperator{
-			getPath: getPath,
-			offset:  offset,
-			page:    offset / persistChannelPageSize,
+			getPath:  getPath,
+			pageSize: opts.PageSize,
+			offset:   offset,
+			page:     offset / opts.PageSize,
 		},
+		retainSize: opts.RetainSize,
+	}
+	r.release = r.retain
+	return r
+}
+
+// retain removes the page retainSize pages before page.
+func (r *PersistChannelReader) retain(page int64) {
+	if page >= r.retainSize {
+		os.Remove(r.getPath(page - r.retainSize))
+	}
+}
+
+// OpenPage opens the read page, and reads to the offset of the reader. It
+// returns errors if the page can't be opened or records before the offset
+// are corrupt, which are returned by Err too.
+func (r *PersistChannelReader) OpenPage() error {
+	offset := r.offset
+	if err := r.openPage(); err != nil {
+		r.lock.Lock()
+		r.err = err
+		r.lock.Unlock()
+		return err
+	}
+	// move cursor to correct position.
+	for r.offset < offset && r.Err() == nil {
+		r.Read()
 	}
+	return r.Err()
 }
 
-// OpenPage open the next read page.
-func (r *PersistChannelReader) OpenPage() {
+// openPage opens the read page, and moves the offset to the start of it.
+func (r *PersistChannelReader) openPage() error {
 	if r.file != nil {
 		r.file.Close()
+		r.file = nil
 	}
 	f, err := os.OpenFile(r.getPath(r.page), os.O_RDONLY|os.O_CREATE, 0644)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to open page %d. err: %v", r.page, err)
 	}
 	r.file = f
 	r.buffer = make([]byte, 0)
+	r.header = false
 	if !r.shadow {
-		if r.page >= persistChannelRetainSize {
-			os.Remove(r.getPath(r.page - persistChannelRetainSize))
-		}
-	}
-	offset := r.offset
-	// move cursor to correct position.
-	r.offset = r.page * persistChannelPageSize
-	for r.offset < offset {
-		r.Read()
+		r.release(r.page)
 	}
+	r.offset = r.page * r.pageSize
+	return nil
 }
 
 // Close closes the reader.
@@ -65,14 +105,11 @@ func (r *PersistChannelReader) Close() {
 	}
 }
 
-// Readline read a line.
-func (r *PersistChannelReader) Readline() []byte {
-	var result []byte
-
-	if idx := bytes.IndexByte(r.buffer, '\n'); idx != -1 {
-		result = r.buffer[:idx]
-		r.buffer = append([]byte{}, r.buffer[idx+1:]...)
-		return result
+// ReadRecord reads the data of a record, or nil if the next record isn't
+// wholly written yet. Errors are returned if the page is corrupt.
+func (r *PersistChannelReader) ReadRecord() ([]byte, error) {
+	if data, err := r.nextRecord(); data != nil || err != nil {
+		return data, err
 	}
 	tmpBuffer := make([]byte, readerBufferSize)
 	for {
@@ -81,37 +118,100 @@ func (r *PersistChannelReader) Readline() []byte {
 		}
 		b, err := r.file.Read(tmpBuffer)
 		if err != nil && err != io.EOF {
-			panic(err)
+			return nil, err
 		}
 		if err != nil || b == 0 {
 			break
 		}
 		r.buffer = append(r.buffer, tmpBuffer[0:b]...)
-		if idx := bytes.IndexByte(tmpBuffer[0:b], '\n'); idx != -1 {
-			break
+		if data, err := r.nextRecord(); data != nil || err != nil {
+			return data, err
+		}
+	}
+	return nil, nil
+}
+
+// Readline reads the data of a record, or nil if there's none or the page
+// is corrupt.
+//
+// Deprecated: Records aren't terminated by newlines anymore. Use ReadRecord,
+// which returns errors of corrupt pages.
+func (r *PersistChannelReader) Readline() []byte {
+	data, _ := r.ReadRecord()
+	return data
+}
+
+// nextRecord pops the record in buffer, after the page header is read. Torn
+// records are truncated when channels are opened, so corrupt records are
+// returned as errors.
+func (r *PersistChannelReader) nextRecord() ([]byte, error) {
+	if !r.header {
+		size, err := decodePersistPageHeader(r.buffer)
+		if err != nil || size == 0 {
+			return nil, err
 		}
+		r.buffer = append(r.buffer[:0], r.buffer[size:]...)
+		r.header = true
 	}
-	if idx := bytes.IndexByte(r.buffer, '\n'); idx != -1 {
-		result = r.buffer[:idx]
-		r.buffer = append([]byte{}, r.buffer[idx+1:]...)
-		return result
+	data, size, err := decodePersistRecord(r.buffer)
+	if err != nil || size == 0 {
+		return nil, err
 	}
-	return nil
+	result := append([]byte{}, data...)
+	r.buffer = append(r.buffer[:0], r.buffer[size:]...)
+	return result, nil
+}
+
+// Err returns the error of the last read, e.g. ErrPersistRecordCorrupt.
+// Reads return no data after errors.
+func (r *PersistChannelReader) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
 }
 
 // Read reads data from page.
 func (r *PersistChannelReader) Read() ([]byte, int64) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	return r.read()
+}
 
-	bytes := r.Readline()
-	if len(bytes) == 0 {
-		return bytes, r.offset
+func (r *PersistChannelReader) read() ([]byte, int64) {
+	if r.err != nil {
+		return nil, r.offset
+	}
+	bytes, err := r.ReadRecord()
+	if err != nil {
+		r.err = err
+	}
+	if bytes == nil {
+		return nil, r.offset
 	}
 	r.offset++
-	if r.offset%persistChannelPageSize == 0 {
+	if r.offset%r.pageSize == 0 {
 		r.page++
-		r.OpenPage()
+		if err := r.openPage(); err != nil {
+			r.err = err
+		}
 	}
 	return bytes, r.offset - 1
 }
+
+// ReadBatch reads at most max records which are written, and returns them
+// with the offset of the first one.
+func (r *PersistChannelReader) ReadBatch(max int) ([][]byte, int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	offset := r.offset
+	var batch [][]byte
+	for len(batch) < max {
+		bytes, _ := r.read()
+		if bytes == nil {
+			break
+		}
+		batch = append(batch, bytes)
+	}
+	return batch, offset
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -28,26 +29,7 @@ func (r *ReaderTestSuite) TearDownSuite() {
 
 func (r *ReaderTestSuite) SetupTest() {
 	setupTestData()
-
-	var (
-		f   *os.File
-		err error
-	)
-	for idx, v := range testData {
-		if int64(idx)%persistChannelPageSize == 0 {
-			if f != nil {
-				f.Close()
-			}
-			f, err = os.OpenFile(getTestFilePath(int64(idx)/persistChannelPageSize),
-				os.O_SYNC|os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-			require.Nil(r.T(), err)
-		}
-		buffer := []byte(v)
-		buffer = append(buffer, '\n')
-		_, err = f.Write(buffer)
-		require.Nil(r.T(), err)
-	}
-	f.Close()
+	writeTestPages(r.T(), testData)
 }
 
 // TestRead tests if reader read at the middle of channel.
@@ -92,10 +74,10 @@ func (r *ReaderTestSuite) TestLargeRead() {
 	require.Nil(r.T(), err)
 
 	data := strings.Repeat("G", 1024*1024*20)
-	_, err = f.Write([]byte(data))
-	require.Nil(r.T(), err)
-	_, err = f.Write([]byte{'\n'})
+	_, err = f.Write(encodePersistRecord(encodePersistPageHeader(nil),
+		[]byte(data)))
 	require.Nil(r.T(), err)
+	f.Close()
 
 	reader := NewPersistChannelReader(getTestFilePath, -1)
 	reader.OpenPage()
@@ -105,6 +87,35 @@ func (r *ReaderTestSuite) TestLargeRead() {
 	reader.Close()
 }
 
+// TestReadline tests the deprecated Readline reads records.
+func (r *ReaderTestSuite) TestReadline() {
+	reader := NewPersistChannelReader(getTestFilePath, 0)
+	reader.OpenPage()
+	require.Equal(r.T(), testData[0], string(reader.Readline()))
+	data, err := reader.ReadRecord()
+	require.Nil(r.T(), err)
+	require.Equal(r.T(), testData[1], string(data))
+	reader.Close()
+}
+
+// TestCorruptPage tests reader returns errors of pages without headers.
+func (r *ReaderTestSuite) TestCorruptPage() {
+	err := ioutil.WriteFile(getTestFilePath(0), []byte("legacy\n"), 0644)
+	require.Nil(r.T(), err)
+
+	reader := NewPersistChannelReader(getTestFilePath, 0)
+	reader.OpenPage()
+	data, err := reader.ReadRecord()
+	require.Nil(r.T(), data)
+	require.Equal(r.T(), ErrPersistPageCorrupt, err)
+
+	readData, readOffset := reader.Read()
+	require.Nil(r.T(), readData)
+	require.Equal(r.T(), int64(0), readOffset)
+	require.Equal(r.T(), ErrPersistPageCorrupt, reader.Err())
+	reader.Close()
+}
+
 // TestRetain tests main reader should delete old pages.
 func (r *ReaderTestSuite) TestRetain() {
 	reader := NewPersistChannelReader(getTestFilePath, testDataSize)
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Pages of persist channels start with
+//
+//	[4 bytes magic][4 bytes version]
+//
+// followed by records of
+//
+//	[4 bytes length][4 bytes CRC-32C of data][data]
+//
+// in big endian, so torn writes are detected by length and checksum. Pages
+// of version 1 have no header, whose records are terminated by newlines.
+const (
+	persistPageHeaderSize   = 8
+	persistRecordHeaderSize = 8
+
+	persistPageMagic         = "\x89MPC"
+	persistPageVersion       = 2
+	persistPageLegacyVersion = 1
+)
+
+var (
+	// ErrPersistRecordCorrupt is returned if the checksum of a record
+	// mismatches.
+	ErrPersistRecordCorrupt = errors.New("persist channel record corrupt")
+	// ErrPersistPageCorrupt is returned if the header of a page is invalid.
+	ErrPersistPageCorrupt = errors.New("persist channel page corrupt")
+
+	persistRecordTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// encodePersistPageHeader appends the header of pages to b.
+func encodePersistPageHeader(b []byte) []byte {
+	var header [persistPageHeaderSize]byte
+	copy(header[:4], persistPageMagic)
+	binary.BigEndian.PutUint32(header[4:], persistPageVersion)
+	return append(b, header[:]...)
+}
+
+// decodePersistPageHeader decodes the header at the beginning of a page,
+// returning its size, or zero size if b doesn't contain the whole header
+// yet.
+func decodePersistPageHeader(b []byte) (int, error) {
+	if !isPersistPageHeader(b) {
+		return 0, ErrPersistPageCorrupt
+	} else if len(b) < persistPageHeaderSize {
+		return 0, nil
+	}
+	if version := binary.BigEndian.Uint32(b[4:8]); version != persistPageVersion {
+		return 0, fmt.Errorf("persist channel page version %d unsupported",
+			version)
+	}
+	return persistPageHeaderSize, nil
+}
+
+// isPersistPageHeader returns whether b starts with the magic of pages, or
+// is a prefix of it.
+func isPersistPageHeader(b []byte) bool {
+	if len(b) < len(persistPageMagic) {
+		return bytes.HasPrefix([]byte(persistPageMagic), b)
+	}
+	return bytes.HasPrefix(b, []byte(persistPageMagic))
+}
+
+// encodePersistRecord appends the record of data to b.
+func encodePersistRecord(b, data []byte) []byte {
+	var header [persistRecordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(data, persistRecordTable))
+	b = append(b, header[:]...)
+	return append(b, data...)
+}
+
+// decodePersistRecord decodes the first record of b, returning its data and
+// size, or zero size if b doesn't contain a whole record yet.
+func decodePersistRecord(b []byte) ([]byte, int, error) {
+	if len(b) < persistRecordHeaderSize {
+		return nil, 0, nil
+	}
+	length := int(binary.BigEndian.Uint32(b[:4]))
+	size := persistRecordHeaderSize + length
+	if len(b) < size {
+		return nil, 0, nil
+	}
+	data := b[persistRecordHeaderSize:size]
+	if crc32.Checksum(data, persistRecordTable) != binary.BigEndian.Uint32(b[4:8]) {
+		return nil, 0, ErrPersistRecordCorrupt
+	}
+	return data, size, nil
+}
+
+// scanPersistPage counts whole and valid records of the page of path, and
+// returns the size of them with the page header. Bytes after them are a
+// torn tail of a crash, and pages without whole headers are empty.
+func scanPersistPage(path string) (count, size int64, err error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var pageHeader [persistPageHeaderSize]byte
+	n, err := io.ReadFull(reader, pageHeader[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if !isPersistPageHeader(pageHeader[:n]) {
+			return 0, 0, ErrPersistPageCorrupt
+		}
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	if _, err := decodePersistPageHeader(pageHeader[:]); err != nil {
+		return 0, 0, err
+	}
+	size = persistPageHeaderSize
+
+	var header [persistRecordHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return count, size, nil
+			}
+			return 0, 0, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		hash := crc32.New(persistRecordTable)
+		n, err := io.CopyN(hash, reader, length)
+		if err == io.EOF || n < length {
+			return count, size, nil
+		} else if err != nil {
+			return 0, 0, err
+		}
+		if hash.Sum32() != binary.BigEndian.Uint32(header[4:]) {
+			return count, size, nil
+		}
+		count++
+		size += persistRecordHeaderSize + length
+	}
+}
+
+// persistPageVersionOf returns the version of the page of path, or zero if
+// it doesn't exist or is empty.
+func persistPageVersionOf(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(persistPageMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	switch {
+	case n == 0:
+		return 0, nil
+	case isPersistPageHeader(magic[:n]):
+		return persistPageVersion, nil
+	}
+	return persistPageLegacyVersion, nil
+}
+
+// migratePersistPage rewrites the page of path in the current version if
+// it's a page of version 1. Lines without newlines are torn tails of
+// crashes and dropped.
+func migratePersistPage(path string) error {
+	version, err := persistPageVersionOf(path)
+	if err != nil || version != persistPageLegacyVersion {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	b := encodePersistPageHeader(nil)
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			break
+		}
+		b = encodePersistRecord(b, data[:idx])
+		data = data[idx+1:]
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
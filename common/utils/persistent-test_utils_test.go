@@ -5,7 +5,6 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
-	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -24,9 +23,18 @@ func assertPage(t *testing.T, page int64, expect []string) {
 	path := getTestFilePath(page)
 	bytes, err := ioutil.ReadFile(path)
 	require.Nil(t, err)
-	data := strings.Split(string(bytes), "\n")
-	// remove tailing '\n'
-	data = data[:len(data)-1]
+	size, err := decodePersistPageHeader(bytes)
+	require.Nil(t, err)
+	require.NotZero(t, size)
+	bytes = bytes[size:]
+	var data []string
+	for len(bytes) > 0 {
+		record, size, err := decodePersistRecord(bytes)
+		require.Nil(t, err)
+		require.NotZero(t, size)
+		data = append(data, string(record))
+		bytes = bytes[size:]
+	}
 	require.Equal(t, len(expect), len(data))
 	for idx, s := range data {
 		require.Equal(t, expect[idx], s)
@@ -48,3 +56,28 @@ func setupTestData() {
 		testData = append(testData, s)
 	}
 }
+
+// writeTestPages writes records of data to pages from offset 0.
+func writeTestPages(t *testing.T, data []string) {
+	var (
+		f   *os.File
+		err error
+	)
+	for idx, v := range data {
+		if int64(idx)%persistChannelPageSize == 0 {
+			if f != nil {
+				f.Close()
+			}
+			f, err = os.OpenFile(getTestFilePath(int64(idx)/persistChannelPageSize),
+				os.O_SYNC|os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+			require.Nil(t, err)
+			_, err = f.Write(encodePersistPageHeader(nil))
+			require.Nil(t, err)
+		}
+		_, err = f.Write(encodePersistRecord(nil, []byte(v)))
+		require.Nil(t, err)
+	}
+	if f != nil {
+		f.Close()
+	}
+}
@@ -23,35 +23,67 @@ type PersistChannelWriter struct {
 	bufferMutex sync.Mutex
 	// mutex for write
 	mutex sync.Mutex
+	// sync policy
+	sync         PersistChannelSyncPolicy
+	syncInterval time.Duration
+	lastSync     time.Time
+	dirty        bool
+	// buffer options
+	writeBuffer    int64
+	bufferDuration time.Duration
+	// written is closed and renewed after records are written to page.
+	written chan struct{}
 }
 
 // NewPersistChannelWriter create a new persist channel writer.
 func NewPersistChannelWriter(getPath func(int64) string) *PersistChannelWriter {
+	return newPersistChannelWriter(getPath,
+		PersistChannelOptions{}.withDefaults())
+}
+
+func newPersistChannelWriter(getPath func(int64) string,
+	opts PersistChannelOptions) *PersistChannelWriter {
 	return &PersistChannelWriter{
 		PersistChannelFileOperator: PersistChannelFileOperator{
-			getPath: getPath,
+			getPath:  getPath,
+			pageSize: opts.PageSize,
 		},
-		ctrl:    make(chan writeCtrlType),
-		ackCtrl: make(chan bool),
+		ctrl:           make(chan writeCtrlType),
+		ackCtrl:        make(chan bool),
+		sync:           opts.Sync,
+		syncInterval:   opts.SyncInterval,
+		writeBuffer:    opts.WriteBuffer,
+		bufferDuration: opts.BufferDuration,
+		written:        make(chan struct{}),
 	}
 }
 
+// SetSyncPolicy sets the fsync policy of the writer before it starts.
+func (w *PersistChannelWriter) SetSyncPolicy(policy PersistChannelSyncPolicy,
+	interval time.Duration) {
+	w.sync = policy
+	w.syncInterval = interval
+}
+
 // Start starts the writer flush ticker.
 func (w *PersistChannelWriter) Start() {
-	w.ticker = time.NewTicker(persistChannelBufferDuration)
+	w.ticker = time.NewTicker(w.bufferDuration)
 	go func() {
 	mainLoop:
 		for {
 			select {
 			case <-w.ticker.C:
-				w.flush()
+				w.flush(false)
 			case cmd := <-w.ctrl:
 				switch cmd {
 				case writeFlush:
-					w.flush()
+					w.flush(true)
 					w.ackCtrl <- true
 				case writeClose:
-					w.flush()
+					w.flush(true)
+					w.bufferMutex.Lock()
+					w.notify()
+					w.bufferMutex.Unlock()
 					w.ackCtrl <- true
 					break mainLoop
 				}
@@ -66,11 +98,22 @@ func (w *PersistChannelWriter) OpenPage() {
 		w.file.Close()
 	}
 	f, err := os.OpenFile(w.getPath(w.page),
-		os.O_SYNC|os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		panic(err)
 	}
 	w.file = f
+	// New pages start with the page header.
+	stat, err := f.Stat()
+	if err != nil {
+		panic(err)
+	}
+	if stat.Size() == 0 {
+		if _, err := f.Write(encodePersistPageHeader(nil)); err != nil {
+			panic(err)
+		}
+		w.dirty = true
+	}
 }
 
 // Close closes the writer.
@@ -86,33 +129,99 @@ func (w *PersistChannelWriter) Close() {
 }
 
 // Write writes data to writer's buffer,
-// it will be flushed to page at next tick.
+// it will be flushed to page at next tick, or before Write returns with
+// PersistChannelSyncEveryWrite.
 func (w *PersistChannelWriter) Write(data []byte) {
+	w.WriteBatch([][]byte{data})
+}
+
+// WriteBatch writes records of batch to writer's buffer contiguously. It
+// blocks while full buffers are flushed, and flushes once after the batch
+// with PersistChannelSyncEveryWrite.
+func (w *PersistChannelWriter) WriteBatch(batch [][]byte) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	w.bufferMutex.Lock()
-	w.bufferLen++
-	data = append(data, '\n')
-	w.buffer = append(w.buffer, data...)
-	needFlush := (w.offset+w.bufferLen)%persistChannelPageSize == 0 ||
-		w.bufferLen >= persistChannelWriteBuffer
-	w.bufferMutex.Unlock()
-	if needFlush {
+	pending := false
+	for _, data := range batch {
+		w.bufferMutex.Lock()
+		w.bufferLen++
+		w.buffer = encodePersistRecord(w.buffer, data)
+		// Pages are switched by flushes, so records can't cross pages.
+		needFlush := (w.offset+w.bufferLen)%w.pageSize == 0 ||
+			w.bufferLen >= w.writeBuffer
+		w.bufferMutex.Unlock()
+		pending = !needFlush
+		if needFlush {
+			w.Flush()
+		}
+	}
+	if pending && w.sync == PersistChannelSyncEveryWrite {
 		w.Flush()
 	}
 }
 
-// Flush triggers flush action.
+// pageOf returns the page of the record written n records after buffered
+// records.
+func (w *PersistChannelWriter) pageOf(n int64) int64 {
+	w.bufferMutex.Lock()
+	defer w.bufferMutex.Unlock()
+	return (w.offset + w.bufferLen + n) / w.pageSize
+}
+
+// Written returns a channel which is closed after records are written to
+// page, or the writer is closed.
+func (w *PersistChannelWriter) Written() <-chan struct{} {
+	w.bufferMutex.Lock()
+	defer w.bufferMutex.Unlock()
+	return w.written
+}
+
+// notify wakes waiters of Written. It should be called with bufferMutex
+// locked.
+func (w *PersistChannelWriter) notify() {
+	close(w.written)
+	w.written = make(chan struct{})
+}
+
+// Flush triggers flush action, syncing written data to disk regardless of
+// the sync policy.
 func (w *PersistChannelWriter) Flush() {
 	w.ctrl <- writeFlush
 	<-w.ackCtrl
 }
 
-func (w *PersistChannelWriter) flush() {
+// shouldSync returns whether to sync written data by the sync policy.
+func (w *PersistChannelWriter) shouldSync(force bool) bool {
+	if !w.dirty {
+		return false
+	}
+	switch {
+	case force:
+		return true
+	case w.sync == PersistChannelSyncNone:
+		return false
+	case w.sync == PersistChannelSyncInterval:
+		return time.Since(w.lastSync) >= w.syncInterval
+	}
+	return true
+}
+
+func (w *PersistChannelWriter) syncFile() {
+	if err := w.file.Sync(); err != nil {
+		panic(err)
+	}
+	w.dirty = false
+	w.lastSync = time.Now()
+}
+
+func (w *PersistChannelWriter) flush(force bool) {
 	w.bufferMutex.Lock()
 	defer w.bufferMutex.Unlock()
 
 	if w.bufferLen == 0 {
+		if w.shouldSync(force) {
+			w.syncFile()
+		}
 		return
 	}
 
@@ -125,18 +234,20 @@ func (w *PersistChannelWriter) flush() {
 	if err != nil {
 		panic(err)
 	}
+	w.dirty = true
 
-	err = w.file.Sync()
-	if err != nil {
-		panic(err)
+	// Full pages are always synced before the next page is opened.
+	if w.shouldSync(force) || w.offset%w.pageSize == 0 {
+		w.syncFile()
 	}
 
-	if w.offset%persistChannelPageSize == 0 {
+	if w.offset%w.pageSize == 0 {
 		w.page++
 		w.OpenPage()
 	}
+	w.notify()
 
 	// reset ticker
 	w.ticker.Stop()
-	w.ticker = time.NewTicker(persistChannelBufferDuration)
+	w.ticker = time.NewTicker(w.bufferDuration)
 }
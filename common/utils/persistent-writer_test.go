@@ -107,6 +107,44 @@ func (w *WriterTestSuite) TestLargeWrite() {
 	writer.Close()
 }
 
+// TestSyncEveryWrite tests writer flushes every write before it returns.
+func (w *WriterTestSuite) TestSyncEveryWrite() {
+	writer := NewPersistChannelWriter(getTestFilePath)
+	writer.SetSyncPolicy(PersistChannelSyncEveryWrite, 0)
+	writer.OpenPage()
+	writer.Start()
+
+	for i := 0; i < 3; i++ {
+		writer.Write([]byte(testData[i]))
+		require.Equal(w.T(), int64(0), writer.bufferLen)
+		require.False(w.T(), writer.dirty)
+		assertPage(w.T(), 0, testData[:i+1])
+	}
+	writer.Close()
+}
+
+// TestSyncInterval tests writer syncs flushed data at most once per
+// interval.
+func (w *WriterTestSuite) TestSyncInterval() {
+	writer := NewPersistChannelWriter(getTestFilePath)
+	writer.SetSyncPolicy(PersistChannelSyncInterval, time.Hour)
+	writer.OpenPage()
+	writer.lastSync = time.Now()
+	writer.Start()
+
+	writer.Write([]byte(testData[0]))
+	time.Sleep(persistChannelBufferDuration * 100)
+	writer.bufferMutex.Lock()
+	require.Equal(w.T(), int64(1), writer.offset)
+	require.True(w.T(), writer.dirty)
+	writer.bufferMutex.Unlock()
+
+	// Flush always syncs.
+	writer.Flush()
+	require.False(w.T(), writer.dirty)
+	writer.Close()
+}
+
 func TestWriter(t *testing.T) {
 	suite.Run(t, new(WriterTestSuite))
 }
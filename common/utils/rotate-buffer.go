@@ -1,6 +1,7 @@
 package utils
 
-// RotateBuffer provides a deque style, limited, auto rotate buffer.
+// RotateBuffer provides a deque style, limited, auto rotate buffer. New code
+// should use collections.RingBuffer of its own type.
 type RotateBuffer struct {
 	limit uint64
 	start uint64
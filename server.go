@@ -1,9 +1,17 @@
 package apimochi
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/jiarung/mochi/common/api/middleware"
+)
+
+// serviceName is the service name of the server in metrics.
+const serviceName = "api-mochi"
 
 func main() {
 	r := gin.Default()
+	r.Use(middleware.MetricsMiddleware(serviceName))
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "pong",
@@ -11,4 +19,3 @@ func main() {
 	})
 	r.Run() // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
 }
-